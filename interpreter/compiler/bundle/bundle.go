@@ -0,0 +1,79 @@
+package bundle
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"bufio"
+	"errors"
+	"encoding/gob"
+	"kumachan/interpreter/def"
+)
+
+
+/**
+ *  A bundle is a binary image of a compiled program together with
+ *  the resources collected by the loader. It can be executed without
+ *  the source files and the standard library (the source code is kept
+ *  only for the purpose of rendering error messages).
+ */
+
+const FileExtension = ".kmi"
+const magic = "KumaChanImage\n"
+const formatVersion = uint(1)
+
+func Encode(w io.Writer, program def.Program, res (map[string] def.Resource)) error {
+	var img, err = encodeImage(program, res)
+	if err != nil { return err }
+	var buf = bufio.NewWriter(w)
+	_, err = buf.WriteString(magic)
+	if err != nil { return err }
+	var enc = gob.NewEncoder(buf)
+	err = enc.Encode(formatVersion)
+	if err != nil { return err }
+	err = enc.Encode(img)
+	if err != nil { return err }
+	return buf.Flush()
+}
+
+func Decode(r io.Reader) (def.Program, (map[string] def.Resource), error) {
+	var buf = bufio.NewReader(r)
+	var header = make([] byte, len(magic))
+	var _, err = io.ReadFull(buf, header)
+	if err != nil || string(header) != magic {
+		return def.Program {}, nil, errors.New("not a program image")
+	}
+	var dec = gob.NewDecoder(buf)
+	var version uint
+	err = dec.Decode(&version)
+	if err != nil { return def.Program {}, nil, err }
+	if version != formatVersion {
+		return def.Program {}, nil, fmt.Errorf(
+			"unsupported image format version %d", version)
+	}
+	var img programImage
+	err = dec.Decode(&img)
+	if err != nil { return def.Program {}, nil, err }
+	return decodeImage(img)
+}
+
+func WriteFile(path string, program def.Program, res (map[string] def.Resource)) error {
+	var f, err = os.OpenFile(path, os.O_WRONLY | os.O_TRUNC | os.O_CREATE, 0666)
+	if err != nil { return err }
+	err = Encode(f, program, res)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func ReadFile(path string) (def.Program, (map[string] def.Resource), error) {
+	var f, err = os.Open(path)
+	if err != nil { return def.Program {}, nil, err }
+	defer (func() {
+		_ = f.Close()
+	})()
+	return Decode(f)
+}
+
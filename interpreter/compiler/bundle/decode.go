@@ -0,0 +1,308 @@
+package bundle
+
+import (
+	"fmt"
+	"bytes"
+	"errors"
+	"math/big"
+	"image/png"
+	"kumachan/stdlib"
+	"kumachan/standalone/rpc"
+	"kumachan/standalone/rpc/kmd"
+	"kumachan/interpreter/def"
+	"kumachan/interpreter/lang/textual/ast"
+	"kumachan/interpreter/lang/textual/cst"
+	"kumachan/interpreter/lang/textual/scanner"
+	"kumachan/interpreter/compiler/generator"
+	. "kumachan/standalone/util/error"
+)
+
+
+type decoder struct {
+	files   [] *cst.Tree
+	groups  [] *def.UiObjectGroup
+}
+
+func decodeImage(img programImage) (def.Program, (map[string] def.Resource), error) {
+	var files = make([] *cst.Tree, len(img.SourceFiles))
+	for i, file := range img.SourceFiles {
+		var code = ([] rune)(file.Code)
+		files[i] = &cst.Tree {
+			Name:    file.Name,
+			Code:    code,
+			Info:    scanner.GetRowColInfo(code),
+			SpanMap: scanner.GetRowSpanMap(code),
+		}
+	}
+	var groups = make([] *def.UiObjectGroup, len(img.UiObjectGroups))
+	for i := range img.UiObjectGroups {
+		var group = img.UiObjectGroups[i]
+		groups[i] = &group
+	}
+	var dec = &decoder {
+		files:  files,
+		groups: groups,
+	}
+	var data_values = make([] def.DataValue, len(img.DataValues))
+	for i, v := range img.DataValues {
+		var decoded, err = decodeDataValue(v)
+		if err != nil { return def.Program {}, nil, err }
+		data_values[i] = decoded
+	}
+	var functions, err = dec.decodeFunctions(img.Functions)
+	if err != nil { return def.Program {}, nil, err }
+	closures, err := dec.decodeFunctions(img.Closures)
+	if err != nil { return def.Program {}, nil, err }
+	effects, err := dec.decodeFunctions(img.Effects)
+	if err != nil { return def.Program {}, nil, err }
+	schema, err := decodeKmdSchemaTable(img.KmdSchemas)
+	if err != nil { return def.Program {}, nil, err }
+	var adapters = make(def.KmdAdapterTable)
+	for _, item := range img.KmdAdapters {
+		adapters[item.Id] = def.KmdAdapterInfo { Index: item.Index }
+	}
+	var validators = make(def.KmdValidatorTable)
+	for _, item := range img.KmdValidators {
+		validators[item.Id] = def.KmdValidatorInfo { Index: item.Index }
+	}
+	services, err := decodeServiceIndex(img.Services)
+	if err != nil { return def.Program {}, nil, err }
+	var res = make(map[string] def.Resource)
+	for _, item := range img.Resources {
+		res[item.Path] = item.Resource
+	}
+	return def.Program {
+		MetaData:   def.ProgramMetaData {
+			EntryModulePath: img.EntryModulePath,
+		},
+		DataValues: data_values,
+		Functions:  functions,
+		Closures:   closures,
+		Effects:    effects,
+		KmdInfo:    def.KmdInfo {
+			SchemaTable:       schema,
+			KmdAdapterTable:   adapters,
+			KmdValidatorTable: validators,
+		},
+		RpcInfo:    def.RpcInfo {
+			ServiceIndex: services,
+		},
+	}, res, nil
+}
+
+func (dec *decoder) decodeErrorPoint(point errorPoint) (ErrorPoint, error) {
+	if point.File < 0 {
+		return ErrorPoint {}, nil
+	}
+	if point.File >= len(dec.files) {
+		return ErrorPoint {}, errors.New("invalid source file reference")
+	}
+	return ErrorPoint { Node: ast.Node {
+		CST:   dec.files[point.File],
+		Point: scanner.Point { Row: point.Row, Col: point.Col },
+		Span:  scanner.Span { Start: point.Start, End: point.End },
+	} }, nil
+}
+
+func (dec *decoder) decodeFunctions(functions ([] function)) ([] *def.Function, error) {
+	var decoded = make([] *def.Function, len(functions))
+	for i, f := range functions {
+		var generated, err = dec.decodeGeneratedSeed(f.Generated)
+		if err != nil { return nil, err }
+		decl_point, err := dec.decodeErrorPoint(f.DeclPoint)
+		if err != nil { return nil, err }
+		var source_map = make([] ErrorPoint, len(f.SourceMap))
+		for j, point := range f.SourceMap {
+			source_map[j], err = dec.decodeErrorPoint(point)
+			if err != nil { return nil, err }
+		}
+		decoded[i] = &def.Function {
+			Kind:      f.Kind,
+			NativeId:  f.NativeId,
+			Generated: generated,
+			Code:      f.Code,
+			BaseSize:  f.BaseSize,
+			Info:      def.FuncInfo {
+				Module:    f.Module,
+				Name:      f.Name,
+				DeclPoint: decl_point,
+				SourceMap: source_map,
+			},
+		}
+	}
+	return decoded, nil
+}
+
+func (dec *decoder) decodeGeneratedSeed(seed generatedSeed) (interface{}, error) {
+	switch seed.Kind {
+	case seedNone:
+		return nil, nil
+	case seedPredefinedValue:
+		var v, err = decodePredefinedValue(seed.Value)
+		if err != nil { return nil, err }
+		return def.PredefinedValueSeed { Value: v }, nil
+	case seedKmdSerializer:
+		return def.KmdApiFunctionSeed {
+			Id: kmd.SerializerId { TypeId: seed.KmdTypeId },
+		}, nil
+	case seedKmdDeserializer:
+		return def.KmdApiFunctionSeed {
+			Id: kmd.DeserializerId { TypeId: seed.KmdTypeId },
+		}, nil
+	case seedKmdEncoder:
+		return def.KmdApiFunctionSeed {
+			Id: kmd.EncoderId { TypeId: seed.KmdTypeId, Format: seed.KmdFormat },
+		}, nil
+	case seedKmdDecoder:
+		return def.KmdApiFunctionSeed {
+			Id: kmd.DecoderId { TypeId: seed.KmdTypeId, Format: seed.KmdFormat },
+		}, nil
+	case seedServiceMethodCaller:
+		return def.ServiceMethodCallerSeed {
+			MethodName: seed.MethodName,
+		}, nil
+	case seedServiceCreator:
+		return def.ServiceCreatorSeed {
+			MethodNames: seed.MethodNames,
+		}, nil
+	case seedUiObject:
+		if seed.UiGroup >= uint(len(dec.groups)) {
+			return nil, errors.New("invalid UI object group reference")
+		}
+		return def.UiObjectThunk {
+			Object: seed.UiObject,
+			Group:  dec.groups[seed.UiGroup],
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown generated function kind %d", seed.Kind)
+	}
+}
+
+func decodePredefinedValue(v predefinedValue) (interface{}, error) {
+	switch v.Kind {
+	case predefinedPNG:
+		return &stdlib.PNG { Data: v.Data }, nil
+	case predefinedRawImage:
+		var decoded, err = png.Decode(bytes.NewReader(v.Data))
+		if err != nil { return nil, err }
+		return &stdlib.RawImage { Data: decoded }, nil
+	case predefinedAssetFile:
+		return stdlib.AssetFile { Path: v.Path }, nil
+	case predefinedServiceIdentifier:
+		return v.ServiceId, nil
+	default:
+		return nil, fmt.Errorf("unknown predefined value kind %d", v.Kind)
+	}
+}
+
+func decodeDataValue(v dataValue) (def.DataValue, error) {
+	switch v.Kind {
+	case dataInteger:
+		if v.Integer == nil {
+			return generator.DataInteger { Value: big.NewInt(0) }, nil
+		}
+		return generator.DataInteger { Value: v.Integer }, nil
+	case dataSmallInteger:
+		return generator.DataSmallInteger { Value: v.Small }, nil
+	case dataFloat:
+		return generator.DataFloat { Value: v.Float }, nil
+	case dataString:
+		return generator.DataString { Value: v.String }, nil
+	case dataStringFormatter:
+		return generator.DataStringFormatter {
+			Segments: v.Segments,
+			Arity:    v.Arity,
+		}, nil
+	case dataArrayInfo:
+		if v.ItemType >= uint(len(arrayItemTypes)) {
+			return nil, errors.New("invalid array item type")
+		}
+		return generator.DataArrayInfo {
+			Length:   v.Length,
+			ItemType: arrayItemTypes[v.ItemType],
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown data value kind %d", v.Kind)
+	}
+}
+
+func decodeKmdType(text string) (*kmd.Type, error) {
+	if text == "" {
+		return nil, nil
+	}
+	var t, ok = kmd.TypeParse(text)
+	if !(ok) {
+		return nil, fmt.Errorf("invalid kmd type: %s", text)
+	}
+	return t, nil
+}
+
+func decodeKmdSchemaTable(schemas ([] kmdSchema)) (kmd.SchemaTable, error) {
+	var table = make(kmd.SchemaTable)
+	for _, s := range schemas {
+		switch s.Kind {
+		case kmd.Record:
+			var fields = make(map[string] kmd.RecordField)
+			for _, field := range s.Fields {
+				var t, err = decodeKmdType(field.Type)
+				if err != nil { return nil, err }
+				fields[field.Name] = kmd.RecordField {
+					Type:           t,
+					Index:          field.Index,
+					Optional:       field.Optional,
+					DefaultLiteral: field.Default,
+				}
+			}
+			table[s.Id] = kmd.RecordSchema {
+				Fields:       fields,
+				AllowUnknown: s.AllowUnknown,
+			}
+		case kmd.Tuple:
+			var elements = make([] *kmd.Type, len(s.Elements))
+			for i, text := range s.Elements {
+				var t, err = decodeKmdType(text)
+				if err != nil { return nil, err }
+				elements[i] = t
+			}
+			table[s.Id] = kmd.TupleSchema { Elements: elements }
+		case kmd.Enum:
+			var cases = make(map[kmd.TypeId] uint)
+			for _, item := range s.Cases {
+				cases[item.Id] = item.Index
+			}
+			table[s.Id] = kmd.EnumSchema { CaseIndexMap: cases }
+		default:
+			return nil, fmt.Errorf("invalid kmd schema kind %d", s.Kind)
+		}
+	}
+	return table, nil
+}
+
+func decodeServiceIndex(services ([] service)) (rpc.ServiceIndex, error) {
+	var index = make(rpc.ServiceIndex)
+	for _, s := range services {
+		var arg_t, err = decodeKmdType(s.ArgType)
+		if err != nil { return nil, err }
+		var methods = make(map[string] rpc.ServiceMethodInterface)
+		for _, m := range s.Methods {
+			var arg_t, err = decodeKmdType(m.ArgType)
+			if err != nil { return nil, err }
+			ret_t, err := decodeKmdType(m.RetType)
+			if err != nil { return nil, err }
+			methods[m.Name] = rpc.ServiceMethodInterface {
+				ArgType:    arg_t,
+				RetType:    ret_t,
+				MultiValue: m.MultiValue,
+			}
+		}
+		index[s.Id] = rpc.ServiceInterface {
+			ServiceIdentifier: s.Id,
+			Constructor:       rpc.ServiceConstructorInterface {
+				ArgType: arg_t,
+			},
+			Methods:           methods,
+		}
+	}
+	return index, nil
+}
+
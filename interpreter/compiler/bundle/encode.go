@@ -0,0 +1,404 @@
+package bundle
+
+import (
+	"fmt"
+	"sort"
+	"bytes"
+	"reflect"
+	"math/big"
+	"image/png"
+	"kumachan/stdlib"
+	"kumachan/standalone/rpc"
+	"kumachan/standalone/rpc/kmd"
+	"kumachan/interpreter/def"
+	"kumachan/interpreter/lang/textual/cst"
+	"kumachan/interpreter/compiler/generator"
+	. "kumachan/standalone/util/error"
+)
+
+
+var arrayItemTypes = [] reflect.Type {
+	def.ValueReflectType(),
+	reflect.TypeOf(big.NewInt(0)),
+	reflect.TypeOf(float64(0)),
+	reflect.TypeOf(complex128(complex(0,1))),
+	reflect.TypeOf(true),
+	reflect.TypeOf(uint8(0)),
+	reflect.TypeOf(uint16(0)),
+	reflect.TypeOf(uint32(0)),
+	reflect.TypeOf(uint64(0)),
+	reflect.TypeOf(int32(0)),
+}
+
+type encoder struct {
+	files     [] sourceFile
+	fileMap   map[*cst.Tree] int
+	groups    [] def.UiObjectGroup
+	groupMap  map[*def.UiObjectGroup] uint
+}
+
+func encodeImage(program def.Program, res (map[string] def.Resource)) (programImage, error) {
+	var enc = &encoder {
+		files:    make([] sourceFile, 0),
+		fileMap:  make(map[*cst.Tree] int),
+		groups:   make([] def.UiObjectGroup, 0),
+		groupMap: make(map[*def.UiObjectGroup] uint),
+	}
+	var data_values = make([] dataValue, len(program.DataValues))
+	for i, v := range program.DataValues {
+		var encoded, err = encodeDataValue(v)
+		if err != nil { return programImage {}, err }
+		data_values[i] = encoded
+	}
+	var functions, err = enc.encodeFunctions(program.Functions)
+	if err != nil { return programImage {}, err }
+	closures, err := enc.encodeFunctions(program.Closures)
+	if err != nil { return programImage {}, err }
+	effects, err := enc.encodeFunctions(program.Effects)
+	if err != nil { return programImage {}, err }
+	return programImage {
+		EntryModulePath: program.MetaData.EntryModulePath,
+		SourceFiles:     enc.files,
+		UiObjectGroups:  enc.groups,
+		DataValues:      data_values,
+		Functions:       functions,
+		Closures:        closures,
+		Effects:         effects,
+		KmdSchemas:      encodeKmdSchemaTable(program.KmdInfo.SchemaTable),
+		KmdAdapters:     encodeKmdAdapterTable(program.KmdInfo.KmdAdapterTable),
+		KmdValidators:   encodeKmdValidatorTable(program.KmdInfo.KmdValidatorTable),
+		Services:        encodeServiceIndex(program.RpcInfo.ServiceIndex),
+		Resources:       encodeResources(res),
+	}, nil
+}
+
+func (enc *encoder) encodeErrorPoint(point ErrorPoint) errorPoint {
+	var node = point.Node
+	var tree = node.CST
+	if tree == nil {
+		return errorPoint { File: -1 }
+	}
+	var file, exists = enc.fileMap[tree]
+	if !(exists) {
+		file = len(enc.files)
+		enc.files = append(enc.files, sourceFile {
+			Name: tree.Name,
+			Code: string(tree.Code),
+		})
+		enc.fileMap[tree] = file
+	}
+	return errorPoint {
+		File:  file,
+		Row:   node.Point.Row,
+		Col:   node.Point.Col,
+		Start: node.Span.Start,
+		End:   node.Span.End,
+	}
+}
+
+func (enc *encoder) encodeFunctions(functions ([] *def.Function)) ([] function, error) {
+	var encoded = make([] function, len(functions))
+	for i, f := range functions {
+		var seed, err = enc.encodeGeneratedSeed(f)
+		if err != nil { return nil, err }
+		var source_map = make([] errorPoint, len(f.Info.SourceMap))
+		for j, point := range f.Info.SourceMap {
+			source_map[j] = enc.encodeErrorPoint(point)
+		}
+		encoded[i] = function {
+			Kind:      f.Kind,
+			NativeId:  f.NativeId,
+			Generated: seed,
+			Code:      f.Code,
+			BaseSize:  f.BaseSize,
+			Module:    f.Info.Module,
+			Name:      f.Info.Name,
+			DeclPoint: enc.encodeErrorPoint(f.Info.DeclPoint),
+			SourceMap: source_map,
+		}
+	}
+	return encoded, nil
+}
+
+func (enc *encoder) encodeGeneratedSeed(f *def.Function) (generatedSeed, error) {
+	var unsupported = func() (generatedSeed, error) {
+		return generatedSeed {}, fmt.Errorf(
+			"function %s::%s cannot be stored in an image",
+			f.Info.Module, f.Info.Name)
+	}
+	switch f.Kind {
+	case def.F_GENERATED:
+		switch seed := f.Generated.(type) {
+		case def.PredefinedValueSeed:
+			var v, err = encodePredefinedValue(seed.Value)
+			if err != nil { return generatedSeed {}, err }
+			return generatedSeed {
+				Kind:  seedPredefinedValue,
+				Value: v,
+			}, nil
+		case def.KmdApiFunctionSeed:
+			switch id := seed.Id.(type) {
+			case kmd.SerializerId:
+				return generatedSeed {
+					Kind:      seedKmdSerializer,
+					KmdTypeId: id.TypeId,
+				}, nil
+			case kmd.DeserializerId:
+				return generatedSeed {
+					Kind:      seedKmdDeserializer,
+					KmdTypeId: id.TypeId,
+				}, nil
+			case kmd.EncoderId:
+				return generatedSeed {
+					Kind:      seedKmdEncoder,
+					KmdTypeId: id.TypeId,
+					KmdFormat: id.Format,
+				}, nil
+			case kmd.DecoderId:
+				return generatedSeed {
+					Kind:      seedKmdDecoder,
+					KmdTypeId: id.TypeId,
+					KmdFormat: id.Format,
+				}, nil
+			default:
+				panic("impossible branch")
+			}
+		case def.ServiceMethodCallerSeed:
+			return generatedSeed {
+				Kind:       seedServiceMethodCaller,
+				MethodName: seed.MethodName,
+			}, nil
+		case def.ServiceCreatorSeed:
+			return generatedSeed {
+				Kind:        seedServiceCreator,
+				MethodNames: seed.MethodNames,
+			}, nil
+		default:
+			return unsupported()
+		}
+	case def.F_RUNTIME_GENERATED:
+		switch seed := f.Generated.(type) {
+		case def.UiObjectThunk:
+			var group, exists = enc.groupMap[seed.Group]
+			if !(exists) {
+				group = uint(len(enc.groups))
+				enc.groups = append(enc.groups, *(seed.Group))
+				enc.groupMap[seed.Group] = group
+			}
+			return generatedSeed {
+				Kind:     seedUiObject,
+				UiObject: seed.Object,
+				UiGroup:  group,
+			}, nil
+		default:
+			return unsupported()
+		}
+	default:
+		return generatedSeed { Kind: seedNone }, nil
+	}
+}
+
+func encodePredefinedValue(v interface{}) (predefinedValue, error) {
+	switch v := v.(type) {
+	case *stdlib.PNG:
+		return predefinedValue {
+			Kind: predefinedPNG,
+			Data: v.Data,
+		}, nil
+	case *stdlib.RawImage:
+		var buf bytes.Buffer
+		var err = png.Encode(&buf, v.Data)
+		if err != nil { return predefinedValue {}, err }
+		return predefinedValue {
+			Kind: predefinedRawImage,
+			Data: buf.Bytes(),
+		}, nil
+	case stdlib.AssetFile:
+		return predefinedValue {
+			Kind: predefinedAssetFile,
+			Path: v.Path,
+		}, nil
+	case rpc.ServiceIdentifier:
+		return predefinedValue {
+			Kind:      predefinedServiceIdentifier,
+			ServiceId: v,
+		}, nil
+	default:
+		return predefinedValue {}, fmt.Errorf(
+			"predefined value of type %T cannot be stored in an image", v)
+	}
+}
+
+func encodeDataValue(v def.DataValue) (dataValue, error) {
+	switch v := v.(type) {
+	case generator.DataInteger:
+		return dataValue { Kind: dataInteger, Integer: v.Value }, nil
+	case generator.DataSmallInteger:
+		return dataValue { Kind: dataSmallInteger, Small: v.Value }, nil
+	case generator.DataFloat:
+		return dataValue { Kind: dataFloat, Float: v.Value }, nil
+	case generator.DataString:
+		return dataValue { Kind: dataString, String: v.Value }, nil
+	case generator.DataStringFormatter:
+		return dataValue {
+			Kind:     dataStringFormatter,
+			Segments: v.Segments,
+			Arity:    v.Arity,
+		}, nil
+	case generator.DataArrayInfo:
+		for i, t := range arrayItemTypes {
+			if t == v.ItemType {
+				return dataValue {
+					Kind:     dataArrayInfo,
+					Length:   v.Length,
+					ItemType: uint(i),
+				}, nil
+			}
+		}
+		return dataValue {}, fmt.Errorf(
+			"array item type %s cannot be stored in an image", v.ItemType)
+	default:
+		return dataValue {}, fmt.Errorf(
+			"data value of type %T cannot be stored in an image", v)
+	}
+}
+
+func encodeKmdType(t *kmd.Type) string {
+	if t == nil {
+		return ""
+	} else {
+		return t.String()
+	}
+}
+
+func encodeKmdSchemaTable(table kmd.SchemaTable) ([] kmdSchema) {
+	var encoded = make([] kmdSchema, 0, len(table))
+	for id, schema := range table {
+		switch s := schema.(type) {
+		case kmd.RecordSchema:
+			var fields = make([] kmdRecordField, 0, len(s.Fields))
+			for name, field := range s.Fields {
+				fields = append(fields, kmdRecordField {
+					Name:     name,
+					Type:     encodeKmdType(field.Type),
+					Index:    field.Index,
+					Optional: field.Optional,
+					Default:  field.DefaultLiteral,
+				})
+			}
+			sort.Slice(fields, func(i, j int) bool {
+				return fields[i].Index < fields[j].Index
+			})
+			encoded = append(encoded, kmdSchema {
+				Id:           id,
+				Kind:         kmd.Record,
+				Fields:       fields,
+				AllowUnknown: s.AllowUnknown,
+			})
+		case kmd.TupleSchema:
+			var elements = make([] string, len(s.Elements))
+			for i, t := range s.Elements {
+				elements[i] = encodeKmdType(t)
+			}
+			encoded = append(encoded, kmdSchema {
+				Id:       id,
+				Kind:     kmd.Tuple,
+				Elements: elements,
+			})
+		case kmd.EnumSchema:
+			var cases = make([] kmdEnumCase, 0, len(s.CaseIndexMap))
+			for case_id, index := range s.CaseIndexMap {
+				cases = append(cases, kmdEnumCase {
+					Id:    case_id,
+					Index: index,
+				})
+			}
+			sort.Slice(cases, func(i, j int) bool {
+				return cases[i].Index < cases[j].Index
+			})
+			encoded = append(encoded, kmdSchema {
+				Id:    id,
+				Kind:  kmd.Enum,
+				Cases: cases,
+			})
+		default:
+			panic("impossible branch")
+		}
+	}
+	sort.Slice(encoded, func(i, j int) bool {
+		return encoded[i].Id.String() < encoded[j].Id.String()
+	})
+	return encoded
+}
+
+func encodeKmdAdapterTable(table def.KmdAdapterTable) ([] kmdAdapter) {
+	var encoded = make([] kmdAdapter, 0, len(table))
+	for id, info := range table {
+		encoded = append(encoded, kmdAdapter {
+			Id:    id,
+			Index: info.Index,
+		})
+	}
+	sort.Slice(encoded, func(i, j int) bool {
+		return encoded[i].Index < encoded[j].Index
+	})
+	return encoded
+}
+
+func encodeKmdValidatorTable(table def.KmdValidatorTable) ([] kmdValidator) {
+	var encoded = make([] kmdValidator, 0, len(table))
+	for id, info := range table {
+		encoded = append(encoded, kmdValidator {
+			Id:    id,
+			Index: info.Index,
+		})
+	}
+	sort.Slice(encoded, func(i, j int) bool {
+		return encoded[i].Index < encoded[j].Index
+	})
+	return encoded
+}
+
+func encodeServiceIndex(index rpc.ServiceIndex) ([] service) {
+	var encoded = make([] service, 0, len(index))
+	for id, s := range index {
+		var methods = make([] serviceMethod, 0, len(s.Methods))
+		for name, m := range s.Methods {
+			methods = append(methods, serviceMethod {
+				Name:       name,
+				ArgType:    encodeKmdType(m.ArgType),
+				RetType:    encodeKmdType(m.RetType),
+				MultiValue: m.MultiValue,
+			})
+		}
+		sort.Slice(methods, func(i, j int) bool {
+			return methods[i].Name < methods[j].Name
+		})
+		encoded = append(encoded, service {
+			Id:      id,
+			ArgType: encodeKmdType(s.Constructor.ArgType),
+			Methods: methods,
+		})
+	}
+	sort.Slice(encoded, func(i, j int) bool {
+		var a = rpc.DescribeServiceIdentifier(encoded[i].Id)
+		var b = rpc.DescribeServiceIdentifier(encoded[j].Id)
+		return a < b
+	})
+	return encoded
+}
+
+func encodeResources(res (map[string] def.Resource)) ([] resource) {
+	var encoded = make([] resource, 0, len(res))
+	for path, item := range res {
+		encoded = append(encoded, resource {
+			Path:     path,
+			Resource: item,
+		})
+	}
+	sort.Slice(encoded, func(i, j int) bool {
+		return encoded[i].Path < encoded[j].Path
+	})
+	return encoded
+}
+
@@ -0,0 +1,153 @@
+package bundle
+
+import (
+	"math/big"
+	"kumachan/standalone/rpc"
+	"kumachan/standalone/rpc/kmd"
+	"kumachan/interpreter/def"
+)
+
+
+type programImage struct {
+	EntryModulePath  string
+	SourceFiles      [] sourceFile
+	UiObjectGroups   [] def.UiObjectGroup
+	DataValues       [] dataValue
+	Functions        [] function
+	Closures         [] function
+	Effects          [] function
+	KmdSchemas       [] kmdSchema
+	KmdAdapters      [] kmdAdapter
+	KmdValidators    [] kmdValidator
+	Services         [] service
+	Resources        [] resource
+}
+
+type sourceFile struct {
+	Name  string
+	Code  string
+}
+
+type errorPoint struct {
+	File   int  // index of source file, -1 if not available
+	Row    int
+	Col    int
+	Start  int
+	End    int
+}
+
+type dataKind uint
+const (
+	dataInteger dataKind = iota
+	dataSmallInteger
+	dataFloat
+	dataString
+	dataStringFormatter
+	dataArrayInfo
+)
+type dataValue struct {
+	Kind      dataKind
+	Integer   *big.Int
+	Small     interface{}
+	Float     float64
+	String    string
+	Segments  [] string
+	Arity     uint
+	Length    uint
+	ItemType  uint
+}
+
+type function struct {
+	Kind       def.FunctionKind
+	NativeId   string
+	Generated  generatedSeed
+	Code       [] def.Instruction
+	BaseSize   def.FrameBaseSize
+	Module     string
+	Name       string
+	DeclPoint  errorPoint
+	SourceMap  [] errorPoint
+}
+
+type seedKind uint
+const (
+	seedNone seedKind = iota
+	seedPredefinedValue
+	seedKmdSerializer
+	seedKmdDeserializer
+	seedServiceMethodCaller
+	seedServiceCreator
+	seedUiObject
+	seedKmdEncoder
+	seedKmdDecoder
+)
+type generatedSeed struct {
+	Kind         seedKind
+	Value        predefinedValue
+	KmdTypeId    kmd.TypeId
+	KmdFormat    kmd.Format
+	MethodName   string
+	MethodNames  [] string
+	UiObject     string
+	UiGroup      uint
+}
+
+type predefinedKind uint
+const (
+	predefinedPNG predefinedKind = iota
+	predefinedRawImage
+	predefinedAssetFile
+	predefinedServiceIdentifier
+)
+type predefinedValue struct {
+	Kind       predefinedKind
+	Data       [] byte
+	Path       string
+	ServiceId  rpc.ServiceIdentifier
+}
+
+type kmdSchema struct {
+	Id            kmd.TypeId
+	Kind          kmd.TypeKind
+	Fields        [] kmdRecordField
+	AllowUnknown  bool
+	Elements      [] string
+	Cases         [] kmdEnumCase
+}
+type kmdRecordField struct {
+	Name      string
+	Type      string
+	Index     uint
+	Optional  bool
+	Default   string
+}
+type kmdEnumCase struct {
+	Id     kmd.TypeId
+	Index  uint
+}
+type kmdAdapter struct {
+	Id     kmd.AdapterId
+	Index  uint
+}
+type kmdValidator struct {
+	Id     kmd.ValidatorId
+	Index  uint
+}
+
+type service struct {
+	Id       rpc.ServiceIdentifier
+	ArgType  string
+	Methods  [] serviceMethod
+}
+type serviceMethod struct {
+	Name        string
+	ArgType     string
+	RetType     string
+	MultiValue  bool
+}
+
+type resource struct {
+	Path  string
+	def.Resource
+}
+
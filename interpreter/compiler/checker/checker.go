@@ -35,22 +35,24 @@ type CheckedFunction struct {
 	CheckedFunctionInfo
 }
 type CheckedFunctionInfo struct {
-	Section      string
-	Public       bool
-	Doc          string
-	Params       [] TypeParam
-	Bounds       TypeBounds
-	Type         Type
-	RawImplicit  [] Type
-	AliasList    [] string
-	IsSelfAlias  bool
-	IsFromConst  bool
+	Section          string
+	Public           bool
+	Doc              string
+	Params           [] TypeParam
+	Bounds           TypeBounds
+	Type             Type
+	RawImplicit      [] Type
+	AliasList        [] string
+	IsSelfAlias      bool
+	IsFromConst      bool
+	AllowedWarnings  map[string] bool
 	FunctionGeneratorFlags
 }
 type FunctionGeneratorFlags struct {
 	Exported        bool
 	ConsideredThunk bool
 	KmdRelated      bool
+	IsTest          bool
 }
 type CheckedEffect struct {
 	Point  ErrorPoint
@@ -82,7 +84,7 @@ type BodyNative struct {
 }
 func (impl BodyGenerated) CheckerBody() {}
 type BodyGenerated struct {
-	Value  interface{}
+	Seed  def.GeneratedFunctionSeed
 }
 func (impl BodyRuntimeGenerated) CheckerBody() {}
 type BodyRuntimeGenerated struct {
@@ -92,9 +94,10 @@ type BodyRuntimeGenerated struct {
 type Index  map[string] *CheckedModule
 
 type CheckContext struct {
-	Types      TypeRegistry
-	Functions  FunctionStore
-	Mapping    KmdIdMapping
+	Types       TypeRegistry
+	Functions   FunctionStore
+	Mapping     KmdIdMapping
+	References  *RefIndex
 }
 func (ctx CheckContext) CollectExportedTypes() (map[string] map[def.Symbol] bool) {
 	var collect_symbols func(Type, string, (map[def.Symbol] bool))
@@ -592,15 +595,17 @@ func TypeCheck(entry *loader.Module, raw_index loader.Index) (
 	var serv, err4 = CollectServices(raw_index, functions, types, sch, mapping)
 	if err4 != nil { return nil, nil, nil, nil, [] E { err4 } }
 	var ctx = CheckContext {
-		Types:     types,
-		Functions: functions,
-		Mapping:   mapping,
+		Types:      types,
+		Functions:  functions,
+		Mapping:    mapping,
+		References: CreateRefIndex(),
 	}
 	var checked_index = make(Index)
 	var checked, errs1 = TypeCheckModule(entry, checked_index, ctx)
 	if errs1 != nil { return nil, nil, nil, nil, errs1 }
 	var errs2 = EnforceGoodKmdFunctions(types, checked_index)
 	if errs2 != nil { return nil, nil, nil, nil, errs2 }
+	BuildRefIndex(ctx.References, checked_index, ctx)
 	return checked, checked_index, sch, serv, nil
 }
 
@@ -621,6 +626,22 @@ func TypeCheckModule(mod *loader.Module, index Index, ctx CheckContext) (
 	// TODO: throw an error if there is a name conflict
 	//       between constants and functions
 	var errors = make([] E, 0)
+	for _, stmt := range mod.AST.Statements {
+		var imp, is_import = stmt.Statement.(ast.Import)
+		if !(is_import) {
+			continue
+		}
+		var _, tags_err = ParseImportTags(imp.Tags)
+		if tags_err != nil {
+			errors = append(errors, &ImportError {
+				Point: ErrorPointFrom(tags_err.Tag.Node),
+				Concrete: E_InvalidImportTag {
+					Tag:  string(tags_err.Tag.RawContent),
+					Info: tags_err.Info,
+				},
+			})
+		}
+	}
 	var imported = make(map[string] *CheckedModule)
 	for alias, imported_item := range mod.ImpMap {
 		var checked, errs = TypeCheckModule(imported_item, index, ctx)
@@ -660,20 +681,22 @@ func TypeCheckModule(mod *loader.Module, index Index, ctx CheckContext) (
 				Implicit: implicit_fields,
 				FunctionKmdInfo:     kmd_info,
 				CheckedFunctionInfo: CheckedFunctionInfo {
-					Section:     f.Section,
-					Public:      f.Public,
-					Doc:         f.Doc,
-					Params:      f.TypeParams,
-					Bounds:      f.TypeBounds,
-					Type:        &AnonymousType { t },
-					RawImplicit: f.RawImplicit,
-					AliasList:   f.AliasList,
-					IsSelfAlias: f.IsSelfAlias,
-					IsFromConst: f.IsFromConst,
+					Section:         f.Section,
+					Public:          f.Public,
+					Doc:             f.Doc,
+					Params:          f.TypeParams,
+					Bounds:          f.TypeBounds,
+					Type:            &AnonymousType { t },
+					RawImplicit:     f.RawImplicit,
+					AliasList:       f.AliasList,
+					IsSelfAlias:     f.IsSelfAlias,
+					IsFromConst:     f.IsFromConst,
+					AllowedWarnings: f.Tags.AllowedWarnings,
 					FunctionGeneratorFlags: FunctionGeneratorFlags {
 						Exported:        f.Public,
 						ConsideredThunk: considered_thunk,
 						KmdRelated:      is_kmd_related,
+						IsTest:          f.Tags.IsTest,
 					},
 				},
 			})
@@ -717,28 +740,24 @@ func TypeCheckModule(mod *loader.Module, index Index, ctx CheckContext) (
 				case def.UiObjectThunk:
 					add(f, BodyRuntimeGenerated { Value: stored })
 				default:
-					var v = def.ValNativeFun(func(_ def.Value, _ def.InteropContext) def.Value {
-						return stored
+					add(f, BodyGenerated {
+						Seed: def.PredefinedValueSeed { Value: stored },
 					})
-					add(f, BodyGenerated { Value: v })
 				}
 			case ast.KmdApiFuncBody:
-				var v = def.CreateKmdApiFunction(body.Id)
-				add(f, BodyGenerated { Value: v })
+				add(f, BodyGenerated {
+					Seed: def.KmdApiFunctionSeed { Id: body.Id },
+				})
 			case ast.ServiceMethodFuncBody:
-				var v = def.CreateServiceMethodCaller(name)
-				add(f, BodyGenerated { Value: v })
+				add(f, BodyGenerated {
+					Seed: def.ServiceMethodCallerSeed { MethodName: name },
+				})
 			case ast.ServiceCreateFuncBody:
-				var names = mod.ServiceMethodNames
-				var v = def.ValNativeFun(func(arg def.Value, h def.InteropContext) def.Value {
-					var prod = arg.(def.TupleValue)
-					var data = prod.Elements[0]
-					var ctx = prod.Elements[1].(def.TupleValue)
-					var dtor = ctx.Elements[0]
-					var methods = ctx.Elements[1:]
-					return def.CreateServiceInstance(data, dtor, methods, names, h)
+				add(f, BodyGenerated {
+					Seed: def.ServiceCreatorSeed {
+						MethodNames: mod.ServiceMethodNames,
+					},
 				})
-				add(f, BodyGenerated { Value: v })
 			default:
 				panic("impossible branch")
 			}
@@ -289,6 +289,17 @@ type E_KmdFieldNotSerializable struct {
 	FieldName  string
 }
 
+func (impl E_KmdFieldNotOptional) KmdError() {}
+type E_KmdFieldNotOptional struct {
+	FieldName  string
+}
+
+func (impl E_KmdFieldInvalidDefault) KmdError() {}
+type E_KmdFieldInvalidDefault struct {
+	FieldName  string
+	Reason     string
+}
+
 func (impl E_KmdElementNotSerializable) KmdError() {}
 type E_KmdElementNotSerializable struct {
 	ElementIndex  uint
@@ -330,6 +341,16 @@ func (err *KmdError) Desc() ErrorMessage {
 		msg.WriteText(TS_ERROR, "Field")
 		msg.WriteInnerText(TS_INLINE_CODE, e.FieldName)
 		msg.WriteText(TS_ERROR, "is not KMD serializable")
+	case E_KmdFieldNotOptional:
+		msg.WriteText(TS_ERROR, "Field")
+		msg.WriteInnerText(TS_INLINE_CODE, e.FieldName)
+		msg.WriteText(TS_ERROR, "cannot be optional")
+		msg.WriteEndText(TS_INFO, "(only Maybe and List fields can be optional without a default value)")
+	case E_KmdFieldInvalidDefault:
+		msg.WriteText(TS_ERROR, "Field")
+		msg.WriteInnerText(TS_INLINE_CODE, e.FieldName)
+		msg.WriteText(TS_ERROR, "has an invalid default value:")
+		msg.WriteEndText(TS_ERROR, e.Reason)
 	case E_KmdElementNotSerializable:
 		msg.WriteText(TS_ERROR, "Element")
 		msg.WriteInnerText(TS_INLINE, fmt.Sprintf("#%d", e.ElementIndex))
@@ -474,6 +495,11 @@ type E_ImplicitContextOnNativeFunction struct {}
 func (impl E_ImplicitContextOnServiceMethod) FunctionError() {}
 type E_ImplicitContextOnServiceMethod struct {}
 
+func (impl E_InvalidTestFunction) FunctionError() {}
+type E_InvalidTestFunction struct {
+	Reason  string
+}
+
 func (impl E_NativeFunctionOutsideStandardLibrary) FunctionError() {}
 type E_NativeFunctionOutsideStandardLibrary struct {}
 
@@ -527,6 +553,9 @@ func (err *FunctionError) Desc() ErrorMessage {
 		msg.WriteText(TS_ERROR, "Cannot use implicit context on a native function")
 	case E_ImplicitContextOnServiceMethod:
 		msg.WriteText(TS_ERROR, "Cannot use implicit context on a service method")
+	case E_InvalidTestFunction:
+		msg.WriteText(TS_ERROR, "Invalid test function:")
+		msg.WriteEndText(TS_ERROR, e.Reason)
 	case E_NativeFunctionOutsideStandardLibrary:
 		msg.WriteText(TS_ERROR, "Cannot define native function outside standard library")
 	case E_MissingFunctionDefinition:
@@ -577,6 +606,52 @@ func (err *FunctionError) Error() string {
 }
 
 
+type ImportError struct {
+	Point     ErrorPoint
+	Concrete  ConcreteImportError
+}
+
+type ConcreteImportError interface { ImportError() }
+
+func (impl E_InvalidImportTag) ImportError() {}
+type E_InvalidImportTag struct {
+	Tag   string
+	Info  string
+}
+
+func (err *ImportError) Desc() ErrorMessage {
+	var msg = make(ErrorMessage, 0)
+	switch e := err.Concrete.(type) {
+	case E_InvalidImportTag:
+		msg.WriteText(TS_ERROR, "Invalid import tag:")
+		msg.WriteInnerText(TS_INLINE_CODE, fmt.Sprintf("'%s'", e.Tag))
+		msg.WriteText(TS_ERROR, fmt.Sprintf("(%s)", e.Info))
+	default:
+		panic("unknown error kind")
+	}
+	return msg
+}
+
+func (err *ImportError) ErrorPoint() ErrorPoint {
+	return err.Point
+}
+
+func (err *ImportError) ErrorConcrete() interface{} {
+	return err.Concrete
+}
+
+func (err *ImportError) Message() ErrorMessage {
+	return FormatErrorAt(err.Point, err.Desc())
+}
+
+func (err *ImportError) Error() string {
+	var msg = MsgFailedToCompile(err.Concrete, [] ErrorMessage {
+		err.Message(),
+	})
+	return msg.String()
+}
+
+
 type ExprError struct {
 	Point     ErrorPoint
 	Concrete  ConcreteExprError
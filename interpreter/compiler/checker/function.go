@@ -309,6 +309,14 @@ func CollectFunctions (
 			},
 		} }
 		var func_type = sig.(*AnonymousType).Repr.(Func)
+		// 3.6.1. Check the signature of a test function
+		if tags.IsTest {
+			var reason = CheckTestFunction(func_type, params, implicit_fields)
+			if reason != "" { return nil, &FunctionError {
+				Point:    ErrorPointFrom(decl.Name.Node),
+				Concrete: E_InvalidTestFunction { reason },
+			} }
+		}
 		var add_function = func(name string, is_alias bool) (struct{}, *FunctionError) {
 			// 3.7. Construct a representation and a reference of the function
 			var additional = GenericFunctionInfo {
@@ -10,14 +10,21 @@ import (
 )
 
 
+const TestTag = "test"
+
 type FunctionTags struct {
-	AliasList  [] string
+	AliasList        [] string
+	AllowedWarnings  map[string] bool
 	FunctionServiceConfig
+	FunctionTestConfig
 	FunctionCompilationFlags
 }
 type FunctionServiceConfig struct {
 	IsServiceMethod  bool
 }
+type FunctionTestConfig struct {
+	IsTest  bool
+}
 type FunctionCompilationFlags struct {
 	ExplicitCall  bool   `flag:"explicit-call"`
 }
@@ -28,7 +35,10 @@ type FunctionTagParsingError struct {
 }
 
 func ParseFunctionTags(ast_tags ([] ast.Tag)) (FunctionTags, *FunctionTagParsingError) {
-	var tags = FunctionTags { AliasList: [] string {} }
+	var tags = FunctionTags {
+		AliasList:       [] string {},
+		AllowedWarnings: make(map[string] bool),
+	}
 	var occurred_alias = make(map[string] bool)
 	var flags_rv = reflect.ValueOf(&(tags.FunctionCompilationFlags))
 	var flags_t = flags_rv.Elem().Type()
@@ -40,6 +50,10 @@ func ParseFunctionTags(ast_tags ([] ast.Tag)) (FunctionTags, *FunctionTagParsing
 				continue
 			}
 		}
+		if raw == TestTag {
+			tags.IsTest = true
+			continue
+		}
 		for i := 0; i < flags_t.NumField(); i += 1 {
 			var flag_name = flags_t.Field(i).Tag.Get("flag")
 			if flag_name == "" { panic("something went wrong") }
@@ -77,6 +91,14 @@ func ParseFunctionTags(ast_tags ([] ast.Tag)) (FunctionTags, *FunctionTagParsing
 					}
 				}
 			}
+		} else if kind == AllowTagKind {
+			var err = parseAllowedWarnings(t[1], tags.AllowedWarnings)
+			if err != nil {
+				return FunctionTags{}, &FunctionTagParsingError {
+					Tag:  ast_tag,
+					Info: err.Error(),
+				}
+			}
 		} else {
 			return FunctionTags{}, &FunctionTagParsingError {
 				Tag:  ast_tag,
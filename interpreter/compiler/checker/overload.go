@@ -34,9 +34,10 @@ func OverloadedCall (
 ) (SemiExpr, *ExprError) {
 	if len(functions) == 0 { panic("something went wrong") }
 	if len(functions) == 1 {
+		var index = functions[0].Index
 		var f = functions[0].Function
 		call, err := GenericFunctionCall (
-			f, name, 0, type_args,
+			f, name, index, type_args,
 			arg, f_info, call_info, ctx,
 		)
 		if err != nil { return SemiExpr{}, err }
@@ -185,9 +186,10 @@ func OverloadedAssignTo (
 	var mod_name = ctx.GetModuleName()
 	if len(functions) == 0 { panic("something went wrong") }
 	if len(functions) == 1 {
+		var index = functions[0].Index
 		var f = functions[0].Function
 		return GenericFunctionAssignTo (
-			expected, name, 0, f, type_args, info, ctx,
+			expected, name, index, f, type_args, info, ctx,
 		)
 	} else {
 		var candidates = make([] UnavailableFuncInfo, 0)
@@ -0,0 +1,383 @@
+package checker
+
+import (
+	"sort"
+	"reflect"
+	"strings"
+	"kumachan/interpreter/def"
+	"kumachan/interpreter/lang/textual/ast"
+	"kumachan/interpreter/compiler/loader"
+	"kumachan/stdlib"
+)
+
+
+/**
+ *  Reference Index
+ *
+ *  The index records the declaration that each reference resolved to,
+ *  and the reverse map from declarations to their references. It is
+ *  built after all modules are checked, since overloaded calls are
+ *  checked speculatively and only the chosen overload should be taken.
+ *
+ *  References to functions and constants are collected from checked
+ *  expressions. References to types are resolved from type_ref nodes
+ *  and inline_ref nodes that did not resolve to a function.
+ *  References to fields are collected from checked field accesses.
+ *  References to local bindings are not recorded.
+ */
+
+type RefIndex struct {
+	Declarations  map[Declaration] bool
+	Definitions   map[ast.Node] Declaration       // reference -> declaration
+	References    map[Declaration] ([] ast.Node)  // declaration -> references
+}
+
+type Declaration struct {
+	Kind  DeclarationKind
+	Node  ast.Node  // declaration node of the function, type or field
+}
+type DeclarationKind int
+const (
+	DK_Function DeclarationKind = iota
+	DK_Constant
+	DK_Type
+	DK_Field
+)
+
+func CreateRefIndex() *RefIndex {
+	return &RefIndex {
+		Declarations: make(map[Declaration] bool),
+		Definitions:  make(map[ast.Node] Declaration),
+		References:   make(map[Declaration] ([] ast.Node)),
+	}
+}
+
+// Lookup finds the innermost reference containing the position
+// (rune index) in the specified source file.
+func (idx *RefIndex) Lookup(file string, pos int) (ast.Node, Declaration, bool) {
+	var found = false
+	var found_node ast.Node
+	var found_decl Declaration
+	for node, decl := range idx.Definitions {
+		if !(nodeContains(node, file, pos)) {
+			continue
+		}
+		if !(found) || nodeInside(node, found_node) {
+			found = true
+			found_node = node
+			found_decl = decl
+		}
+	}
+	return found_node, found_decl, found
+}
+
+// LookupDeclaration finds the innermost declaration containing
+// the position (rune index) in the specified source file.
+func (idx *RefIndex) LookupDeclaration(file string, pos int) (Declaration, bool) {
+	var found = false
+	var found_decl Declaration
+	for decl := range idx.Declarations {
+		if !(nodeContains(decl.Node, file, pos)) {
+			continue
+		}
+		if !(found) || nodeInside(decl.Node, found_decl.Node) {
+			found = true
+			found_decl = decl
+		}
+	}
+	return found_decl, found
+}
+
+// GetReferences returns references to the declaration, sorted by
+// file names and positions.
+func (idx *RefIndex) GetReferences(decl Declaration) ([] ast.Node) {
+	var refs = idx.References[decl]
+	var sorted = make([] ast.Node, len(refs))
+	copy(sorted, refs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		var a = sorted[i]
+		var b = sorted[j]
+		if a.CST.Name != b.CST.Name {
+			return a.CST.Name < b.CST.Name
+		} else {
+			return a.Span.Start < b.Span.Start
+		}
+	})
+	return sorted
+}
+
+func (idx *RefIndex) addDeclaration(decl Declaration) {
+	if decl.Node.CST == nil {
+		return
+	}
+	idx.Declarations[decl] = true
+}
+
+func (idx *RefIndex) addReference(node ast.Node, decl Declaration) {
+	if node.CST == nil || decl.Node.CST == nil {
+		return
+	}
+	var _, exists = idx.Definitions[node]
+	if exists {
+		return
+	}
+	idx.Definitions[node] = decl
+	idx.References[decl] = append(idx.References[decl], node)
+}
+
+func nodeContains(node ast.Node, file string, pos int) bool {
+	return (node.CST.Name == file &&
+		node.Span.Start <= pos && pos < node.Span.End)
+}
+
+func nodeInside(node ast.Node, another ast.Node) bool {
+	var a = node.Span
+	var b = another.Span
+	return (b.Start <= a.Start && a.End <= b.End && (a.End - a.Start) < (b.End - b.Start))
+}
+
+func BuildRefIndex(idx *RefIndex, index Index, ctx CheckContext) {
+	for _, g := range ctx.Types {
+		idx.addDeclaration(Declaration { Kind: DK_Type, Node: g.Node })
+		for _, field := range g.FieldInfo {
+			idx.addDeclaration(Declaration { Kind: DK_Field, Node: field.Node })
+		}
+	}
+	var mod_names = make([] string, 0, len(index))
+	for name := range index {
+		mod_names = append(mod_names, name)
+	}
+	sort.Strings(mod_names)
+	for _, name := range mod_names {
+		var mod = index[name]
+		var functions = ctx.Functions[mod.Name]
+		for _, group := range functions {
+			for _, f_ref := range group {
+				if !(f_ref.IsImported) {
+					idx.addDeclaration(getFunctionDeclaration(f_ref.Function))
+				}
+			}
+		}
+		var collector = refCollector {
+			index:     idx,
+			module:    mod.RawModule,
+			types:     ctx.Types,
+			functions: functions,
+		}
+		var func_names = make([] string, 0, len(mod.Functions))
+		for name := range mod.Functions {
+			func_names = append(func_names, name)
+		}
+		sort.Strings(func_names)
+		for _, name := range func_names {
+			for _, f := range mod.Functions[name] {
+				switch body := f.Body.(type) {
+				case BodyLambda:
+					collector.collectLambda(body.Lambda)
+				case BodyThunk:
+					collector.collectExpr(body.Value)
+				}
+			}
+		}
+		for _, effect := range mod.Effects {
+			collector.collectExpr(effect.Value)
+		}
+		collector.collectAst(mod.RawModule.AST)
+	}
+}
+
+func getFunctionDeclaration(f *GenericFunction) Declaration {
+	if f.IsFromConst {
+		return Declaration { Kind: DK_Constant, Node: f.Node }
+	} else {
+		return Declaration { Kind: DK_Function, Node: f.Node }
+	}
+}
+
+type refCollector struct {
+	index      *RefIndex
+	module     *loader.Module
+	types      TypeRegistry
+	functions  FunctionCollection
+}
+
+func (c refCollector) collectLambda(lambda Lambda) {
+	c.collectExpr(lambda.Output)
+}
+
+func (c refCollector) collectExpr(expr Expr) {
+	var node = expr.Info.ErrorPoint.Node
+	switch v := expr.Value.(type) {
+	case RefFunction:
+		var group = c.functions[v.Name]
+		if v.Index < uint(len(group)) {
+			var f = group[v.Index].Function
+			c.index.addReference(node, getFunctionDeclaration(f))
+		}
+	case Call:
+		c.collectExpr(v.Function)
+		c.collectExpr(v.Argument)
+	case Lambda:
+		c.collectLambda(v)
+	case Block:
+		for _, b := range v.Bindings {
+			c.collectExpr(b.Value)
+		}
+		c.collectExpr(v.Returned)
+	case Array:
+		for _, item := range v.Items {
+			c.collectExpr(item)
+		}
+	case Product:
+		for _, value := range v.Values {
+			c.collectExpr(value)
+		}
+	case Get:
+		c.collectExpr(v.Product)
+		var field, ok = c.getFieldNode(v.Product.Type, v.Index)
+		if ok {
+			c.index.addReference(node, Declaration { Kind: DK_Field, Node: field })
+		}
+	case Set:
+		c.collectExpr(v.Product)
+		c.collectExpr(v.NewValue)
+	case Reference:
+		c.collectExpr(v.Base)
+	case Sum:
+		c.collectExpr(v.Value)
+	case Switch:
+		c.collectExpr(v.Argument)
+		for _, b := range v.Branches {
+			c.collectExpr(b.Value)
+		}
+	case MultiSwitch:
+		for _, arg := range v.Arguments {
+			c.collectExpr(arg)
+		}
+		for _, b := range v.Branches {
+			c.collectExpr(b.Value)
+		}
+	}
+}
+
+func (c refCollector) getFieldNode(t Type, index uint) (ast.Node, bool) {
+	var named, is_named = t.(*NamedType)
+	if !(is_named) {
+		return ast.Node {}, false
+	}
+	var g, exists = c.types[named.Name]
+	if !(exists) {
+		return ast.Node {}, false
+	}
+	var boxed, is_boxed = g.Definition.(*Boxed)
+	if !(is_boxed) {
+		return ast.Node {}, false
+	}
+	switch inner := boxed.InnerType.(type) {
+	case *NamedType:
+		return c.getFieldNode(inner, index)
+	case *AnonymousType:
+		var record, is_record = inner.Repr.(Record)
+		if !(is_record) {
+			return ast.Node {}, false
+		}
+		for name, field := range record.Fields {
+			if field.Index == index {
+				var info, exists = g.FieldInfo[name]
+				return info.Node, exists
+			}
+		}
+	}
+	return ast.Node {}, false
+}
+
+// collectAst collects references to types, which are not available
+// in checked expressions, e.g. types in signatures and boxing calls.
+func (c refCollector) collectAst(root ast.Root) {
+	var visit func(v reflect.Value, params ([] ast.TypeParam))
+	visit = func(v reflect.Value, params ([] ast.TypeParam)) {
+		switch v.Kind() {
+		case reflect.Interface:
+			if !(v.IsNil()) {
+				visit(v.Elem(), params)
+			}
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i += 1 {
+				visit(v.Index(i), params)
+			}
+		case reflect.Struct:
+			switch node := v.Interface().(type) {
+			case ast.Node:
+				return
+			case ast.DeclFunction:
+				params = node.Params
+			case ast.DeclType:
+				params = node.Params
+			case ast.TypeRef:
+				c.collectTypeRef(node, params)
+			case ast.InlineRef:
+				c.collectInlineRef(node)
+			}
+			for i := 0; i < v.NumField(); i += 1 {
+				visit(v.Field(i), params)
+			}
+		}
+	}
+	visit(reflect.ValueOf(root), nil)
+}
+
+func (c refCollector) collectTypeRef(ref ast.TypeRef, params ([] ast.TypeParam)) {
+	var name = ast.Id2String(ref.Id)
+	if len(ref.Module.Name) == 0 {
+		for _, param := range params {
+			if ast.Id2String(param.Name) == name {
+				return
+			}
+		}
+	}
+	var sym, ok = c.module.SymbolFromTypeRef(ref).(def.Symbol)
+	if !(ok) {
+		return
+	}
+	var g, exists = c.lookupType(sym)
+	if exists {
+		c.index.addReference(ref.Node, Declaration { Kind: DK_Type, Node: g.Node })
+	}
+}
+
+func (c refCollector) collectInlineRef(ref ast.InlineRef) {
+	var _, recorded = c.index.Definitions[ref.Node]
+	if recorded {
+		return
+	}
+	var sym, ok = c.module.SymbolFromInlineRef(ref).(def.Symbol)
+	if !(ok) {
+		return
+	}
+	if sym.ModuleName == "" {
+		var core_sym = def.MakeSymbol(stdlib.Mod_core, sym.SymbolName)
+		var g, exists = c.lookupType(core_sym)
+		if exists {
+			c.index.addReference(ref.Node, Declaration { Kind: DK_Type, Node: g.Node })
+			return
+		}
+		sym = def.MakeSymbol(c.module.Name, sym.SymbolName)
+	}
+	var g, exists = c.lookupType(sym)
+	if exists {
+		c.index.addReference(ref.Node, Declaration { Kind: DK_Type, Node: g.Node })
+	}
+}
+
+func (c refCollector) lookupType(sym def.Symbol) (*GenericType, bool) {
+	var g, exists = c.types[sym]
+	if exists {
+		return g, true
+	}
+	if strings.HasSuffix(sym.SymbolName, ForceExactSuffix) {
+		var name = strings.TrimSuffix(sym.SymbolName, ForceExactSuffix)
+		var g, exists = c.types[def.MakeSymbol(sym.ModuleName, name)]
+		return g, exists
+	}
+	return nil, false
+}
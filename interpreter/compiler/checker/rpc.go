@@ -169,12 +169,21 @@ func CollectKmdApi (
 			Statement: CraftKmdApiFunction(sym, reg, nodes,
 				kmd.DeserializerId { TypeId: id }),
 		}
-		var _, exists = inj[mod]
-		if exists {
-			inj[mod] = append(inj[mod], serializer, deserializer)
-		} else {
-			inj[mod] = [] ast.VariousStatement { serializer, deserializer }
+		var functions = [] ast.VariousStatement { serializer, deserializer }
+		for _, f := range [] kmd.Format { kmd.JsonFormat, kmd.MsgpackFormat } {
+			var encoder = ast.VariousStatement {
+				Node:      nodes[sym],
+				Statement: CraftKmdApiFunction(sym, reg, nodes,
+					kmd.EncoderId { TypeId: id, Format: f }),
+			}
+			var decoder = ast.VariousStatement {
+				Node:      nodes[sym],
+				Statement: CraftKmdApiFunction(sym, reg, nodes,
+					kmd.DecoderId { TypeId: id, Format: f }),
+			}
+			functions = append(functions, encoder, decoder)
 		}
+		inj[mod] = append(inj[mod], functions...)
 	}
 	return mapping, sch, inj, nil
 }
@@ -313,11 +322,30 @@ func CraftKmdApiFunction (
 		}
 	}
 	var binary_t = make_type(stdlib.Bytes)
+	var string_t = make_type(stdlib.String)
 	var object_t = make_type(sym.SymbolName)
 	var error_t = make_type(stdlib.Error)
+	var result_t = ast.VariousType {
+		Node: node,
+		Type: ast.TypeRef {
+			Node:     node,
+			Id:       ast.Identifier {
+				Node: node,
+				Name: ([] rune)(stdlib.Result),
+			},
+			TypeArgs: [] ast.VariousType { object_t, error_t },
+		},
+	}
+	// JSON is text, and other formats are binary
+	var data_t = func(f kmd.Format) ast.VariousType {
+		switch f {
+		case kmd.JsonFormat: return string_t
+		default:             return binary_t
+		}
+	}
 	var name string
 	var sig ast.ReprFunc
-	switch id.(type) {
+	switch id := id.(type) {
 	case kmd.SerializerId:
 		name = KmdSerializerName
 		sig = ast.ReprFunc {
@@ -330,17 +358,29 @@ func CraftKmdApiFunction (
 		sig = ast.ReprFunc {
 			Node:   node,
 			Input:  binary_t,
-			Output: ast.VariousType {
-				Node: node,
-				Type: ast.TypeRef {
-					Node:     node,
-					Id:       ast.Identifier {
-						Node: node,
-						Name: ([] rune)(stdlib.Result),
-					},
-					TypeArgs: [] ast.VariousType { object_t, error_t },
-				},
-			},
+			Output: result_t,
+		}
+	case kmd.EncoderId:
+		switch id.Format {
+		case kmd.JsonFormat:    name = KmdJsonEncoderName
+		case kmd.MsgpackFormat: name = KmdMsgpackEncoderName
+		default:                panic("impossible branch")
+		}
+		sig = ast.ReprFunc {
+			Node:   node,
+			Input:  object_t,
+			Output: data_t(id.Format),
+		}
+	case kmd.DecoderId:
+		switch id.Format {
+		case kmd.JsonFormat:    name = KmdJsonDecoderName
+		case kmd.MsgpackFormat: name = KmdMsgpackDecoderName
+		default:                panic("impossible branch")
+		}
+		sig = ast.ReprFunc {
+			Node:   node,
+			Input:  data_t(id.Format),
+			Output: result_t,
 		}
 	default:
 		panic("impossible branch")
@@ -404,7 +444,40 @@ func GetKmdSchema (
 			inner = def.InnerType
 		}
 		var generic = len(g.Params) > 0
-		return GetKmdInnerTypeSchema(id, generic, inner, p, reg, mapping)
+		var schema, err = GetKmdInnerTypeSchema(id, generic, inner, p, reg, mapping)
+		if err != nil { return nil, err }
+		var record, is_record = schema.(kmd.RecordSchema)
+		if is_record {
+			record.AllowUnknown = g.Tags.DataConfig.AllowUnknown
+			for name, info := range g.FieldInfo {
+				if info.Tags.Optional {
+					var field = record.Fields[name]
+					var kind = field.Type.Kind()
+					if info.Tags.Default != "" {
+						var err = kmd.ValidateDefaultLiteral(info.Tags.Default, field.Type)
+						if err != nil {
+							return nil, &KmdError {
+								Point:    ErrorPointFrom(info.Node),
+								Concrete: E_KmdFieldInvalidDefault {
+									FieldName: name,
+									Reason:    err.Error(),
+								},
+							}
+						}
+						field.DefaultLiteral = info.Tags.Default
+					} else if !(kind == kmd.Optional || kind == kmd.Array) {
+						return nil, &KmdError {
+							Point:    ErrorPointFrom(info.Node),
+							Concrete: E_KmdFieldNotOptional { name },
+						}
+					}
+					field.Optional = true
+					record.Fields[name] = field
+				}
+			}
+			return record, nil
+		}
+		return schema, nil
 	case *Enum:
 		var index_map = make(map[kmd.TypeId] uint)
 		for i, case_t := range def.CaseTypes {
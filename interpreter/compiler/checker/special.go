@@ -19,10 +19,15 @@ const BadIndex = ^(uint(0))
 const DefaultValueGetter = "@default"
 const KmdSerializerName = "@serialize"
 const KmdDeserializerName = "@deserialize"
+const KmdJsonEncoderName = "@encode-json"
+const KmdJsonDecoderName = "@decode-json"
+const KmdMsgpackEncoderName = "@encode-msgpack"
+const KmdMsgpackDecoderName = "@decode-msgpack"
 const KmdAdapterName = "@adapt"
 const KmdValidatorName = "@validate"
 var __Observable = CoreSymbol(stdlib.Observable)
 var __Async = CoreSymbol(stdlib.Async)
+var __Sync = CoreSymbol(stdlib.Sync)
 var __ProjRef = CoreSymbol(stdlib.ProjRef)
 var __CaseRef = CoreSymbol(stdlib.CaseRef)
 var __ProjRefParams = [] TypeParam {
@@ -0,0 +1,50 @@
+package checker
+
+
+/**
+ *  Test Functions
+ *
+ *  A function or a constant tagged with `# test` is a test function,
+ *  which is executed by the test runner (`--mode=test`) instead of
+ *  being called by other functions. A test function
+ *    (1) takes no argument, i.e. its input type is the unit type;
+ *    (2) returns an effect (Sync, Async or Observable);
+ *    (3) does not have type parameters or an implicit context.
+ *  A test passes if its effect completes, and fails if its effect
+ *    throws an error or a runtime error (e.g. a failed `assert`) occurs.
+ *  Test functions are not reported as unused private functions.
+ */
+
+// CheckTestFunction returns the reason why a function cannot be a test
+// function, or an empty string if it can be.
+func CheckTestFunction (
+	t         Func,
+	params    [] TypeParam,
+	implicit  map[string] Field,
+) string {
+	if len(params) > 0 {
+		return "a test function cannot have type parameters"
+	}
+	if len(implicit) > 0 {
+		return "a test function cannot have an implicit context"
+	}
+	var is_unit_input = (func() bool {
+		switch T := t.Input.(type) {
+		case *AnonymousType:
+			switch T.Repr.(type) {
+			case Unit:
+				return true
+			}
+		}
+		return false
+	})()
+	if !(is_unit_input) {
+		return "the input type of a test function should be the unit type"
+	}
+	var named, is_named = t.Output.(*NamedType)
+	if !(is_named) ||
+		!(named.Name == __Sync || named.Name == __Async || named.Name == __Observable) {
+		return "the output type of a test function should be an effect type"
+	}
+	return ""
+}
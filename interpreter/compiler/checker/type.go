@@ -31,6 +31,7 @@ func TypeParamsNames(params ([] TypeParam)) ([] string) {
 	return draft
 }
 type FieldInfo struct {
+	Node  ast.Node
 	Doc   string
 	Tags  FieldTags
 }
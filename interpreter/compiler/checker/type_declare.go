@@ -345,6 +345,7 @@ func RegisterTypes(entry *loader.Module, idx loader.Index) (TypeRegistry, TypeDe
 								field_info = make(map[string] FieldInfo)
 							}
 							field_info[name] = FieldInfo {
+								Node: f.Node,
 								Doc:  doc,
 								Tags: tags,
 							}
@@ -15,8 +15,9 @@ type TypeTags struct {
 	TypeServiceConfig
 }
 type TypeDataConfig struct {
-	Name     string
-	Version  string
+	Name          string
+	Version       string
+	AllowUnknown  bool
 }
 type TypeServiceConfig struct {
 	IsServiceArgument  bool
@@ -65,6 +66,17 @@ func ParseTypeTags(ast_tags ([] ast.Tag)) (TypeTags, *TypeTagParsingError) {
 								Info: fmt.Sprintf("invalid value for item 'name': %s", val),
 							}
 						}
+					} else if key == "unknown" {
+						if val == "skip" {
+							tags.DataConfig.AllowUnknown = true
+						} else if val == "reject" {
+							tags.DataConfig.AllowUnknown = false
+						} else {
+							return TypeTags{}, &TypeTagParsingError {
+								Tag:  ast_tag,
+								Info: fmt.Sprintf("invalid value for item 'unknown': %s", val),
+							}
+						}
 					} else if key == "ver" {
 						if syntax.GetIdentifierFullRegexp().MatchString(val) {
 							tags.DataConfig.Version = val
@@ -117,15 +129,40 @@ func ValidateTypeTags(tags TypeTags) error {
 }
 
 
-type FieldTags struct {}
+type FieldTags struct {
+	Optional  bool
+	Default   string  // JSON literal, implies Optional
+}
 
 type FieldTagParsingError struct {
 	Tag   ast.Tag
 	Info  string
 }
 
-func ParseFieldTags(_ ([] ast.Tag)) (FieldTags, *FieldTagParsingError) {
-	return FieldTags{}, nil
+func ParseFieldTags(ast_tags ([] ast.Tag)) (FieldTags, *FieldTagParsingError) {
+	var tags FieldTags
+	for _, ast_tag := range ast_tags {
+		var raw = ast.GetTagContent(ast_tag)
+		if raw == "optional" {
+			tags.Optional = true
+		} else if strings.HasPrefix(raw, "default:") {
+			var literal = strings.Trim(strings.TrimPrefix(raw, "default:"), " ")
+			if literal == "" {
+				return FieldTags{}, &FieldTagParsingError {
+					Tag:  ast_tag,
+					Info: "missing default value",
+				}
+			}
+			tags.Optional = true
+			tags.Default = literal
+		} else {
+			return FieldTags{}, &FieldTagParsingError {
+				Tag:  ast_tag,
+				Info: fmt.Sprintf("invalid field tag: %s", raw),
+			}
+		}
+	}
+	return tags, nil
 }
 
 
@@ -0,0 +1,382 @@
+package checker
+
+import (
+	"fmt"
+	"sort"
+	"errors"
+	"reflect"
+	"strings"
+	"kumachan/interpreter/compiler/loader"
+	"kumachan/interpreter/lang/textual/ast"
+	. "kumachan/standalone/util/error"
+)
+
+
+/**
+ *  Warnings
+ *
+ *  Warnings are problems that do not prevent a program from being
+ *  compiled. They are collected from checked modules after the type
+ *  checking succeeded, and reported separately from errors.
+ *
+ *  (1) unused-import: an imported module that is never referenced,
+ *      neither by a qualified name nor by a function imported from it.
+ *  (2) shadowing: a pattern variable (of a `let`, a lambda or a branch)
+ *      with the same name as a binding in an outer scope.
+ *
+ *  A warning can be suppressed by a tag on the declaration containing it,
+ *  e.g. `# allow: shadowing` on a function or a constant,
+ *  and `# allow: unused-import` on an import statement.
+ *  Note that unused bindings are not warnings but errors,
+ *    which are reported by the code generator.
+ *  Standard library modules are not inspected.
+ */
+
+const AllowTagKind = "allow"
+const (
+	WarnUnusedImport  =  "unused-import"
+	WarnShadowing     =  "shadowing"
+)
+var __WarningKinds = [] string {
+	WarnUnusedImport,
+	WarnShadowing,
+}
+
+type Warning struct {
+	Point     ErrorPoint
+	Concrete  ConcreteWarning
+}
+
+type ConcreteWarning interface { WarningKind() string }
+
+func (impl W_UnusedImport) WarningKind() string { return WarnUnusedImport }
+type W_UnusedImport struct {
+	Alias  string
+}
+func (impl W_Shadowing) WarningKind() string { return WarnShadowing }
+type W_Shadowing struct {
+	Name  string
+}
+
+func (w *Warning) Desc() ErrorMessage {
+	var msg = make(ErrorMessage, 0)
+	switch e := w.Concrete.(type) {
+	case W_UnusedImport:
+		msg.WriteText(TS_WARNING, "Unused import")
+		msg.WriteEndText(TS_INLINE_CODE, e.Alias)
+	case W_Shadowing:
+		msg.WriteText(TS_WARNING, "Binding")
+		msg.WriteInnerText(TS_INLINE_CODE, e.Name)
+		msg.WriteText(TS_WARNING, "shadows an outer binding")
+	default:
+		panic("unknown warning kind")
+	}
+	msg.WriteEndText(TS_INFO, fmt.Sprintf("(%s)", w.Concrete.WarningKind()))
+	return msg
+}
+
+func (w *Warning) ErrorPoint() ErrorPoint {
+	return w.Point
+}
+
+func (w *Warning) ErrorConcrete() interface{} {
+	return w.Concrete
+}
+
+func (w *Warning) Message() ErrorMessage {
+	return FormatErrorAt(w.Point, w.Desc())
+}
+
+
+type ImportTags struct {
+	AllowedWarnings  map[string] bool
+}
+
+type ImportTagParsingError struct {
+	Tag   ast.Tag
+	Info  string
+}
+
+func ParseImportTags(ast_tags ([] ast.Tag)) (ImportTags, *ImportTagParsingError) {
+	var tags = ImportTags { AllowedWarnings: make(map[string] bool) }
+	for _, ast_tag := range ast_tags {
+		var raw = ast.GetTagContent(ast_tag)
+		var t = strings.Split(raw, ":")
+		if len(t) != 2 {
+			return ImportTags{}, &ImportTagParsingError {
+				Tag:  ast_tag,
+				Info: "wrong format",
+			}
+		}
+		var kind = t[0]
+		if kind != AllowTagKind {
+			return ImportTags{}, &ImportTagParsingError {
+				Tag:  ast_tag,
+				Info: fmt.Sprintf("invalid import tag kind: %s", kind),
+			}
+		}
+		var err = parseAllowedWarnings(t[1], tags.AllowedWarnings)
+		if err != nil {
+			return ImportTags{}, &ImportTagParsingError {
+				Tag:  ast_tag,
+				Info: err.Error(),
+			}
+		}
+	}
+	return tags, nil
+}
+
+func parseAllowedWarnings(list string, allowed (map[string] bool)) error {
+	outer: for _, item := range strings.Split(list, ",") {
+		item = strings.Trim(item, " ")
+		for _, kind := range __WarningKinds {
+			if item == kind {
+				allowed[item] = true
+				continue outer
+			}
+		}
+		return errors.New(fmt.Sprintf("unknown warning kind: %s", item))
+	}
+	return nil
+}
+
+
+// CollectWarnings finds warnings in all checked modules in the index,
+// except for modules of the standard library.
+func CollectWarnings(index Index) ([] *Warning) {
+	var warnings = make([] *Warning, 0)
+	for name, mod := range index {
+		if mod == nil || loader.IsStdLibModule(name) {
+			continue
+		}
+		warnings = append(warnings, collectModuleWarnings(mod)...)
+	}
+	sort.SliceStable(warnings, func(i, j int) bool {
+		var a = warnings[i].Point.Node
+		var b = warnings[j].Point.Node
+		if a.CST.Name != b.CST.Name {
+			return a.CST.Name < b.CST.Name
+		} else {
+			return a.Span.Start < b.Span.Start
+		}
+	})
+	return warnings
+}
+
+func collectModuleWarnings(mod *CheckedModule) ([] *Warning) {
+	var warnings = make([] *Warning, 0)
+	var c = &warningCollector {
+		functions: mod.Context.Functions[mod.Name],
+		used:      make(map[string] bool),
+		warnings:  &warnings,
+	}
+	for _, group := range mod.Functions {
+		for _, f := range group {
+			c.allowed = f.AllowedWarnings
+			var root = &localScope {
+				bindings: make(map[string] bool),
+			}
+			for _, name := range f.Implicit {
+				root.bindings[name] = true
+			}
+			switch body := f.Body.(type) {
+			case BodyLambda:
+				c.walkLambda(body.Lambda, root)
+			case BodyThunk:
+				c.walk(body.Value, root)
+			}
+		}
+	}
+	c.allowed = nil
+	for _, effect := range mod.Effects {
+		c.walk(effect.Value, nil)
+	}
+	var aliases = collectModuleAliases(mod.RawModule.AST)
+	for _, stmt := range mod.RawModule.AST.Statements {
+		var imp, is_import = stmt.Statement.(ast.Import)
+		if !(is_import) {
+			continue
+		}
+		var alias = ast.Id2String(imp.Name)
+		var imported, exists = mod.RawModule.ImpMap[alias]
+		if !(exists) || aliases[alias] || c.used[imported.Name] {
+			continue
+		}
+		var tags, _ = ParseImportTags(imp.Tags)
+		if tags.AllowedWarnings[WarnUnusedImport] {
+			continue
+		}
+		warnings = append(warnings, &Warning {
+			Point:    ErrorPointFrom(imp.Node),
+			Concrete: W_UnusedImport { alias },
+		})
+	}
+	return warnings
+}
+
+type warningCollector struct {
+	functions  FunctionCollection
+	allowed    map[string] bool
+	used       map[string] bool  // names of modules that functions are used
+	warnings   *([] *Warning)
+}
+
+type localScope struct {
+	parent    *localScope
+	bindings  map[string] bool
+}
+
+func (s *localScope) lookup(name string) bool {
+	for current := s; current != nil; current = current.parent {
+		if current.bindings[name] {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *warningCollector) add(point ErrorPoint, w ConcreteWarning) {
+	if c.allowed[w.WarningKind()] {
+		return
+	}
+	*(c.warnings) = append(*(c.warnings), &Warning {
+		Point:    point,
+		Concrete: w,
+	})
+}
+
+func (c *warningCollector) declare(p MaybePattern, scope *localScope) *localScope {
+	var new_scope = &localScope {
+		parent:   scope,
+		bindings: make(map[string] bool),
+	}
+	var declare_name = func(name string, point ErrorPoint) {
+		if name == IgnoreMark {
+			return
+		}
+		if scope.lookup(name) {
+			c.add(point, W_Shadowing { name })
+		}
+		new_scope.bindings[name] = true
+	}
+	var pattern, ok = p.(Pattern)
+	if !(ok) {
+		return new_scope
+	}
+	switch P := pattern.Concrete.(type) {
+	case TrivialPattern:
+		declare_name(P.ValueName, P.Point)
+	case TuplePattern:
+		for _, item := range P.Items {
+			declare_name(item.Name, item.Point)
+		}
+	case RecordPattern:
+		for _, item := range P.Items {
+			declare_name(item.Name, item.Point)
+		}
+	}
+	return new_scope
+}
+
+func (c *warningCollector) walkLambda(lambda Lambda, scope *localScope) {
+	var inner = c.declare(lambda.Input, scope)
+	c.walk(lambda.Output, inner)
+}
+
+func (c *warningCollector) walk(expr Expr, scope *localScope) {
+	c.walkValue(expr.Value, scope)
+}
+
+func (c *warningCollector) walkValue(value ExprVal, scope *localScope) {
+	switch v := value.(type) {
+	case RefFunction:
+		var group = c.functions[v.Name]
+		if v.Index < uint(len(group)) && group[v.Index].IsImported {
+			c.used[group[v.Index].ModuleName] = true
+		}
+		for _, implicit := range v.Implicit {
+			c.walkValue(implicit, scope)
+		}
+	case RefConstant:
+		c.used[v.Name.ModuleName] = true
+	case Call:
+		c.walk(v.Function, scope)
+		c.walk(v.Argument, scope)
+	case Lambda:
+		c.walkLambda(v, scope)
+	case Block:
+		var current = scope
+		for _, b := range v.Bindings {
+			if b.Recursive {
+				current = c.declare(b.Pattern, current)
+				c.walk(b.Value, current)
+			} else {
+				c.walk(b.Value, current)
+				current = c.declare(b.Pattern, current)
+			}
+		}
+		c.walk(v.Returned, current)
+	case Array:
+		for _, item := range v.Items {
+			c.walk(item, scope)
+		}
+	case Product:
+		for _, value := range v.Values {
+			c.walk(value, scope)
+		}
+	case Get:
+		c.walk(v.Product, scope)
+	case Set:
+		c.walk(v.Product, scope)
+		c.walk(v.NewValue, scope)
+	case Reference:
+		c.walk(v.Base, scope)
+	case Sum:
+		c.walk(v.Value, scope)
+	case Switch:
+		c.walk(v.Argument, scope)
+		for _, b := range v.Branches {
+			c.walk(b.Value, c.declare(b.Pattern, scope))
+		}
+	case MultiSwitch:
+		for _, arg := range v.Arguments {
+			c.walk(arg, scope)
+		}
+		for _, b := range v.Branches {
+			c.walk(b.Value, c.declare(b.Pattern, scope))
+		}
+	}
+}
+
+// collectModuleAliases collects aliases of modules used in qualified
+// references, e.g. `mod::name` and `mod::Type`.
+func collectModuleAliases(root ast.Root) (map[string] bool) {
+	var aliases = make(map[string] bool)
+	var visit func(v reflect.Value)
+	visit = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Interface:
+			if !(v.IsNil()) {
+				visit(v.Elem())
+			}
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i += 1 {
+				visit(v.Index(i))
+			}
+		case reflect.Struct:
+			switch node := v.Interface().(type) {
+			case ast.Node:
+				return
+			case ast.TypeRef:
+				aliases[ast.Id2String(node.Module)] = true
+			case ast.InlineRef:
+				aliases[ast.Id2String(node.Module)] = true
+			}
+			for i := 0; i < v.NumField(); i += 1 {
+				visit(v.Field(i))
+			}
+		}
+	}
+	visit(reflect.ValueOf(root))
+	return aliases
+}
@@ -94,7 +94,7 @@ func CompileFunction (
 	case ch.BodyGenerated:
 		return &def.Function {
 			Kind:      def.F_GENERATED,
-			Generated: b.Value,
+			Generated: b.Seed,
 			Code:      nil,
 			BaseSize:  def.FrameBaseSize {},
 			Info: def.FuncInfo {
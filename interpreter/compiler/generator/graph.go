@@ -0,0 +1,153 @@
+package generator
+
+import (
+	ch "kumachan/interpreter/compiler/checker"
+	. "kumachan/standalone/util/error"
+)
+
+
+// DepGraph is the dependency graph of functions and effects of a program,
+// which is checked for unused private functions and circular dependencies
+// among thunks. It is shared by code generators targeting different VMs.
+type DepGraph struct {
+	Functions  [] DepNode
+	Effects    [] DepNode
+}
+type DepNode struct {
+	Name          string
+	Point         ErrorPoint
+	Dependencies  [] uint  // indexes of functions (closures flattened)
+	ch.FunctionGeneratorFlags
+}
+
+func (g DepGraph) Check() E {
+	var err = g.checkUnused()
+	if err != nil { return err }
+	return g.checkThunks()
+}
+
+func (g DepGraph) checkUnused() E {
+	var functions = g.Functions
+	var used = make([] bool, len(functions))
+	var visited = make([] bool, len(functions))
+	var mark_dep_used func(DepNode, bool, uint)
+	mark_dep_used = func(f DepNode, do_skip bool, skip uint) {
+		for _, index := range f.Dependencies {
+			if visited[index] {
+				continue
+			} else {
+				visited[index] = true
+			}
+			if !(do_skip) || index != skip {
+				used[index] = true
+			}
+			mark_dep_used(functions[index], do_skip, skip)
+		}
+	}
+	for i, f := range functions {
+		if f.Exported || f.IsTest {
+			mark_dep_used(f, true, uint(i))
+		}
+	}
+	for _, e := range g.Effects {
+		mark_dep_used(e, false, ^uint(0))
+	}
+	var unused = make([] uint, 0)
+	for i, f := range functions {
+		if !(f.Exported) && !(f.KmdRelated) && !(f.IsTest) && !(used[i]) {
+			unused = append(unused, uint(i))
+		}
+	}
+	if len(unused) > 0 {
+		var all_names = make([] string, len(unused))
+		for i, index := range unused {
+			all_names[i] = functions[index].Name
+		}
+		return &Error {
+			Point:    functions[unused[0]].Point,
+			Concrete: E_UnusedPrivateFunctions { Names: all_names },
+		}
+	}
+	return nil
+}
+
+func (g DepGraph) checkThunks() E {
+	var functions = g.Functions
+	var thunk_index_map = make(map[uint] uint)
+	var thunks = make([] DepNode, 0)
+	for i, f := range functions {
+		if f.ConsideredThunk {
+			thunk_index_map[uint(i)] = uint(len(thunks))
+			thunks = append(thunks, f)
+		}
+	}
+	var thunk_dep_map = make([][] uint, len(thunks))
+	for thunk_index, thunk := range thunks {
+		var dep_indexes = make([] uint, 0)
+		var visited_index_map = make(map[uint] bool)
+		var collect_deps_from func(DepNode)
+		collect_deps_from = func(f DepNode) {
+			for _, dep_f_index := range f.Dependencies {
+				var dep_index, this_is_thunk = thunk_index_map[dep_f_index]
+				if this_is_thunk {
+					dep_indexes = append(dep_indexes, dep_index)
+					continue
+				}
+				if !(visited_index_map[dep_f_index]) {
+					visited_index_map[dep_f_index] = true
+					collect_deps_from(functions[dep_f_index])
+				}
+			}
+		}
+		collect_deps_from(thunk)
+		thunk_dep_map[thunk_index] = dep_indexes
+	}
+	var L = uint(len(thunks))
+	var in_degrees = make([] uint, L)
+	var inv_map = make([][] uint, L)
+	for i := uint(0); i < L; i += 1 {
+		inv_map[i] = make([] uint, 0)
+	}
+	for i := uint(0); i < L; i += 1 {
+		var deps = thunk_dep_map[i]
+		in_degrees[i] = uint(len(deps))
+		for _, dep := range deps {
+			inv_map[dep] = append(inv_map[dep], i)
+		}
+	}
+	var queue = make([] uint, 0)
+	for i := uint(0); i < L; i += 1 {
+		if in_degrees[i] == 0 {
+			queue = append(queue, i)
+		}
+	}
+	var sorted_count = uint(0)
+	for len(queue) > 0 {
+		var i = queue[0]
+		queue = queue[1:]
+		sorted_count += 1
+		for _, j := range inv_map[i] {
+			if in_degrees[j] < 1 { panic("something went wrong") }
+			in_degrees[j] -= 1
+			if in_degrees[j] == 0 {
+				queue = append(queue, j)
+			}
+		}
+	}
+	if sorted_count < L {
+		var rest_names = make([] string, 0)
+		var point ErrorPoint
+		for i := uint(0); i < L; i += 1 {
+			if in_degrees[i] > 0 {
+				rest_names = append(rest_names, thunks[i].Name)
+				point = thunks[i].Point
+			}
+		}
+		if len(rest_names) == 0 { panic("something went wrong") }
+		return &Error {
+			Point:    point,
+			Concrete: E_CircularThunkDependency { rest_names },
+		}
+	}
+	return nil
+}
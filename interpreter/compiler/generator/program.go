@@ -28,9 +28,6 @@ func CreateProgram (
 	}
 	var function_index_map = make(map[DepFunction] uint)
 	var functions = make([] FuncNode, 0)
-	var thunk_index_map = make(map[DepFunction] uint)
-	var thunk_names = make([] string, 0)
-	var thunks = make([] FuncNode, 0)
 	for mod_name, mod := range idx {
 		for f_name, items := range mod.Functions {
 			for f_index, item := range items {
@@ -42,12 +39,6 @@ func CreateProgram (
 					Index:  uint(f_index),
 				}
 				function_index_map[dep] = global_index
-				if item.ConsideredThunk {
-					var name = fmt.Sprintf("%s::%s", mod_name, f_name)
-					thunk_index_map[dep] = uint(len(thunk_index_map))
-					thunk_names = append(thunk_names, name)
-					thunks = append(thunks, item)
-				}
 			}
 		}
 	}
@@ -63,145 +54,39 @@ func CreateProgram (
 			panic("something went wrong")
 		}
 	}
-	var used = make([] bool, len(functions))
-	var visited = make([] bool, len(functions))
-	var mark_dep_used func(FuncNode, bool, uint)
-	mark_dep_used = func(f FuncNode, do_skip bool, skip uint) {
+	var get_graph_deps func(FuncNode) ([] uint)
+	get_graph_deps = func(f FuncNode) ([] uint) {
+		var deps = make([] uint, 0)
 		for _, dep := range f.Dependencies {
 			switch D := dep.(type) {
 			case DepClosure:
-				mark_dep_used(closures[D.Index], do_skip, skip)
+				deps = append(deps, get_graph_deps(closures[D.Index])...)
 			case DepFunction:
-				var index = get_function_index(D)
-				if visited[index] {
-					continue
-				} else {
-					visited[index] = true
-				}
-				if do_skip {
-					if index != skip {
-						used[index] = true
-					}
-				} else {
-					used[index] = true
-				}
-				mark_dep_used(functions[index], do_skip, skip)
+				deps = append(deps, get_function_index(D))
 			}
 		}
+		return deps
 	}
-	for i, f := range functions {
-		if f.Exported {
-			mark_dep_used(f, true, uint(i))
-		}
-	}
-	for _, e := range effects {
-		mark_dep_used(e, false, ^uint(0))
+	var graph = DepGraph {
+		Functions: make([] DepNode, len(functions)),
+		Effects:   make([] DepNode, len(effects)),
 	}
-	var unused = make([] uint, 0)
 	for i, f := range functions {
-		if !(f.Exported) && !(f.KmdRelated) && !(used[i]) {
-			unused = append(unused, uint(i))
-		}
-	}
-	if len(unused) > 0 {
-		var first_info = functions[unused[0]].Underlying.Info
-		var point = first_info.DeclPoint
-		var all_names = make([] string, len(unused))
-		for i, index := range unused {
-			var info = functions[index].Underlying.Info
-			all_names[i] = fmt.Sprintf("%s::%s", info.Module, info.Name)
-		}
-		return def.Program{}, DepLocator{}, &Error {
-			Point:    point,
-			Concrete: E_UnusedPrivateFunctions { Names: all_names },
-		}
-	}
-	var get_thunk_index = func(d DepFunction) (uint, bool) {
-		var index, exists = thunk_index_map[d]
-		return index, exists
-	}
-	var thunk_dep_map = make([][] uint, len(thunks))
-	for thunk_index, thunk := range thunks {
-		var dep_indexes = make([] uint, 0)
-		var visited_index_map = make(map[uint] bool)
-		var collect_deps_from func(FuncNode)
-		collect_deps_from = func(f FuncNode) {
-			for _, f_dep := range f.Dependencies {
-				switch D := f_dep.(type) {
-				case DepFunction:
-					{
-						var dep_index, this_is_thunk =
-							get_thunk_index(D)
-						if this_is_thunk {
-							dep_indexes = append(dep_indexes, dep_index)
-							break
-						}
-					}
-					var dep_f_index = get_function_index(D)
-					var _, exists = visited_index_map[dep_f_index]
-					if !(exists) {
-						visited_index_map[dep_f_index] = true
-						var dep_f = functions[dep_f_index]
-						collect_deps_from(dep_f)
-					}
-				case DepClosure:
-					var closure = closures[D.Index]
-					collect_deps_from(closure)
-				default:
-					// do nothing
-				}
-			}
-		}
-		collect_deps_from(thunk)
-		thunk_dep_map[thunk_index] = dep_indexes
-	}
-	var L = uint(len(thunks))
-	var in_degrees = make([] uint, L)
-	var inv_map = make([][] uint, L)
-	for i := uint(0); i < L; i += 1 {
-		inv_map[i] = make([] uint, 0)
-	}
-	for i := uint(0); i < L; i += 1 {
-		var deps = thunk_dep_map[i]
-		in_degrees[i] = uint(len(deps))
-		for _, dep := range deps {
-			inv_map[dep] = append(inv_map[dep], i)
-		}
-	}
-	var queue = make([] uint, 0)
-	for i := uint(0); i < L; i += 1 {
-		if in_degrees[i] == 0 {
-			queue = append(queue, i)
-		}
-	}
-	var sorted_count = uint(0)
-	for len(queue) > 0 {
-		var i = queue[0]
-		queue = queue[1:]
-		sorted_count += 1
-		for _, j := range inv_map[i] {
-			if in_degrees[j] < 1 { panic("something went wrong") }
-			in_degrees[j] -= 1
-			if in_degrees[j] == 0 {
-				queue = append(queue, j)
-			}
+		var info = f.Underlying.Info
+		graph.Functions[i] = DepNode {
+			Name:         fmt.Sprintf("%s::%s", info.Module, info.Name),
+			Point:        info.DeclPoint,
+			Dependencies: get_graph_deps(f),
+			FunctionGeneratorFlags: f.FunctionGeneratorFlags,
 		}
 	}
-	if sorted_count < L {
-		var rest_names = make([] string, 0)
-		var point ErrorPoint
-		for i := uint(0); i < L; i += 1 {
-			if in_degrees[i] > 0 {
-				rest_names = append(rest_names, thunk_names[i])
-				point = thunks[i].Underlying.Info.DeclPoint
-			}
-		}
-		if len(rest_names) == 0 { panic("something went wrong") }
-		return def.Program{}, DepLocator{}, &Error {
-			Point:    point,
-			Concrete: E_CircularThunkDependency { rest_names },
+	for i, e := range effects {
+		graph.Effects[i] = DepNode {
+			Dependencies: get_graph_deps(e),
 		}
 	}
+	var err = graph.Check()
+	if err != nil { return def.Program{}, DepLocator{}, err }
 	var base_data = uint(0)
 	var base_function = base_data + uint(len(data))
 	var base_closure = base_function + uint(len(functions))
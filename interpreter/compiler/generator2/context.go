@@ -0,0 +1,333 @@
+package generator2
+
+import (
+	. "kumachan/standalone/util/error"
+	"kumachan/interpreter/runtime/vm2/def"
+	ch "kumachan/interpreter/compiler/checker"
+	"kumachan/interpreter/compiler/generator"
+)
+
+
+type Context struct {
+	Function  *FunctionContext
+	Branch    *BranchContext
+	Scope     *Scope
+}
+
+type FunctionContext struct {
+	Static       [] def.StaticValueSeed
+	StaticNil    *def.LocalAddr
+	StaticRefs   map[def.Symbol] def.LocalAddr
+	Captured     [] *Binding
+	CapturedMap  map[*Binding] def.LocalAddr
+	Shared       *FunctionSharedInfo
+}
+
+// FunctionSharedInfo is shared by a top-level function and all closures
+// defined inside it.
+type FunctionSharedInfo struct {
+	Symbol        def.Symbol
+	Dependencies  [] def.Symbol
+	Bindings      [] *Binding
+}
+
+type BranchContext struct {
+	Data     *def.BranchData
+	Offset   def.LocalAddr
+	Pending  [] func(offset def.LocalAddr)
+	Regions  [] *ParallelRegion
+}
+
+type Scope struct {
+	Bindings  map[string] *Binding
+}
+
+type Binding struct {
+	Name   string
+	Point  ErrorPoint
+	Used   bool
+	Owner  *FunctionContext
+	Addr   def.LocalAddr
+}
+
+func MakeTopLevelContext(sym def.Symbol, name string, point ErrorPoint, implicit ([] string)) Context {
+	var shared = &FunctionSharedInfo {
+		Symbol:       sym,
+		Dependencies: make([] def.Symbol, 0),
+		Bindings:     make([] *Binding, 0),
+	}
+	var f = makeFunctionContext(shared)
+	var scope = &Scope {
+		Bindings: make(map[string] *Binding),
+	}
+	for _, field := range implicit {
+		var b = &Binding {
+			Name:  field,
+			Used:  true,  // assume always used, omit error point
+			Owner: nil,
+		}
+		f.Capture(b)
+		scope.Bindings[field] = b
+	}
+	return Context {
+		Function: f,
+		Branch:   makeBranchContext(sym, name, point, 0),
+		Scope:    scope,
+	}
+}
+
+func makeFunctionContext(shared *FunctionSharedInfo) *FunctionContext {
+	return &FunctionContext {
+		Static:      make([] def.StaticValueSeed, 0),
+		StaticNil:   nil,
+		StaticRefs:  make(map[def.Symbol] def.LocalAddr),
+		Captured:    make([] *Binding, 0),
+		CapturedMap: make(map[*Binding] def.LocalAddr),
+		Shared:      shared,
+	}
+}
+
+func makeBranchContext(sym def.Symbol, name string, point ErrorPoint, offset def.LocalAddr) *BranchContext {
+	return &BranchContext {
+		Data: &def.BranchData {
+			InstList:  make([] def.Instruction, 0),
+			ExtIdxMap: make(def.ExternalIndexMapping, 0),
+			Stages:    nil,
+			Branches:  make([] *def.BranchData, 0),
+			Closures:  make([] *def.FunctionSeedUsual, 0),
+			Info: def.FunctionInfo {
+				Symbol: sym,
+				Name:   name,
+				Decl:   point,
+				SrcMap: make([] ErrorPoint, 0),
+			},
+		},
+		Offset:  offset,
+		Pending: make([] func(def.LocalAddr), 0),
+		Regions: make([] *ParallelRegion, 0),
+	}
+}
+
+func (ctx Context) MakeClosure(point ErrorPoint) Context {
+	var info = ctx.Branch.Data.Info
+	return Context {
+		Function: makeFunctionContext(ctx.Function.Shared),
+		Branch:   makeBranchContext(info.Symbol, "(closure)", point, 0),
+		Scope:    ctx.Scope.MakeChild(),
+	}
+}
+
+func (ctx Context) MakeBranch(offset def.LocalAddr, scope *Scope) Context {
+	var info = ctx.Branch.Data.Info
+	var branch = makeBranchContext(info.Symbol, info.Name, info.Decl, offset)
+	ctx.Branch.Data.Branches = append(ctx.Branch.Data.Branches, branch.Data)
+	return Context {
+		Function: ctx.Function,
+		Branch:   branch,
+		Scope:    scope,
+	}
+}
+
+func (ctx Context) WithChildScope() Context {
+	return Context {
+		Function: ctx.Function,
+		Branch:   ctx.Branch,
+		Scope:    ctx.Scope.MakeChild(),
+	}
+}
+
+func (ctx Context) NextAddr() def.LocalAddr {
+	return ctx.Branch.Offset + def.LocalAddr(len(ctx.Branch.Data.InstList))
+}
+
+func (ctx Context) LastAddr() def.LocalAddr {
+	if len(ctx.Branch.Data.InstList) == 0 { panic("something went wrong") }
+	return ctx.NextAddr() - 1
+}
+
+func (ctx Context) Emit(inst def.Instruction, info ch.ExprInfo) def.LocalAddr {
+	var data = ctx.Branch.Data
+	if (uint(ctx.NextAddr()) + 1) >= def.MaxFrameValues {
+		panic("maximum frame size exceeded")
+	}
+	var addr = ctx.NextAddr()
+	data.InstList = append(data.InstList, inst)
+	data.Info.SrcMap = append(data.Info.SrcMap, info.ErrorPoint)
+	return addr
+}
+
+func (ctx Context) EmitOperands(addrs ([] def.LocalAddr), info ch.ExprInfo) def.LocalAddr {
+	var size = uint(len(addrs))
+	if size >= def.MaxFrameValues {
+		panic("too many operands")
+	}
+	var size_addr = ctx.Emit(def.InstSize(def.LocalSize(size)), info)
+	for _, addr := range addrs {
+		ctx.Emit(def.Instruction {
+			OpCode: def.FRAME,
+			Src:    addr,
+		}, info)
+	}
+	return size_addr
+}
+
+func (ctx Context) EmitResult(addr def.LocalAddr, info ch.ExprInfo) {
+	if len(ctx.Branch.Data.InstList) == 0 || addr != ctx.LastAddr() {
+		ctx.Emit(def.Instruction {
+			OpCode: def.FRAME,
+			Src:    addr,
+		}, info)
+	}
+}
+
+// NextBranchIndex returns the index of the branch to be created by
+// the next deferred compilation in the current branch.
+func (ctx Context) NextBranchIndex() uint {
+	return uint(len(ctx.Branch.Pending))
+}
+
+func (ctx Context) Defer(compile func(offset def.LocalAddr)) {
+	ctx.Branch.Pending = append(ctx.Branch.Pending, compile)
+}
+
+func (ctx Context) Finish() {
+	var data = ctx.Branch.Data
+	var length = uint(len(data.InstList))
+	if length == 0 { panic("something went wrong") }
+	if length >= def.MaxInsSeqLength {
+		panic("maximum instruction sequence length exceeded")
+	}
+	var offset = ctx.NextAddr()
+	var pending = ctx.Branch.Pending
+	ctx.Branch.Pending = nil
+	for _, compile := range pending {
+		compile(offset)
+	}
+	// branches are required to be compiled before stage analysis
+	data.Stages = analyzeStages(ctx.Branch)
+}
+
+func (ctx Context) StaticValue(v def.Value, info ch.ExprInfo) def.LocalAddr {
+	var f = ctx.Function
+	var index = f.addStatic(def.StaticValueSeedImmediate {
+		ValuePointer: &v,
+	})
+	return ctx.Emit(def.Instruction {
+		OpCode: def.STATIC,
+		Src:    index,
+	}, info)
+}
+
+func (ctx Context) StaticNil(info ch.ExprInfo) def.LocalAddr {
+	var f = ctx.Function
+	if f.StaticNil == nil {
+		var v def.Value = nil
+		var index = f.addStatic(def.StaticValueSeedImmediate {
+			ValuePointer: &v,
+		})
+		f.StaticNil = &index
+	}
+	return ctx.Emit(def.Instruction {
+		OpCode: def.STATIC,
+		Src:    *(f.StaticNil),
+	}, info)
+}
+
+func (ctx Context) StaticFunction(sym def.Symbol, info ch.ExprInfo) def.LocalAddr {
+	var f = ctx.Function
+	var index, exists = f.StaticRefs[sym]
+	if !(exists) {
+		index = f.addStatic(def.StaticValueSeedFunctionReference {
+			Symbol: sym,
+		})
+		f.StaticRefs[sym] = index
+		f.Shared.Dependencies = append(f.Shared.Dependencies, sym)
+	}
+	return ctx.Emit(def.Instruction {
+		OpCode: def.STATIC,
+		Src:    index,
+	}, info)
+}
+
+func (f *FunctionContext) addStatic(seed def.StaticValueSeed) def.LocalAddr {
+	var index = uint(len(f.Static))
+	if index >= def.MaxStaticValues {
+		panic("maximum quantity of static values exceeded")
+	}
+	f.Static = append(f.Static, seed)
+	return def.LocalAddr(index)
+}
+
+func (f *FunctionContext) Capture(b *Binding) def.LocalAddr {
+	var index, exists = f.CapturedMap[b]
+	if exists {
+		return index
+	}
+	if uint(len(f.Captured)) >= def.MaxClosureContexts {
+		panic("maximum closure context size exceeded")
+	}
+	index = def.LocalAddr(len(f.Captured))
+	f.Captured = append(f.Captured, b)
+	f.CapturedMap[b] = index
+	return index
+}
+
+func (ctx Context) AddBinding(name string, point ErrorPoint, addr def.LocalAddr) *Binding {
+	var b = &Binding {
+		Name:  name,
+		Point: point,
+		Used:  false,
+		Owner: ctx.Function,
+		Addr:  addr,
+	}
+	ctx.Scope.Bindings[name] = b
+	var shared = ctx.Function.Shared
+	shared.Bindings = append(shared.Bindings, b)
+	return b
+}
+
+func (ctx Context) LoadBinding(b *Binding, info ch.ExprInfo) def.LocalAddr {
+	b.Used = true
+	if b.Owner == ctx.Function {
+		return b.Addr
+	} else {
+		var index = ctx.Function.Capture(b)
+		return ctx.Emit(def.Instruction {
+			OpCode: def.CTX,
+			Src:    index,
+		}, info)
+	}
+}
+
+func (ctx Context) LoadLocal(name string, info ch.ExprInfo) def.LocalAddr {
+	var b, exists = ctx.Scope.Bindings[name]
+	if !(exists) { panic("binding " + name + " does not exist") }
+	return ctx.LoadBinding(b, info)
+}
+
+func (scope *Scope) MakeChild() *Scope {
+	var bindings = make(map[string] *Binding)
+	for k, v := range scope.Bindings {
+		bindings[k] = v
+	}
+	return &Scope {
+		Bindings: bindings,
+	}
+}
+
+func (shared *FunctionSharedInfo) CollectUnusedAsErrors() ([] E) {
+	var errs = make([] E, 0)
+	for _, b := range shared.Bindings {
+		if !(b.Used) && b.Name != ch.IgnoreMark {
+			errs = append(errs, &generator.Error {
+				Point:    b.Point,
+				Concrete: generator.E_UnusedBinding { Name: b.Name },
+			})
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	} else {
+		return errs
+	}
+}
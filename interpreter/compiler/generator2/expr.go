@@ -0,0 +1,399 @@
+package generator2
+
+import (
+	"kumachan/interpreter/runtime/vm2/def"
+	ch "kumachan/interpreter/compiler/checker"
+	"kumachan/interpreter/compiler/generator"
+)
+
+
+func CompileExpr(expr ch.Expr, ctx Context) def.LocalAddr {
+	var info = expr.Info
+	switch v := expr.Value.(type) {
+	case ch.UnitValue:
+		return ctx.StaticNil(info)
+	case ch.IntegerLiteral:
+		return ctx.StaticValue(generator.DataInteger(v).ToValue(), info)
+	case ch.SmallIntLiteral:
+		return ctx.StaticValue(generator.DataSmallInteger(v).ToValue(), info)
+	case ch.FloatLiteral:
+		return ctx.StaticValue(generator.DataFloat(v).ToValue(), info)
+	case ch.StringLiteral:
+		var data = generator.DataString { Value: v.Value }
+		return ctx.StaticValue(data.ToValue(), info)
+	case ch.StringFormatter:
+		return ctx.StaticValue(generator.DataStringFormatter(v).ToValue(), info)
+	case ch.RefFunction:
+		var sym = FunctionSymbol(v.AbsRef.Module, v.AbsRef.Name, v.AbsRef.Index)
+		var f = ctx.StaticFunction(sym, info)
+		if len(v.Implicit) > 0 {
+			var addrs = make([] def.LocalAddr, len(v.Implicit))
+			for i, ref := range v.Implicit {
+				addrs[i] = CompileExpr(ch.Expr {
+					Type:  nil,
+					Value: ref,
+					Info:  info,
+				}, ctx)
+			}
+			var obj = ctx.EmitOperands(addrs, info)
+			return ctx.Emit(def.Instruction {
+				OpCode: def.INJ,
+				Obj:    obj,
+				Src:    f,
+			}, info)
+		} else {
+			return f
+		}
+	case ch.RefConstant:
+		panic("constant reference is not supported")
+	case ch.RefLocal:
+		return ctx.LoadLocal(v.Name, info)
+	case ch.Array:
+		var addrs = make([] def.LocalAddr, len(v.Items))
+		for i, item := range v.Items {
+			addrs[i] = CompileExpr(item, ctx)
+		}
+		var obj = ctx.EmitOperands(addrs, info)
+		var array_info = ch.GetArrayInfo(uint(len(v.Items)), v.ItemType)
+		var t = array_info.ItemType
+		var idx, is_compact = def.GetCompactArrayTypeIndex(t)
+		if is_compact {
+			return ctx.Emit(def.Instruction {
+				OpCode: def.LSC,
+				Idx:    idx,
+				Obj:    obj,
+			}, info)
+		} else {
+			return ctx.Emit(def.Instruction {
+				OpCode: def.LSV,
+				Obj:    obj,
+			}, info)
+		}
+	case ch.Product:
+		if uint(len(v.Values)) > def.MaxTupleElements {
+			panic("maximum tuple size exceeded")
+		}
+		var addrs = make([] def.LocalAddr, len(v.Values))
+		for i, item := range v.Values {
+			addrs[i] = CompileExpr(item, ctx)
+		}
+		var obj = ctx.EmitOperands(addrs, info)
+		return ctx.Emit(def.Instruction {
+			OpCode: def.TUPLE,
+			Obj:    obj,
+		}, info)
+	case ch.Get:
+		var obj = CompileExpr(v.Product, ctx)
+		return ctx.Emit(def.Instruction {
+			OpCode: def.GET,
+			Idx:    ShortIndex(v.Index),
+			Obj:    obj,
+		}, info)
+	case ch.Set:
+		var obj = CompileExpr(v.Product, ctx)
+		var src = CompileExpr(v.NewValue, ctx)
+		return ctx.Emit(def.Instruction {
+			OpCode: def.SET,
+			Idx:    ShortIndex(v.Index),
+			Obj:    obj,
+			Src:    src,
+		}, info)
+	case ch.Reference:
+		var obj = CompileExpr(v.Base, ctx)
+		return ctx.Emit(def.Instruction {
+			OpCode: RefOpCode(v.Kind, v.Operand),
+			Idx:    ShortIndex(v.Index),
+			Obj:    obj,
+		}, info)
+	case ch.Sum:
+		var obj = CompileExpr(v.Value, ctx)
+		return ctx.Emit(def.Instruction {
+			OpCode: def.ENUM,
+			Idx:    ShortIndex(v.Index),
+			Obj:    obj,
+		}, info)
+	case ch.Switch:
+		var obj = CompileExpr(v.Argument, ctx)
+		var base = ctx.NextBranchIndex()
+		var m = def.ExternalIndexMap {
+			VectorMap: make(map[def.ShortIndexVector] uint),
+		}
+		for i, b := range v.Branches {
+			var target = (base + uint(i))
+			if b.IsDefault {
+				m.HasDefault = true
+				m.Default = target
+			} else {
+				var index = ShortIndex(b.Index)
+				var vec = def.CreateShortIndexVectorSingleElement(index)
+				m.VectorMap[vec] = target
+			}
+		}
+		var ext = AppendExternalIndexMap(m, ctx)
+		for _, b := range v.Branches {
+			DeferBranch(b.Pattern, b.Value, ctx)
+		}
+		return ctx.Emit(def.Instruction {
+			OpCode: def.SWITCH,
+			ExtIdx: ext,
+			Obj:    obj,
+		}, info)
+	case ch.MultiSwitch:
+		var A = uint(len(v.Arguments))
+		if A > def.MaxShortIndexVectorElements {
+			panic("too many arguments for a multi-switch")
+		}
+		var addrs = make([] def.LocalAddr, A)
+		for i, arg := range v.Arguments {
+			addrs[i] = CompileExpr(arg, ctx)
+		}
+		var obj = ctx.EmitOperands(addrs, info)
+		var base = ctx.NextBranchIndex()
+		var m = def.ExternalIndexMap {
+			VectorMap:  make(map[def.ShortIndexVector] uint),
+			MaskedList: make([] def.MaskedVectorTarget, 0),
+		}
+		for i, b := range v.Branches {
+			var target = (base + uint(i))
+			if b.IsDefault {
+				m.HasDefault = true
+				m.Default = target
+				continue
+			}
+			if uint(len(b.Indexes)) != A { panic("something went wrong") }
+			var indexes = make([] def.ShortIndex, A)
+			var wildcards = make([] bool, A)
+			var has_wildcard = false
+			for j, el := range b.Indexes {
+				if el.IsDefault {
+					wildcards[j] = true
+					has_wildcard = true
+				} else {
+					indexes[j] = ShortIndex(el.Index)
+				}
+			}
+			var vec = def.CreateShortIndexVector(indexes)
+			if has_wildcard {
+				m.MaskedList = append(m.MaskedList, def.MaskedVectorTarget {
+					Mask:   def.CreateShortIndexVectorMask(wildcards),
+					Vector: vec,
+					Target: target,
+				})
+			} else {
+				var shadowed = false
+				for _, item := range m.MaskedList {
+					if (vec & item.Mask) == (item.Vector & item.Mask) {
+						shadowed = true
+						break
+					}
+				}
+				var _, exists = m.VectorMap[vec]
+				if !(shadowed) && !(exists) {
+					m.VectorMap[vec] = target
+				}
+			}
+		}
+		var ext = AppendExternalIndexMap(m, ctx)
+		for _, b := range v.Branches {
+			DeferBranch(b.Pattern, b.Value, ctx)
+		}
+		return ctx.Emit(def.Instruction {
+			OpCode: def.SELECT,
+			ExtIdx: ext,
+			Obj:    obj,
+		}, info)
+	case ch.Lambda:
+		return CompileClosure(v, info, nil, ctx)
+	case ch.PipelineLambdaArgument:
+		// the argument of the current function (ARG at the start of trunk)
+		return 0
+	case ch.Block:
+		var block_ctx = ctx.WithChildScope()
+		var units = make([] ParallelUnit, 0)
+		for _, b := range v.Bindings {
+			var start = block_ctx.NextAddr()
+			var pattern = b.Pattern
+			if b.Recursive {
+				var p, ok = pattern.Concrete.(ch.TrivialPattern)
+				if !(ok) { panic("something went wrong") }
+				var lambda, is_lambda = b.Value.Value.(ch.Lambda)
+				if !(is_lambda) { panic("something went wrong") }
+				var self = block_ctx.AddBinding(p.ValueName, p.Point, 0)
+				var addr = CompileClosure(lambda, b.Value.Info, self, block_ctx)
+				self.Addr = addr
+			} else {
+				var addr = CompileExpr(b.Value, block_ctx)
+				BindPattern(pattern, addr, block_ctx)
+			}
+			var end = block_ctx.NextAddr()
+			if end > start {
+				var offset = ctx.Branch.Offset
+				units = append(units, ParallelUnit {
+					Start: (start - offset),
+					End:   (end - 1 - offset),
+				})
+			}
+		}
+		var ret = CompileExpr(v.Returned, block_ctx)
+		if len(units) >= 2 {
+			var last_unit = units[len(units) - 1]
+			if (last_unit.End + ctx.Branch.Offset) == ctx.LastAddr() {
+				// the last instruction must not be inside a unit
+				ret = ctx.Emit(def.Instruction {
+					OpCode: def.FRAME,
+					Src:    ret,
+				}, v.Returned.Info)
+			}
+			ctx.AddParallelRegion(units)
+		}
+		return ret
+	case ch.Call:
+		var arg = CompileExpr(v.Argument, ctx)
+		var f = CompileExpr(v.Function, ctx)
+		return ctx.Emit(def.Instruction {
+			OpCode: def.CALL,
+			Obj:    f,
+			Src:    arg,
+		}, info)
+	default:
+		panic("unknown expression kind")
+	}
+}
+
+func CompileClosure (
+	lambda  ch.Lambda,
+	info    ch.ExprInfo,
+	self    *Binding,
+	ctx     Context,
+) def.LocalAddr {
+	var inner_ctx = ctx.MakeClosure(info.ErrorPoint)
+	if self != nil {
+		inner_ctx.Function.Capture(self)
+	}
+	var arg = inner_ctx.Emit(def.Instruction {
+		OpCode: def.ARG,
+	}, info)
+	BindPattern(lambda.Input, arg, inner_ctx)
+	var ret = CompileExpr(lambda.Output, inner_ctx)
+	inner_ctx.EmitResult(ret, lambda.Output.Info)
+	inner_ctx.Finish()
+	var captured = inner_ctx.Function.Captured
+	var seed = &def.FunctionSeedUsual {
+		Trunk:  inner_ctx.Branch.Data,
+		Static: inner_ctx.Function.Static,
+		IsEff:  false,
+		CtxLen: def.LocalSize(len(captured)),
+	}
+	var closures = &(ctx.Branch.Data.Closures)
+	var index = uint(len(*closures))
+	if index >= def.MaxClosures {
+		panic("maximum quantity of closures exceeded")
+	}
+	*closures = append(*closures, seed)
+	var op def.OpCode
+	if self != nil {
+		op = def.CLR
+		captured = captured[1:]
+	} else {
+		op = def.CL
+	}
+	var addrs = make([] def.LocalAddr, len(captured))
+	for i, b := range captured {
+		addrs[i] = ctx.LoadBinding(b, info)
+	}
+	var obj = ctx.EmitOperands(addrs, info)
+	return ctx.Emit(def.Instruction {
+		OpCode: op,
+		Obj:    obj,
+		Src:    def.LocalAddr(index),
+	}, info)
+}
+
+func DeferBranch(maybe_pattern ch.MaybePattern, value ch.Expr, ctx Context) {
+	var scope = ctx.Scope
+	ctx.Defer(func(offset def.LocalAddr) {
+		var branch_ctx = ctx.MakeBranch(offset, scope.MakeChild())
+		var arg = branch_ctx.Emit(def.Instruction {
+			OpCode: def.ARG,
+		}, value.Info)
+		var pattern, ok = maybe_pattern.(ch.Pattern)
+		if ok {
+			BindPattern(pattern, arg, branch_ctx)
+		}
+		var ret = CompileExpr(value, branch_ctx)
+		branch_ctx.EmitResult(ret, value.Info)
+		branch_ctx.Finish()
+	})
+}
+
+func BindPattern(pattern ch.Pattern, addr def.LocalAddr, ctx Context) {
+	var info = ch.ExprInfo {
+		ErrorPoint: pattern.Point,
+	}
+	var bind_items = func(items ([] ch.PatternItem)) {
+		for _, item := range items {
+			var item_addr = ctx.Emit(def.Instruction {
+				OpCode: def.GET,
+				Idx:    ShortIndex(item.Index),
+				Obj:    addr,
+			}, info)
+			ctx.AddBinding(item.Name, item.Point, item_addr)
+		}
+	}
+	switch p := pattern.Concrete.(type) {
+	case ch.NullPattern:
+		// do nothing
+	case ch.TrivialPattern:
+		ctx.AddBinding(p.ValueName, p.Point, addr)
+	case ch.TuplePattern:
+		bind_items(p.Items)
+	case ch.RecordPattern:
+		bind_items(p.Items)
+	default:
+		panic("impossible branch")
+	}
+}
+
+func AppendExternalIndexMap(m def.ExternalIndexMap, ctx Context) def.ExternalIndexMapPointer {
+	var mapping = &(ctx.Branch.Data.ExtIdxMap)
+	var ptr = uint(len(*mapping))
+	if ptr >= def.MaxBranchExpressions {
+		panic("maximum quantity of branch expressions exceeded")
+	}
+	*mapping = append(*mapping, m)
+	return def.ExternalIndexMapPointer(ptr)
+}
+
+func RefOpCode(k ch.ReferenceKind, o ch.ReferenceOperand) def.OpCode {
+	switch k {
+	case ch.RK_Branch:
+		switch o {
+		case ch.RO_Enum:
+			return def.BR
+		case ch.RO_CaseRef:
+			return def.BRC
+		case ch.RO_ProjRef:
+			return def.BRP
+		default:
+			panic("invalid operand")
+		}
+	case ch.RK_Field:
+		switch o {
+		case ch.RO_Record:
+			return def.FR
+		case ch.RO_ProjRef:
+			return def.FRP
+		default:
+			panic("invalid operand")
+		}
+	default:
+		panic("impossible branch")
+	}
+}
+
+func ShortIndex(index uint) def.ShortIndex {
+	if index >= def.ShortSizeMax {
+		panic("index out of range")
+	}
+	return def.ShortIndex(index)
+}
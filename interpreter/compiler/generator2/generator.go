@@ -0,0 +1,163 @@
+package generator2
+
+import (
+	"fmt"
+	. "kumachan/standalone/util/error"
+	"kumachan/interpreter/runtime/vm2/def"
+	ch "kumachan/interpreter/compiler/checker"
+	legacy "kumachan/interpreter/def"
+)
+
+
+type CompiledModule struct {
+	Functions   map[string] ([] FuncNode)
+	Effects     [] FuncNode
+}
+
+type Index  map[string] *CompiledModule
+
+type FuncNode struct {
+	Seed          def.FunctionSeed
+	Dependencies  [] def.Symbol
+	ch.FunctionGeneratorFlags
+	ch.FunctionKmdInfo
+}
+
+func FunctionSymbol(mod string, name string, index uint) def.Symbol {
+	return def.MakeSymbol(mod, fmt.Sprintf("%s[%d]", name, index))
+}
+
+func EffectSymbol(mod string, index uint) def.Symbol {
+	return def.MakeSymbol(mod, fmt.Sprintf("(do)[%d]", index))
+}
+
+
+func CompileModule(mod *ch.CheckedModule, idx Index) [] E {
+	var _, exists = idx[mod.Name]
+	if exists {
+		return nil
+	}
+	var errs = make([] E, 0)
+	for _, imported := range mod.Imported {
+		var err = CompileModule(imported, idx)
+		if err != nil {
+			errs = append(errs, err...)
+		}
+	}
+	var functions = make(map[string] ([] FuncNode))
+	var effects = make([] FuncNode, 0)
+	for name, instances := range mod.Functions {
+		var nodes = make([] FuncNode, len(instances))
+		for i, item := range instances {
+			var sym = FunctionSymbol(mod.Name, name, uint(i))
+			var seed, deps, err = CompileFunction (
+				item.Body, item.Implicit, sym, name, item.Point, false,
+			)
+			if err != nil { errs = append(errs, err...) }
+			nodes[i] = FuncNode {
+				Seed:                   seed,
+				Dependencies:           deps,
+				FunctionGeneratorFlags: item.FunctionGeneratorFlags,
+				FunctionKmdInfo:        item.FunctionKmdInfo,
+			}
+		}
+		functions[name] = nodes
+	}
+	for i, item := range mod.Effects {
+		var body = ch.BodyThunk {
+			Value: item.Value,
+		}
+		var sym = EffectSymbol(mod.Name, uint(i))
+		var seed, deps, err = CompileFunction (
+			body, ([] string {}), sym, "(do)", item.Point, true,
+		)
+		if err != nil { errs = append(errs, err...) }
+		effects = append(effects, FuncNode {
+			Seed:         seed,
+			Dependencies: deps,
+		})
+	}
+	idx[mod.Name] = &CompiledModule {
+		Functions: functions,
+		Effects:   effects,
+	}
+	if len(errs) != 0 {
+		return errs
+	} else {
+		return nil
+	}
+}
+
+
+func CompileFunction (
+	body    ch.Body,
+	imp     [] string,
+	sym     def.Symbol,
+	name    string,
+	point   ErrorPoint,
+	is_eff  bool,
+) (def.FunctionSeed, [] def.Symbol, [] E) {
+	if uint(len(imp)) > def.MaxClosureContexts {
+		panic("something went wrong")
+	}
+	var info = def.FunctionInfo {
+		Symbol: sym,
+		Name:   name,
+		Decl:   point,
+		SrcMap: nil,
+	}
+	switch b := body.(type) {
+	case ch.BodyGenerated:
+		return &def.FunctionSeedGeneratedNative {
+			Data: &def.PredefinedNativeFunctionSeed {
+				Value: b.Seed.GenerateFunctionValue(),
+			},
+			Info: info,
+		}, nil, nil
+	case ch.BodyRuntimeGenerated:
+		var thunk = b.Value.(legacy.UiObjectThunk)
+		return &def.FunctionSeedGeneratedNative {
+			Data: &def.UiObjectSeed {
+				Object: thunk.Object,
+				Group:  thunk.Group,
+			},
+			Info: info,
+		}, nil, nil
+	case ch.BodyNative:
+		return &def.FunctionSeedLibraryNative {
+			Id:   b.Name,
+			Info: info,
+		}, nil, nil
+	case ch.BodyThunk:
+		var ctx = MakeTopLevelContext(sym, name, point, imp)
+		var expr_info = ch.ExprInfo { ErrorPoint: point }
+		ctx.Emit(def.Instruction { OpCode: def.ARG }, expr_info)
+		var ret = CompileExpr(b.Value, ctx)
+		ctx.EmitResult(ret, b.Value.Info)
+		ctx.Finish()
+		var shared = ctx.Function.Shared
+		return &def.FunctionSeedUsual {
+			Trunk:  ctx.Branch.Data,
+			Static: ctx.Function.Static,
+			IsEff:  is_eff,
+			CtxLen: def.LocalSize(len(imp)),
+		}, shared.Dependencies, shared.CollectUnusedAsErrors()
+	case ch.BodyLambda:
+		var ctx = MakeTopLevelContext(sym, name, point, imp)
+		var lambda = b.Lambda
+		var arg = ctx.Emit(def.Instruction { OpCode: def.ARG }, b.Info)
+		BindPattern(lambda.Input, arg, ctx)
+		var ret = CompileExpr(lambda.Output, ctx)
+		ctx.EmitResult(ret, lambda.Output.Info)
+		ctx.Finish()
+		var shared = ctx.Function.Shared
+		return &def.FunctionSeedUsual {
+			Trunk:  ctx.Branch.Data,
+			Static: ctx.Function.Static,
+			IsEff:  is_eff,
+			CtxLen: def.LocalSize(len(imp)),
+		}, shared.Dependencies, shared.CollectUnusedAsErrors()
+	default:
+		panic("impossible branch")
+	}
+}
@@ -0,0 +1,95 @@
+package generator2
+
+import (
+	"fmt"
+	"kumachan/standalone/rpc"
+	"kumachan/standalone/rpc/kmd"
+	"kumachan/interpreter/runtime/vm2/def"
+	"kumachan/interpreter/compiler/generator"
+	. "kumachan/standalone/util/error"
+)
+
+
+func CreateProgram (
+	metadata  def.ProgramMetaData,
+	idx       Index,
+	schema    kmd.SchemaTable,
+	services  rpc.ServiceIndex,
+) (def.Program, E) {
+	var kmd_info = def.KmdInfo {
+		SchemaTable:       schema,
+		KmdAdapterTable:   make(def.KmdAdapterTable),
+		KmdValidatorTable: make(def.KmdValidatorTable),
+	}
+	var rpc_info = def.RpcInfo {
+		ServiceIndex: services,
+	}
+	var function_index_map = make(map[def.Symbol] uint)
+	var functions = make([] FuncNode, 0)
+	for _, mod := range idx {
+		for _, items := range mod.Functions {
+			for _, item := range items {
+				var sym = item.Seed.GetInfo().Symbol
+				function_index_map[sym] = uint(len(functions))
+				functions = append(functions, item)
+			}
+		}
+	}
+	var effects = make([] FuncNode, 0)
+	for _, mod := range idx {
+		effects = append(effects, mod.Effects...)
+	}
+	var get_graph_deps = func(f FuncNode) ([] uint) {
+		var deps = make([] uint, len(f.Dependencies))
+		for i, dep := range f.Dependencies {
+			var index, exists = function_index_map[dep]
+			if !(exists) { panic("something went wrong") }
+			deps[i] = index
+		}
+		return deps
+	}
+	var graph = generator.DepGraph {
+		Functions: make([] generator.DepNode, len(functions)),
+		Effects:   make([] generator.DepNode, len(effects)),
+	}
+	for i, f := range functions {
+		var info = f.Seed.GetInfo()
+		graph.Functions[i] = generator.DepNode {
+			Name:         fmt.Sprintf("%s::%s", info.Symbol.ModuleName, info.Name),
+			Point:        info.Decl,
+			Dependencies: get_graph_deps(f),
+			FunctionGeneratorFlags: f.FunctionGeneratorFlags,
+		}
+	}
+	for i, e := range effects {
+		graph.Effects[i] = generator.DepNode {
+			Dependencies: get_graph_deps(e),
+		}
+	}
+	var err = graph.Check()
+	if err != nil { return def.Program {}, err }
+	var seeds = make([] def.FunctionSeed, 0, (len(functions) + len(effects)))
+	for _, f := range functions {
+		var sym = f.Seed.GetInfo().Symbol
+		if f.IsAdapter {
+			kmd_info.KmdAdapterTable[f.AdapterId] = def.KmdAdapterInfo {
+				Symbol: sym,
+			}
+		}
+		if f.IsValidator {
+			kmd_info.KmdValidatorTable[f.ValidatorId] = def.KmdValidatorInfo {
+				Symbol: sym,
+			}
+		}
+		seeds = append(seeds, f.Seed)
+	}
+	for _, e := range effects {
+		seeds = append(seeds, e.Seed)
+	}
+	return def.Program {
+		MetaData:  metadata,
+		Functions: seeds,
+		KmdInfo:   kmd_info,
+		RpcInfo:   rpc_info,
+	}, nil
+}
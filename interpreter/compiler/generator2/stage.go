@@ -0,0 +1,269 @@
+package generator2
+
+import (
+	"sort"
+	"kumachan/interpreter/runtime/vm2/def"
+)
+
+
+/**
+ *  Stage Analysis
+ *
+ *  The bindings of a block are compiled into consecutive instruction
+ *  ranges (units). A unit depends on another unit if it reads a value
+ *  produced by the other unit, either directly or from the branches
+ *  of a SWITCH or SELECT inside it. Units are grouped into levels of
+ *  the dependency DAG, and independent units of the same level are put
+ *  into a common stage, so that they can be executed concurrently.
+ *
+ *  Constraints imposed by the runtime:
+ *   1. All branches of a code share the same part of the stack frame,
+ *      so units containing a SWITCH or SELECT must not be executed
+ *      concurrently with each other.
+ *   2. A CALL at the last instruction of a code is a tail call that
+ *      replaces the frame, so a unit must not contain the last
+ *      instruction. (guaranteed by the compilation of blocks)
+ */
+
+type ParallelRegion struct {
+	Units  [] ParallelUnit
+}
+
+type ParallelUnit struct {
+	Start  def.LocalAddr  // index of the first instruction
+	End    def.LocalAddr  // index of the last instruction
+}
+
+func (r *ParallelRegion) Start() def.LocalAddr {
+	return r.Units[0].Start
+}
+
+func (r *ParallelRegion) End() def.LocalAddr {
+	return r.Units[len(r.Units) - 1].End
+}
+
+func (u ParallelUnit) Contains(r *ParallelRegion) bool {
+	return (u.Start <= r.Start() && r.End() <= u.End)
+}
+
+func (ctx Context) AddParallelRegion(units ([] ParallelUnit)) {
+	if len(units) < 2 { panic("something went wrong") }
+	var branch = ctx.Branch
+	branch.Regions = append(branch.Regions, &ParallelRegion {
+		Units: units,
+	})
+}
+
+type stageAnalysis struct {
+	data     *def.BranchData
+	offset   def.LocalAddr
+	regions  [] *ParallelRegion
+}
+
+func analyzeStages(branch *BranchContext) ([] def.Stage) {
+	var data = branch.Data
+	var last = def.LocalAddr(len(data.InstList) - 1)
+	var regions = make([] *ParallelRegion, len(branch.Regions))
+	copy(regions, branch.Regions)
+	sort.SliceStable(regions, func(i, j int) bool {
+		return regions[i].Start() < regions[j].Start()
+	})
+	var a = &stageAnalysis {
+		data:    data,
+		offset:  branch.Offset,
+		regions: regions,
+	}
+	return a.buildStages(0, last)
+}
+
+func (a *stageAnalysis) buildStages(start def.LocalAddr, end def.LocalAddr) ([] def.Stage) {
+	var stages = make([] def.Stage, 0)
+	var pos = start
+	for _, r := range a.regions {
+		if !(pos <= r.Start() && r.End() <= end) {
+			// outside of the range, or nested in a previous region
+			continue
+		}
+		if pos < r.Start() {
+			stages = append(stages, simpleStage(pos, (r.Start() - 1)))
+		}
+		stages = append(stages, a.buildRegionStages(r)...)
+		pos = (r.End() + 1)
+	}
+	if pos <= end {
+		stages = append(stages, simpleStage(pos, end))
+	}
+	return mergeSimpleStages(stages)
+}
+
+func (a *stageAnalysis) buildRegionStages(r *ParallelRegion) ([] def.Stage) {
+	var N = len(r.Units)
+	var levels = make([] uint, N)
+	var max_level = uint(0)
+	for i, unit := range r.Units {
+		var level = uint(0)
+		a.forEachRead(unit, func(addr def.LocalAddr) {
+			if addr < a.offset { return }
+			var index = (addr - a.offset)
+			for j := 0; j < i; j += 1 {
+				var dep = r.Units[j]
+				if dep.Start <= index && index <= dep.End {
+					if levels[j] + 1 > level {
+						level = (levels[j] + 1)
+					}
+				}
+			}
+		})
+		levels[i] = level
+		if level > max_level {
+			max_level = level
+		}
+	}
+	var stages = make([] def.Stage, 0)
+	for level := uint(0); level <= max_level; level += 1 {
+		var heavy = make([] def.Flow, 0)
+		var branching = make([] def.Flow, 0)
+		var deferred = make([] def.Stage, 0)
+		for i, unit := range r.Units {
+			if levels[i] != level { continue }
+			var flow = a.buildUnitFlow(unit)
+			switch a.getUnitWeight(unit) {
+			case unitLight:
+				stages = append(stages, def.Stage { flow })
+			case unitHeavy:
+				heavy = append(heavy, flow)
+			case unitBranching:
+				if len(branching) == 0 {
+					branching = append(branching, flow)
+				} else {
+					deferred = append(deferred, def.Stage { flow })
+				}
+			}
+		}
+		var parallel = append(heavy, branching...)
+		if len(parallel) > 0 {
+			stages = append(stages, def.Stage(parallel))
+		}
+		stages = append(stages, deferred...)
+	}
+	return stages
+}
+
+func (a *stageAnalysis) buildUnitFlow(unit ParallelUnit) def.Flow {
+	for _, r := range a.regions {
+		if unit.Contains(r) {
+			var stages = a.buildStages(unit.Start, unit.End)
+			if len(stages) == 1 && len(stages[0]) == 1 {
+				return stages[0].TheOnlyFlow()
+			} else {
+				return def.Flow { NestedFlow: def.NestedFlow {
+					Stages: stages,
+				} }
+			}
+		}
+	}
+	return def.Flow { SimpleFlow: def.SimpleFlow {
+		Start: unit.Start,
+		End:   unit.End,
+	} }
+}
+
+type unitWeight int
+const (
+	unitLight unitWeight = iota
+	unitHeavy
+	unitBranching
+)
+func (a *stageAnalysis) getUnitWeight(unit ParallelUnit) unitWeight {
+	var weight = unitLight
+	for i := unit.Start; i <= unit.End; i += 1 {
+		switch a.data.InstList[i].OpCode {
+		case def.SWITCH, def.SELECT:
+			return unitBranching
+		case def.CALL:
+			weight = unitHeavy
+		}
+	}
+	return weight
+}
+
+func (a *stageAnalysis) forEachRead(unit ParallelUnit, f func(def.LocalAddr)) {
+	for i := unit.Start; i <= unit.End; i += 1 {
+		var inst = a.data.InstList[i]
+		forEachInstructionRead(inst, f)
+		switch inst.OpCode {
+		case def.SWITCH, def.SELECT:
+			var m = a.data.ExtIdxMap[inst.ExtIdx]
+			forEachBranchTarget(m, func(target uint) {
+				forEachBranchRead(a.data.Branches[target], f)
+			})
+		}
+	}
+}
+
+func forEachBranchRead(branch *def.BranchData, f func(def.LocalAddr)) {
+	for _, inst := range branch.InstList {
+		forEachInstructionRead(inst, f)
+	}
+	for _, sub := range branch.Branches {
+		forEachBranchRead(sub, f)
+	}
+}
+
+func forEachBranchTarget(m def.ExternalIndexMap, f func(uint)) {
+	for _, target := range m.VectorMap {
+		f(target)
+	}
+	for _, item := range m.MaskedList {
+		f(item.Target)
+	}
+	if m.HasDefault {
+		f(m.Default)
+	}
+}
+
+// forEachInstructionRead enumerates the frame addresses that might be
+// read by an instruction. False positives are harmless.
+func forEachInstructionRead(inst def.Instruction, f func(def.LocalAddr)) {
+	switch inst.OpCode {
+	case def.SIZE, def.ARG, def.STATIC, def.CTX:
+		return
+	case def.FRAME:
+		f(inst.Src)
+	case def.CL, def.CLR:
+		f(inst.Obj)
+	default:
+		f(inst.Obj)
+		f(inst.Src)
+	}
+}
+
+func simpleStage(start def.LocalAddr, end def.LocalAddr) def.Stage {
+	return def.Stage { def.Flow { SimpleFlow: def.SimpleFlow {
+		Start: start,
+		End:   end,
+	} } }
+}
+
+func mergeSimpleStages(stages ([] def.Stage)) ([] def.Stage) {
+	var merged = make([] def.Stage, 0, len(stages))
+	for _, stage := range stages {
+		if len(merged) > 0 {
+			var prev = merged[len(merged) - 1]
+			if isSimpleStage(prev) && isSimpleStage(stage) {
+				var a = prev.TheOnlyFlow()
+				var b = stage.TheOnlyFlow()
+				if a.End + 1 == b.Start {
+					merged[len(merged) - 1] = simpleStage(a.Start, b.End)
+					continue
+				}
+			}
+		}
+		merged = append(merged, stage)
+	}
+	return merged
+}
+
+func isSimpleStage(stage def.Stage) bool {
+	return (len(stage) == 1 && stage[0].Simple())
+}
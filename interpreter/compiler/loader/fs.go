@@ -2,8 +2,11 @@ package loader
 
 import (
 	"os"
+	"fmt"
 	"time"
+	"errors"
 	"io/ioutil"
+	"path/filepath"
 )
 
 
@@ -46,6 +49,45 @@ func (f RealFile) ReadContent() ([] byte, error) {
 	return ioutil.ReadAll(f.Fd)
 }
 
+// OverlayFileSystem replaces the content of some files of the base
+// file system, e.g. unsaved documents of an editor. Overlay files that
+// do not exist in the base file system are not listed in directories.
+type OverlayFileSystem struct {
+	Base   FileSystem
+	Files  map[string] ([] byte)  // absolute path -> content
+}
+type overlayFile struct {
+	name     string
+	content  [] byte
+}
+func (fs OverlayFileSystem) Open(path string) (File, error) {
+	var content, exists = fs.Files[filepath.Clean(path)]
+	if exists {
+		return overlayFile {
+			name:    filepath.Base(path),
+			content: content,
+		}, nil
+	}
+	return fs.Base.Open(path)
+}
+func (f overlayFile) Close() error {
+	return nil
+}
+func (f overlayFile) Info() (os.FileInfo, error) {
+	return craftedFileInfo {
+		name:    f.name,
+		size:    int64(len(f.content)),
+		mode:    0644,
+		modTime: time.Now(),
+	}, nil
+}
+func (f overlayFile) ReadDir() ([] os.FileInfo, error) {
+	return nil, errors.New(fmt.Sprintf("%s is not a directory", f.name))
+}
+func (f overlayFile) ReadContent() ([] byte, error) {
+	return f.content, nil
+}
+
 type craftedFileInfo struct {
 	name     string
 	size     int64
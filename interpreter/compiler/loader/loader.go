@@ -102,6 +102,15 @@ func LoadEntry(path string) (*Module, Index, ResIndex, *Error) {
 	return loadEntry(abs_path, RealFileSystem {})
 }
 
+// LoadEntryFrom is similar to LoadEntry but reads files from the
+// specified file system. The standard library is always read from
+// the real file system.
+func LoadEntryFrom(path string, fs FileSystem) (*Module, Index, ResIndex, *Error) {
+	var abs_path, e = entryPathToAbsPath(path)
+	if e != nil { return nil, nil, nil, e }
+	return loadEntry(abs_path, fs)
+}
+
 func LoadEntryThunk(raw_mod ModuleThunk) (*Module, Index, ResIndex, *Error) {
 	return loadEntryThunk(raw_mod, RealFileSystem {})
 }
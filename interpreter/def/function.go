@@ -36,6 +36,22 @@ type FuncInfo struct {
 	SourceMap  [] ErrorPoint
 }
 
+// GeneratedFunctionSeed is stored in a F_GENERATED function instead of
+// the generated value, which makes it possible to write the function
+// into a program image.
+type GeneratedFunctionSeed interface {
+	GenerateFunctionValue() NativeFunctionValue
+}
+type PredefinedValueSeed struct {
+	Value  interface{}
+}
+func (seed PredefinedValueSeed) GenerateFunctionValue() NativeFunctionValue {
+	var v = seed.Value
+	return ValNativeFun(func(_ Value, _ InteropContext) Value {
+		return v
+	})
+}
+
 type UiObjectThunk struct {
 	Object  string
 	Group   *UiObjectGroup
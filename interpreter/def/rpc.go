@@ -70,6 +70,28 @@ func CreateServiceInstance (
 		methods: methods,
 	}
 }
+func CreateServiceCreator(method_names ([] string)) NativeFunctionValue {
+	return ValNativeFun(func(arg Value, h InteropContext) Value {
+		var prod = arg.(TupleValue)
+		var data = prod.Elements[0]
+		var ctx = prod.Elements[1].(TupleValue)
+		var dtor = ctx.Elements[0]
+		var methods = ctx.Elements[1:]
+		return CreateServiceInstance(data, dtor, methods, method_names, h)
+	})
+}
+type ServiceMethodCallerSeed struct {
+	MethodName  string
+}
+func (seed ServiceMethodCallerSeed) GenerateFunctionValue() NativeFunctionValue {
+	return CreateServiceMethodCaller(seed.MethodName)
+}
+type ServiceCreatorSeed struct {
+	MethodNames  [] string
+}
+func (seed ServiceCreatorSeed) GenerateFunctionValue() NativeFunctionValue {
+	return CreateServiceCreator(seed.MethodNames)
+}
 func AdaptServiceInstance(instance *rpc.ClientInstance) ServiceInstance {
 	return ClientSideServiceInstance {
 		underlying: instance,
@@ -80,6 +102,8 @@ type KmdApi interface {
 	GetTypeFromId(id kmd.TypeId) *kmd.Type
 	Serialize(v Value, t *kmd.Type) ([] byte, error)
 	Deserialize(binary ([] byte), t *kmd.Type) (Value, error)
+	Encode(v Value, t *kmd.Type, f kmd.Format) ([] byte, error)
+	Decode(data ([] byte), t *kmd.Type, f kmd.Format) (Value, error)
 	rpc.KmdApi
 }
 type KmdInfo struct {
@@ -95,6 +119,12 @@ type KmdValidatorTable  map[kmd.ValidatorId] KmdValidatorInfo
 type KmdValidatorInfo   struct {
 	Index  uint
 }
+type KmdApiFunctionSeed struct {
+	Id  kmd.TransformerPartId
+}
+func (seed KmdApiFunctionSeed) GenerateFunctionValue() NativeFunctionValue {
+	return CreateKmdApiFunction(seed.Id)
+}
 func CreateKmdApiFunction(id kmd.TransformerPartId) NativeFunctionValue {
 	switch id := id.(type) {
 	case kmd.SerializerId:
@@ -116,6 +146,37 @@ func CreateKmdApiFunction(id kmd.TransformerPartId) NativeFunctionValue {
 			if err != nil { return Ng(err) }
 			return Ok(obj)
 		})
+	case kmd.EncoderId:
+		return ValNativeFun(func(arg Value, h InteropContext) Value {
+			var api = h.GetKmdApi()
+			var t = api.GetTypeFromId(id.TypeId)
+			var data, err = api.Encode(arg, t, id.Format)
+			if err != nil {
+				var wrapped = fmt.Errorf("%s encoding error: %w", id.Format, err)
+				panic(wrapped)
+			}
+			switch id.Format {
+			case kmd.JsonFormat:
+				return string(data)
+			default:
+				return data
+			}
+		})
+	case kmd.DecoderId:
+		return ValNativeFun(func(arg Value, h InteropContext) Value {
+			var api = h.GetKmdApi()
+			var t = api.GetTypeFromId(id.TypeId)
+			var data ([] byte)
+			switch id.Format {
+			case kmd.JsonFormat:
+				data = ([] byte)(arg.(string))
+			default:
+				data = arg.([] byte)
+			}
+			var obj, err = api.Decode(data, t, id.Format)
+			if err != nil { return Ng(err) }
+			return Ok(obj)
+		})
 	default:
 		panic("impossible branch")
 	}
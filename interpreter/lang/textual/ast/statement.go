@@ -18,6 +18,7 @@ type Title struct {
 func (impl Import) Statement() {}
 type Import struct {
     Node               `part:"import"`
+    Tags  [] Tag       `list_rec:"tags"`
     Name  Identifier   `part:"name"`
     Path  StringText   `part:"string_text"`
 }
@@ -0,0 +1,140 @@
+package formatter
+
+import (
+	"fmt"
+	"errors"
+	"kumachan/interpreter/lang/textual/cst"
+	"kumachan/interpreter/lang/textual/parser"
+	"kumachan/interpreter/lang/textual/syntax"
+	"kumachan/interpreter/lang/textual/scanner"
+)
+
+
+/**
+ *  Source Formatter
+ *
+ *  The formatter prints the CST of a source file again, in which
+ *    (1) line breaks are decided by the structure and the source:
+ *        - each statement, doc and tag begins a new line;
+ *        - each case of an enum type begins a new line;
+ *        - other line breaks in the source are kept, unless they
+ *          are followed by a separator (`,` `;` `:` `:=`);
+ *        - more than two consecutive blank lines are merged into two;
+ *    (2) indentation is decided by the structure only:
+ *        - a line is indented relative to the line where the
+ *          innermost syntax unit containing its first token begins;
+ *        - items of a block, branches of a switch/select/if and
+ *          continued CPS expressions keep the indentation,
+ *          closing brackets and the `end` keyword as well;
+ *        - other parts (arguments, items of lists and records,
+ *          pipes, bodies of lambdas and branches) are indented;
+ *    (3) spaces inside a line are decided by the tokens only,
+ *        i.e. at most one space between two tokens, except that
+ *        names of fields in multi-line records are aligned.
+ *  Comments are kept at their original positions relative to tokens.
+ *  Since line breaks in the source are respected, formatting a file
+ *    twice yields the same result as formatting it once.
+ */
+
+const Indent = "    "
+const MaxBlankLines = 2
+
+type E_ParseFailed struct {
+	ParserError  *parser.Error
+}
+func (e E_ParseFailed) Error() string {
+	return e.ParserError.Message().String()
+}
+
+func Format(code ([] rune), name string) (string, error) {
+	var tree, err = parser.Parse(code, syntax.RootPartName, name)
+	if err != nil {
+		return "", E_ParseFailed { err }
+	}
+	var comments, s_err = scanComments(tree)
+	if s_err != nil { return "", s_err }
+	var p = &printer {
+		tree:     tree,
+		comments: comments,
+		padding:  make(map[int] int),
+		tight:    make(map[int] bool),
+		prevEnd:  -1,
+	}
+	p.node(0, 0)
+	p.flushComments(len(code), 0)
+	if p.started {
+		p.buf.WriteString("\n")
+		if p.countLines(len(code)) > 1 {
+			// a blank line at the end of file
+			p.buf.WriteString("\n")
+		}
+	}
+	var formatted = p.buf.String()
+	var v_err = verify(tree, comments, formatted)
+	if v_err != nil { return "", v_err }
+	return formatted, nil
+}
+
+// verify ensures that the formatted code consists of the same tokens
+// and comments as the original code.
+func verify(tree *cst.Tree, comments scanner.Tokens, formatted string) error {
+	var code = ([] rune)(formatted)
+	var tokens, _, _, err = scanner.Scan(code)
+	if err != nil { return err }
+	var new_tree = &cst.Tree { Code: code, Tokens: tokens }
+	new_comments, err := scanComments(new_tree)
+	if err != nil { return err }
+	var same = func(a scanner.Tokens, b scanner.Tokens) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i].Id != b[i].Id || string(a[i].Content) != string(b[i].Content) {
+				return false
+			}
+		}
+		return true
+	}
+	if !(same(tree.Tokens, tokens)) || !(same(comments, new_comments)) {
+		return errors.New(fmt.Sprintf(
+			"unable to format '%s': tokens changed after formatting", tree.Name))
+	}
+	return nil
+}
+
+// scanComments finds comments in the gaps between tokens,
+// which are ignored by the scanner.
+func scanComments(tree *cst.Tree) (scanner.Tokens, error) {
+	var comment = syntax.Name2IdMustExist("Comment")
+	var comments = make(scanner.Tokens, 0)
+	var scan_gap = func(start int, end int) error {
+		var pos = start
+		for pos < end {
+			var amount, id = scanner.MatchToken(tree.Code, pos, false)
+			if amount == 0 {
+				return errors.New(fmt.Sprintf(
+					"unable to format '%s': invalid code in position %d",
+					tree.Name, pos))
+			}
+			if id == comment {
+				var span = scanner.Span { Start: pos, End: (pos + amount) }
+				comments = append(comments, scanner.Token {
+					Id:      id,
+					Span:    span,
+					Content: tree.Code[span.Start: span.End],
+				})
+			}
+			pos += amount
+		}
+		return nil
+	}
+	var pos = 0
+	for _, token := range tree.Tokens {
+		var err = scan_gap(pos, token.Span.Start)
+		if err != nil { return nil, err }
+		pos = token.Span.End
+	}
+	var err = scan_gap(pos, len(tree.Code))
+	if err != nil { return nil, err }
+	return comments, nil
+}
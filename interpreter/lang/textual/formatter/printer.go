@@ -0,0 +1,399 @@
+package formatter
+
+import (
+	"strings"
+	"unicode/utf8"
+	"kumachan/interpreter/lang/textual/cst"
+	"kumachan/interpreter/lang/textual/syntax"
+	"kumachan/interpreter/lang/textual/scanner"
+)
+
+
+type printer struct {
+	tree         *cst.Tree
+	comments     scanner.Tokens
+	nextComment  int
+	buf          strings.Builder
+	started      bool
+	lineIndent   int   // indentation level of the current line
+	pending      [] *construct
+	prev         leaf
+	prevEnd      int   // end of the last token or comment in the code
+	afterComment bool  // the last thing written is a comment
+	force        bool  // a line break is required before the next token
+	mustBreak    bool  // a line comment is written
+	padding      map[int] int   // token index -> extra spaces after it
+	tight        map[int] bool  // token index -> no spaces inside braces
+}
+
+// construct is a syntax unit that lines inside it are indented
+// relative to the line where it begins.
+type construct struct {
+	rule   string
+	base   int
+	ready  bool
+}
+
+type leaf struct {
+	text       string
+	parent     string
+	index      int
+	first      bool
+	tight      bool
+	lineStart  bool
+}
+
+var transparentRules = (func() (map[string] bool) {
+	var set = make(map[string] bool)
+	for _, name := range [] string {
+		"stmts", "stmt", "docs", "doc", "tags", "tag", "scope", "name",
+		"type", "type_literal", "repr", "input_type", "output_type",
+		"type_list", "more_types", "field_list", "more_fields",
+		"type_def", "t_boxed", "t_implicit", "box_option", "match_option",
+		"inner_type", "more_decl_types", "more_type_params",
+		"sig", "body", "pattern", "namelist", "more_names",
+		"field_map_list", "more_field_maps",
+		"const_def", "const_value",
+		"pipes", "pipe", "callee", "pipe_func_arg",
+		"term", "call", "infix_left", "operator", "infix_right",
+		"branch_list", "more_branches", "type_ref_list", "more_type_refs",
+		"exprlist", "more_exprs", "multi_branch_list", "more_multi_branches",
+		"cond", "elifs", "if_yes", "if_no",
+		"more_bindings", "block_value", "cps_input", "cps_output",
+		"pairlist", "more_pairs", "formatter_parts", "formatter_part",
+		"formatter_text", "string_parts", "string_part", "string_text",
+		"int", "float", "char",
+	} {
+		set[name] = true
+	}
+	return set
+})()
+
+var declarationHeads = map[string] bool {
+	"docs": true, "doc": true, "Doc": true,
+	"tags": true, "tag": true, "Tag": true,
+	"scope": true, "@export": true, "name": true,
+	"@function": true, "@const": true, "@type": true, "@import": true,
+}
+
+func delta(rule string, part string) int {
+	switch part {
+	case ")", "]", "}", "@end":
+		return 0
+	}
+	switch rule {
+	case "root", "block":
+		return 0
+	case "decl_func", "decl_const", "decl_type", "field", "import":
+		if declarationHeads[part] {
+			return 0
+		}
+	case "switch":
+		switch part {
+		case "branch_list", "more_branches", "branch":
+			return 0
+		}
+	case "multi_switch":
+		switch part {
+		case "multi_branch_list", "more_multi_branches", "multi_branch":
+			return 0
+		}
+	case "if":
+		switch part {
+		case "elifs", "elif", "Else":
+			return 0
+		}
+	case "cps":
+		if part == "cps_output" {
+			return 0
+		}
+	}
+	return 1
+}
+
+func forced(rule string, part string) bool {
+	switch rule {
+	case "root":
+		return (part == "stmt")
+	case "t_enum":
+		return (part == "decl_type" || part == "}")
+	default:
+		return false
+	}
+}
+
+func canBreak(cur leaf) bool {
+	switch cur.text {
+	case ",", ";", ":", ":=":
+		return false
+	default:
+		return true
+	}
+}
+
+func space(prev leaf, cur leaf) bool {
+	var p = prev.text
+	var c = cur.text
+	if p == "." {
+		// pipes beginning a line: ". { f x }"
+		return prev.lineStart
+	}
+	switch c {
+	case ",", ";", ":", ")", "]", ".", "..", "::[":
+		return false
+	case "}":
+		return !(p == "{" || cur.tight)
+	case "::":
+		if !(cur.first) {
+			return false
+		}
+	case "[":
+		switch cur.parent {
+		case "type_args", "type_params", "type_param_default", "pipe_cast":
+			return false
+		}
+	}
+	switch p {
+	case "(", "[", "::", "::[", "..":
+		return false
+	case "{":
+		return !(prev.tight)
+	case "&":
+		return (c == "{")
+	}
+	return true
+}
+
+func ruleName(node *cst.TreeNode) string {
+	return syntax.Id2Name(node.Part.Id)
+}
+
+func (p *printer) node(ptr int, cont int) {
+	var node = &p.tree.Nodes[ptr]
+	var c = &construct { rule: ruleName(node) }
+	p.pending = append(p.pending, c)
+	switch c.rule {
+	case "repr_record":
+		p.align(ptr, "field")
+	case "record":
+		p.align(ptr, "pair")
+	case "pipe_func":
+		p.tighten(ptr)
+	}
+	p.children(ptr, c, cont)
+}
+
+func (p *printer) children(ptr int, c *construct, cont int) {
+	var node = &p.tree.Nodes[ptr]
+	var parent = ruleName(node)
+	var first = true
+	for i := 0; i < node.Length; i += 1 {
+		var child_ptr = node.Children[i]
+		var child = &p.tree.Nodes[child_ptr]
+		if child.Amount == 0 {
+			continue
+		}
+		var name = ruleName(child)
+		var child_cont = cont
+		if !(first) && c.ready {
+			child_cont = (c.base + delta(c.rule, name))
+		}
+		if forced(c.rule, name) && p.started {
+			p.force = true
+		}
+		if child.Part.PartType != syntax.Recursive {
+			p.token(child_ptr, parent, first, child_cont)
+		} else if transparentRules[name] {
+			p.children(child_ptr, c, child_cont)
+		} else {
+			p.node(child_ptr, child_cont)
+		}
+		first = false
+	}
+}
+
+func (p *printer) token(ptr int, parent string, first bool, cont int) {
+	var node = &p.tree.Nodes[ptr]
+	var token = p.tree.Tokens[node.Pos]
+	var cur = leaf {
+		text:    string(token.Content),
+		parent:  parent,
+		index:   node.Pos,
+		first:   first,
+		tight:   p.tight[node.Pos],
+	}
+	var comment_cont = cont
+	if delta("", ruleName(node)) == 0 {
+		// comments before a closing bracket or the `end` keyword
+		comment_cont += 1
+	}
+	p.flushComments(token.Span.Start, comment_cont)
+	var lines = p.countLines(token.Span.Start)
+	var should_break = (p.force || p.mustBreak || (lines > 0 && canBreak(cur)))
+	if p.started && should_break {
+		p.newline(lines, cont)
+		cur.lineStart = true
+	} else if p.afterComment {
+		p.buf.WriteString(" ")
+	} else if p.started && space(p.prev, cur) {
+		p.buf.WriteString(strings.Repeat(" ", (1 + p.padding[p.prev.index])))
+	} else if !(p.started) {
+		cur.lineStart = true
+	}
+	p.buf.WriteString(cur.text)
+	p.started = true
+	p.afterComment = false
+	p.mustBreak = false
+	switch syntax.Id2Name(token.Id) {
+	case "Shebang", "Doc", "Tag":
+		p.force = true
+	default:
+		p.force = false
+	}
+	p.prev = cur
+	p.prevEnd = token.Span.End
+	for _, c := range p.pending {
+		c.base = p.lineIndent
+		c.ready = true
+	}
+	p.pending = p.pending[:0]
+}
+
+func (p *printer) flushComments(pos int, cont int) {
+	for p.nextComment < len(p.comments) {
+		var comment = p.comments[p.nextComment]
+		if !(comment.Span.Start < pos) {
+			break
+		}
+		var lines = p.countLines(comment.Span.Start)
+		if p.started && (lines > 0 || p.mustBreak) {
+			p.newline(lines, cont)
+		} else if p.started {
+			p.buf.WriteString(" ")
+		}
+		var content = string(comment.Content)
+		p.buf.WriteString(content)
+		p.started = true
+		p.afterComment = true
+		p.mustBreak = strings.HasPrefix(content, "//")
+		p.prevEnd = comment.Span.End
+		p.nextComment += 1
+	}
+}
+
+// countLines counts line breaks between the last token or comment
+// and the specified position in the code.
+func (p *printer) countLines(pos int) int {
+	if p.prevEnd < 0 {
+		return 0
+	}
+	var count = 0
+	for _, char := range p.tree.Code[p.prevEnd: pos] {
+		if char == '\n' {
+			count += 1
+		}
+	}
+	return count
+}
+
+func (p *printer) newline(lines int, indent int) {
+	p.buf.WriteString("\n")
+	for i := 1; i < lines && i <= MaxBlankLines; i += 1 {
+		p.buf.WriteString("\n")
+	}
+	p.buf.WriteString(strings.Repeat(Indent, indent))
+	p.lineIndent = indent
+}
+
+// align pads names of fields in a multi-line record, so that
+// the types or values of the fields are aligned.
+func (p *printer) align(ptr int, item string) {
+	var items = p.collect(ptr, item)
+	if len(items) < 2 {
+		return
+	}
+	var first = p.tree.Nodes[items[0]].Span.Start
+	var last = p.tree.Nodes[items[len(items)-1]].Span.Start
+	if p.tree.Info[first].Row == p.tree.Info[last].Row {
+		return
+	}
+	var widths = make(map[int] int)
+	var max = 0
+	for _, item_ptr := range items {
+		var name_ptr = p.child(item_ptr, "name")
+		var colon_ptr = p.child(item_ptr, ":")
+		if name_ptr == -1 || colon_ptr == -1 {
+			continue
+		}
+		var name_token = p.tree.Tokens[p.tree.Nodes[name_ptr].Pos]
+		var width = utf8.RuneCountInString(string(name_token.Content))
+		var colon = p.tree.Nodes[colon_ptr].Pos
+		widths[colon] = width
+		if width > max {
+			max = width
+		}
+	}
+	for colon, width := range widths {
+		p.padding[colon] = (max - width)
+	}
+}
+
+// tighten removes spaces inside braces of an inline pipe which
+// consists of a single name, e.g. "x.{String}".
+func (p *printer) tighten(ptr int) {
+	if p.child(ptr, "pipe_func_arg") != -1 {
+		return
+	}
+	var callee = p.child(ptr, "callee")
+	if callee == -1 || p.tree.Nodes[callee].Amount != 1 {
+		return
+	}
+	var lb = p.child(ptr, "{")
+	var rb = p.child(ptr, "}")
+	if lb == -1 || rb == -1 {
+		return
+	}
+	var lb_pos = p.tree.Nodes[lb].Pos
+	if lb_pos >= 2 {
+		var dot = p.tree.Tokens[lb_pos - 1]
+		var before_dot = p.tree.Tokens[lb_pos - 2]
+		for _, char := range p.tree.Code[before_dot.Span.End: dot.Span.Start] {
+			if char == '\n' {
+				return
+			}
+		}
+	}
+	p.tight[lb_pos] = true
+	p.tight[p.tree.Nodes[rb].Pos] = true
+}
+
+func (p *printer) collect(ptr int, item string) ([] int) {
+	var items = make([] int, 0)
+	var node = &p.tree.Nodes[ptr]
+	for i := 0; i < node.Length; i += 1 {
+		var child_ptr = node.Children[i]
+		var child = &p.tree.Nodes[child_ptr]
+		if child.Amount == 0 {
+			continue
+		}
+		var name = ruleName(child)
+		if name == item {
+			items = append(items, child_ptr)
+		} else if transparentRules[name] && child.Part.PartType == syntax.Recursive {
+			items = append(items, p.collect(child_ptr, item)...)
+		}
+	}
+	return items
+}
+
+func (p *printer) child(ptr int, part string) int {
+	var node = &p.tree.Nodes[ptr]
+	for i := 0; i < node.Length; i += 1 {
+		var child_ptr = node.Children[i]
+		var child = &p.tree.Nodes[child_ptr]
+		if child.Amount > 0 && ruleName(child) == part {
+			return child_ptr
+		}
+	}
+	return -1
+}
@@ -120,7 +120,7 @@ var __SyntaxDefinition = [...] string {
       "stmts? = stmt stmts",
         "stmt = title | import | do | decl_type | decl_const | decl_func",
           "title = Title",
-          "import = @import name! @from! string_text! ;!",
+          "import = tags @import name! @from! string_text! ;!",
             "name = Name",
           "do = @do expr! ;!",
     "repl_root = repl_assign | repl_do | repl_eval",
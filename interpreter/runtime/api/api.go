@@ -17,6 +17,7 @@ var NativeFunctionMaps = [] (map[string] interface{}) {
 	BitwiseFunctions,
 	IO_Functions,
 	OS_Functions,
+	TimeFunctions,
 	NetFunctions,
 	RpcFunctions,
 	UiFunctions,
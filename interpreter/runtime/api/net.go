@@ -1,7 +1,9 @@
 package api
 
 import (
+	"time"
 	"net/url"
+	"net/http"
 	"math/big"
 	"kumachan/standalone/rx"
 	. "kumachan/interpreter/def"
@@ -10,6 +12,54 @@ import (
 )
 
 
+const httpReadChunkSize = 4096
+
+func AdaptHttpRequest(req TupleValue) rx.HttpRequest {
+	var method = req.Elements[0].(string)
+	var u = req.Elements[1].(*url.URL)
+	var header = make(http.Header)
+	req.Elements[2].(Map).ForEach(func(k Value, v Value) {
+		header[k.(string)] = ListFrom(v).CopyAsStringSlice()
+	})
+	var body ([] byte)
+	var body_stream *rx.Observable
+	var body_enum = req.Elements[3].(EnumValue)
+	switch body_enum.Index {
+	case 0:
+		// no body
+	case 1:
+		body = body_enum.Value.([] byte)
+	case 2:
+		var stream = body_enum.Value.(rx.Observable)
+		body_stream = &stream
+	default:
+		panic("impossible branch")
+	}
+	var timeout time.Duration
+	var timeout_v, has_timeout = Unwrap(req.Elements[4].(EnumValue))
+	if has_timeout {
+		timeout = timeout_v.(time.Duration)
+	}
+	// cases of the HttpRedirectPolicy type are declared in the order of rx.HttpRedirectPolicy
+	var redirect = rx.HttpRedirectPolicy(req.Elements[5].(EnumValue).Index)
+	return rx.HttpRequest {
+		Method:     method,
+		URL:        u,
+		Header:     header,
+		Body:       body,
+		BodyStream: body_stream,
+		Timeout:    timeout,
+		Redirect:   redirect,
+	}
+}
+func AdaptHttpHeader(h http.Header) Map {
+	var m = NewMapOfStringKey()
+	for k, v := range h {
+		m, _ = m.Inserted(k, v)
+	}
+	return m
+}
+
 var NetFunctions = map[string] interface{} {
 	"parse-url": func(str string) EnumValue {
 		var url, err = url.Parse(str)
@@ -28,12 +78,7 @@ var NetFunctions = map[string] interface{} {
 		return util.GetNumberUint(res.StatusCode)
 	},
 	"http-response-header": func(res rx.HttpResponse) Map {
-		var h = res.Header
-		var m = NewMapOfStringKey()
-		for k, v := range h {
-			m, _ = m.Inserted(k, v)
-		}
-		return m
+		return AdaptHttpHeader(res.Header)
 	},
 	"http-response-body": func(res rx.HttpResponse) ([] byte) {
 		return res.Body
@@ -41,4 +86,19 @@ var NetFunctions = map[string] interface{} {
 	"http-get": func(url *url.URL) rx.Observable {
 		return rx.HttpGet(url)
 	},
+	"http-request": func(req TupleValue) rx.Observable {
+		return rx.HttpDo(AdaptHttpRequest(req))
+	},
+	"http-request-stream": func(req TupleValue) rx.Observable {
+		return rx.HttpDoStream(AdaptHttpRequest(req))
+	},
+	"http-stream-status-code": func(s rx.HttpResponseStream) *big.Int {
+		return util.GetNumberUint(s.StatusCode)
+	},
+	"http-stream-header": func(s rx.HttpResponseStream) Map {
+		return AdaptHttpHeader(s.Header)
+	},
+	"http-stream-body": func(s rx.HttpResponseStream) rx.Observable {
+		return s.ReadBody(httpReadChunkSize)
+	},
 }
@@ -119,6 +119,47 @@ func GetArgs(raw ([] string)) ([] string) {
 	return args
 }
 
+const fileReadChunkSize = 4096
+const watchPollInterval = (500 * time.Millisecond)
+
+type ProcessStatus struct {
+	Code     *big.Int
+	Success  bool
+}
+func AdaptCommand(cmd TupleValue) rx.ProcessOptions {
+	var program = cmd.Elements[0].(string)
+	var args = ListFrom(cmd.Elements[1]).CopyAsStringSlice()
+	var env ([] string)
+	var env_map, has_env = Unwrap(cmd.Elements[2].(EnumValue))
+	if has_env {
+		env = make([] string, 0)
+		env_map.(Map).ForEach(func(k Value, v Value) {
+			env = append(env, (k.(string) + "=" + v.(string)))
+		})
+	}
+	var dir string
+	var dir_path, has_dir = Unwrap(cmd.Elements[3].(EnumValue))
+	if has_dir {
+		dir = dir_path.(stdlib.Path).String()
+	}
+	return rx.ProcessOptions {
+		Program: program,
+		Args:    args,
+		Env:     env,
+		Dir:     dir,
+	}
+}
+
+// exitInterceptor is implemented by interop contexts of machines
+// embedded in a host process, which should not exit with the program.
+type exitInterceptor interface {
+	InterceptExit(code int) bool
+}
+func interceptExit(h InteropContext, code int) bool {
+	var i, ok = h.(exitInterceptor)
+	return (ok && i.InterceptExit(code))
+}
+
 var OS_Functions = map[string] Value {
 	"String from Path": func(path stdlib.Path) string {
 		return path.String()
@@ -207,11 +248,130 @@ var OS_Functions = map[string] Value {
 			return string(runes.([] rune))
 		})
 	},
+	"file-read-chunks": func(f rx.File) rx.Observable {
+		return f.ReadChunks(fileReadChunkSize)
+	},
 	"file-read-all": func(f rx.File) rx.Observable {
 		return f.ReadAll()
 	},
-	"exit": func(code *big.Int) rx.Observable {
-		return rx.NewSync(func() (rx.Object, bool) {
+	"file-with": func(open rx.Observable, use Value, h InteropContext) rx.Observable {
+		return rx.WithFile(open, func(f rx.File) rx.Observable {
+			return h.Call(use, f).(rx.Observable)
+		})
+	},
+	"file-mode": func(perm *big.Int) uint32 {
+		var n = util.GetUintNumber(perm)
+		if n > uint(os.ModePerm) { panic("invalid file permission") }
+		return uint32(n)
+	},
+	"file-mode-permission": func(mode uint32) *big.Int {
+		return big.NewInt(int64(os.FileMode(mode).Perm()))
+	},
+	"file-mode-is-dir": func(mode uint32) EnumValue {
+		return ToBool(os.FileMode(mode).IsDir())
+	},
+	"file-mode-is-symlink": func(mode uint32) EnumValue {
+		return ToBool((os.FileMode(mode) & os.ModeSymlink) != 0)
+	},
+	"String from FileMode": func(mode uint32) string {
+		return os.FileMode(mode).String()
+	},
+	"path-stat": func(path stdlib.Path) rx.Observable {
+		return rx.Stat(path.String()).Map(func(state rx.Object) rx.Object {
+			return Struct2Prod(state)
+		})
+	},
+	"path-lstat": func(path stdlib.Path) rx.Observable {
+		return rx.Lstat(path.String()).Map(func(state rx.Object) rx.Object {
+			return Struct2Prod(state)
+		})
+	},
+	"mkdir": func(path stdlib.Path, mode uint32) rx.Observable {
+		return rx.Mkdir(path.String(), os.FileMode(mode))
+	},
+	"mkdir-all": func(path stdlib.Path, mode uint32) rx.Observable {
+		return rx.MkdirAll(path.String(), os.FileMode(mode))
+	},
+	"remove": func(path stdlib.Path) rx.Observable {
+		return rx.Remove(path.String())
+	},
+	"remove-all": func(path stdlib.Path) rx.Observable {
+		return rx.RemoveAll(path.String())
+	},
+	"rename": func(old_path stdlib.Path, new_path stdlib.Path) rx.Observable {
+		return rx.Rename(old_path.String(), new_path.String())
+	},
+	"copy-file": func(src stdlib.Path, dst stdlib.Path) rx.Observable {
+		return rx.CopyFile(src.String(), dst.String())
+	},
+	"chmod": func(path stdlib.Path, mode uint32) rx.Observable {
+		return rx.Chmod(path.String(), os.FileMode(mode))
+	},
+	"read-link": func(path stdlib.Path) rx.Observable {
+		return rx.ReadLink(path.String()).Map(func(target rx.Object) rx.Object {
+			return stdlib.ParsePath(target.(string))
+		})
+	},
+	"resolve-symlinks": func(path stdlib.Path) rx.Observable {
+		return rx.EvalSymlinks(path.String()).Map(func(resolved rx.Object) rx.Object {
+			return stdlib.ParsePath(resolved.(string))
+		})
+	},
+	"temp-file": func(pattern string) rx.Observable {
+		return rx.OpenTempFile("", pattern).Map(func(obj rx.Object) rx.Object {
+			var item = obj.(rx.TempFile)
+			return Tuple(stdlib.ParsePath(item.Path), item.File)
+		})
+	},
+	"temp-dir": func(pattern string) rx.Observable {
+		return rx.CreateTempDir("", pattern).Map(func(path rx.Object) rx.Object {
+			return stdlib.ParsePath(path.(string))
+		})
+	},
+	"watch-path": func(path stdlib.Path, opts TupleValue) rx.Observable {
+		var recursive = FromBool(opts.Elements[0].(EnumValue))
+		var polling = FromBool(opts.Elements[1].(EnumValue))
+		return rx.WatchPath(path.String(), rx.WatchOptions {
+			Recursive:    recursive,
+			Polling:      polling,
+			PollInterval: watchPollInterval,
+		}).Map(func(obj rx.Object) rx.Object {
+			var event = obj.(rx.FileEvent)
+			// cases of the FileEventKind type are declared in the order of rx.FileEventKind
+			var kind = &ValEnum { Index: uint(event.Kind) }
+			return Tuple(kind, stdlib.ParsePath(event.Path))
+		})
+	},
+	"process-spawn": func(cmd TupleValue) rx.Observable {
+		return rx.SpawnProcess(AdaptCommand(cmd))
+	},
+	"process-stdin": func(p rx.Process) rx.File {
+		return p.Stdin
+	},
+	"process-stdout": func(p rx.Process) rx.File {
+		return p.Stdout
+	},
+	"process-stderr": func(p rx.Process) rx.File {
+		return p.Stderr
+	},
+	"process-wait": func(p rx.Process) rx.Observable {
+		return p.Wait().Map(func(status_ rx.Object) rx.Object {
+			var status = status_.(rx.ProcessStatus)
+			return Struct2Prod(ProcessStatus {
+				Code:    big.NewInt(int64(status.ExitCode)),
+				Success: status.Success,
+			})
+		})
+	},
+	"process-kill": func(p rx.Process) rx.Observable {
+		return p.Kill()
+	},
+	"exit": func(code *big.Int, h InteropContext) rx.Observable {
+		return rx.NewSyncWithSender(func(_ rx.Sender) {
+			if interceptExit(h, int(code.Int64())) {
+				// the machine is stopped and the host process keeps running
+				return
+			}
 			qt.Quit(func() {
 				os.Exit(int(code.Int64()))
 			})
@@ -5,20 +5,38 @@ import (
 	"math/big"
 	"kumachan/standalone/rx"
 	"kumachan/standalone/rpc"
+	"kumachan/standalone/rpc/kmd"
 	"kumachan/interpreter/runtime/lib/librpc"
+	"kumachan/interpreter/runtime/lib/container"
 	. "kumachan/interpreter/def"
 	"kumachan/standalone/util"
 )
 
 
 func rpcAdaptServerOptions(opts TupleValue) librpc.ServerOptions {
+	var interceptors = make([] rpc.Interceptor, 0)
+	container.ListFrom(opts.Elements[1]).ForEach(func(_ uint, v Value) {
+		interceptors = append(interceptors, v.(rpc.Interceptor))
+	})
 	return librpc.ServerOptions {
 		CommonOptions: rpcAdaptCommonOptions(opts.Elements[0].(TupleValue)),
+		Interceptors:  interceptors,
 	}
 }
 func rpcAdaptClientOptions(opts TupleValue) librpc.ClientOptions {
 	return librpc.ClientOptions {
 		CommonOptions: rpcAdaptCommonOptions(opts.Elements[0].(TupleValue)),
+		Encoding:      rpcAdaptEncoding(opts.Elements[1].(EnumValue)),
+	}
+}
+func rpcAdaptEncoding(enc EnumValue) kmd.Encoding {
+	switch enc.Index {
+	case 0:
+		return kmd.TextEncoding
+	case 1:
+		return kmd.BinaryEncoding
+	default:
+		panic("impossible branch")
 	}
 }
 func rpcAdaptCommonOptions(opts TupleValue) librpc.CommonOptions {
@@ -30,6 +48,58 @@ func rpcAdaptCommonOptions(opts TupleValue) librpc.CommonOptions {
 		Limits:     limits,
 	}
 }
+func rpcAdaptServerTLSOptions(opts TupleValue) (librpc.KeyPair, librpc.PEM) {
+	var key_pair = rpcAdaptKeyPair(opts.Elements[0].(TupleValue))
+	var client_ca librpc.PEM
+	var ca, has_ca = Unwrap(opts.Elements[1].(EnumValue))
+	if has_ca {
+		client_ca = rpcAdaptPEM(ca.(EnumValue))
+	}
+	return key_pair, client_ca
+}
+func rpcAdaptClientTLSOptions(opts TupleValue) (string, librpc.PEM, *librpc.KeyPair) {
+	var server_name = opts.Elements[0].(string)
+	var ca librpc.PEM
+	var key_pair *librpc.KeyPair
+	var ca_v, has_ca = Unwrap(opts.Elements[1].(EnumValue))
+	if has_ca {
+		ca = rpcAdaptPEM(ca_v.(EnumValue))
+	}
+	var pair_v, has_pair = Unwrap(opts.Elements[2].(EnumValue))
+	if has_pair {
+		var pair = rpcAdaptKeyPair(pair_v.(TupleValue))
+		key_pair = &pair
+	}
+	return server_name, ca, key_pair
+}
+func rpcAdaptKeyPair(pair TupleValue) librpc.KeyPair {
+	return librpc.KeyPair {
+		Cert: rpcAdaptPEM(pair.Elements[0].(EnumValue)),
+		Key:  rpcAdaptPEM(pair.Elements[1].(EnumValue)),
+	}
+}
+func rpcAdaptPEM(pem EnumValue) librpc.PEM {
+	switch pem.Index {
+	case 0:
+		return librpc.PemFile(pem.Value.(string))
+	case 1:
+		return librpc.PemData(pem.Value.([] byte))
+	default:
+		panic("impossible branch")
+	}
+}
+func rpcAdaptCallInfo(call rpc.CallInfo) TupleValue {
+	var metadata = container.NewMapOfStringKey()
+	for k, v := range call.Metadata {
+		metadata, _ = metadata.Inserted(k, v)
+	}
+	return Tuple(
+		rpc.DescribeServiceIdentifier(call.Connection.Service),
+		call.Method,
+		metadata,
+		call.Connection.RemoteAddr.String(),
+	)
+}
 func rpcAdaptLimitOptions(opts TupleValue) rpc.Limits {
 	var ms = func(v Value) time.Duration {
 		var n = v.(*big.Int)
@@ -56,6 +126,58 @@ var RpcFunctions = map[string] interface{} {
 			Address: addr,
 		}
 	},
+	"rpc-server-pipe": func(opts TupleValue) librpc.ServerBackend {
+		return librpc.ServerPipe {
+			Name: SingleValueFromRecord(opts).(string),
+		}
+	},
+	"rpc-client-pipe": func(opts TupleValue) librpc.ClientBackend {
+		return librpc.ClientPipe {
+			Name: SingleValueFromRecord(opts).(string),
+		}
+	},
+	"rpc-server-unix": func(opts TupleValue) librpc.ServerBackend {
+		return librpc.ServerUnix {
+			Path: SingleValueFromRecord(opts).(string),
+		}
+	},
+	"rpc-client-unix": func(opts TupleValue) librpc.ClientBackend {
+		return librpc.ClientUnix {
+			Path: SingleValueFromRecord(opts).(string),
+		}
+	},
+	"rpc-server-tls-net": func(network string, addr string, opts TupleValue) librpc.ServerBackend {
+		var key_pair, client_ca = rpcAdaptServerTLSOptions(opts)
+		return librpc.ServerTLSNet {
+			Network:  network,
+			Address:  addr,
+			KeyPair:  key_pair,
+			ClientCA: client_ca,
+		}
+	},
+	"rpc-client-tls-net": func(network string, addr string, opts TupleValue) librpc.ClientBackend {
+		var server_name, ca, key_pair = rpcAdaptClientTLSOptions(opts)
+		return librpc.ClientTLSNet {
+			Network:    network,
+			Address:    addr,
+			ServerName: server_name,
+			CA:         ca,
+			KeyPair:    key_pair,
+		}
+	},
+	"rpc-interceptor-authenticate": func(check Value, h InteropContext) rpc.Interceptor {
+		return rpc.Authenticate(func(call rpc.CallInfo) rx.Observable {
+			return h.Call(check, rpcAdaptCallInfo(call)).(rx.Observable)
+		})
+	},
+	"rpc-interceptor-rate-limit": func(opts TupleValue) rpc.Interceptor {
+		var rate = util.GetUintNumber(opts.Elements[0].(*big.Int))
+		var burst = util.GetUintNumber(opts.Elements[1].(*big.Int))
+		return rpc.RateLimit(float64(rate), burst)
+	},
+	"rpc-interceptor-access-log": func() rpc.Interceptor {
+		return rpc.AccessLog()
+	},
 	"rpc-connection-close": func(conn *rx.WrappedConnection) rx.Observable {
 		return rx.NewSync(func() (rx.Object, bool) {
 			_ = conn.Close()
@@ -263,7 +263,7 @@ var EffectFunctions = map[string] Value {
 		const reset = "\033[0m"
 		var point = h.ErrorPoint()
 		var source_point = point.Node.Point
-		return rx.NewSync(func() (rx.Object, bool) {
+		return rx.NewSyncWithSender(func(_ rx.Sender) {
 			fmt.Fprintf (
 				os.Stderr, "%v*** Crash: (%d, %d) at %s%v\n",
 				bold+red,
@@ -274,6 +274,9 @@ var EffectFunctions = map[string] Value {
 				os.Stderr, "%v%s%v\n",
 				bold+red, msg, reset,
 			)
+			if interceptExit(h, 255) {
+				return
+			}
 			os.Exit(255)
 			// noinspection GoUnreachableCode
 			panic("program should have crashed")
@@ -1,8 +1,11 @@
 package api
 
 import (
-	. "kumachan/interpreter/def"
 	"time"
+	"math/big"
+	"kumachan/standalone/rx"
+	"kumachan/standalone/util"
+	. "kumachan/interpreter/def"
 )
 
 
@@ -14,3 +17,158 @@ var TimeConstants = map[string] NativeConstant {
 		return time.Local
 	},
 }
+
+type Date struct {
+	Year   *big.Int
+	Month  *big.Int
+	Day    *big.Int
+}
+type Clock struct {
+	Hour        *big.Int
+	Minute      *big.Int
+	Second      *big.Int
+	Nanosecond  *big.Int
+}
+var nanosecondsPerSecond = big.NewInt(int64(time.Second))
+
+var TimeFunctions = map[string] Value {
+	"time-now": func() rx.Observable {
+		return rx.NewSync(func() (rx.Object, bool) {
+			return time.Now(), true
+		})
+	},
+	"=Time": func(a time.Time, b time.Time) EnumValue {
+		return ToBool(a.Equal(b))
+	},
+	"<Time": func(a time.Time, b time.Time) EnumValue {
+		return ToBool(a.Before(b))
+	},
+	"time+duration": func(t time.Time, d time.Duration) time.Time {
+		return t.Add(d)
+	},
+	"time-duration": func(t time.Time, d time.Duration) time.Time {
+		return t.Add(-d)
+	},
+	"time-time": func(t time.Time, u time.Time) time.Duration {
+		return t.Sub(u)
+	},
+	"time-add-date": func(t time.Time, delta TupleValue) time.Time {
+		var years = util.GetIntInteger(delta.Elements[0].(*big.Int))
+		var months = util.GetIntInteger(delta.Elements[1].(*big.Int))
+		var days = util.GetIntInteger(delta.Elements[2].(*big.Int))
+		return t.AddDate(years, months, days)
+	},
+	"time-unix-seconds": func(t time.Time) *big.Int {
+		return big.NewInt(t.Unix())
+	},
+	"time-unix-nanoseconds": func(t time.Time) *big.Int {
+		// t.UnixNano() overflows beyond the years 1678 and 2262
+		var n = big.NewInt(t.Unix())
+		n.Mul(n, nanosecondsPerSecond)
+		n.Add(n, big.NewInt(int64(t.Nanosecond())))
+		return n
+	},
+	"time-from-unix-seconds": func(sec *big.Int) time.Time {
+		return time.Unix(util.GetInt64Integer(sec), 0)
+	},
+	"time-from-unix-nanoseconds": func(n *big.Int) time.Time {
+		var sec, nsec = new(big.Int).DivMod(n, nanosecondsPerSecond, new(big.Int))
+		return time.Unix(util.GetInt64Integer(sec), nsec.Int64())
+	},
+	"time-date": func(t time.Time) TupleValue {
+		var year, month, day = t.Date()
+		return Struct2Prod(Date {
+			Year:  big.NewInt(int64(year)),
+			Month: big.NewInt(int64(month)),
+			Day:   big.NewInt(int64(day)),
+		})
+	},
+	"time-clock": func(t time.Time) TupleValue {
+		var hour, minute, second = t.Clock()
+		return Struct2Prod(Clock {
+			Hour:       big.NewInt(int64(hour)),
+			Minute:     big.NewInt(int64(minute)),
+			Second:     big.NewInt(int64(second)),
+			Nanosecond: big.NewInt(int64(t.Nanosecond())),
+		})
+	},
+	"time-weekday": func(t time.Time) EnumValue {
+		// cases of the Weekday type are declared in the order of time.Weekday
+		return &ValEnum { Index: uint(t.Weekday()) }
+	},
+	"time-year-day": func(t time.Time) *big.Int {
+		return big.NewInt(int64(t.YearDay()))
+	},
+	"time-from-calendar": func(date TupleValue, clock TupleValue, zone *time.Location) time.Time {
+		var get = func(p TupleValue, i int) int {
+			return util.GetIntInteger(p.Elements[i].(*big.Int))
+		}
+		return time.Date (
+			get(date, 0), time.Month(get(date, 1)), get(date, 2),
+			get(clock, 0), get(clock, 1), get(clock, 2), get(clock, 3),
+			zone,
+		)
+	},
+	"time-zone": func(t time.Time) *time.Location {
+		return t.Location()
+	},
+	"time-in-zone": func(t time.Time, zone *time.Location) time.Time {
+		return t.In(zone)
+	},
+	"time-format": func(t time.Time, layout string) string {
+		return t.Format(layout)
+	},
+	"time-parse": func(text string, layout string) EnumValue {
+		var t, err = time.Parse(layout, text)
+		if err != nil { return Ng(err) }
+		return Ok(t)
+	},
+	"time-parse-in-zone": func(text string, layout string, zone *time.Location) EnumValue {
+		var t, err = time.ParseInLocation(layout, text, zone)
+		if err != nil { return Ng(err) }
+		return Ok(t)
+	},
+	"load-zone": func(name string) EnumValue {
+		var zone, err = time.LoadLocation(name)
+		if err != nil { return Ng(err) }
+		return Ok(zone)
+	},
+	"fixed-zone": func(name string, offset *big.Int) *time.Location {
+		return time.FixedZone(name, util.GetIntInteger(offset))
+	},
+	"String from Zone": func(zone *time.Location) string {
+		return zone.String()
+	},
+	"duration-from-nanoseconds": func(n *big.Int) time.Duration {
+		return time.Duration(util.GetInt64Integer(n))
+	},
+	"duration-in-nanoseconds": func(d time.Duration) *big.Int {
+		return big.NewInt(int64(d))
+	},
+	"duration-in-seconds": func(d time.Duration) float64 {
+		return d.Seconds()
+	},
+	"=Duration": func(a time.Duration, b time.Duration) EnumValue {
+		return ToBool(a == b)
+	},
+	"<Duration": func(a time.Duration, b time.Duration) EnumValue {
+		return ToBool(a < b)
+	},
+	"duration+duration": func(a time.Duration, b time.Duration) time.Duration {
+		return (a + b)
+	},
+	"duration-duration": func(a time.Duration, b time.Duration) time.Duration {
+		return (a - b)
+	},
+	"-duration": func(d time.Duration) time.Duration {
+		return -d
+	},
+	"String from Duration": func(d time.Duration) string {
+		return d.String()
+	},
+	"parse-duration": func(text string) EnumValue {
+		var d, err = time.ParseDuration(text)
+		if err != nil { return Ng(err) }
+		return Ok(d)
+	},
+}
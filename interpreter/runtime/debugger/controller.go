@@ -0,0 +1,223 @@
+package debugger
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"strings"
+	"path/filepath"
+	"kumachan/interpreter/runtime/vm"
+	"kumachan/interpreter/lang/textual/cst"
+	. "kumachan/standalone/util/error"
+)
+
+
+// controller holds breakpoints and the stepping state, which are
+// shared by all front-ends of the debugger.
+type controller struct {
+	mutex        sync.Mutex
+	breakpoints  map[uint] Breakpoint
+	nextId       uint
+	step         stepState
+}
+
+type Breakpoint struct {
+	File  string
+	Line  int
+}
+
+type stepMode int
+const (
+	stepPause stepMode = iota
+	stepContinue
+	stepInto
+	stepOver
+	stepOut
+)
+type stepState struct {
+	mode      stepMode
+	anchor    uintptr
+	depth     uint
+	tree      *cst.Tree
+	line      int
+	entry     bool
+}
+
+type stopReason struct {
+	Kind        string  // "entry", "breakpoint" or "step"
+	Breakpoint  uint
+}
+func (r stopReason) String() string {
+	if r.Kind == "breakpoint" {
+		return fmt.Sprintf("breakpoint %d", r.Breakpoint)
+	} else {
+		return r.Kind
+	}
+}
+
+func createController() *controller {
+	return &controller {
+		breakpoints: make(map[uint] Breakpoint),
+		nextId:      1,
+		step:        stepState { mode: stepContinue },
+	}
+}
+
+// PauseOnEntry makes the execution pause before the first line.
+func (c *controller) PauseOnEntry() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.step = stepState { mode: stepPause, entry: true }
+}
+
+func (c *controller) AddBreakpoint(b Breakpoint) uint {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var id = c.nextId
+	c.nextId += 1
+	c.breakpoints[id] = b
+	return id
+}
+
+func (c *controller) DeleteBreakpoint(id uint) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var _, exists = c.breakpoints[id]
+	delete(c.breakpoints, id)
+	return exists
+}
+
+// ReplaceBreakpoints deletes all breakpoints in the specified file
+// and then adds new breakpoints to the file.
+func (c *controller) ReplaceBreakpoints(file string, lines ([] int)) ([] uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for id, b := range c.breakpoints {
+		if b.File == file {
+			delete(c.breakpoints, id)
+		}
+	}
+	var ids = make([] uint, len(lines))
+	for i, line := range lines {
+		var id = c.nextId
+		c.nextId += 1
+		c.breakpoints[id] = Breakpoint { File: file, Line: line }
+		ids[i] = id
+	}
+	return ids
+}
+
+func (c *controller) ForEachBreakpoint(f func(id uint, b Breakpoint)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, id := range c.sortedBreakpointIds() {
+		f(id, c.breakpoints[id])
+	}
+}
+
+func (c *controller) Resume(t vm.DebugTarget, mode stepMode) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var point = t.Point()
+	c.step = stepState {
+		mode:   mode,
+		anchor: t.Id(),
+		depth:  t.Depth(),
+		tree:   point.Node.CST,
+		line:   point.Node.Point.Row,
+	}
+}
+
+func (c *controller) Detach() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.breakpoints = make(map[uint] Breakpoint)
+	c.step = stepState { mode: stepContinue }
+}
+
+func (c *controller) ContextExited(t vm.DebugTarget) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	switch c.step.mode {
+	case stepInto, stepOver, stepOut:
+		if c.step.anchor == t.Id() {
+			c.step = stepState { mode: stepPause }
+		}
+	}
+}
+
+func (c *controller) ShouldStop(t vm.DebugTarget) (stopReason, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var point = t.Point()
+	var step = c.step
+	var file = point.Node.CST
+	var line = point.Node.Point.Row
+	var same_line = (file == step.tree && line == step.line)
+	var stepped = stopReason { Kind: "step" }
+	if !(t.Returned()) {
+		for _, id := range c.sortedBreakpointIds() {
+			if c.breakpoints[id].Match(file.Name, line) {
+				return stopReason { Kind: "breakpoint", Breakpoint: id }, true
+			}
+		}
+	}
+	switch step.mode {
+	case stepPause:
+		if step.entry {
+			return stopReason { Kind: "entry" }, true
+		} else {
+			return stepped, true
+		}
+	case stepInto:
+		if t.Id() != step.anchor || t.Depth() > step.depth {
+			return stepped, !(t.Returned())
+		} else if t.Depth() < step.depth {
+			return stepped, true
+		} else {
+			return stepped, !(same_line) && !(t.Returned())
+		}
+	case stepOver:
+		if t.Id() != step.anchor || t.Depth() > step.depth {
+			return stopReason {}, false
+		} else if t.Depth() < step.depth {
+			return stepped, true
+		} else {
+			return stepped, !(same_line) && !(t.Returned())
+		}
+	case stepOut:
+		if t.Id() == step.anchor && t.Depth() < step.depth {
+			return stepped, true
+		} else {
+			return stopReason {}, false
+		}
+	default:
+		return stopReason {}, false
+	}
+}
+
+func (c *controller) sortedBreakpointIds() ([] uint) {
+	var ids = make([] uint, 0, len(c.breakpoints))
+	for id := range c.breakpoints {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+func (b Breakpoint) Match(file string, line int) bool {
+	if line != b.Line {
+		return false
+	}
+	var normalized = filepath.ToSlash(file)
+	var expected = filepath.ToSlash(b.File)
+	return (normalized == expected ||
+		strings.HasSuffix(normalized, ("/" + expected)))
+}
+
+func describePoint(point ErrorPoint) string {
+	var node = point.Node
+	return fmt.Sprintf("%s:%d:%d", node.CST.Name, node.Point.Row, node.Point.Col)
+}
@@ -0,0 +1,560 @@
+package debugger
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"sync"
+	"bufio"
+	"errors"
+	"strings"
+	"strconv"
+	"path/filepath"
+	"encoding/json"
+	"kumachan/standalone/rx"
+	"kumachan/interpreter/def"
+	"kumachan/interpreter/runtime/vm"
+)
+
+
+/**
+ *  Debug Adapter Protocol (DAP) Server
+ *
+ *  Supported requests: initialize, launch, setBreakpoints,
+ *  configurationDone, threads, stackTrace, scopes, variables,
+ *  continue, next, stepIn, stepOut and disconnect.
+ *
+ *  Execution contexts of the VM are not exposed as threads, instead,
+ *  there is only one thread, which represents the paused context.
+ *  The program starts after both launch and configurationDone are
+ *  received. The output of the program is sent as output events.
+ */
+
+const dapThreadId = 1
+const dapThreadName = "main"
+
+type DapOptions struct {
+	Load          func(path string) (def.Program, (map[string] def.Resource), error)
+	MaxStackSize  uint
+	Environment   [] string
+	Quit          func()
+}
+
+type DapServer struct {
+	input       *bufio.Reader
+	output      io.Writer
+	outputLock  sync.Mutex
+	seq         int
+	options     DapOptions
+	control     *controller
+	pauseLock   sync.Mutex  // only one context can be paused at a time
+	stateLock   sync.Mutex
+	paused      *dapPausedState
+	resume      chan struct{}
+	launch      *dapLaunchState
+	configured  bool
+	started     bool
+}
+
+type dapPausedState struct {
+	target  vm.DebugTarget
+	frames  [] vm.DebugFrame
+	stack   [] def.Value
+}
+
+type dapLaunchState struct {
+	program    def.Program
+	resources  map[string] def.Resource
+	arguments  [] string
+}
+
+type dapRequest struct {
+	Seq        int              `json:"seq"`
+	Type       string           `json:"type"`
+	Command    string           `json:"command"`
+	Arguments  json.RawMessage  `json:"arguments,omitempty"`
+}
+
+type dapResponse struct {
+	Seq         int          `json:"seq"`
+	Type        string       `json:"type"`
+	RequestSeq  int          `json:"request_seq"`
+	Success     bool         `json:"success"`
+	Command     string       `json:"command"`
+	Message     string       `json:"message,omitempty"`
+	Body        interface{}  `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq    int          `json:"seq"`
+	Type   string       `json:"type"`
+	Event  string       `json:"event"`
+	Body   interface{}  `json:"body,omitempty"`
+}
+
+type dapSource struct {
+	Name  string  `json:"name"`
+	Path  string  `json:"path"`
+}
+
+type dapBreakpoint struct {
+	Id        uint       `json:"id"`
+	Verified  bool       `json:"verified"`
+	Line      int        `json:"line"`
+	Source    dapSource  `json:"source"`
+}
+
+type dapStackFrame struct {
+	Id      int        `json:"id"`
+	Name    string     `json:"name"`
+	Source  dapSource  `json:"source"`
+	Line    int        `json:"line"`
+	Column  int        `json:"column"`
+}
+
+type dapScope struct {
+	Name                string  `json:"name"`
+	VariablesReference  int     `json:"variablesReference"`
+	Expensive           bool    `json:"expensive"`
+}
+
+type dapVariable struct {
+	Name                string  `json:"name"`
+	Value               string  `json:"value"`
+	VariablesReference  int     `json:"variablesReference"`
+}
+
+// variable references are encoded as (frame_index * N + scope + 1)
+type dapScopeKind int
+const (
+	dapScopeLocals dapScopeKind = iota
+	dapScopeContext
+	dapScopeTemporary
+	dapScopeDataStack
+	dapNumScopeKinds
+)
+var dapScopeNames = [] string {
+	"Locals",
+	"Closure Context",
+	"Temporary",
+	"Data Stack",
+}
+
+func CreateDapServer(input io.Reader, output io.Writer, opts DapOptions) *DapServer {
+	return &DapServer {
+		input:   bufio.NewReader(input),
+		output:  output,
+		options: opts,
+		control: createController(),
+		resume:  make(chan struct{}),
+	}
+}
+
+// Serve handles requests until the input is closed or a disconnect
+// request is received.
+func (s *DapServer) Serve() error {
+	for {
+		var req, err = s.readRequest()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if req.Type != "request" {
+			continue
+		}
+		var body, handle_err = s.handleRequest(req)
+		if handle_err != nil {
+			s.respond(req, false, handle_err.Error(), nil)
+			continue
+		}
+		s.respond(req, true, "", body)
+		switch req.Command {
+		case "initialize":
+			s.sendEvent("initialized", nil)
+		case "launch", "configurationDone":
+			s.startIfReady()
+		case "continue", "next", "stepIn", "stepOut":
+			s.resume <- struct{} {}
+		case "disconnect":
+			if s.options.Quit != nil {
+				s.options.Quit()
+			}
+			return nil
+		}
+	}
+}
+
+func (s *DapServer) handleRequest(req dapRequest) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		return map[string] interface{} {
+			"supportsConfigurationDoneRequest": true,
+		}, nil
+	case "launch":
+		var args struct {
+			Program      string    `json:"program"`
+			Args         [] string `json:"args"`
+			StopOnEntry  bool      `json:"stopOnEntry"`
+		}
+		var err = s.parseArguments(req, &args)
+		if err != nil { return nil, err }
+		if args.Program == "" {
+			return nil, errors.New("program not specified")
+		}
+		s.stateLock.Lock()
+		var launched = (s.launch != nil)
+		s.stateLock.Unlock()
+		if launched {
+			return nil, errors.New("program already launched")
+		}
+		program, res, err := s.options.Load(args.Program)
+		if err != nil { return nil, err }
+		if args.StopOnEntry {
+			s.control.PauseOnEntry()
+		}
+		s.stateLock.Lock()
+		s.launch = &dapLaunchState {
+			program:   program,
+			resources: res,
+			arguments: append([] string { args.Program }, args.Args...),
+		}
+		s.stateLock.Unlock()
+		return nil, nil
+	case "setBreakpoints":
+		var args struct {
+			Source       dapSource  `json:"source"`
+			Breakpoints  [] struct {
+				Line  int  `json:"line"`
+			}  `json:"breakpoints"`
+		}
+		var err = s.parseArguments(req, &args)
+		if err != nil { return nil, err }
+		var lines = make([] int, len(args.Breakpoints))
+		for i, b := range args.Breakpoints {
+			lines[i] = b.Line
+		}
+		var path = args.Source.Path
+		var ids = s.control.ReplaceBreakpoints(path, lines)
+		var breakpoints = make([] dapBreakpoint, len(ids))
+		for i, id := range ids {
+			breakpoints[i] = dapBreakpoint {
+				Id:       id,
+				Verified: true,
+				Line:     lines[i],
+				Source:   args.Source,
+			}
+		}
+		return map[string] interface{} {
+			"breakpoints": breakpoints,
+		}, nil
+	case "configurationDone":
+		s.stateLock.Lock()
+		s.configured = true
+		s.stateLock.Unlock()
+		return nil, nil
+	case "threads":
+		return map[string] interface{} {
+			"threads": [] interface{} {
+				map[string] interface{} {
+					"id":   dapThreadId,
+					"name": dapThreadName,
+				},
+			},
+		}, nil
+	case "stackTrace":
+		var p, err = s.getPausedState()
+		if err != nil { return nil, err }
+		var frames = make([] dapStackFrame, len(p.frames))
+		for i, f := range p.frames {
+			var node = f.Point.Node
+			frames[i] = dapStackFrame {
+				Id:     i,
+				Name:   describeFunction(f.Function),
+				Source: dapSourceOf(node.CST.Name),
+				Line:   node.Point.Row,
+				Column: node.Point.Col,
+			}
+		}
+		return map[string] interface{} {
+			"stackFrames": frames,
+			"totalFrames": len(frames),
+		}, nil
+	case "scopes":
+		var args struct {
+			FrameId  int  `json:"frameId"`
+		}
+		var err = s.parseArguments(req, &args)
+		if err != nil { return nil, err }
+		p, err := s.getPausedState()
+		if err != nil { return nil, err }
+		if !(0 <= args.FrameId && args.FrameId < len(p.frames)) {
+			return nil, fmt.Errorf("invalid frame id %d", args.FrameId)
+		}
+		var scopes = make([] dapScope, dapNumScopeKinds)
+		for kind := dapScopeKind(0); kind < dapNumScopeKinds; kind += 1 {
+			var ref = (args.FrameId * int(dapNumScopeKinds)) + int(kind) + 1
+			scopes[kind] = dapScope {
+				Name:               dapScopeNames[kind],
+				VariablesReference: ref,
+				Expensive:          (kind == dapScopeDataStack),
+			}
+		}
+		return map[string] interface{} {
+			"scopes": scopes,
+		}, nil
+	case "variables":
+		var args struct {
+			VariablesReference  int  `json:"variablesReference"`
+		}
+		var err = s.parseArguments(req, &args)
+		if err != nil { return nil, err }
+		p, err := s.getPausedState()
+		if err != nil { return nil, err }
+		var ref = (args.VariablesReference - 1)
+		var frame_index = (ref / int(dapNumScopeKinds))
+		var kind = dapScopeKind(ref % int(dapNumScopeKinds))
+		if !(ref >= 0 && frame_index < len(p.frames)) {
+			return nil, fmt.Errorf("invalid variables reference %d",
+				args.VariablesReference)
+		}
+		var values = p.frames[frame_index].Values
+		var list ([] def.Value)
+		switch kind {
+		case dapScopeLocals:
+			list = values.Locals
+		case dapScopeContext:
+			list = values.Context
+		case dapScopeTemporary:
+			list = values.Temporary
+		case dapScopeDataStack:
+			list = p.stack
+		}
+		var variables = make([] dapVariable, len(list))
+		for i, v := range list {
+			variables[i] = dapVariable {
+				Name:  fmt.Sprintf("[%d]", i),
+				Value: inspect(v),
+			}
+		}
+		return map[string] interface{} {
+			"variables": variables,
+		}, nil
+	case "continue", "next", "stepIn", "stepOut":
+		var p, err = s.getPausedState()
+		if err != nil { return nil, err }
+		var mode = map[string] stepMode {
+			"continue": stepContinue,
+			"next":     stepOver,
+			"stepIn":   stepInto,
+			"stepOut":  stepOut,
+		}[req.Command]
+		s.control.Resume(p.target, mode)
+		if req.Command == "continue" {
+			return map[string] interface{} {
+				"allThreadsContinued": true,
+			}, nil
+		} else {
+			return nil, nil
+		}
+	case "disconnect":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported request %s", strconv.Quote(req.Command))
+	}
+}
+
+func (s *DapServer) LineEntered(t vm.DebugTarget) {
+	s.pauseLock.Lock()
+	defer s.pauseLock.Unlock()
+	var reason, stop = s.control.ShouldStop(t)
+	if !(stop) {
+		return
+	}
+	s.stateLock.Lock()
+	s.paused = &dapPausedState {
+		target: t,
+		frames: t.Frames(),
+		stack:  t.DataStack(),
+	}
+	s.stateLock.Unlock()
+	var body = map[string] interface{} {
+		"reason":            reason.Kind,
+		"threadId":          dapThreadId,
+		"allThreadsStopped": true,
+	}
+	if reason.Kind == "breakpoint" {
+		body["hitBreakpointIds"] = [] uint { reason.Breakpoint }
+	}
+	s.sendEvent("stopped", body)
+	<- s.resume
+	s.stateLock.Lock()
+	s.paused = nil
+	s.stateLock.Unlock()
+}
+
+func (s *DapServer) ContextExited(t vm.DebugTarget) {
+	s.control.ContextExited(t)
+}
+
+func (s *DapServer) getPausedState() (*dapPausedState, error) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	if s.paused == nil {
+		return nil, errors.New("program not paused")
+	}
+	return s.paused, nil
+}
+
+func (s *DapServer) startIfReady() {
+	s.stateLock.Lock()
+	var ready = (s.launch != nil && s.configured && !(s.started))
+	if ready {
+		s.started = true
+	}
+	var launch = s.launch
+	s.stateLock.Unlock()
+	if ready {
+		go s.run(launch)
+	}
+}
+
+func (s *DapServer) run(launch *dapLaunchState) {
+	var null, err = os.Open(os.DevNull)
+	if err != nil { panic(err) }
+	stdout_r, stdout_w, err := os.Pipe()
+	if err != nil { panic(err) }
+	stderr_r, stderr_w, err := os.Pipe()
+	if err != nil { panic(err) }
+	var wg sync.WaitGroup
+	var forward = func(r io.Reader, category string) {
+		defer wg.Done()
+		var buf = make([] byte, 4096)
+		for {
+			var n, err = r.Read(buf)
+			if n > 0 {
+				s.sendEvent("output", map[string] interface{} {
+					"category": category,
+					"output":   string(buf[:n]),
+				})
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	wg.Add(2)
+	go forward(stdout_r, "stdout")
+	go forward(stderr_r, "stderr")
+	var code = vm.Execute(launch.program, vm.Options {
+		Resources:    launch.resources,
+		MaxStackSize: s.options.MaxStackSize,
+		Environment:  s.options.Environment,
+		Arguments:    launch.arguments,
+		Debugger:     s,
+		Embedded:     true,
+		StdIO:        def.StdIO {
+			Stdin:  rx.FileFrom(null),
+			Stdout: rx.FileFrom(stdout_w),
+			Stderr: rx.FileFrom(stderr_w),
+		},
+	}, nil)
+	_ = stdout_w.Close()
+	_ = stderr_w.Close()
+	wg.Wait()
+	_ = null.Close()
+	s.sendEvent("exited", map[string] interface{} {
+		"exitCode": code,
+	})
+	s.sendEvent("terminated", nil)
+}
+
+func (s *DapServer) parseArguments(req dapRequest, v interface{}) error {
+	if len(req.Arguments) == 0 {
+		return nil
+	}
+	var err = json.Unmarshal(req.Arguments, v)
+	if err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+func (s *DapServer) readRequest() (dapRequest, error) {
+	var length = -1
+	for {
+		var line, err = s.input.ReadString('\n')
+		if err != nil { return dapRequest {}, err }
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		const prefix = "Content-Length:"
+		if strings.HasPrefix(line, prefix) {
+			var value = strings.TrimSpace(strings.TrimPrefix(line, prefix))
+			length, err = strconv.Atoi(value)
+			if err != nil { return dapRequest {}, err }
+		}
+	}
+	if length < 0 {
+		return dapRequest {}, errors.New("missing Content-Length header")
+	}
+	var content = make([] byte, length)
+	var _, err = io.ReadFull(s.input, content)
+	if err != nil { return dapRequest {}, err }
+	var req dapRequest
+	err = json.Unmarshal(content, &req)
+	if err != nil { return dapRequest {}, err }
+	return req, nil
+}
+
+func (s *DapServer) respond(req dapRequest, ok bool, msg string, body interface{}) {
+	s.send(func(seq int) interface{} {
+		return dapResponse {
+			Seq:        seq,
+			Type:       "response",
+			RequestSeq: req.Seq,
+			Success:    ok,
+			Command:    req.Command,
+			Message:    msg,
+			Body:       body,
+		}
+	})
+}
+
+func (s *DapServer) sendEvent(event string, body interface{}) {
+	s.send(func(seq int) interface{} {
+		return dapEvent {
+			Seq:   seq,
+			Type:  "event",
+			Event: event,
+			Body:  body,
+		}
+	})
+}
+
+func (s *DapServer) send(msg func(seq int) interface{}) {
+	s.outputLock.Lock()
+	defer s.outputLock.Unlock()
+	s.seq += 1
+	var content, err = json.Marshal(msg(s.seq))
+	if err != nil { panic(err) }
+	_, _ = fmt.Fprintf(s.output, "Content-Length: %d\r\n\r\n", len(content))
+	_, _ = s.output.Write(content)
+}
+
+func dapSourceOf(path string) dapSource {
+	return dapSource {
+		Name: filepath.Base(path),
+		Path: path,
+	}
+}
+
+func describeFunction(f *def.Function) string {
+	if f.Info.Module != "" {
+		return fmt.Sprintf("%s::%s", f.Info.Module, f.Info.Name)
+	} else {
+		return f.Info.Name
+	}
+}
@@ -0,0 +1,263 @@
+package debugger
+
+import (
+	"io"
+	"fmt"
+	"sync"
+	"bufio"
+	"strings"
+	"strconv"
+	"kumachan/interpreter/def"
+	"kumachan/interpreter/runtime/vm"
+	. "kumachan/standalone/util/error"
+)
+
+
+/**
+ *  A line-based command protocol for the step debugger.
+ *
+ *  The execution is paused before the first line of the program.
+ *  When the execution is paused, a "stopped" line is written, followed
+ *  by the source code of the current line, and then commands are read
+ *  line by line until a command resuming the execution is received.
+ *
+ *  Commands:
+ *    break FILE:LINE     (b)   set a breakpoint
+ *    delete N            (d)   delete a breakpoint
+ *    breakpoints               list breakpoints
+ *    continue            (c)   resume until a breakpoint is hit
+ *    step                (s)   step into calls
+ *    next                (n)   step over calls
+ *    out                 (o)   step out of the current call
+ *    backtrace           (bt)  show the call stack
+ *    locals [FRAME]      (l)   show values of a frame
+ *    context [FRAME]           show closure context values of a frame
+ *    stack                     show the whole data stack
+ *    help                (h)   show available commands
+ *    quit                (q)   terminate the program
+ *
+ *  The command input reaching EOF clears all breakpoints and resumes
+ *  the execution until the program exits.
+ */
+
+type Session struct {
+	mutex        sync.Mutex
+	input        *bufio.Scanner
+	inputClosed  bool
+	output       io.Writer
+	quit         func()
+	control      *controller
+}
+
+func CreateSession(input io.Reader, output io.Writer, quit func()) *Session {
+	var control = createController()
+	control.PauseOnEntry()
+	return &Session {
+		input:   bufio.NewScanner(input),
+		output:  output,
+		quit:    quit,
+		control: control,
+	}
+}
+
+func (s *Session) LineEntered(t vm.DebugTarget) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var reason, stop = s.control.ShouldStop(t)
+	if !(stop) {
+		return
+	}
+	s.printStopped(reason, t.Point())
+	s.readCommands(t)
+}
+
+func (s *Session) ContextExited(t vm.DebugTarget) {
+	s.control.ContextExited(t)
+}
+
+func (s *Session) printStopped(reason stopReason, point ErrorPoint) {
+	s.printf("stopped (%s) at %s\n", reason, describePoint(point))
+	var tree = point.Node.CST
+	var row = point.Node.Point.Row
+	if row < len(tree.SpanMap) {
+		var span = tree.SpanMap[row]
+		s.printf("%d | %s\n", row, string(tree.Code[span.Start:span.End]))
+	}
+}
+
+func (s *Session) readCommands(t vm.DebugTarget) {
+	for {
+		if s.inputClosed || !(s.input.Scan()) {
+			s.inputClosed = true
+			s.control.Detach()
+			return
+		}
+		var line = strings.TrimSpace(s.input.Text())
+		if line == "" {
+			continue
+		}
+		var fields = strings.Fields(line)
+		var cmd = fields[0]
+		var args = fields[1:]
+		switch cmd {
+		case "continue", "c":
+			s.control.Resume(t, stepContinue)
+			return
+		case "step", "s":
+			s.control.Resume(t, stepInto)
+			return
+		case "next", "n":
+			s.control.Resume(t, stepOver)
+			return
+		case "out", "o":
+			s.control.Resume(t, stepOut)
+			return
+		case "break", "b":
+			s.commandBreak(args)
+		case "delete", "d":
+			s.commandDelete(args)
+		case "breakpoints":
+			s.commandBreakpoints()
+		case "backtrace", "bt":
+			s.commandBacktrace(t)
+		case "locals", "l":
+			s.commandLocals(t, args)
+		case "context":
+			s.commandContext(t, args)
+		case "stack":
+			s.commandStack(t)
+		case "help", "h":
+			s.commandHelp()
+		case "quit", "q":
+			s.printf("quit\n")
+			s.quit()
+			return
+		default:
+			s.printf("error: unknown command %s\n", strconv.Quote(cmd))
+		}
+	}
+}
+
+func (s *Session) commandBreak(args ([] string)) {
+	if len(args) != 1 {
+		s.printf("error: usage: break FILE:LINE\n")
+		return
+	}
+	var arg = args[0]
+	var sep = strings.LastIndex(arg, ":")
+	if sep <= 0 {
+		s.printf("error: invalid location %s\n", strconv.Quote(arg))
+		return
+	}
+	var line, err = strconv.Atoi(arg[sep+1:])
+	if err != nil || line <= 0 {
+		s.printf("error: invalid line number %s\n", strconv.Quote(arg[sep+1:]))
+		return
+	}
+	var id = s.control.AddBreakpoint(Breakpoint {
+		File: arg[:sep],
+		Line: line,
+	})
+	s.printf("breakpoint %d at %s:%d\n", id, arg[:sep], line)
+}
+
+func (s *Session) commandDelete(args ([] string)) {
+	if len(args) != 1 {
+		s.printf("error: usage: delete N\n")
+		return
+	}
+	var id, err = strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		s.printf("error: invalid breakpoint number %s\n", strconv.Quote(args[0]))
+		return
+	}
+	if !(s.control.DeleteBreakpoint(uint(id))) {
+		s.printf("error: breakpoint %d does not exist\n", id)
+		return
+	}
+	s.printf("deleted breakpoint %d\n", id)
+}
+
+func (s *Session) commandBreakpoints() {
+	var count = 0
+	s.control.ForEachBreakpoint(func(id uint, b Breakpoint) {
+		s.printf("breakpoint %d at %s:%d\n", id, b.File, b.Line)
+		count += 1
+	})
+	s.printf("%d breakpoint(s)\n", count)
+}
+
+func (s *Session) commandBacktrace(t vm.DebugTarget) {
+	for i, f := range t.Frames() {
+		s.printf("#%d %s at %s\n", i, f.Function.Info.Name, describePoint(f.Point))
+	}
+}
+
+func (s *Session) commandLocals(t vm.DebugTarget, args ([] string)) {
+	var f, ok = s.getFrame(t, args)
+	if !(ok) { return }
+	var v = f.Values
+	var offset = 0
+	var print = func(kind string, value def.Value) {
+		s.printf("[%d] %s = %s\n", offset, kind, inspect(value))
+		offset += 1
+	}
+	for _, value := range v.Context {
+		print("context", value)
+	}
+	for _, value := range v.Locals {
+		print("local", value)
+	}
+	for _, value := range v.Temporary {
+		print("temporary", value)
+	}
+}
+
+func (s *Session) commandContext(t vm.DebugTarget, args ([] string)) {
+	var f, ok = s.getFrame(t, args)
+	if !(ok) { return }
+	for i, value := range f.Values.Context {
+		s.printf("[%d] %s\n", i, inspect(value))
+	}
+	s.printf("%d context value(s)\n", len(f.Values.Context))
+}
+
+func (s *Session) commandStack(t vm.DebugTarget) {
+	var stack = t.DataStack()
+	for i, value := range stack {
+		s.printf("[%d] %s\n", i, inspect(value))
+	}
+	s.printf("%d value(s)\n", len(stack))
+}
+
+func (s *Session) commandHelp() {
+	s.printf("commands:\n")
+	s.printf("\tbreak FILE:LINE, delete N, breakpoints\n")
+	s.printf("\tcontinue, step, next, out\n")
+	s.printf("\tbacktrace, locals [FRAME], context [FRAME], stack\n")
+	s.printf("\thelp, quit\n")
+}
+
+func (s *Session) getFrame(t vm.DebugTarget, args ([] string)) (vm.DebugFrame, bool) {
+	var frames = t.Frames()
+	var index = 0
+	if len(args) > 0 {
+		var n, err = strconv.Atoi(args[0])
+		if err != nil || n < 0 || n >= len(frames) {
+			s.printf("error: invalid frame number %s\n", strconv.Quote(args[0]))
+			return vm.DebugFrame {}, false
+		}
+		index = n
+	}
+	return frames[index], true
+}
+
+func (s *Session) printf(format string, args ...interface{}) {
+	var _, _ = fmt.Fprintf(s.output, format, args...)
+}
+
+func inspect(value def.Value) string {
+	// keep the output of a value in a single line
+	var text = def.Inspect(value).StringPlain()
+	return strings.ReplaceAll(text, "\n", " ")
+}
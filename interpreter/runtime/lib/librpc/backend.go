@@ -1,6 +1,13 @@
 package librpc
 
-import "net"
+import (
+	"os"
+	"net"
+	"errors"
+	"io/ioutil"
+	"crypto/tls"
+	"crypto/x509"
+)
 
 
 type ServerBackend interface {
@@ -25,3 +32,115 @@ func (backend ClientCleartextNet) Access() (net.Conn, error) {
 	return net.Dial(backend.Network, backend.Address)
 }
 
+type ServerUnix struct {
+	Path  string
+}
+type ClientUnix struct {
+	Path  string
+}
+func (backend ServerUnix) Serve() (net.Listener, error) {
+	var info, err = os.Lstat(backend.Path)
+	if err == nil && (info.Mode() & os.ModeSocket) != 0 {
+		// remove the socket file left by a dead server
+		var conn, err = net.Dial("unix", backend.Path)
+		if err == nil {
+			_ = conn.Close()
+		} else {
+			_ = os.Remove(backend.Path)
+		}
+	}
+	return net.Listen("unix", backend.Path)
+}
+func (backend ClientUnix) Access() (net.Conn, error) {
+	return net.Dial("unix", backend.Path)
+}
+
+type ServerTLSNet struct {
+	Network   string
+	Address   string
+	KeyPair   KeyPair
+	ClientCA  PEM  // nil: client certificates are not requested
+}
+type ClientTLSNet struct {
+	Network     string
+	Address     string
+	ServerName  string    // empty: the host part of the address
+	CA          PEM       // nil: root CAs of the system
+	KeyPair     *KeyPair  // nil: no client certificate
+}
+func (backend ServerTLSNet) Serve() (net.Listener, error) {
+	var config, err = backend.Config()
+	if err != nil { return nil, err }
+	return tls.Listen(backend.Network, backend.Address, config)
+}
+func (backend ClientTLSNet) Access() (net.Conn, error) {
+	var config, err = backend.Config()
+	if err != nil { return nil, err }
+	return tls.Dial(backend.Network, backend.Address, config)
+}
+func (backend ServerTLSNet) Config() (*tls.Config, error) {
+	var cert, err = backend.KeyPair.Load()
+	if err != nil { return nil, err }
+	var config = &tls.Config {
+		Certificates: [] tls.Certificate { cert },
+		MinVersion:   tls.VersionTLS12,
+	}
+	if backend.ClientCA != nil {
+		var pool, err = LoadCertPool(backend.ClientCA)
+		if err != nil { return nil, err }
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+func (backend ClientTLSNet) Config() (*tls.Config, error) {
+	var config = &tls.Config {
+		ServerName: backend.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if backend.CA != nil {
+		var pool, err = LoadCertPool(backend.CA)
+		if err != nil { return nil, err }
+		config.RootCAs = pool
+	}
+	if backend.KeyPair != nil {
+		var cert, err = backend.KeyPair.Load()
+		if err != nil { return nil, err }
+		config.Certificates = [] tls.Certificate { cert }
+	}
+	return config, nil
+}
+
+type PEM interface {
+	ReadPEM() ([] byte, error)
+}
+type PemFile string
+type PemData ([] byte)
+func (path PemFile) ReadPEM() ([] byte, error) {
+	return ioutil.ReadFile(string(path))
+}
+func (data PemData) ReadPEM() ([] byte, error) {
+	return data, nil
+}
+
+type KeyPair struct {
+	Cert  PEM
+	Key   PEM
+}
+func (pair KeyPair) Load() (tls.Certificate, error) {
+	var cert, err = pair.Cert.ReadPEM()
+	if err != nil { return tls.Certificate {}, err }
+	key, err := pair.Key.ReadPEM()
+	if err != nil { return tls.Certificate {}, err }
+	return tls.X509KeyPair(cert, key)
+}
+
+func LoadCertPool(ca PEM) (*x509.CertPool, error) {
+	var data, err = ca.ReadPEM()
+	if err != nil { return nil, err }
+	var pool = x509.NewCertPool()
+	if !(pool.AppendCertsFromPEM(data)) {
+		return nil, errors.New("no valid certificate found in CA data")
+	}
+	return pool, nil
+}
@@ -0,0 +1,235 @@
+package librpc
+
+import (
+	"os"
+	"net"
+	"time"
+	"testing"
+	"math/big"
+	"io/ioutil"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/pem"
+	"path/filepath"
+	"crypto/x509/pkix"
+)
+
+
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certPEM  [] byte
+	keyPEM   [] byte
+}
+
+func createTestCert(t *testing.T, name string, parent *testCert, server bool) *testCert {
+	var key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil { t.Fatal(err) }
+	var serial, _ = rand.Int(rand.Reader, big.NewInt(1 << 62))
+	var template = &x509.Certificate {
+		SerialNumber: serial,
+		Subject:      pkix.Name { CommonName: name },
+		NotBefore:    time.Now().Add(-(time.Hour)),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else if server {
+		template.DNSNames = [] string { name }
+		template.IPAddresses = [] net.IP { net.ParseIP("127.0.0.1") }
+		template.ExtKeyUsage = [] x509.ExtKeyUsage { x509.ExtKeyUsageServerAuth }
+	} else {
+		template.ExtKeyUsage = [] x509.ExtKeyUsage { x509.ExtKeyUsageClientAuth }
+	}
+	var issuer, issuer_key = template, key
+	if parent != nil {
+		issuer, issuer_key = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &(key.PublicKey), issuer_key)
+	if err != nil { t.Fatal(err) }
+	cert, err := x509.ParseCertificate(der)
+	if err != nil { t.Fatal(err) }
+	key_der, err := x509.MarshalECPrivateKey(key)
+	if err != nil { t.Fatal(err) }
+	return &testCert {
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block { Type: "CERTIFICATE", Bytes: der }),
+		keyPEM:  pem.EncodeToMemory(&pem.Block { Type: "EC PRIVATE KEY", Bytes: key_der }),
+	}
+}
+
+func (c *testCert) keyPair() KeyPair {
+	return KeyPair { Cert: PemData(c.certPEM), Key: PemData(c.keyPEM) }
+}
+
+// exchange accepts a connection and sends a message through it,
+// and returns the errors occurred on the server side and the client side.
+func exchange(t *testing.T, server ServerBackend, client func(addr string) ClientBackend) (error, error) {
+	var l, err = server.Serve()
+	if err != nil { t.Fatal(err) }
+	defer (func() { _ = l.Close() })()
+	var server_err = make(chan error, 1)
+	go (func() {
+		var conn, err = l.Accept()
+		if err != nil { server_err <- err; return }
+		defer (func() { _ = conn.Close() })()
+		_, err = conn.Write(([] byte)("hello"))
+		server_err <- err
+	})()
+	conn, client_err := client(l.Addr().String()).Access()
+	if client_err == nil {
+		var buf = make([] byte, 5)
+		_, client_err = conn.Read(buf)
+		if client_err == nil && string(buf) != "hello" {
+			t.Fatalf("unexpected message: %s", string(buf))
+		}
+		_ = conn.Close()
+	}
+	return <- server_err, client_err
+}
+
+func TestTLS(t *testing.T) {
+	var ca = createTestCert(t, "Test CA", nil, false)
+	var another_ca = createTestCert(t, "Another CA", nil, false)
+	var server = createTestCert(t, "localhost", ca, true)
+	var client = createTestCert(t, "client", ca, false)
+	var untrusted_client = createTestCert(t, "client", another_ca, false)
+	var dir, err = ioutil.TempDir("", "librpc-tls-")
+	if err != nil { t.Fatal(err) }
+	defer (func() { _ = os.RemoveAll(dir) })()
+	var cert_file = filepath.Join(dir, "server.pem")
+	var key_file = filepath.Join(dir, "server.key")
+	err = ioutil.WriteFile(cert_file, server.certPEM, 0600)
+	if err != nil { t.Fatal(err) }
+	err = ioutil.WriteFile(key_file, server.keyPEM, 0600)
+	if err != nil { t.Fatal(err) }
+	var tls_server = ServerTLSNet {
+		Network: "tcp",
+		Address: "127.0.0.1:0",
+		KeyPair: KeyPair { Cert: PemFile(cert_file), Key: PemFile(key_file) },
+	}
+	var mtls_server = tls_server
+	mtls_server.ClientCA = PemData(ca.certPEM)
+	var tls_client = func(name string, key_pair *KeyPair) func(string) ClientBackend {
+		return func(addr string) ClientBackend {
+			return ClientTLSNet {
+				Network:    "tcp",
+				Address:    addr,
+				ServerName: name,
+				CA:         PemData(ca.certPEM),
+				KeyPair:    key_pair,
+			}
+		}
+	}
+	var client_pair = client.keyPair()
+	var untrusted_pair = untrusted_client.keyPair()
+	t.Run("tls", func(t *testing.T) {
+		var s_err, c_err = exchange(t, tls_server, tls_client("localhost", nil))
+		if s_err != nil || c_err != nil {
+			t.Fatalf("unexpected errors: %v, %v", s_err, c_err)
+		}
+	})
+	t.Run("server name", func(t *testing.T) {
+		var _, c_err = exchange(t, tls_server, tls_client("example.com", nil))
+		if c_err == nil {
+			t.Fatal("server name mismatch not detected")
+		}
+	})
+	t.Run("untrusted server", func(t *testing.T) {
+		var _, c_err = exchange(t, tls_server, func(addr string) ClientBackend {
+			return ClientTLSNet {
+				Network: "tcp",
+				Address: addr,
+				CA:      PemData(another_ca.certPEM),
+			}
+		})
+		if c_err == nil {
+			t.Fatal("untrusted server certificate not detected")
+		}
+	})
+	t.Run("mutual tls", func(t *testing.T) {
+		var s_err, c_err = exchange(t, mtls_server, tls_client("localhost", &client_pair))
+		if s_err != nil || c_err != nil {
+			t.Fatalf("unexpected errors: %v, %v", s_err, c_err)
+		}
+	})
+	t.Run("missing client certificate", func(t *testing.T) {
+		var s_err, _ = exchange(t, mtls_server, tls_client("localhost", nil))
+		if s_err == nil {
+			t.Fatal("missing client certificate not detected")
+		}
+	})
+	t.Run("untrusted client certificate", func(t *testing.T) {
+		var s_err, _ = exchange(t, mtls_server, tls_client("localhost", &untrusted_pair))
+		if s_err == nil {
+			t.Fatal("untrusted client certificate not detected")
+		}
+	})
+	t.Run("invalid ca", func(t *testing.T) {
+		var _, err = ClientTLSNet { CA: PemData("invalid") }.Config()
+		if err == nil {
+			t.Fatal("invalid CA data not detected")
+		}
+	})
+}
+
+func TestPipe(t *testing.T) {
+	var client = func(name string) ClientBackend {
+		return ClientPipe { Name: name }
+	}
+	var s_err, c_err = exchange(t, ServerPipe { Name: "test" }, client)
+	if s_err != nil || c_err != nil {
+		t.Fatalf("unexpected errors: %v, %v", s_err, c_err)
+	}
+	// the name is released after the listener is closed
+	var l, err = ServerPipe { Name: "test" }.Serve()
+	if err != nil { t.Fatal(err) }
+	_, err = ServerPipe { Name: "test" }.Serve()
+	if err == nil { t.Fatal("duplicate pipe name not detected") }
+	_ = l.Close()
+	// the client waits for the server
+	var accessed = make(chan error, 1)
+	go (func() {
+		var conn, err = ClientPipe { Name: "late" }.Access()
+		if err == nil { _ = conn.Close() }
+		accessed <- err
+	})()
+	time.Sleep(50 * time.Millisecond)
+	l, err = ServerPipe { Name: "late" }.Serve()
+	if err != nil { t.Fatal(err) }
+	defer (func() { _ = l.Close() })()
+	conn, err := l.Accept()
+	if err != nil { t.Fatal(err) }
+	_ = conn.Close()
+	err = <- accessed
+	if err != nil { t.Fatal(err) }
+}
+
+func TestUnix(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "librpc-unix-")
+	if err != nil { t.Fatal(err) }
+	defer (func() { _ = os.RemoveAll(dir) })()
+	var path = filepath.Join(dir, "test.sock")
+	var client = func(path string) ClientBackend {
+		return ClientUnix { Path: path }
+	}
+	var s_err, c_err = exchange(t, ServerUnix { Path: path }, client)
+	if s_err != nil || c_err != nil {
+		t.Fatalf("unexpected errors: %v, %v", s_err, c_err)
+	}
+	// a socket file left by a dead server is removed
+	stale, err := net.ListenUnix("unix", &net.UnixAddr { Name: path, Net: "unix" })
+	if err != nil { t.Fatal(err) }
+	stale.SetUnlinkOnClose(false)
+	_ = stale.Close()
+	s_err, c_err = exchange(t, ServerUnix { Path: path }, client)
+	if s_err != nil || c_err != nil {
+		t.Fatalf("unexpected errors: %v, %v", s_err, c_err)
+	}
+}
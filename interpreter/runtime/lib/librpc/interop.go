@@ -49,18 +49,18 @@ func CreateKmdApi(ctx KmdTransformContext) KmdApi {
 func (impl *KmdApiImpl) GetTypeFromId(id kmd.TypeId) *kmd.Type {
 	return impl.config.GetTypeFromId(id)
 }
-func (impl *KmdApiImpl) SerializeToStream(v Value, t *kmd.Type, stream io.Writer) error {
+func (impl *KmdApiImpl) SerializeToStream(v Value, t *kmd.Type, stream io.Writer, enc kmd.Encoding) error {
 	var serializer = impl.transformer.Serializer
 	var tv = KmdTypedValue {
 		Type:  t,
 		Value: v,
 	}
-	return kmd.Serialize(tv, serializer, stream)
+	return enc.Serialize(tv, serializer, stream)
 }
-func (impl *KmdApiImpl) DeserializeFromStream(t *kmd.Type, stream io.Reader) (Value, error) {
+func (impl *KmdApiImpl) DeserializeFromStream(t *kmd.Type, stream io.Reader, enc kmd.Encoding) (Value, error) {
 	var ts = impl.transformer
 	var deserializer = ts.Deserializer
-	obj, real_t, err := kmd.Deserialize(stream, deserializer)
+	obj, real_t, err := enc.Deserialize(stream, deserializer)
 	if err != nil { return nil, err }
 	obj, err = ts.AssignObject(obj, real_t, t)
 	if err != nil { return nil, err }
@@ -68,12 +68,24 @@ func (impl *KmdApiImpl) DeserializeFromStream(t *kmd.Type, stream io.Reader) (Va
 }
 func (impl *KmdApiImpl) Serialize(v Value, t *kmd.Type) ([] byte, error) {
 	var buf bytes.Buffer
-	var err = impl.SerializeToStream(v, t, &buf)
+	var err = impl.SerializeToStream(v, t, &buf, kmd.TextEncoding)
 	if err != nil { return nil, err }
 	return buf.Bytes(), nil
 }
 func (impl *KmdApiImpl) Deserialize(binary ([] byte), t *kmd.Type) (Value, error) {
 	var reader = bytes.NewReader(binary)
-	return impl.DeserializeFromStream(t, reader)
+	return impl.DeserializeFromStream(t, reader, kmd.TextEncoding)
+}
+func (impl *KmdApiImpl) Encode(v Value, t *kmd.Type, f kmd.Format) ([] byte, error) {
+	var serializer = impl.transformer.Serializer
+	var tv = KmdTypedValue {
+		Type:  t,
+		Value: v,
+	}
+	return f.Encode(tv, t, serializer, impl.config.SchemaTable)
+}
+func (impl *KmdApiImpl) Decode(data ([] byte), t *kmd.Type, f kmd.Format) (Value, error) {
+	var deserializer = impl.transformer.Deserializer
+	return f.Decode(data, t, deserializer, impl.config.SchemaTable)
 }
 
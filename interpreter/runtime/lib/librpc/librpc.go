@@ -34,6 +34,7 @@ func Access (
 			DebugOutput:         options.GetDebugOutput(),
 			ConstructorArgument: argument,
 			InstanceConsumer:    wrapped_consumer,
+			Encoding:            options.Encoding,
 			Limits:              options.Limits,
 			KmdApi:              api.GetKmdApi(),
 		}
@@ -61,10 +62,11 @@ func Serve (
 		}
 		var service_impl = implementService(service, constructor, destructor)
 		var full_options = &rpc.ServerOptions {
-			Listener:    l,
-			DebugOutput: options.GetDebugOutput(),
-			Limits:      options.Limits,
-			KmdApi:      api.GetKmdApi(),
+			Listener:     l,
+			DebugOutput:  options.GetDebugOutput(),
+			Interceptors: options.Interceptors,
+			Limits:       options.Limits,
+			KmdApi:       api.GetKmdApi(),
 		}
 		return rpc.Server(service_impl, full_options)
 	})
@@ -4,14 +4,17 @@ import (
 	"os"
 	"io"
 	"kumachan/standalone/rpc"
+	"kumachan/standalone/rpc/kmd"
 )
 
 
 type ServerOptions struct {
 	CommonOptions
+	Interceptors  [] rpc.Interceptor
 }
 type ClientOptions struct {
 	CommonOptions
+	Encoding  kmd.Encoding
 }
 type CommonOptions struct {
 	LogEnabled  bool
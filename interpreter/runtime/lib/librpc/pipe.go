@@ -0,0 +1,122 @@
+package librpc
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+	"errors"
+)
+
+
+/**
+ *  In-process Pipes
+ *
+ *  A pipe is identified by a name, which is unique in the process.
+ *  The server listens on a name, and each connection from a client is
+ *  a pair of synchronous in-memory connections created by net.Pipe.
+ *  Since the server and the client are usually started concurrently,
+ *  a client waits for a server to listen on the name (until timeout)
+ *  instead of failing immediately.
+ */
+
+const PipeDialTimeout = (5 * time.Second)
+
+var pipeRegistry = struct {
+	mutex      sync.Mutex
+	listeners  map[string] *pipeListener
+	changed    chan struct{}  // closed when a listener is registered
+} {
+	listeners: make(map[string] *pipeListener),
+	changed:   make(chan struct{}),
+}
+
+type ServerPipe struct {
+	Name  string
+}
+type ClientPipe struct {
+	Name  string
+}
+func (backend ServerPipe) Serve() (net.Listener, error) {
+	var r = &pipeRegistry
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var _, exists = r.listeners[backend.Name]
+	if exists {
+		return nil, fmt.Errorf("pipe %s is already in use", backend.Name)
+	}
+	var l = &pipeListener {
+		name:   backend.Name,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	r.listeners[backend.Name] = l
+	close(r.changed)
+	r.changed = make(chan struct{})
+	return l, nil
+}
+func (backend ClientPipe) Access() (net.Conn, error) {
+	var r = &pipeRegistry
+	var timeout = time.After(PipeDialTimeout)
+	for {
+		r.mutex.Lock()
+		var l, exists = r.listeners[backend.Name]
+		var changed = r.changed
+		r.mutex.Unlock()
+		if exists {
+			var server, client = net.Pipe()
+			select {
+			case l.conns <- server:
+				return client, nil
+			case <- l.closed:
+				_ = server.Close()
+				_ = client.Close()
+				continue
+			case <- timeout:
+				_ = server.Close()
+				_ = client.Close()
+			}
+		} else {
+			select {
+			case <- changed:
+				continue
+			case <- timeout:
+			}
+		}
+		return nil, fmt.Errorf("no server is listening on pipe %s", backend.Name)
+	}
+}
+
+type pipeListener struct {
+	name    string
+	conns   chan net.Conn
+	closed  chan struct{}
+	once    sync.Once
+}
+type pipeAddr string
+func (addr pipeAddr) Network() string { return "pipe" }
+func (addr pipeAddr) String() string { return string(addr) }
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <- l.conns:
+		return conn, nil
+	case <- l.closed:
+		return nil, errors.New("use of closed pipe listener")
+	}
+}
+func (l *pipeListener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+		var r = &pipeRegistry
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if r.listeners[l.name] == l {
+			delete(r.listeners, l.name)
+		}
+	})
+	return nil
+}
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr(l.name)
+}
@@ -37,6 +37,11 @@ func kmdCreateTransformer(ctx KmdTransformContext) kmd.Transformer {
 			return nil
 		}
 	}
+	var adapter_ids = make([] kmd.AdapterId, 0, len(conf.KmdAdapterTable))
+	for id, _ := range conf.KmdAdapterTable {
+		adapter_ids = append(adapter_ids, id)
+	}
+	var adapters = kmd.CreateAdapterIndex(adapter_ids)
 	return kmd.Transformer {
 		Serializer: &kmd.Serializer {
 			DetermineType: func(obj kmd.Object) *kmd.Type {
@@ -205,13 +210,13 @@ func kmdCreateTransformer(ctx KmdTransformContext) kmd.Transformer {
 						return obj, nil
 					} else if from.Identifier() != (kmd.TypeId {}) &&
 						to.Identifier() != (kmd.TypeId {}) {
-						var adapter_id = kmd.AdapterId {
-							From: from.Identifier(),
-							To:   to.Identifier(),
-						}
-						var info, exists = conf.KmdAdapterTable[adapter_id]
+						var chain, exists = adapters.FindChain(from.Identifier(), to.Identifier())
 						if exists {
-							var adapted = ctx.KmdCallAdapter(info, obj)
+							var adapted = obj
+							for _, adapter_id := range chain {
+								var info = conf.KmdAdapterTable[adapter_id]
+								adapted = ctx.KmdCallAdapter(info, adapted)
+							}
 							return adapted, nil
 						} else {
 							return nil, errors.New(fmt.Sprintf(
@@ -224,27 +229,14 @@ func kmdCreateTransformer(ctx KmdTransformContext) kmd.Transformer {
 							from, to))
 					}
 				},
-				CheckRecord: func(record_t kmd.TypeId, size uint) error {
+				GetRecordSchema: func(record_t kmd.TypeId) (kmd.RecordSchema, error) {
 					var t, exists = conf.SchemaTable[record_t]
-					if !(exists) { return errors.New(fmt.Sprintf(
+					if !(exists) { return kmd.RecordSchema {}, errors.New(fmt.Sprintf(
 						"type %s does not exist", record_t)) }
 					var schema, ok = t.(kmd.RecordSchema)
-					if !(ok) { return errors.New(fmt.Sprintf(
+					if !(ok) { return kmd.RecordSchema {}, errors.New(fmt.Sprintf(
 						"type %s is not a record type", record_t)) }
-					var schema_size = uint(len(schema.Fields))
-					if schema_size != size { return errors.New(fmt.Sprintf(
-						"record size not matching: given %d, require %d",
-						size, schema_size)) }
-					return nil
-				},
-				GetFieldInfo: func(record_t kmd.TypeId, name string) (*kmd.Type, uint, error) {
-					var t, t_exists = conf.SchemaTable[record_t]
-					if !(t_exists) { panic("something went wrong") }
-					var schema = t.(kmd.RecordSchema)
-					var schema_field, exists = schema.Fields[name]
-					if !(exists) { return nil, ^uint(0), errors.New(fmt.Sprintf(
-						"field %s does not exist on type %s", name, record_t)) }
-					return schema_field.Type, schema_field.Index, nil
+					return schema, nil
 				},
 				CreateRecord: func(record_t kmd.TypeId) kmd.Object {
 					var t, exists = conf.SchemaTable[record_t]
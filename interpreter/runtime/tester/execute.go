@@ -0,0 +1,68 @@
+package tester
+
+import (
+	"io"
+	"fmt"
+	"kumachan/standalone/rx"
+	"kumachan/interpreter/def"
+	"kumachan/interpreter/runtime/vm"
+	. "kumachan/standalone/util/error"
+)
+
+
+const ExitPassed = 0
+const ExitFailed = 1
+
+// Execute executes a single test case in the current process, writes the
+// error thrown by the test case (if any) to the output, and returns the
+// exit code. A runtime error is not recovered, which terminates the process.
+func Execute(program def.Program, address uint, opts vm.Options, output io.Writer) int {
+	var base = uint(len(program.DataValues))
+	if !(base <= address && address < (base + uint(len(program.Functions)))) {
+		panic("invalid test case address")
+	}
+	var info = program.Functions[address - base].Info
+	var code = ExitFailed
+	var wait_m = make(chan *vm.Machine, 1)
+	var run = func() {
+		var m = <- wait_m
+		var f, _ = m.GetGlobalValue(address)
+		var e = m.Call(f.(def.UserFunctionValue), nil, rx.Background())
+		var ch_values = make(chan rx.Object, 1024)
+		var ch_error = make(chan rx.Object, 4)
+		rx.Schedule(e.(rx.Observable), m.GetScheduler(), rx.Receiver {
+			Context: rx.Background(),
+			Values:  ch_values,
+			Error:   ch_error,
+		})
+		for {
+			select {
+			case _, not_closed := <- ch_values:
+				if !(not_closed) {
+					code = ExitPassed
+					return
+				}
+			case err, not_closed := <- ch_error:
+				if not_closed {
+					var msg = make(ErrorMessage, 0)
+					msg.WriteText(TS_ERROR, "Test effect threw an error:")
+					msg.Write(T_SPACE)
+					msg.WriteAll(def.Inspect(err))
+					var _, _ = fmt.Fprintln(output, FormatErrorAt(info.DeclPoint, msg))
+				}
+				return
+			}
+		}
+	}
+	var do_test = &def.Function {
+		Kind: def.F_RUNTIME_GENERATED,
+		Generated: rx.NewGoroutineSingle(func(_ *rx.Context) (rx.Object, bool) {
+			run()
+			return nil, true
+		}),
+	}
+	// effects declared by `do` are not executed
+	program.Effects = [] *def.Function { do_test }
+	vm.Execute(program, opts, wait_m)
+	return code
+}
@@ -0,0 +1,98 @@
+package tester
+
+import (
+	"io"
+	"fmt"
+	"time"
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+	"context"
+)
+
+
+type Options struct {
+	Executable    string         // the interpreter executable
+	Image         string         // path of the program image
+	Timeout       time.Duration
+	MaxStackSize  uint
+}
+
+type Status int
+const (
+	Passed Status = iota
+	Failed
+	TimedOut
+)
+
+type Result struct {
+	Case      Case
+	Status    Status
+	Duration  time.Duration
+	Output    string
+}
+
+// Run executes a test case in a separate process.
+func Run(c Case, opts Options) Result {
+	var ctx, cancel = context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+	var cmd = exec.CommandContext(ctx, opts.Executable,
+		"--mode=test",
+		fmt.Sprintf("--test-case=%d", c.Address),
+		fmt.Sprintf("--max-stack-size=%d", opts.MaxStackSize),
+		"--", opts.Image)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	var start = time.Now()
+	var err = cmd.Run()
+	var result = Result {
+		Case:     c,
+		Duration: time.Since(start),
+		Output:   stripGoTrace(output.String()),
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		result.Status = TimedOut
+	} else if err != nil {
+		result.Status = Failed
+	} else {
+		result.Status = Passed
+	}
+	return result
+}
+
+// stripGoTrace removes the goroutine dump printed by the Go runtime
+// when the process is terminated by a runtime error.
+func stripGoTrace(output string) string {
+	if strings.HasPrefix(output, "panic: ") {
+		return ""
+	}
+	var i = strings.Index(output, "\npanic: ")
+	if i != -1 {
+		return output[:(i + 1)]
+	} else {
+		return output
+	}
+}
+
+func Report(w io.Writer, r Result, timeout time.Duration) {
+	var name = r.Case.FullName()
+	var seconds = r.Duration.Seconds()
+	switch r.Status {
+	case Passed:
+		fmt.Fprintf(w, "--- PASS: %s (%.2fs)\n", name, seconds)
+	case Failed:
+		fmt.Fprintf(w, "--- FAIL: %s (%.2fs)\n", name, seconds)
+		fmt.Fprintf(w, "    declared at %s\n", r.Case.Location())
+		var output = strings.TrimRight(r.Output, "\n")
+		if output != "" {
+			for _, line := range strings.Split(output, "\n") {
+				fmt.Fprintf(w, "    %s\n", line)
+			}
+		}
+	case TimedOut:
+		fmt.Fprintf(w, "--- FAIL: %s (timed out after %s)\n", name, timeout)
+		fmt.Fprintf(w, "    declared at %s\n", r.Case.Location())
+	}
+}
@@ -0,0 +1,99 @@
+package tester
+
+import (
+	"fmt"
+	"sort"
+	"regexp"
+	"kumachan/interpreter/compiler/loader"
+	"kumachan/interpreter/compiler/checker"
+	"kumachan/interpreter/compiler/generator"
+	. "kumachan/standalone/util/error"
+)
+
+
+/**
+ *  Test Runner
+ *
+ *  Test cases are functions tagged with `# test` (see checker), which
+ *  are collected from all modules of a module tree, except for modules
+ *  of the standard library, and optionally filtered by a regular
+ *  expression matching their full names (`module::name`).
+ *
+ *  The program is compiled once and saved as a program image, then each
+ *  test case is executed in a separate process running the image,
+ *  so that a runtime error or a timeout of a test case does not affect
+ *  other test cases. In the separate process, effects declared by `do`
+ *  are not executed; instead, the effect returned by the test function
+ *  is executed, and the exit code of the process reports the result:
+ *    0 - the effect completed (pass)
+ *    1 - the effect threw an error (fail)
+ *    other - a runtime error occurred, e.g. a failed `assert` (fail)
+ *  Messages of failed test cases, including source points of runtime
+ *  errors, are read from the output of the process.
+ */
+
+type Case struct {
+	Module   string
+	Name     string
+	Index    uint        // index in the overload group
+	Point    ErrorPoint  // declaration point
+	Address  uint        // global index of the function in the program
+}
+
+func (c Case) FullName() string {
+	return fmt.Sprintf("%s::%s", c.Module, c.Name)
+}
+
+func (c Case) Location() string {
+	var node = c.Point.Node
+	return fmt.Sprintf("%s:%d:%d", node.CST.Name, node.Point.Row, node.Point.Col)
+}
+
+// CollectCases collects test cases from a checked module tree. The locator
+// should be the one returned when generating the program from the tree.
+func CollectCases (
+	idx      checker.Index,
+	locator  generator.DepLocator,
+	filter   *regexp.Regexp,
+) ([] Case) {
+	var cases = make([] Case, 0)
+	for mod_name, mod := range idx {
+		if mod == nil || loader.IsStdLibModule(mod_name) {
+			continue
+		}
+		for name, group := range mod.Functions {
+			for i, f := range group {
+				if !(f.IsTest) || f.IsSelfAlias {
+					continue
+				}
+				var c = Case {
+					Module: mod_name,
+					Name:   name,
+					Index:  uint(i),
+					Point:  f.Point,
+				}
+				if filter != nil && !(filter.MatchString(c.FullName())) {
+					continue
+				}
+				var address, ok = locator.Locate(generator.DepFunction {
+					Module: mod_name,
+					Name:   name,
+					Index:  uint(i),
+				})
+				if !(ok) { panic("something went wrong") }
+				c.Address = address
+				cases = append(cases, c)
+			}
+		}
+	}
+	sort.SliceStable(cases, func(i, j int) bool {
+		var a = cases[i].Point.Node
+		var b = cases[j].Point.Node
+		if a.CST.Name != b.CST.Name {
+			return a.CST.Name < b.CST.Name
+		} else {
+			return a.Span.Start < b.Span.Start
+		}
+	})
+	return cases
+}
@@ -0,0 +1,141 @@
+package vm
+
+import (
+	"reflect"
+	. "kumachan/interpreter/def"
+	. "kumachan/standalone/util/error"
+)
+
+
+// Debugger receives notifications from the instruction loop.
+// Notifications may come from different goroutines concurrently.
+// The notifying goroutine is paused until the handler returns.
+type Debugger interface {
+	// LineEntered is called before the first instruction of a new
+	// source line is executed. The instructions of a line are not
+	// necessarily consecutive, e.g. a line containing a call is
+	// entered again when the call returns.
+	LineEntered(t DebugTarget)
+	// ContextExited is called when an execution context finished
+	// its work. The id of the context might be reused afterwards.
+	ContextExited(t DebugTarget)
+}
+
+type debugState struct {
+	lastPoint  ErrorPoint
+	returned   bool
+}
+
+// DebugTarget is a read-only view of a paused execution context.
+// It is only valid during a notification.
+type DebugTarget struct {
+	ec  *ExecutionContext
+}
+
+type DebugFrame struct {
+	Function  *Function
+	Point     ErrorPoint
+	Values    DebugFrameValues
+}
+
+// DebugFrameValues is the content of a frame in the data stack.
+// The argument of a function is initially at the bottom of the
+// temporary values, and usually moved into a local slot.
+type DebugFrameValues struct {
+	Context    [] Value
+	Locals     [] Value
+	Temporary  [] Value
+}
+
+// Id returns an identifier of the execution context.
+func (t DebugTarget) Id() uintptr {
+	return reflect.ValueOf(t.ec).Pointer()
+}
+
+// Depth returns the depth of the call stack. Frames replaced by
+// tail calls are not counted.
+func (t DebugTarget) Depth() uint {
+	return uint(len(t.ec.callStack))
+}
+
+// Point returns the source position of the instruction to be executed.
+func (t DebugTarget) Point() ErrorPoint {
+	var f = t.ec.workingFrame
+	return f.function.Info.SourceMap[f.instPtr]
+}
+
+// Returned tells whether the line is entered by returning from a call.
+func (t DebugTarget) Returned() bool {
+	return t.ec.debug.returned
+}
+
+// Frames returns the frames in the call stack, starting from the
+// working frame. Frames replaced by tail calls are not available.
+func (t DebugTarget) Frames() ([] DebugFrame) {
+	var ec = t.ec
+	var frames = make([] DebugFrame, 0)
+	var end = uint(len(ec.dataStack))
+	var add = func(f CallStackFrame, point ErrorPoint) {
+		frames = append(frames, DebugFrame {
+			Function: f.function,
+			Point:    point,
+			Values:   getDebugFrameValues(ec.dataStack, f, end),
+		})
+		end = f.baseAddr
+	}
+	add(ec.workingFrame, t.Point())
+	for i := (len(ec.callStack) - 1); i >= 1; i -= 1 {
+		var f = ec.callStack[i]
+		add(f, GetFrameErrorPoint(f))
+	}
+	return frames
+}
+
+// DataStack returns a copy of the whole data stack.
+func (t DebugTarget) DataStack() ([] Value) {
+	var stack = make([] Value, len(t.ec.dataStack))
+	copy(stack, t.ec.dataStack)
+	return stack
+}
+
+func getDebugFrameValues(stack DataStack, f CallStackFrame, end uint) DebugFrameValues {
+	var base = f.baseAddr
+	var context_size = uint(f.function.BaseSize.Context)
+	var reserved_size = uint(f.function.BaseSize.Reserved)
+	var temp_addr = (base + context_size + reserved_size)
+	var clone = func(values ([] Value)) ([] Value) {
+		var cloned = make([] Value, len(values))
+		copy(cloned, values)
+		return cloned
+	}
+	return DebugFrameValues {
+		Context:   clone(stack[base: (base + context_size)]),
+		Locals:    clone(stack[(base + context_size): temp_addr]),
+		Temporary: clone(stack[temp_addr: end]),
+	}
+}
+
+func (ec *ExecutionContext) debugResetLine(returned bool) {
+	ec.debug = debugState {
+		lastPoint: ErrorPoint {},
+		returned:  returned,
+	}
+}
+
+func (ec *ExecutionContext) debugCheckLine(d Debugger) {
+	var f = ec.workingFrame
+	var point = f.function.Info.SourceMap[f.instPtr]
+	var tree = point.Node.CST
+	if tree == nil {
+		return
+	}
+	var last = ec.debug.lastPoint
+	if tree == last.Node.CST && point.Node.Point.Row == last.Node.Point.Row {
+		return
+	}
+	d.LineEntered(DebugTarget { ec })
+	ec.debug = debugState {
+		lastPoint: point,
+		returned:  false,
+	}
+}
@@ -16,6 +16,7 @@ type ExecutionContext struct {
 	workingFrame  CallStackFrame
 	indexBufLen   uint
 	indexBuf      [ProductMaxSize] uint
+	debug         debugState
 }
 type DataStack  [] Value
 type CallStack  [] CallStackFrame
@@ -25,7 +26,7 @@ type CallStackFrame struct {
 	instPtr   uint
 }
 
-func execute(p Program, m *Machine) {
+func execute(p Program, m *Machine) int {
 	var L = len(p.DataValues) + len(p.Functions) + len(p.Closures)
 	assert(L <= GlobalSlotMaxSize, "maximum global slot size exceeded")
 	m.globalSlot = make([] Value, L)
@@ -44,7 +45,8 @@ func execute(p Program, m *Machine) {
 		case F_NATIVE:
 			add_global(api.GetNativeFunctionValue(f.NativeId))
 		case F_GENERATED:
-			add_global(f.Generated)
+			var seed = f.Generated.(GeneratedFunctionSeed)
+			add_global(seed.GenerateFunctionValue())
 		case F_RUNTIME_GENERATED:
 			switch seed := f.Generated.(type) {
 			case UiObjectThunk:
@@ -61,21 +63,21 @@ func execute(p Program, m *Machine) {
 		var v = &ValFun { Underlying: f }
 		add_global(v)
 	}
-	var background = rx.Background()
+	var root = m.root
 	var wg = make(chan bool, len(p.Effects))
 	for _, f := range p.Effects {
 		switch f.Kind {
 		case F_USER:
 			var evaluate = &ValFun { Underlying: f }
-			var e = (call(evaluate, nil, m, background)).(rx.Observable)
+			var e = (call(evaluate, nil, m, root)).(rx.Observable)
 			rx.Schedule(e, m.scheduler, rx.Receiver {
-				Context:   background,
+				Context:   root,
 				Terminate: wg,
 			})
 		case F_RUNTIME_GENERATED:
 			var  e = f.Generated.(rx.Observable)
 			rx.Schedule(e, m.scheduler, rx.Receiver {
-				Context:   background,
+				Context:   root,
 				Terminate: wg,
 			})
 		default:
@@ -83,19 +85,28 @@ func execute(p Program, m *Machine) {
 		}
 	}
 	for i := 0; i < len(p.Effects); i += 1 {
-		<- wg
+		select {
+		case <- wg:
+		case code := <- m.exitCode:
+			return code
+		}
 	}
+	return 0
 }
 
 func call(f UserFunctionValue, arg Value, m *Machine, sync_ctx *rx.Context) Value {
 	var ec = m.contextPool.Get().(*ExecutionContext)
 	var l = InteropErrorPointLocatorFromExecutionContext(ec)
 	var h = InteropHandle { machine: m, locator: l, sync_ctx: sync_ctx }
+	var debugger = m.options.Debugger
 	defer (func() {
 		var err = recover()
 		if err != nil {
 			var _, is_cancel = err.(SyncCancellationError)
 			if is_cancel {
+				if debugger != nil {
+					debugger.ContextExited(DebugTarget { ec })
+				}
 				ec.clear()
 				m.contextPool.Put(ec)
 				panic(err)
@@ -114,6 +125,9 @@ func call(f UserFunctionValue, arg Value, m *Machine, sync_ctx *rx.Context) Valu
 		var base_addr = ec.workingFrame.baseAddr
 		var inst_ptr_ref = &(ec.workingFrame.instPtr)
 		for *inst_ptr_ref < uint(len(code)) {
+			if debugger != nil {
+				ec.debugCheckLine(debugger)
+			}
 			var inst = code[*inst_ptr_ref]
 			*inst_ptr_ref += 1
 			switch inst.OpCode {
@@ -348,6 +362,9 @@ func call(f UserFunctionValue, arg Value, m *Machine, sync_ctx *rx.Context) Valu
 		ec.popCall()
 	}
 	var ret = ec.popValue()
+	if debugger != nil {
+		debugger.ContextExited(DebugTarget { ec })
+	}
 	ec.clear()
 	m.contextPool.Put(ec)
 	return ret
@@ -369,6 +386,7 @@ func (ec *ExecutionContext) clear() {
 	}
 	ec.dataStack = ec.dataStack[:0]
 	ec.indexBufLen = 0
+	ec.debug = debugState {}
 }
 
 func (ec *ExecutionContext) getCurrentValue() Value {
@@ -417,6 +435,7 @@ func (ec *ExecutionContext) pushCall(f UserFunctionValue, arg Value) {
 		baseAddr: new_base_addr,
 		instPtr:  0,
 	}
+	ec.debugResetLine(false)
 }
 
 func (ec *ExecutionContext) popCall() {
@@ -430,6 +449,7 @@ func (ec *ExecutionContext) popCall() {
 	ec.popValuesTo(ec.workingFrame.baseAddr)
 	ec.pushValue(ret)
 	ec.workingFrame = popped
+	ec.debugResetLine(true)
 }
 
 func (ec *ExecutionContext) popTailCall() {
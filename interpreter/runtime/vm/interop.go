@@ -61,6 +61,12 @@ func (h InteropHandle) Scheduler() rx.Scheduler {
 	return h.machine.scheduler
 }
 
+// InterceptExit stops the machine instead of the host process when
+// the machine is embedded. (see also: api.exitInterceptor)
+func (h InteropHandle) InterceptExit(code int) bool {
+	return h.machine.interceptExit(code)
+}
+
 func (h InteropHandle) GetSysEnv() ([] string) {
 	return h.machine.options.Environment
 }
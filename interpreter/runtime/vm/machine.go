@@ -19,6 +19,9 @@ type Machine struct {
 	extraLock    *sync.Mutex
 	contextPool  *sync.Pool
 	scheduler    rx.Scheduler
+	root         *rx.Context
+	stop         func()
+	exitCode     chan int
 	GeneratedObjects
 }
 
@@ -27,6 +30,12 @@ type Options struct {
 	MaxStackSize  uint
 	Environment   [] string
 	Arguments     [] string
+	Debugger      Debugger
+	// Embedded indicates that the program is executed inside a host
+	// process which should not be terminated when the program exits.
+	// In this case the machine is stopped on exit and the exit code
+	// is returned by Execute.
+	Embedded      bool
 	DebugOptions
 	StdIO
 }
@@ -37,7 +46,7 @@ type GeneratedObjects struct {
 	resources  map[string] map[string] Resource  // kind -> path -> res
 }
 
-func Execute(p Program, opts Options, m_signal (chan <- *Machine)) {
+func Execute(p Program, opts Options, m_signal (chan <- *Machine)) int {
 	var sched = rx.TrivialScheduler {
 		EventLoop: rx.SpawnEventLoop(),
 	}
@@ -55,12 +64,14 @@ func Execute(p Program, opts Options, m_signal (chan <- *Machine)) {
 		extraLock:    &sync.Mutex {},
 		contextPool:  pool,
 		scheduler:    sched,
+		exitCode:     make(chan int, 1),
 	}
+	m.root, m.stop = rx.CreateCancellableContext(sched)
 	m.generateObjects()
 	if m_signal != nil {
 		m_signal <- m
 	}
-	execute(p, m)
+	return execute(p, m)
 }
 
 func (m *Machine) generateObjects() {
@@ -71,6 +82,19 @@ func (m *Machine) generateObjects() {
 	}
 }
 
+func (m *Machine) interceptExit(code int) bool {
+	if !(m.options.Embedded) {
+		return false
+	}
+	select {
+	case m.exitCode <- code:
+	default:
+		// another exit already happened
+	}
+	m.stop()
+	return true
+}
+
 func (m *Machine) GetGlobalValue(index uint) (Value, bool) {
 	var L = uint(len(m.globalSlot))
 	if index < L {
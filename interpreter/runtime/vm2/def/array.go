@@ -10,7 +10,11 @@ var compactArrayTypes = [] reflect.Type {
 	reflect.TypeOf(big.NewInt(0)),
 	reflect.TypeOf(float64(0)),
 	reflect.TypeOf(complex128(complex(0,1))),
+	reflect.TypeOf(true),
 	reflect.TypeOf(uint8(0)),
+	reflect.TypeOf(uint16(0)),
+	reflect.TypeOf(uint32(0)),
+	reflect.TypeOf(uint64(0)),
 	reflect.TypeOf(int32(0)),
 }
 
@@ -143,6 +143,7 @@ func (seed *FunctionSeedUsual) writeContent(buf *strings.Builder) string {
 	buf.WriteRune('\n')
 	for i, s := range seed.Static {
 		fmt.Fprintf(buf, "    [%d] %s", i, s)
+		buf.WriteRune('\n')
 	}
 	buf.WriteString(".code")
 	buf.WriteRune('\n')
@@ -172,13 +173,13 @@ func writeBranchData (
 		for i, m := range branch.ExtIdxMap {
 			var default_target string
 			if m.HasDefault {
-				default_target = "()"
-			} else {
 				default_target = fmt.Sprintf("()[%d]", m.Default)
+			} else {
+				default_target = "()"
 			}
 			var targets = make([] string, 0)
 			for vec, target := range m.VectorMap {
-				var t = make([] string, 0)
+				var t = make([] string, len(vec.Decode()))
 				for j, idx := range vec.Decode() {
 					t[j] = fmt.Sprint(idx)
 				}
@@ -186,6 +187,20 @@ func writeBranchData (
 				var target_ = fmt.Sprintf("(%s)[%d]", vec_str, target)
 				targets = append(targets, target_)
 			}
+			for _, item := range m.MaskedList {
+				var mask = item.Mask.Decode()
+				var t = make([] string, len(mask))
+				for j, idx := range item.Vector.Decode() {
+					if mask[j] == 0 {
+						t[j] = "_"
+					} else {
+						t[j] = fmt.Sprint(idx)
+					}
+				}
+				var vec_str = strings.Join(t, "-")
+				var target_ = fmt.Sprintf("(%s)[%d]", vec_str, item.Target)
+				targets = append(targets, target_)
+			}
 			var targets_ = strings.Join(targets, " ")
 			fmt.Fprintf(buf, "    [%d] %s %s", i, default_target, targets_)
 			buf.WriteRune('\n')
@@ -194,7 +209,7 @@ func writeBranchData (
 	var flow_map = make([] string, len(branch.InstList))
 	var gen_flow_map func([] uint, [] Stage)
 	gen_flow_map = func(path ([] uint), stages ([] Stage)) {
-		for i, stage := range branch.Stages {
+		for i, stage := range stages {
 			for j, flow := range stage {
 				var i_j_path = make([] uint, len(path), len(path) + 2)
 				copy(i_j_path, path)
@@ -247,6 +262,7 @@ func writeBranchData (
 	}
 	for i, cl := range branch.Closures {
 		fmt.Fprintf(buf, ".closure-%d", i)
+		buf.WriteRune('\n')
 		cl.writeContent(buf)
 	}
 }
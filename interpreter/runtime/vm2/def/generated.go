@@ -3,6 +3,7 @@ package def
 import (
 	"fmt"
 	"strconv"
+	legacy "kumachan/interpreter/def"
 )
 
 
@@ -18,14 +19,7 @@ type UiObjectSeed struct {
 	Object  string
 	Group   *UiObjectGroup
 }
-type UiObjectGroup struct {
-	GroupName  string
-	BaseDir    string
-	XmlDef     string
-	RootName   string
-	Widgets    [] string
-	Actions    [] string
-}
+type UiObjectGroup = legacy.UiObjectGroup
 func (seed *UiObjectSeed) String() string {
 	return fmt.Sprintf("%s %s %s",
 		strconv.Quote(seed.Object),
@@ -36,4 +30,14 @@ func (seed *UiObjectSeed) Evaluate(ctx GeneratedNativeFunctionSeedEvaluator) Val
 	return ctx.EvaluateUiObjectSeed(seed)
 }
 
+type PredefinedNativeFunctionSeed struct {
+	Value  Value
+}
+func (seed *PredefinedNativeFunctionSeed) String() string {
+	return "(predefined)"
+}
+func (seed *PredefinedNativeFunctionSeed) Evaluate(_ GeneratedNativeFunctionSeedEvaluator) Value {
+	return seed.Value
+}
+
 
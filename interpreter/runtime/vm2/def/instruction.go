@@ -46,14 +46,14 @@ type LocalAddr uint16
 type LocalSize = LocalAddr
 const LocalAddrSize = unsafe.Sizeof(LocalAddr(0))
 
-const MaxEnumCases = (1 << ShortIndexSize)
-const MaxTupleElements = (1 << ShortIndexSize)
-const MaxBranchExpressions = (1 << ExternalIndexMapPointerSize)
-const MaxFrameValues = ((1 << LocalAddrSize) - 1)
-const MaxInsSeqLength = ((1 << LocalAddrSize) - 1)
-const MaxStaticValues = (1 << LocalAddrSize)
-const MaxClosures = (1 << LocalAddrSize)
-const MaxClosureContexts = (1 << LocalAddrSize)
+const MaxEnumCases = (1 << (8 * ShortIndexSize))
+const MaxTupleElements = (1 << (8 * ShortIndexSize))
+const MaxBranchExpressions = (1 << (8 * ExternalIndexMapPointerSize))
+const MaxFrameValues = ((1 << (8 * LocalAddrSize)) - 1)
+const MaxInsSeqLength = ((1 << (8 * LocalAddrSize)) - 1)
+const MaxStaticValues = (1 << (8 * LocalAddrSize))
+const MaxClosures = (1 << (8 * LocalAddrSize))
+const MaxClosureContexts = (1 << (8 * LocalAddrSize))
 
 type Instruction struct {
 	OpCode  OpCode
@@ -68,11 +68,32 @@ var _ = (func() struct{} {
 	return struct{}{}
 })()
 
+func CreateShortIndexVectorMask(wildcards ([] bool)) ShortIndexVector {
+	if !(uint(len(wildcards)) <= MaxShortIndexVectorElements) {
+		panic("something went wrong")
+	}
+	const all_bits = ((1 << (ShortIndexSize * 8)) - 1)
+	var mask ShortIndexVector = 0
+	for _, is_wildcard := range wildcards {
+		mask = (mask << (ShortIndexSize * 8))
+		if !(is_wildcard) {
+			mask = (mask | all_bits)
+		}
+	}
+	return mask
+}
+
 type ExternalIndexMapping ([] ExternalIndexMap)
 type ExternalIndexMap struct {
 	HasDefault  bool
 	Default     uint
 	VectorMap   map[ShortIndexVector] uint
+	MaskedList  [] MaskedVectorTarget  // checked in order if not in VectorMap
+}
+type MaskedVectorTarget struct {
+	Mask    ShortIndexVector
+	Vector  ShortIndexVector
+	Target  uint
 }
 func (all ExternalIndexMapping) ChooseBranch (
 	ptr  ExternalIndexMapPointer,
@@ -83,18 +104,22 @@ func (all ExternalIndexMapping) ChooseBranch (
 	var branch, found = m.VectorMap[vec]
 	if found {
 		return branch
-	} else {
-		if m.HasDefault {
-			return m.Default
-		} else {
-			panic("matching branch not found")
+	}
+	for _, item := range m.MaskedList {
+		if (vec & item.Mask) == (item.Vector & item.Mask) {
+			return item.Target
 		}
 	}
+	if m.HasDefault {
+		return m.Default
+	} else {
+		panic("matching branch not found")
+	}
 }
 
 type OpCode uint8
 const (
-	SIZE    OpCode = iota  // whole instruction as an unsigned number
+	SIZE    OpCode = iota  // [ SIZE ___ ____ ]: Number of the following operands
 	ARG     // [ ____ ___ ____ ]: Get the argument
 	STATIC  // [ ____ ___ PTR  ]: Copy a value from a static address
 	CTX     // [ ____ ___ PTR  ]: Copy a value from a context address
@@ -115,14 +140,20 @@ const (
 	MPS     // [ OBJ* ___ SRC* ]: Create a map with string key
 	MPI     // [ OBJ* ___ SRC* ]: Create a map with integer key
 	CL      // [ OBJ* ___ PTR  ]: Create a closure
-	CLR     // [ OBJ* ___ PTR  ]: Create a self-referential closure
+	CLR     // [ OBJ* ___ PTR  ]: Create a self-referential closure (self at 0)
 	INJ     // [ OBJ* ___ SRC  ]: Inject context values to a function value
 	CALL    // [ OBJ  ___ SRC  ]: Call a function
 )
 
+func InstSize(size LocalSize) Instruction {
+	return Instruction {
+		OpCode: SIZE,
+		Obj:    size,
+	}
+}
 func (inst *Instruction) ToSize() LocalSize {
 	if inst.OpCode != SIZE { panic("invalid operation") }
-	return LocalSize(*(*uint64)(unsafe.Pointer(inst)))
+	return inst.Obj
 }
 
 func (inst Instruction) String() string {
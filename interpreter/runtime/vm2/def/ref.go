@@ -6,11 +6,11 @@ func BranchRef(enum EnumValue, idx ShortIndex) NativeFuncValue {
 		var new_value, update = Unwrap(arg.(EnumValue))
 		if update {
 			return Tuple(&ValEnum {
-				Index: idx,
+				Index: uint(idx),
 				Value: new_value,
 			}, arg)
 		} else {
-			if enum.Index == idx {
+			if enum.Index == uint(idx) {
 				return Tuple(enum, Some(enum.Value))
 			} else {
 				return Tuple(enum, None())
@@ -30,13 +30,13 @@ func BranchRefFromCaseRef(base_ref Value, idx ShortIndex) NativeFuncValue {
 		if has_value {
 			if update {
 				var u = h.Call(base_ref, Some(&ValEnum {
-					Index: idx,
+					Index: uint(idx),
 					Value: new_value,
 				}))
 				return Tuple(u.(TupleValue).Elements[0], arg)
 			} else {
 				var enum = value.(EnumValue)
-				if enum.Index == idx {
+				if enum.Index == uint(idx) {
 					return Tuple(base_enum, Some(enum.Value))
 				} else {
 					return Tuple(base_enum, None())
@@ -53,7 +53,7 @@ func BranchRefFromProjRef(base_ref Value, idx ShortIndex) NativeFuncValue {
 		var new_value, update = Unwrap(arg.(EnumValue))
 		if update {
 			var t = h.Call(base_ref, Some(&ValEnum {
-				Index: idx,
+				Index: uint(idx),
 				Value: new_value,
 			}))
 			return Tuple(t.(TupleValue).Elements[0], arg)
@@ -63,7 +63,7 @@ func BranchRefFromProjRef(base_ref Value, idx ShortIndex) NativeFuncValue {
 			var base_tup = pair[0]
 			var base_field = pair[1]
 			var enum = base_field.(EnumValue)
-			if enum.Index == idx {
+			if enum.Index == uint(idx) {
 				return Tuple(base_tup, Some(enum.Value))
 			} else {
 				return Tuple(base_tup, None())
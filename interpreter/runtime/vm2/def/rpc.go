@@ -80,6 +80,8 @@ type KmdApi interface {
 	GetTypeFromId(id kmd.TypeId) *kmd.Type
 	Serialize(v Value, t *kmd.Type) ([] byte, error)
 	Deserialize(binary ([] byte), t *kmd.Type) (Value, error)
+	Encode(v Value, t *kmd.Type, f kmd.Format) ([] byte, error)
+	Decode(data ([] byte), t *kmd.Type, f kmd.Format) (Value, error)
 	rpc.KmdApi
 }
 type KmdInfo struct {
@@ -116,6 +118,37 @@ func CreateKmdApiFunction(id kmd.TransformerPartId) NativeFuncValue {
 			if err != nil { return Ng(err) }
 			return Ok(obj)
 		})
+	case kmd.EncoderId:
+		return ValNativeFunc(func(arg Value, h InteropContext) Value {
+			var api = h.GetKmdApi()
+			var t = api.GetTypeFromId(id.TypeId)
+			var data, err = api.Encode(arg, t, id.Format)
+			if err != nil {
+				var wrapped = fmt.Errorf("%s encoding error: %w", id.Format, err)
+				panic(wrapped)
+			}
+			switch id.Format {
+			case kmd.JsonFormat:
+				return string(data)
+			default:
+				return data
+			}
+		})
+	case kmd.DecoderId:
+		return ValNativeFunc(func(arg Value, h InteropContext) Value {
+			var api = h.GetKmdApi()
+			var t = api.GetTypeFromId(id.TypeId)
+			var data ([] byte)
+			switch id.Format {
+			case kmd.JsonFormat:
+				data = ([] byte)(arg.(string))
+			default:
+				data = arg.([] byte)
+			}
+			var obj, err = api.Decode(data, t, id.Format)
+			if err != nil { return Ng(err) }
+			return Ok(obj)
+		})
 	default:
 		panic("impossible branch")
 	}
@@ -4,21 +4,21 @@ import (
 	"unsafe"
 	"reflect"
 	"kumachan/stdlib"
+	legacy "kumachan/interpreter/def"
 )
 
 
 type Value = interface {}
 
+// Enum and tuple values share their representation with the legacy
+// runtime, so that native library functions can be used without any
+// conversion of arguments and return values.
+
 type EnumValue = *ValEnum
-type ValEnum struct {
-	Index  ShortIndex
-	Value  Value
-}
+type ValEnum = legacy.ValEnum
 
 type TupleValue = *ValTup
-type ValTup struct {
-	Elements  [] Value
-}
+type ValTup = legacy.ValTup
 
 type UsualFuncValue = *ValFunc
 type ValFunc struct {
@@ -32,10 +32,10 @@ func ValNativeFunc(f NativeFunction) NativeFuncValue {
 }
 
 func Tuple(elements... Value) TupleValue {
-	return &ValTup { elements }
+	return &ValTup { Elements: elements }
 }
 func TupleOf(elements ([] Value)) TupleValue {
-	return &ValTup { elements }
+	return &ValTup { Elements: elements }
 }
 func SingleValueFromRecord(b TupleValue) Value {
 	if len(b.Elements) != 1 { panic("record size is not 1") }
@@ -141,7 +141,7 @@ func Struct2Prod(v interface{}) TupleValue {
 	for i := 0; i < rv.NumField(); i += 1 {
 		elements[i] = ToValue(rv.Field(i).Interface())
 	}
-	return &ValTup { elements }
+	return &ValTup { Elements: elements }
 }
 
 func ToValue(go_value interface{}) Value {
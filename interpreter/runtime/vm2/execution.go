@@ -3,10 +3,12 @@ package vm2
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"reflect"
 	"kumachan/standalone/rx"
 	. "kumachan/interpreter/runtime/vm2/def"
 	. "kumachan/interpreter/runtime/vm2/frame"
+	legacy "kumachan/interpreter/def"
 )
 
 
@@ -37,7 +39,8 @@ func execFrame(ctx Context, m *Machine, u *Frame, kv ContVal) {
 		if e != nil {
 			kv(e, nil)
 		} else {
-			kv(nil, u.Data(u.LastDataAddr()))
+			var code = u.Code()
+			kv(nil, u.Data(code.InstDstAddr(u.LastInsAddr())))
 		}
 	})
 	if m.options.ParallelEnabled {
@@ -74,7 +77,8 @@ func execParallel(ctx Context, m *Machine, u *Frame, stages ([] Stage), k0 Cont)
 			execParallel(ctx, m, u, remaining_stages, k)
 		})
 	} else {
-		var sem = make(chan struct{}, (num_of_flows - 1))
+		// the last finished flow continues with the remaining stages
+		var pending = int32(num_of_flows)
 		this_stage.ForEachFlow(func(flow Flow) {
 			m.pool.Execute(func() {
 				execFlow(ctx, m, u, flow, func(e interface{}) {
@@ -82,9 +86,7 @@ func execParallel(ctx Context, m *Machine, u *Frame, stages ([] Stage), k0 Cont)
 						k(e)
 						return
 					}
-					select {
-					case sem <- struct{}{}:
-					default:
+					if atomic.AddInt32(&pending, -1) == 0 {
 						execParallel(ctx, m, u, remaining_stages, k)
 					}
 				})
@@ -147,33 +149,33 @@ func execIns(ctx Context, m *Machine, u *Frame, ipp *LocalAddr, end LocalAddr, k
 		*dst = u.Data(inst.Src)
 	case ENUM:
 		*dst = &ValEnum {
-			Index: inst.Idx,
+			Index: uint(inst.Idx),
 			Value: u.Data(inst.Obj),
 		}
 	case SWITCH:
 		var obj = u.Data(inst.Obj)
 		var enum = obj.(EnumValue)
-		var vec = CreateShortIndexVectorSingleElement(enum.Index)
+		var vec = CreateShortIndexVectorSingleElement(ShortIndex(enum.Index))
 		var target = code.ChooseBranch(inst.ExtIdx, vec)
 		var f = code.BranchFuncValue(target)
 		callBranch(ctx, m, f, enum.Value, u, kv_dst); return
 	case SELECT:
 		var objects_addr = inst.Obj
 		var num_of_objects = u.DataGetSizeAt(objects_addr)
-		assert(uint(num_of_objects) < MaxShortIndexVectorElements,
+		assert(uint(num_of_objects) <= MaxShortIndexVectorElements,
 			"SELECT: too many operands")
 		var objects = u.DataRange(objects_addr, num_of_objects)
 		var indexes = make([] ShortIndex, num_of_objects)
 		var values = make([] Value, num_of_objects)
 		for n := uint(0); n < uint(num_of_objects); n += 1 {
 			var enum = objects[n].(EnumValue)
-			indexes[n] = enum.Index
+			indexes[n] = ShortIndex(enum.Index)
 			values[n] = enum.Value
 		}
 		var vec = CreateShortIndexVector(indexes)
 		var target = code.ChooseBranch(inst.ExtIdx, vec)
 		var f = code.BranchFuncValue(target)
-		callBranch(ctx, m, f, values, u, kv_dst); return
+		callBranch(ctx, m, f, TupleOf(values), u, kv_dst); return
 	case BR:
 		var enum = u.Data(inst.Obj).(EnumValue)
 		*dst = BranchRef(enum, inst.Idx)
@@ -216,7 +218,7 @@ func execIns(ctx Context, m *Machine, u *Frame, ipp *LocalAddr, end LocalAddr, k
 		var num_of_objects = u.DataGetSizeAt(objects_addr)
 		var t = GetCompactArrayType(inst.Idx)
 		var length = int(num_of_objects)
-		var r_list = reflect.MakeSlice(t, length, length)
+		var r_list = reflect.MakeSlice(reflect.SliceOf(t), length, length)
 		var objects = u.DataRange(objects_addr, num_of_objects)
 		for index, item := range objects {
 			r_list.Index(index).Set(reflect.ValueOf(item))
@@ -234,7 +236,9 @@ func execIns(ctx Context, m *Machine, u *Frame, ipp *LocalAddr, end LocalAddr, k
 		var num_of_values = num_of_objects
 		if op == CLR { num_of_values += 1 }
 		var context = make([] Value, num_of_values)
-		copy(context, u.DataRange(objects_addr, num_of_objects))
+		var captured = context
+		if op == CLR { captured = context[1:] }
+		copy(captured, u.DataRange(objects_addr, num_of_objects))
 		var entity = u.Func().Entity.Code.ClosureEntity(ptr)
 		var required = entity.ContextLength
 		assert(num_of_values == required, "CL: invalid context length")
@@ -242,7 +246,7 @@ func execIns(ctx Context, m *Machine, u *Frame, ipp *LocalAddr, end LocalAddr, k
 			Entity:  entity,
 			Context: context,
 		}
-		if op == CLR { context[num_of_values - 1] = closure }
+		if op == CLR { context[0] = closure }
 		*dst = closure
 	case INJ:
 		var f = u.Data(inst.Src)
@@ -262,7 +266,12 @@ func execIns(ctx Context, m *Machine, u *Frame, ipp *LocalAddr, end LocalAddr, k
 			}
 		case NativeFuncValue:
 			closure = ValNativeFunc(func(arg Value, h InteropContext) Value {
-				var arg_with_context = Tuple(arg, context)
+				var arg_with_context = Tuple(arg, TupleOf(context))
+				return (*f)(arg_with_context, h)
+			})
+		case legacy.NativeFunctionValue:
+			closure = legacy.ValNativeFun(func(arg Value, h legacy.InteropContext) Value {
+				var arg_with_context = Tuple(arg, TupleOf(context))
 				return (*f)(arg_with_context, h)
 			})
 		default:
@@ -293,6 +302,17 @@ func execIns(ctx Context, m *Machine, u *Frame, ipp *LocalAddr, end LocalAddr, k
 				location: l,
 			}
 			*dst = (*f)(arg, h)
+		case legacy.NativeFunctionValue:
+			var l = Location {
+				Function: u.Func().Entity,
+				InstPtr:  ip,
+			}
+			var h = InteropHandle {
+				context:  ctx,
+				machine:  m,
+				location: l,
+			}
+			*dst = (*f)(arg, LegacyInteropHandle { h })
 		default:
 			panic("CALL: operand not callable")
 		}
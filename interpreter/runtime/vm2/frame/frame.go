@@ -64,9 +64,7 @@ func (u *Frame) TailCall(f UsualFuncValue, arg Value) *Frame {
 			data:     u.data,
 		}
 	} else {
-		var new_frame = CreateFrame(f, arg)
-		u.data = new_frame.data
-		return new_frame
+		return CreateFrame(f, arg)
 	}
 }
 
@@ -5,6 +5,7 @@ import (
 	"strings"
 	. "kumachan/interpreter/runtime/vm2/def"
 	"kumachan/standalone/rx"
+	legacy "kumachan/interpreter/def"
 )
 
 
@@ -20,6 +21,8 @@ func (h InteropHandle) Call(v Value, arg Value) Value {
 		return h.machine.Call(h.context, f, arg)
 	case NativeFuncValue:
 		return (*f)(arg, h)
+	case legacy.NativeFunctionValue:
+		return (*f)(arg, LegacyInteropHandle { h })
 	default:
 		panic("cannot call a non-callable value")
 	}
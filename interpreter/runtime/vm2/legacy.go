@@ -0,0 +1,138 @@
+package vm2
+
+import (
+	"kumachan/standalone/rx"
+	. "kumachan/interpreter/runtime/vm2/def"
+	. "kumachan/standalone/util/error"
+	legacy "kumachan/interpreter/def"
+	"kumachan/interpreter/runtime/lib/librpc"
+)
+
+
+// LegacyInteropHandle exposes an InteropHandle as the interop context
+// of the legacy runtime, so that the native library can be shared.
+type LegacyInteropHandle struct {
+	handle  InteropHandle
+}
+
+func (h LegacyInteropHandle) Call(f Value, arg Value) Value {
+	return h.CallWithSyncContext(f, arg, h.handle.context)
+}
+func (h LegacyInteropHandle) CallWithSyncContext(f Value, arg Value, ctx *rx.Context) Value {
+	defer (func() {
+		var e = recover()
+		if e != nil {
+			var _, is_cancel = e.(ExecutionCancelled)
+			if is_cancel {
+				panic(legacy.SyncCancellationError {})
+			} else {
+				panic(e)
+			}
+		}
+	})()
+	return h.handle.CallWithContext(ctx, f, arg)
+}
+func (h LegacyInteropHandle) SyncContext() *rx.Context {
+	return h.handle.context
+}
+func (h LegacyInteropHandle) Scheduler() rx.Scheduler {
+	return h.handle.Scheduler()
+}
+func (h LegacyInteropHandle) ErrorPoint() ErrorPoint {
+	var l = h.handle.location
+	if l.Function == nil {
+		return ErrorPoint {}
+	}
+	if uint(l.InstPtr) < uint(len(l.Function.SrcMap)) {
+		return l.Function.SrcMap[l.InstPtr]
+	} else {
+		return l.Function.Decl
+	}
+}
+func (h LegacyInteropHandle) GetSysEnv() ([] string) {
+	return h.handle.GetSysEnv()
+}
+func (h LegacyInteropHandle) GetSysArgs() ([] string) {
+	return h.handle.GetSysArgs()
+}
+func (h LegacyInteropHandle) GetStdIO() legacy.StdIO {
+	var stdio = h.handle.GetStdIO()
+	return legacy.StdIO {
+		Stdin:  stdio.Stdin,
+		Stdout: stdio.Stdout,
+		Stderr: stdio.Stderr,
+	}
+}
+func (h LegacyInteropHandle) GetDebugOptions() legacy.DebugOptions {
+	var opts = h.handle.GetDebugOptions()
+	return legacy.DebugOptions {
+		DebugUI: opts.DebugUI,
+	}
+}
+func (h LegacyInteropHandle) GetEntryModulePath() string {
+	return h.handle.GetEntryModulePath()
+}
+func (h LegacyInteropHandle) GetKmdApi() legacy.KmdApi {
+	return h.handle.machine.legacyRpcApi.GetKmdApi()
+}
+func (h LegacyInteropHandle) GetRpcApi() legacy.RpcApi {
+	return h.handle.machine.legacyRpcApi
+}
+func (h LegacyInteropHandle) GetResource(kind string, path string) (legacy.Resource, bool) {
+	var res, exists = h.handle.GetResource(kind, path)
+	return legacy.Resource {
+		Kind: res.Kind,
+		MIME: res.MIME,
+		Data: res.Data,
+	}, exists
+}
+
+type legacyRpcApiAdapter struct {
+	legacy.RpcApi
+}
+func (api legacyRpcApiAdapter) GetKmdApi() KmdApi {
+	return api.RpcApi.GetKmdApi()
+}
+
+type legacyRpcInfoContext struct {
+	machine  *Machine
+}
+func createLegacyRpcApi(m *Machine) legacy.RpcApi {
+	return librpc.CreateRpcApi(legacyRpcInfoContext { m })
+}
+func (ctx legacyRpcInfoContext) GetRpcInfo() legacy.RpcInfo {
+	return legacy.RpcInfo {
+		ServiceIndex: ctx.machine.program.RpcInfo.ServiceIndex,
+	}
+}
+func (ctx legacyRpcInfoContext) KmdGetInfo() legacy.KmdInfo {
+	var info = ctx.machine.program.KmdInfo
+	var adapters = make(legacy.KmdAdapterTable)
+	for id, item := range info.KmdAdapterTable {
+		var index, exists = ctx.machine.funcMap[item.Symbol]
+		if !(exists) { panic("something went wrong") }
+		adapters[id] = legacy.KmdAdapterInfo { Index: index }
+	}
+	var validators = make(legacy.KmdValidatorTable)
+	for id, item := range info.KmdValidatorTable {
+		var index, exists = ctx.machine.funcMap[item.Symbol]
+		if !(exists) { panic("something went wrong") }
+		validators[id] = legacy.KmdValidatorInfo { Index: index }
+	}
+	return legacy.KmdInfo {
+		SchemaTable:       info.SchemaTable,
+		KmdAdapterTable:   adapters,
+		KmdValidatorTable: validators,
+	}
+}
+func (ctx legacyRpcInfoContext) KmdCallAdapter(info legacy.KmdAdapterInfo, x Value) Value {
+	var f = ctx.machine.functions[info.Index]
+	return ctx.machine.Call(rx.Background(), f.(UsualFuncValue), x)
+}
+func (ctx legacyRpcInfoContext) KmdCallValidator(info legacy.KmdValidatorInfo, x Value) bool {
+	var f = ctx.machine.functions[info.Index]
+	var ok = ctx.machine.Call(rx.Background(), f.(UsualFuncValue), x)
+	return FromBool(ok.(EnumValue))
+}
+
+
@@ -3,9 +3,9 @@ package vm2
 import (
 	"kumachan/standalone/rx"
 	. "kumachan/interpreter/runtime/vm2/def"
-	"kumachan/interpreter/runtime/lib/librpc"
 	"kumachan/interpreter/runtime/api"
 	"kumachan/interpreter/runtime/lib/ui"
+	legacy "kumachan/interpreter/def"
 )
 
 
@@ -35,6 +35,7 @@ type GeneratedObjects struct {
 	kmdApi     KmdApi
 	rpcApi     RpcApi
 	resources  map[string] map[string] Resource  // kind -> path -> res
+	legacyRpcApi  legacy.RpcApi
 }
 
 func Execute(p Program, opts Options, ret (chan <- *Machine)) {
@@ -76,7 +77,12 @@ func generateObjects(m *Machine) {
 			return api.GetNativeFunctionValue(id)
 		},
 		GeneratedNativeFunctionSeedEvaluator: GeneratedNativeFunctionSeedEvaluator {
-			EvaluateUiObjectSeed: ui.EvaluateObjectThunk,
+			EvaluateUiObjectSeed: func(seed *UiObjectSeed) Value {
+				return ui.EvaluateObjectThunk(legacy.UiObjectThunk {
+					Object: seed.Object,
+					Group:  seed.Group,
+				})
+			},
 		},
 		StaticValueSeedEvaluator: StaticValueSeedEvaluator {
 			GetFunctionReference: func(sym Symbol) *Value {
@@ -93,10 +99,12 @@ func generateObjects(m *Machine) {
 		functions: functions,
 		funcMap:   funcMap,
 		effects:   effects,
-		kmdApi:    librpc.CreateKmdApi(m),
-		rpcApi:    librpc.CreateRpcApi(m),
 		resources: CategorizeResources(m.options.Resources),
 	}
+	var rpc_api = createLegacyRpcApi(m)
+	m.legacyRpcApi = rpc_api
+	m.rpcApi = legacyRpcApiAdapter { rpc_api }
+	m.kmdApi = rpc_api.GetKmdApi()
 }
 
 func runAllEffects(m *Machine) {
@@ -158,24 +166,4 @@ func (m *Machine) GetScheduler() rx.Scheduler {
 	return m.scheduler
 }
 
-func (m *Machine) KmdGetInfo() KmdInfo {
-	return m.program.KmdInfo
-}
-
-func (m *Machine) GetRpcInfo() RpcInfo {
-	return m.program.RpcInfo
-}
-
-func (m *Machine) KmdCallAdapter(info KmdAdapterInfo, x Value) Value {
-	var f, exists = m.GetFuncValue(info.Symbol)
-	if !(exists) { panic("something went wrong") }
-	return m.Call(rx.Background(), f.(UsualFuncValue), x)
-}
-
-func (m *Machine) KmdCallValidator(info KmdValidatorInfo, x Value) bool {
-	var f, exists = m.GetFuncValue(info.Symbol)
-	if !(exists) { panic("something went wrong") }
-	return FromBool(m.Call(rx.Background(), f.(UsualFuncValue), x).(EnumValue))
-}
-
 
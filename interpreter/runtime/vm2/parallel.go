@@ -5,14 +5,16 @@ import (
 )
 
 
-const GoroutinePoolRunQueueSize = 32768
-
+// GoroutinePool hands tasks only to idle workers. A task is executed in
+// the calling goroutine when all workers are busy. Tasks are never queued,
+// since a worker may be blocked by a synchronous call (e.g. a native
+// function calling back into the machine) that waits for queued tasks.
 type GoroutinePool struct {
 	runQueue  chan func()
 }
 func CreateGoroutinePool() GoroutinePool {
 	var p = GoroutinePool {
-		runQueue: make(chan func(), GoroutinePoolRunQueueSize),
+		runQueue: make(chan func()),
 	}
 	var n = runtime.NumCPU()
 	for i := 0; i < n; i += 1 {
@@ -4,11 +4,16 @@ import (
     "io"
     "os"
     "fmt"
+    "sort"
     "reflect"
     "strings"
     "runtime"
+    "regexp"
     "strconv"
+    "time"
     "io/ioutil"
+    "path/filepath"
+    "encoding/json"
     "kumachan/standalone/rx"
     "kumachan/standalone/rpc"
     "kumachan/standalone/rpc/kmd"
@@ -16,10 +21,17 @@ import (
     . "kumachan/standalone/util/error"
     "kumachan/support/docs"
     "kumachan/support/atom"
+    "kumachan/support/lsp"
     "kumachan/interpreter/compiler/loader"
     "kumachan/interpreter/compiler/checker"
     "kumachan/interpreter/compiler/generator"
+    "kumachan/interpreter/compiler/generator2"
+    "kumachan/interpreter/compiler/bundle"
     "kumachan/interpreter/runtime/vm"
+    "kumachan/interpreter/runtime/vm2"
+    "kumachan/interpreter/runtime/debugger"
+    "kumachan/interpreter/runtime/tester"
+    vm2def "kumachan/interpreter/runtime/vm2/def"
     "kumachan/standalone/qt"
 	"kumachan/interpreter/def"
 	"kumachan/interpreter/lang/textual/parser"
@@ -27,6 +39,7 @@ import (
 	"kumachan/interpreter/lang/textual/scanner"
 	"kumachan/interpreter/lang/textual/syntax"
 	"kumachan/interpreter/lang/textual/transformer"
+	"kumachan/interpreter/lang/textual/formatter"
 )
 
 
@@ -78,72 +91,447 @@ func debug_parser(file io.Reader, name string, root string) {
     }
 }
 
+func load(path string) (*loader.Module, loader.Index, loader.ResIndex) {
+    var mod, idx, res, err = loader.LoadEntry(path)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+        os.Exit(3)
+    }
+    return mod, idx, res
+}
+
+func check(mod *loader.Module, idx loader.Index) (*checker.CheckedModule, checker.Index, kmd.SchemaTable, rpc.ServiceIndex) {
+    var c_mod, c_idx, sch, serv, errs = checker.TypeCheck(mod, idx)
+    if errs != nil {
+        fmt.Fprintf(os.Stderr, "%s\n", MergeErrors(errs))
+        os.Exit(4)
+    }
+    for _, w := range checker.CollectWarnings(c_idx) {
+        fmt.Fprintf(os.Stderr, "%s\n", w.Message())
+    }
+    return c_mod, c_idx, sch, serv
+}
+
+func compile(entry *checker.CheckedModule, sch kmd.SchemaTable, serv rpc.ServiceIndex) def.Program {
+    var program, _ = compile_locatable(entry, sch, serv)
+    return program
+}
+
+func compile_locatable(entry *checker.CheckedModule, sch kmd.SchemaTable, serv rpc.ServiceIndex) (def.Program, generator.DepLocator) {
+    var data = make([] def.DataValue, 0)
+    var closures = make([] generator.FuncNode, 0)
+    var idx = make(generator.Index)
+    var errs = generator.CompileModule(entry, idx, &data, &closures)
+    if errs != nil {
+        fmt.Fprintf(os.Stderr, "%s\n", MergeErrors(errs))
+        os.Exit(5)
+    }
+    var meta = def.ProgramMetaData {
+        EntryModulePath: entry.RawModule.Path,
+    }
+    var program, locator, err = generator.CreateProgram(meta, idx, data, closures, sch, serv)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "%s\n", MergeErrors([] E { err }))
+        os.Exit(6)
+    }
+    return program, locator
+}
+
+func compile2(entry *checker.CheckedModule, sch kmd.SchemaTable, serv rpc.ServiceIndex) vm2def.Program {
+    var idx = make(generator2.Index)
+    var errs = generator2.CompileModule(entry, idx)
+    if errs != nil {
+        fmt.Fprintf(os.Stderr, "%s\n", MergeErrors(errs))
+        os.Exit(5)
+    }
+    var meta = vm2def.ProgramMetaData {
+        EntryModulePath: entry.RawModule.Path,
+    }
+    var program, err = generator2.CreateProgram(meta, idx, sch, serv)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "%s\n", MergeErrors([] E { err }))
+        os.Exit(6)
+    }
+    return program
+}
+
+func dump_asm(program fmt.Stringer, file_path string) {
+    var f, err = os.OpenFile(file_path, os.O_WRONLY | os.O_TRUNC | os.O_CREATE, 0666)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "cannot open asm dump file: %s", err)
+        os.Exit(99)
+    }
+    _, err = fmt.Fprint(f, program.String())
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "error writing to asm dump file: %s", err)
+        os.Exit(99)
+    }
+    _ = f.Close()
+}
+
 func interpret (
     path string, args ([] string),
     max_stack_size int, asm_dump string, debug_opts def.DebugOptions,
+    use_vm2 bool, parallel bool,
 ) {
-    var load = func(path string) (*loader.Module, loader.Index, loader.ResIndex) {
-        var mod, idx, res, err = loader.LoadEntry(path)
+    if strings.HasSuffix(path, bundle.FileExtension) {
+        if use_vm2 {
+            fmt.Fprintf(os.Stderr, "program images can only be executed on vm1\n")
+            os.Exit(100)
+        }
+        var program, res, err = bundle.ReadFile(path)
         if err != nil {
-            fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+            fmt.Fprintf(os.Stderr, "cannot read program image: %s\n", err)
             os.Exit(3)
         }
-        return mod, idx, res
+        if asm_dump != "" {
+            dump_asm(program, asm_dump)
+        }
+        vm.Execute(program, vm.Options {
+            Resources:    res,
+            MaxStackSize: uint(max_stack_size),
+            Environment:  os.Environ(),
+            Arguments:    args,
+            DebugOptions: debug_opts,
+            StdIO:        stdio,
+        }, nil)
+        return
     }
-    var check = func(mod *loader.Module, idx loader.Index) (*checker.CheckedModule, checker.Index, kmd.SchemaTable, rpc.ServiceIndex) {
-        var c_mod, c_idx, sch, serv, errs = checker.TypeCheck(mod, idx)
-        if errs != nil {
-            fmt.Fprintf(os.Stderr, "%s\n", MergeErrors(errs))
-            os.Exit(4)
+    var mod, idx, res = load(path)
+    var c_mod, _, sch, serv = check(mod, idx)
+    if use_vm2 {
+        var program = compile2(c_mod, sch, serv)
+        if asm_dump != "" {
+            dump_asm(program, asm_dump)
+        }
+        var resources = make(map[string] vm2def.Resource)
+        for path, item := range res {
+            resources[path] = vm2def.Resource {
+                Kind: item.Kind,
+                MIME: item.MIME,
+                Data: item.Data,
+            }
+        }
+        vm2.Execute(program, vm2.Options {
+            Resources:       resources,
+            MaxStackSize:    uint(max_stack_size),
+            SysEnv:          os.Environ(),
+            SysArgs:         args,
+            ParallelEnabled: parallel,
+            DebugOptions:    vm2def.DebugOptions {
+                DebugUI: debug_opts.DebugUI,
+            },
+            StdIO: vm2def.StdIO {
+                Stdin:  stdio.Stdin,
+                Stdout: stdio.Stdout,
+                Stderr: stdio.Stderr,
+            },
+        }, nil)
+        return
+    }
+    var program = compile(c_mod, sch, serv)
+    if asm_dump != "" {
+        dump_asm(program, asm_dump)
+    }
+    vm.Execute(program, vm.Options {
+        Resources:    res,
+        MaxStackSize: uint(max_stack_size),
+        Environment:  os.Environ(),
+        Arguments:    args,
+        DebugOptions: debug_opts,
+        StdIO:        stdio,
+    }, nil)
+}
+
+func debug(path string, args ([] string), max_stack_size int) {
+    var program def.Program
+    var res map[string] def.Resource
+    if strings.HasSuffix(path, bundle.FileExtension) {
+        var err error
+        program, res, err = bundle.ReadFile(path)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "cannot read program image: %s\n", err)
+            os.Exit(3)
         }
-        return c_mod, c_idx, sch, serv
+    } else {
+        var mod, idx, loader_res = load(path)
+        var c_mod, _, sch, serv = check(mod, idx)
+        program = compile(c_mod, sch, serv)
+        res = loader_res
     }
-    var compile = func(entry *checker.CheckedModule, sch kmd.SchemaTable, serv rpc.ServiceIndex) def.Program {
-        var data = make([] def.DataValue, 0)
-        var closures = make([] generator.FuncNode, 0)
-        var idx = make(generator.Index)
-        var errs = generator.CompileModule(entry, idx, &data, &closures)
-        if errs != nil {
-            fmt.Fprintf(os.Stderr, "%s\n", MergeErrors(errs))
-            os.Exit(5)
+    // debugger commands are read from stdin, therefore the program
+    // itself gets an empty stdin
+    var null, err = os.Open(os.DevNull)
+    if err != nil { panic(err) }
+    var session = debugger.CreateSession(os.Stdin, os.Stderr, func() {
+        os.Exit(0)
+    })
+    vm.Execute(program, vm.Options {
+        Resources:    res,
+        MaxStackSize: uint(max_stack_size),
+        Environment:  os.Environ(),
+        Arguments:    args,
+        Debugger:     session,
+        StdIO:        def.StdIO {
+            Stdin:  rx.FileFrom(null),
+            Stdout: stdio.Stdout,
+            Stderr: stdio.Stderr,
+        },
+    }, nil)
+}
+
+func dap(max_stack_size int) {
+    var server = debugger.CreateDapServer(os.Stdin, os.Stdout, debugger.DapOptions {
+        Load:         load_program,
+        MaxStackSize: uint(max_stack_size),
+        Environment:  os.Environ(),
+        Quit:         func() { os.Exit(0) },
+    })
+    var err = server.Serve()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "dap: %s\n", err)
+        os.Exit(8)
+    }
+}
+
+// load_program is similar to load/check/compile but returns errors
+// instead of exiting, which is required by long-running servers.
+func load_program(path string) (def.Program, (map[string] def.Resource), error) {
+    if strings.HasSuffix(path, bundle.FileExtension) {
+        return bundle.ReadFile(path)
+    }
+    var mod, idx, res, ldr_err = loader.LoadEntry(path)
+    if ldr_err != nil { return def.Program {}, nil, ldr_err }
+    var c_mod, _, sch, serv, errs = checker.TypeCheck(mod, idx)
+    if errs != nil { return def.Program {}, nil, MergeErrors(errs) }
+    var data = make([] def.DataValue, 0)
+    var closures = make([] generator.FuncNode, 0)
+    var gen_idx = make(generator.Index)
+    errs = generator.CompileModule(c_mod, gen_idx, &data, &closures)
+    if errs != nil { return def.Program {}, nil, MergeErrors(errs) }
+    var meta = def.ProgramMetaData {
+        EntryModulePath: c_mod.RawModule.Path,
+    }
+    var program, _, err = generator.CreateProgram(meta, gen_idx, data, closures, sch, serv)
+    if err != nil { return def.Program {}, nil, MergeErrors([] E { err }) }
+    return program, res, nil
+}
+
+func build(path string, output string) {
+    if output == "" {
+        var base = strings.TrimRight(path, `/\`)
+        output = (strings.TrimSuffix(base, filepath.Ext(base)) + bundle.FileExtension)
+    }
+    var mod, idx, res = load(path)
+    var c_mod, _, sch, serv = check(mod, idx)
+    var program = compile(c_mod, sch, serv)
+    var err = bundle.WriteFile(output, program, res)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "cannot write program image: %s\n", err)
+        os.Exit(7)
+    }
+}
+
+func service_describe(path string, service string, go_package string, output string) {
+    var mod, idx, _ = load(path)
+    var _, _, sch, serv = check(mod, idx)
+    var services = make([] rpc.ServiceInterface, 0)
+    for id, s := range serv {
+        if service == "" || service == rpc.DescribeServiceIdentifier(id) {
+            services = append(services, s)
         }
-        var meta = def.ProgramMetaData {
-            EntryModulePath: entry.RawModule.Path,
+    }
+    sort.Slice(services, func(i, j int) bool {
+        var a = rpc.DescribeServiceIdentifier(services[i].ServiceIdentifier)
+        var b = rpc.DescribeServiceIdentifier(services[j].ServiceIdentifier)
+        return a < b
+    })
+    if len(services) == 0 {
+        fmt.Fprintf(os.Stderr, "service-describe: no service found\n")
+        os.Exit(100)
+    }
+    var content ([] byte)
+    if go_package != "" {
+        if len(services) != 1 {
+            fmt.Fprintf(os.Stderr,
+                "service-describe: more than one service found, specify one by --service\n")
+            os.Exit(100)
         }
-        var program, _, err = generator.CreateProgram(meta, idx, data, closures, sch, serv)
+        var code, err = rpc.GenerateGoClient(services[0], sch, go_package)
         if err != nil {
-            fmt.Fprintf(os.Stderr, "%s\n", MergeErrors([] E { err }))
-            os.Exit(6)
+            fmt.Fprintf(os.Stderr, "service-describe: %s\n", err)
+            os.Exit(8)
         }
-        return program
+        content = code
+    } else {
+        var descriptions = make([] rpc.ServiceDescription, len(services))
+        for i, s := range services {
+            descriptions[i] = rpc.DescribeService(s, sch)
+        }
+        var bin, err = json.MarshalIndent(descriptions, "", "  ")
+        if err != nil { panic(err) }
+        content = append(bin, '\n')
     }
-    var dump_asm = func(program def.Program, file_path string) {
-        var f, err = os.OpenFile(file_path, os.O_WRONLY | os.O_TRUNC | os.O_CREATE, 0666)
+    if output == "" {
+        _, err := os.Stdout.Write(content)
+        if err != nil { panic(err) }
+    } else {
+        var err = ioutil.WriteFile(output, content, 0666)
         if err != nil {
-            fmt.Fprintf(os.Stderr, "cannot open asm dump file: %s", err)
-            os.Exit(99)
+            fmt.Fprintf(os.Stderr, "cannot write output: %s\n", err)
+            os.Exit(7)
         }
-        _, err = fmt.Fprint(f, program.String())
+    }
+}
+
+func run_tests(path string, filter string, timeout time.Duration, max_stack_size int) {
+    var pattern *regexp.Regexp
+    if filter != "" {
+        var err error
+        pattern, err = regexp.Compile(filter)
         if err != nil {
-            fmt.Fprintf(os.Stderr, "error writing to asm dump file: %s", err)
-            os.Exit(99)
+            fmt.Fprintf(os.Stderr, "test: invalid pattern: %s\n", err)
+            os.Exit(100)
         }
-        _ = f.Close()
     }
     var mod, idx, res = load(path)
-    var c_mod, _, sch, serv = check(mod, idx)
-    var program = compile(c_mod, sch, serv)
-    if asm_dump != "" {
-        dump_asm(program, asm_dump)
+    var c_mod, c_idx, sch, serv = check(mod, idx)
+    var program, locator = compile_locatable(c_mod, sch, serv)
+    var cases = tester.CollectCases(c_idx, locator, pattern)
+    if len(cases) == 0 {
+        fmt.Fprintf(os.Stderr, "test: no test cases found\n")
+        return
     }
-    vm.Execute(program, vm.Options {
+    // test cases are executed in separate processes running a program image
+    var exe, err = os.Executable()
+    if err != nil { panic(err) }
+    temp_dir, err := ioutil.TempDir("", "kumachan-test-")
+    if err != nil { panic(err) }
+    defer (func() { _ = os.RemoveAll(temp_dir) })()
+    var image = filepath.Join(temp_dir, ("test" + bundle.FileExtension))
+    err = bundle.WriteFile(image, program, res)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "cannot write program image: %s\n", err)
+        os.Exit(7)
+    }
+    var opts = tester.Options {
+        Executable:   exe,
+        Image:        image,
+        Timeout:      timeout,
+        MaxStackSize: uint(max_stack_size),
+    }
+    var failed = 0
+    for _, c := range cases {
+        var result = tester.Run(c, opts)
+        if result.Status != tester.Passed {
+            failed += 1
+        }
+        tester.Report(os.Stdout, result, timeout)
+    }
+    if failed > 0 {
+        fmt.Printf("FAIL (%d passed, %d failed)\n", (len(cases) - failed), failed)
+        _ = os.RemoveAll(temp_dir)
+        os.Exit(1)
+    } else {
+        fmt.Printf("PASS (%d passed)\n", len(cases))
+    }
+}
+
+func run_test_case(image string, address string, max_stack_size int) {
+    var addr, err = strconv.ParseUint(address, 10, 64)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "test: invalid test case: %s\n", strconv.Quote(address))
+        os.Exit(100)
+    }
+    program, res, err := bundle.ReadFile(image)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "cannot read program image: %s\n", err)
+        os.Exit(3)
+    }
+    var code = tester.Execute(program, uint(addr), vm.Options {
         Resources:    res,
         MaxStackSize: uint(max_stack_size),
         Environment:  os.Environ(),
-        Arguments:    args,
-        DebugOptions: debug_opts,
+        Arguments:    [] string { image },
         StdIO:        stdio,
-    }, nil)
+    }, os.Stderr)
+    os.Exit(code)
+}
+
+func format_sources(paths ([] string), check bool) {
+    if len(paths) == 0 || (len(paths) == 1 && paths[0] == "-") {
+        var code, err = ioutil.ReadAll(os.Stdin)
+        if err != nil { panic(err) }
+        formatted, err := formatter.Format([] rune(string(code)), "(stdin)")
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "%s\n", err)
+            os.Exit(2)
+        }
+        if check {
+            if formatted != string(code) {
+                fmt.Println("(stdin)")
+                os.Exit(1)
+            }
+            return
+        }
+        fmt.Print(formatted)
+        return
+    }
+    var files = make([] string, 0)
+    for _, path := range paths {
+        var err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+            if err != nil { return err }
+            if info.IsDir() {
+                if file != path && strings.HasPrefix(info.Name(), ".") {
+                    return filepath.SkipDir
+                }
+                return nil
+            }
+            if file == path || strings.HasSuffix(file, loader.SourceSuffix) {
+                files = append(files, file)
+            }
+            return nil
+        })
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "fmt: %s\n", err)
+            os.Exit(2)
+        }
+    }
+    var failed = false
+    var changed = false
+    for _, file := range files {
+        var content, err = ioutil.ReadFile(file)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "fmt: %s\n", err)
+            failed = true
+            continue
+        }
+        formatted, err := formatter.Format([] rune(string(content)), file)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "%s\n", err)
+            failed = true
+            continue
+        }
+        if formatted == string(content) {
+            continue
+        }
+        changed = true
+        if check {
+            fmt.Println(file)
+            continue
+        }
+        err = ioutil.WriteFile(file, [] byte(formatted), 0666)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "fmt: %s\n", err)
+            failed = true
+        }
+    }
+    if failed {
+        os.Exit(2)
+    }
+    if check && changed {
+        os.Exit(1)
+    }
 }
 
 func repl(args ([] string), max_stack_size int, debug_opts def.DebugOptions) {
@@ -305,14 +693,31 @@ func main() {
     var program_args = make([] string, 0)
     var mode = "interpreter"
     var asm_dump = ""
+    var output = ""
     var max_stack_size_string = "33554432"
     var debug_options_string = ""
+    var vm_version = "1"
+    var parallel_string = "off"
+    var check = false
+    var test_filter = ""
+    var test_timeout_string = "10s"
+    var test_case = ""
+    var service = ""
+    var go_package = ""
     var no_more_options = false
     var options = map[string] *string {
         "--mode=":           &mode,
         "--asm-dump=":       &asm_dump,
+        "--output=":         &output,
         "--max-stack-size=": &max_stack_size_string,
         "--debug=":          &debug_options_string,
+        "--vm=":             &vm_version,
+        "--parallel=":       &parallel_string,
+        "--run=":            &test_filter,
+        "--timeout=":        &test_timeout_string,
+        "--test-case=":      &test_case,  // used by test processes
+        "--service=":        &service,
+        "--go-package=":     &go_package,
     }
     var set_option = func(arg string) bool {
         for opt_prefix, val := range options {
@@ -331,14 +736,24 @@ func main() {
             fmt.Println("options:")
             fmt.Println("\t--help,-h\tshow help")
             fmt.Println("\t--version,-v\tshow version")
-            fmt.Println("\t--mode={interpreter,docs,parser-debug,atom-lang-server}")
+            fmt.Println("\t--mode={interpreter,build,debug,dap,test,fmt,docs,service-describe,parser-debug,lsp,atom-lang-server}")
             fmt.Println("\t--asm-dump=[FILE]")
+            fmt.Println("\t--output=[FILE]")
             fmt.Println("\t--max-stack-size=[NUMBER]")
             fmt.Println("\t--debug=[ui]")
+            fmt.Println("\t--vm={1,2}")
+            fmt.Println("\t--parallel={off,on}\t(vm2 only)")
+            fmt.Println("\t--check\tlist files that would change (fmt only)")
+            fmt.Println("\t--run=[REGEXP]\trun matching test cases only (test only)")
+            fmt.Println("\t--timeout=[DURATION]\ttimeout of each test case (test only)")
+            fmt.Println("\t--service=[VENDOR:PROJECT:NAME:VERSION]\tselect a service (service-describe only)")
+            fmt.Println("\t--go-package=[NAME]\tgenerate a Go client instead of JSON (service-describe only)")
             return
         } else if (arg == "--version" || arg == "-v") && !(no_more_options) {
             fmt.Println("KumaChan 0.0.0 pre-alpha debugging version")
             return
+        } else if arg == "--check" && !(no_more_options) {
+            check = true
         } else if strings.HasPrefix(arg, "--") && !(no_more_options) {
             if !(set_option(arg)) {
                 fmt.Fprintf(os.Stderr,
@@ -359,6 +774,31 @@ func main() {
             strconv.Quote(max_stack_size_string))
         os.Exit(100)
     }
+    if vm_version != "1" && vm_version != "2" {
+        fmt.Fprintf(os.Stderr,
+            "invalid vm: %s",
+            strconv.Quote(vm_version))
+        os.Exit(100)
+    }
+    var use_vm2 = (vm_version == "2")
+    if parallel_string != "off" && parallel_string != "on" {
+        fmt.Fprintf(os.Stderr,
+            "invalid parallel: %s",
+            strconv.Quote(parallel_string))
+        os.Exit(100)
+    }
+    var parallel = (parallel_string == "on")
+    if parallel && !(use_vm2) {
+        fmt.Fprintf(os.Stderr, "parallel execution is only available on vm2\n")
+        os.Exit(100)
+    }
+    test_timeout, err := time.ParseDuration(test_timeout_string)
+    if err != nil || test_timeout <= 0 {
+        fmt.Fprintf(os.Stderr,
+            "invalid timeout: %s\n",
+            strconv.Quote(test_timeout_string))
+        os.Exit(100)
+    }
     var debug_ui = (debug_options_string == "ui")
     var debug_opts = def.DebugOptions { DebugUI: debug_ui }
     if debug_ui {
@@ -388,7 +828,11 @@ func main() {
             }
             if got_path {
                 interpret(path, program_args,
-                    max_stack_size, asm_dump, debug_opts)
+                    max_stack_size, asm_dump, debug_opts, use_vm2, parallel)
+            } else if use_vm2 {
+                _, err = fmt.Fprintln(os.Stderr, "REPL is not available on vm2")
+                if err != nil { panic(err) }
+                os.Exit(100)
             } else {
                 _, err = fmt.Fprintln(os.Stderr, "Starting REPL...")
                 if err != nil { panic(err) }
@@ -398,6 +842,57 @@ func main() {
             qt.NotifyNotUsed()
         })()
         qt.Main()
+    case "debug":
+        if len(program_args) == 0 {
+            fmt.Fprintf(os.Stderr, "debug: source path not specified\n")
+            os.Exit(100)
+        }
+        if use_vm2 {
+            fmt.Fprintf(os.Stderr, "debug: the debugger is only available on vm1\n")
+            os.Exit(100)
+        }
+        go (func() {
+            debug(program_args[0], program_args, max_stack_size)
+            qt.NotifyNotUsed()
+        })()
+        qt.Main()
+    case "build":
+        if len(program_args) == 0 {
+            fmt.Fprintf(os.Stderr, "build: source path not specified\n")
+            os.Exit(100)
+        }
+        build(program_args[0], output)
+    case "dap":
+        if use_vm2 {
+            fmt.Fprintf(os.Stderr, "dap: the debugger is only available on vm1\n")
+            os.Exit(100)
+        }
+        go (func() {
+            dap(max_stack_size)
+            qt.NotifyNotUsed()
+        })()
+        qt.Main()
+    case "test":
+        if len(program_args) == 0 {
+            fmt.Fprintf(os.Stderr, "test: source path not specified\n")
+            os.Exit(100)
+        }
+        if test_case != "" {
+            go (func() {
+                run_test_case(program_args[0], test_case, max_stack_size)
+            })()
+            qt.Main()
+        } else {
+            run_tests(program_args[0], test_filter, test_timeout, max_stack_size)
+        }
+    case "fmt":
+        format_sources(program_args, check)
+    case "service-describe":
+        if len(program_args) == 0 {
+            fmt.Fprintf(os.Stderr, "service-describe: source path not specified\n")
+            os.Exit(100)
+        }
+        service_describe(program_args[0], service, go_package, output)
     case "parser-debug":
         var program_path string
         var program_file *os.File
@@ -414,6 +909,9 @@ func main() {
     case "atom-lang-server":
         err := atom.LangServer(os.Stdin, os.Stdout, os.Stderr)
         if err != nil { panic(err) }
+    case "lsp":
+        err := lsp.LangServer(os.Stdin, os.Stdout, os.Stderr)
+        if err != nil { panic(err) }
     case "docs":
         var mod_thunk = loader.CraftEmptyThunk(loader.Manifest {
             Vendor:  "",
@@ -0,0 +1,245 @@
+package rpc
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+	"bytes"
+	"strings"
+	"testing"
+	"encoding/json"
+	"kumachan/standalone/rx"
+	"kumachan/standalone/rpc/kmd"
+)
+
+
+type testSyncBuffer struct {
+	mutex  sync.Mutex
+	buf    bytes.Buffer
+}
+func (b *testSyncBuffer) Write(p ([] byte)) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+func (b *testSyncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+type testCallService struct {
+	Service
+	ticksStopped  chan struct{}
+	hangStopped   chan struct{}
+}
+
+func createTestCallService() testCallService {
+	var service = testService()
+	var ticks_stopped = make(chan struct{}, 1)
+	var hang_stopped = make(chan struct{}, 1)
+	var methods = make(map[string] ServiceMethod)
+	for name, method := range service.Methods {
+		methods[name] = method
+	}
+	methods["ticks"] = ServiceMethod {
+		ServiceMethodInterface: ServiceMethodInterface {
+			ArgType:    testInteger,
+			RetType:    testInteger,
+			MultiValue: true,
+		},
+		GetAction: func(_ kmd.Object, _ kmd.Object) rx.Observable {
+			return rx.NewGoroutine(func(sender rx.Sender) {
+				var ticker = time.NewTicker(5 * time.Millisecond)
+				defer ticker.Stop()
+				for i := 1; ; i += 1 {
+					select {
+					case <- ticker.C:
+						var n = json.Number(string(rune('0' + (i % 10))))
+						sender.Next(testTyped(n, testInteger))
+					case <- sender.Context().CancelSignal():
+						ticks_stopped <- struct{}{}
+						return
+					}
+				}
+			})
+		},
+	}
+	methods["hang"] = ServiceMethod {
+		ServiceMethodInterface: ServiceMethodInterface {
+			ArgType: testTypeFromName("Unit"),
+			RetType: testString,
+		},
+		GetAction: func(_ kmd.Object, _ kmd.Object) rx.Observable {
+			return rx.NewGoroutine(func(sender rx.Sender) {
+				<- sender.Context().CancelSignal()
+				hang_stopped <- struct{}{}
+			})
+		},
+	}
+	service.Methods = methods
+	return testCallService {
+		Service:      service,
+		ticksStopped: ticks_stopped,
+		hangStopped:  hang_stopped,
+	}
+}
+
+func startTestServer(t *testing.T, service Service, debug io.Writer) (string, func()) {
+	var l, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil { t.Fatal(err) }
+	var api = TransformerKmdApi { Transformer: kmd.CreateJsonTransformer(testSchema) }
+	var server = Server(service, &ServerOptions {
+		Listener:    l,
+		DebugOutput: debug,
+		KmdApi:      api,
+	})
+	rx.ScheduleBackground(server.Catch(func(_ rx.Object) rx.Observable {
+		return rx.Noop()
+	}), testScheduler)
+	return l.Addr().String(), func() {
+		_ = l.Close()
+	}
+}
+
+func testAccess(t *testing.T, addr string, service Service, consumer func(*ClientInstance) rx.Observable) {
+	var conn, err = net.Dial("tcp", addr)
+	if err != nil { t.Fatal(err) }
+	var config = map[string] interface{} { "name": "tester" }
+	var client = Client(testServiceInterface(service), &ClientOptions {
+		Connection:          conn,
+		ConstructorArgument: testTyped(config, testTypeFromName("Config")),
+		InstanceConsumer:    consumer,
+		KmdApi: TransformerKmdApi {
+			Transformer: kmd.CreateJsonTransformer(testSchema),
+		},
+	})
+	var terminated = make(chan bool, 1)
+	var e = make(chan rx.Object, 1)
+	rx.Schedule(client, testScheduler, rx.Receiver {
+		Context:   rx.Background(),
+		Error:     e,
+		Terminate: terminated,
+	})
+	select {
+	case ok := <- terminated:
+		if !(ok) { t.Fatal(<- e) }
+	case <- time.After(5 * time.Second):
+		t.Fatal("client timeout")
+	}
+}
+
+func waitSignal(t *testing.T, signal chan struct{}, desc string) {
+	select {
+	case <- signal:
+	case <- time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for %s", desc)
+	}
+}
+
+func TestCallPayload(t *testing.T) {
+	var payload = encodeCallPayload("greet", CallHeader {})
+	if string(payload) != "greet" {
+		t.Fatalf("unexpected payload: %q", payload)
+	}
+	payload = encodeCallPayload("greet", CallHeader {
+		Timeout:  100,
+		Metadata: map[string] string { "trace-id": "abc" },
+	})
+	var method, header, err = decodeCallPayload(payload)
+	if err != nil { t.Fatal(err) }
+	if method != "greet" || header.Timeout != 100 || header.Metadata["trace-id"] != "abc" {
+		t.Fatalf("unexpected decoded payload: %s %+v", method, header)
+	}
+	_, _, err = decodeCallPayload(([] byte)("greet\n{\"timeout\":-1}"))
+	if err == nil { t.Fatal("negative timeout not detected") }
+}
+
+func TestCallCancel(t *testing.T) {
+	var service = createTestCallService()
+	var addr, stop = startTestServer(t, service.Service, nil)
+	defer stop()
+	var ticks = make([] rx.Object, 0)
+	var greeting rx.Object
+	testAccess(t, addr, service.Service, func(instance *ClientInstance) rx.Observable {
+		var zero = testTyped(json.Number("0"), testInteger)
+		var arg = testTyped(map[string] interface{} { "greeting": "Hi" },
+			testTypeFromName("Greeting"))
+		return instance.Call("ticks", zero).Take(3).ConcatMap(func(v rx.Object) rx.Observable {
+			ticks = append(ticks, v)
+			return rx.Noop()
+		}).WaitComplete().Then(func(_ rx.Object) rx.Observable {
+			// late values of the cancelled call must not break the connection
+			return rx.Timer(50)
+		}).Then(func(_ rx.Object) rx.Observable {
+			return instance.Call("greet", arg)
+		}).Then(func(v rx.Object) rx.Observable {
+			greeting = v
+			return rx.Noop()
+		})
+	})
+	waitSignal(t, service.ticksStopped, "cancellation of ticks")
+	if len(ticks) != 3 {
+		t.Fatalf("unexpected values: %v", ticks)
+	}
+	if greeting != "Hi, tester!" {
+		t.Fatalf("unexpected greeting: %v", greeting)
+	}
+}
+
+func TestCallDeadline(t *testing.T) {
+	var service = createTestCallService()
+	var addr, stop = startTestServer(t, service.Service, nil)
+	defer stop()
+	var unit = testTyped(nil, testTypeFromName("Unit"))
+	var errs = make([] error, 0)
+	var catch = func(e rx.Object) rx.Observable {
+		errs = append(errs, e.(error))
+		return rx.Noop()
+	}
+	testAccess(t, addr, service.Service, func(instance *ClientInstance) rx.Observable {
+		var deadline = time.Now().Add(50 * time.Millisecond)
+		var passed = time.Now().Add(-(time.Second))
+		return instance.CallWithOptions("hang", unit, CallOptions {
+			Deadline: deadline,
+		}).Catch(catch).WaitComplete().Then(func(_ rx.Object) rx.Observable {
+			return instance.CallWithOptions("hang", unit, CallOptions {
+				Deadline: passed,
+			}).Catch(catch).WaitComplete()
+		})
+	})
+	waitSignal(t, service.hangStopped, "cancellation of hang")
+	if len(errs) != 2 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	for _, e := range errs {
+		if !(IsDeadlineExceeded(e)) {
+			t.Fatalf("unexpected error: %s", e)
+		}
+	}
+}
+
+func TestCallMetadata(t *testing.T) {
+	var service = createTestCallService()
+	var debug = new(testSyncBuffer)
+	var addr, stop = startTestServer(t, service.Service, debug)
+	defer stop()
+	var unit = testTyped(nil, testTypeFromName("Unit"))
+	var failed = false
+	testAccess(t, addr, service.Service, func(instance *ClientInstance) rx.Observable {
+		return instance.CallWithOptions("fail", unit, CallOptions {
+			Metadata: map[string] string { "trace-id": "abc" },
+		}).Catch(func(_ rx.Object) rx.Observable {
+			failed = true
+			return rx.Noop()
+		}).WaitComplete()
+	})
+	if !(failed) {
+		t.Fatal("error not received")
+	}
+	var log = debug.String()
+	if !(strings.Contains(log, `call 0 (fail) trace-id="abc": Error: failed`)) {
+		t.Fatalf("unexpected log: %s", log)
+	}
+}
@@ -16,6 +16,7 @@ type ClientOptions struct {
 	DebugOutput          io.Writer
 	ConstructorArgument  kmd.Object
 	InstanceConsumer     func(*ClientInstance) rx.Observable
+	Encoding             kmd.Encoding  // zero value: text encoding
 	Limits
 	KmdApi
 }
@@ -32,7 +33,7 @@ func Client(service ServiceInterface, opts *ClientOptions) rx.Observable {
 			conn.Fatal(err)
 			return struct{}{}
 		}
-		err := sendServiceConfirmation(conn, service)
+		err := sendServiceConfirmation(conn, service, opts)
 		if err != nil { return fatal(err) }
 		err = sendConstructorArgument(conn, service, opts)
 		if err != nil { return fatal(err) }
@@ -41,6 +42,7 @@ func Client(service ServiceInterface, opts *ClientOptions) rx.Observable {
 		var instance = createClientInstance(conn, logger, service, opts)
 		consumeClientInstance(instance, conn, opts)
 		err = clientProcessMessages(instance, conn, opts)
+		instance.abort(err)
 		if err != nil { return fatal(err) }
 		return struct{}{}
 	}
@@ -69,10 +71,20 @@ type ClientInstanceState struct {
 	mutator     *rx.Worker
 	calls       map[uint64] Call
 	nextCallId  uint64
+	lost        error  // the connection is lost if not nil
 }
 type Call struct {
-	sender   rx.Sender
-	retType  *kmd.Type
+	sender     rx.Sender
+	retType    *kmd.Type
+	cancelled  bool  // waiting for the terminal message
+}
+var ErrConnectionLost = errors.New("connection lost")
+func IsConnectionLost(e error) bool {
+	return errors.Is(e, ErrConnectionLost)
+}
+type CallOptions struct {
+	Deadline  time.Time  // no deadline if zero value
+	Metadata  map[string] string
 }
 func createClientInstance(conn *rx.WrappedConnection, logger ClientLogger, service ServiceInterface, opts *ClientOptions) *ClientInstance {
 	return &ClientInstance {
@@ -89,16 +101,38 @@ func createClientInstance(conn *rx.WrappedConnection, logger ClientLogger, servi
 	}
 }
 func (instance *ClientInstance) Call(method_name string, arg kmd.Object) rx.Observable {
+	return instance.CallWithOptions(method_name, arg, CallOptions {})
+}
+// CallWithOptions calls a method with a deadline and/or metadata.
+// If the subscription is cancelled before the call finishes,
+// the server is notified to stop the call.
+func (instance *ClientInstance) CallWithOptions(method_name string, arg kmd.Object, opts CallOptions) rx.Observable {
 	var method, exists = instance.service.Methods[method_name]
 	if !(exists) { panic("something went wrong") }
 	return rx.NewSyncWithSender(func(sender rx.Sender) {
+		var header = CallHeader { Metadata: opts.Metadata }
+		if !(opts.Deadline.IsZero()) {
+			var timeout = time.Until(opts.Deadline)
+			if timeout <= 0 {
+				sender.Error(deadlineExceededError())
+				return
+			}
+			header.Timeout = int64((timeout + time.Millisecond - 1) / time.Millisecond)
+		}
 		instance.state.mutator.Do(func() {
+			if instance.state.lost != nil {
+				sender.Error(instance.state.lost)
+				return
+			}
 			var id = instance.state.nextCallId
 			instance.state.nextCallId += 1
 			instance.state.calls[id] = Call {
 				sender:  sender,
 				retType: method.RetType,
 			}
+			go sender.Context().WaitDispose(func() {
+				instance.cancel(id)
+			})
 			var send_request = func() struct{} {
 				var conn = instance.connection
 				var fatal = func(err error) struct{} {
@@ -107,7 +141,7 @@ func (instance *ClientInstance) Call(method_name string, arg kmd.Object) rx.Obse
 					instance.logger.LogError(wrapped)
 					return struct{}{}
 				}
-				var method_name_bin = ([] byte)(method_name)
+				var payload = encodeCallPayload(method_name, header)
 				var msg_kind = (func() string {
 					if method.MultiValue {
 						return MSG_CALL_MULTI
@@ -115,7 +149,7 @@ func (instance *ClientInstance) Call(method_name string, arg kmd.Object) rx.Obse
 						return MSG_CALL
 					}
 				})()
-				err := sendMessage(msg_kind, id, method_name_bin, conn)
+				err := sendMessage(msg_kind, id, payload, conn)
 				if err != nil { return fatal(err) }
 				err = sendCallArgument(arg, method, conn, instance.options)
 				if err != nil { return fatal(err) }
@@ -127,6 +161,36 @@ func (instance *ClientInstance) Call(method_name string, arg kmd.Object) rx.Obse
 		})
 	})
 }
+func (instance *ClientInstance) cancel(id uint64) {
+	instance.state.mutator.Do(func() {
+		var call, exists = instance.state.calls[id]
+		if !(exists) || call.cancelled { return }
+		call.cancelled = true
+		instance.state.calls[id] = call
+		instance.requester.Do(func() {
+			var conn = instance.connection
+			err := sendMessage(MSG_CANCEL, id, ([] byte {}), conn)
+			if err != nil {
+				var wrapped = fmt.Errorf("error sending cancel request: %w", err)
+				conn.Fatal(wrapped)
+				instance.logger.LogError(wrapped)
+			}
+		})
+	})
+}
+// abort fails all calls in progress, as well as calls made later,
+// when the connection is lost.
+func (instance *ClientInstance) abort(err error) {
+	var lost = fmt.Errorf("%w: %s", ErrConnectionLost, err.Error())
+	instance.state.mutator.Do(func() {
+		instance.state.lost = lost
+		for id, call := range instance.state.calls {
+			delete(instance.state.calls, id)
+			if call.cancelled { continue }
+			call.sender.Error(lost)
+		}
+	})
+}
 func (instance *ClientInstance) lookupCall(id uint64) (Call, bool) {
 	var call, exists = instance.state.calls[id]
 	if !(exists) {
@@ -150,7 +214,7 @@ func (instance *ClientInstance) getCallReturnValueType(id uint64) *kmd.Type {
 func (instance *ClientInstance) next(id uint64, value kmd.Object) {
 	instance.state.mutator.Do(func() {
 		var call, ok = instance.lookupCall(id)
-		if !(ok) { return }
+		if !(ok) || call.cancelled { return }
 		call.sender.Next(value)
 	})
 }
@@ -158,6 +222,8 @@ func (instance *ClientInstance) error(id uint64, e error) {
 	instance.state.mutator.Do(func() {
 		var call, ok = instance.lookupCall(id)
 		if !(ok) { return }
+		delete(instance.state.calls, id)
+		if call.cancelled { return }
 		call.sender.Error(e)
 	})
 }
@@ -166,19 +232,21 @@ func (instance *ClientInstance) complete(id uint64) {
 		var call, ok = instance.lookupCall(id)
 		if !(ok) { return }
 		delete(instance.state.calls, id)
+		if call.cancelled { return }
 		call.sender.Complete()
 	})
 }
 
-func sendServiceConfirmation(conn io.Writer, service ServiceInterface) error {
+func sendServiceConfirmation(conn io.Writer, service ServiceInterface, opts *ClientOptions) error {
 	var service_id = DescribeServiceIdentifier(service.ServiceIdentifier)
-	return sendMessage(MSG_SERVICE, ^uint64(0), ([] byte)(service_id), conn)
+	var payload = encodeServicePayload(service_id, opts.Encoding)
+	return sendMessage(MSG_SERVICE, ^uint64(0), payload, conn)
 }
 
 func sendConstructorArgument(conn io.Writer, service ServiceInterface, opts *ClientOptions) error {
 	var ctor = service.Constructor
 	var arg = opts.ConstructorArgument
-	return sendObject(arg, ctor.ArgType, conn, opts.KmdApi)
+	return sendObject(arg, ctor.ArgType, conn, opts.KmdApi, opts.Encoding)
 }
 
 func receiveInstanceCreated(conn io.Reader) error {
@@ -199,7 +267,9 @@ func receiveInstanceCreated(conn io.Reader) error {
 func consumeClientInstance(instance *ClientInstance, conn *rx.WrappedConnection, opts *ClientOptions) {
 	var consume = opts.InstanceConsumer(instance)
 	var consume_and_dispose = consume.WaitComplete().Then(func(_ rx.Object) rx.Observable {
-		_ = conn.Close()
+		// closing the connection waits for the scheduler,
+		// which cannot be done on the scheduler itself
+		go (func() { _ = conn.Close() })()
 		return rx.Noop()
 	})
 	rx.Schedule(consume_and_dispose, conn.Scheduler(), rx.Receiver {
@@ -208,7 +278,7 @@ func consumeClientInstance(instance *ClientInstance, conn *rx.WrappedConnection,
 }
 
 func sendCallArgument(arg kmd.Object, method ServiceMethodInterface, conn *rx.WrappedConnection, opts *ClientOptions) error {
-	return sendObject(arg, method.ArgType, conn, opts.KmdApi)
+	return sendObject(arg, method.ArgType, conn, opts.KmdApi, opts.Encoding)
 }
 
 func clientProcessMessages(instance *ClientInstance, conn *rx.WrappedConnection, opts *ClientOptions) error {
@@ -223,7 +293,7 @@ func clientProcessMessages(instance *ClientInstance, conn *rx.WrappedConnection,
 		case MSG_VALUE:
 			var ret_type = instance.getCallReturnValueType(id)
 			var limit = opts.RecvMaxObjectSize
-			value, err := receiveObject(ret_type, conn, limit, opts.KmdApi)
+			value, err := receiveObject(ret_type, conn, limit, opts.KmdApi, opts.Encoding)
 			if err != nil { return fmt.Errorf("error receiving value object: %w", err) }
 			instance.next(id, value)
 		case MSG_ERROR:
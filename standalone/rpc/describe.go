@@ -0,0 +1,192 @@
+package rpc
+
+import (
+	"sort"
+	"kumachan/standalone/rpc/kmd"
+)
+
+
+/**
+ *  Service Description
+ *
+ *  A machine-readable description of a service interface, which can be
+ *  serialized as JSON by encoding/json. All types are written in the
+ *  notation of KMD (e.g. "[] {} vendor.project.Name v1") and can be
+ *  parsed back by kmd.TypeParse(). The schemas of all record, tuple and
+ *  enum types referenced by the service (directly or transitively) are
+ *  included, keyed by their type identifiers (e.g. "vendor.project.Name v1").
+ */
+
+type ServiceDescription struct {
+	Identifier   string                               `json:"identifier"`
+	Vendor       string                               `json:"vendor"`
+	Project      string                               `json:"project"`
+	Name         string                               `json:"name"`
+	Version      string                               `json:"version"`
+	Constructor  ServiceConstructorDescription        `json:"constructor"`
+	Methods      map[string] ServiceMethodDescription  `json:"methods"`
+	Types        map[string] TypeDescription           `json:"types"`
+}
+type ServiceConstructorDescription struct {
+	Argument  string  `json:"argument"`
+}
+type ServiceMethodDescription struct {
+	Argument    string  `json:"argument"`
+	Return      string  `json:"return"`
+	MultiValue  bool    `json:"multi-value"`
+}
+type TypeDescription struct {
+	Kind          string                `json:"kind"`
+	Vendor        string                `json:"vendor"`
+	Project       string                `json:"project"`
+	Name          string                `json:"name"`
+	Version       string                `json:"version"`
+	Fields        [] FieldDescription   `json:"fields,omitempty"`
+	AllowUnknown  bool                  `json:"allow-unknown,omitempty"`
+	Elements      [] string             `json:"elements,omitempty"`
+	Cases         [] string             `json:"cases,omitempty"`
+}
+type FieldDescription struct {
+	Name      string  `json:"name"`
+	Type      string  `json:"type"`
+	Optional  bool    `json:"optional,omitempty"`
+}
+const (
+	TypeKindRecord = "record"
+	TypeKindTuple  = "tuple"
+	TypeKindEnum   = "enum"
+)
+
+func DescribeService(service ServiceInterface, sch kmd.SchemaTable) ServiceDescription {
+	var methods = make(map[string] ServiceMethodDescription)
+	for name, method := range service.Methods {
+		methods[name] = ServiceMethodDescription {
+			Argument:   method.ArgType.String(),
+			Return:     method.RetType.String(),
+			MultiValue: method.MultiValue,
+		}
+	}
+	var types = make(map[string] TypeDescription)
+	for _, id := range collectServiceTypes(service, sch) {
+		types[id.String()] = describeType(id, sch)
+	}
+	var sid = service.ServiceIdentifier
+	return ServiceDescription {
+		Identifier:  DescribeServiceIdentifier(sid),
+		Vendor:      sid.Vendor,
+		Project:     sid.Project,
+		Name:        sid.Name,
+		Version:     sid.Version,
+		Constructor: ServiceConstructorDescription {
+			Argument: service.Constructor.ArgType.String(),
+		},
+		Methods:     methods,
+		Types:       types,
+	}
+}
+
+func describeType(id kmd.TypeId, sch kmd.SchemaTable) TypeDescription {
+	var desc = TypeDescription {
+		Vendor:  id.Vendor,
+		Project: id.Project,
+		Name:    id.Name,
+		Version: id.Version,
+	}
+	switch S := sch[id].(type) {
+	case kmd.RecordSchema:
+		desc.Kind = TypeKindRecord
+		for _, name := range sortedRecordFields(S) {
+			desc.Fields = append(desc.Fields, FieldDescription {
+				Name:     name,
+				Type:     S.Fields[name].Type.String(),
+				Optional: S.Fields[name].Optional,
+			})
+		}
+		desc.AllowUnknown = S.AllowUnknown
+	case kmd.TupleSchema:
+		desc.Kind = TypeKindTuple
+		for _, el := range S.Elements {
+			desc.Elements = append(desc.Elements, el.String())
+		}
+	case kmd.EnumSchema:
+		desc.Kind = TypeKindEnum
+		for _, case_id := range sortedEnumCases(S) {
+			desc.Cases = append(desc.Cases, sch.GetTypeFromId(case_id).String())
+		}
+	default:
+		panic("something went wrong")
+	}
+	return desc
+}
+
+// collectServiceTypes returns the identifiers of all algebraic types
+// referenced by the service, sorted by their string representations.
+func collectServiceTypes(service ServiceInterface, sch kmd.SchemaTable) ([] kmd.TypeId) {
+	var visited = make(map[kmd.TypeId] bool)
+	var ids = make([] kmd.TypeId, 0)
+	var visit func(t *kmd.Type)
+	var visit_id = func(id kmd.TypeId) {
+		if visited[id] { return }
+		visited[id] = true
+		ids = append(ids, id)
+		switch S := sch[id].(type) {
+		case kmd.RecordSchema:
+			for _, name := range sortedRecordFields(S) {
+				visit(S.Fields[name].Type)
+			}
+		case kmd.TupleSchema:
+			for _, el := range S.Elements {
+				visit(el)
+			}
+		case kmd.EnumSchema:
+			for _, case_id := range sortedEnumCases(S) {
+				visit(sch.GetTypeFromId(case_id))
+			}
+		default:
+			panic("something went wrong")
+		}
+	}
+	visit = func(t *kmd.Type) {
+		switch t.Kind() {
+		case kmd.Array, kmd.Optional:
+			visit(t.ElementType())
+		case kmd.Record, kmd.Tuple, kmd.Enum:
+			visit_id(t.Identifier())
+		}
+	}
+	visit(service.Constructor.ArgType)
+	for _, name := range sortedMethodNames(service) {
+		var method = service.Methods[name]
+		visit(method.ArgType)
+		visit(method.RetType)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].String() < ids[j].String()
+	})
+	return ids
+}
+
+func sortedRecordFields(S kmd.RecordSchema) ([] string) {
+	var names = make([] string, len(S.Fields))
+	for name, field := range S.Fields {
+		names[field.Index] = name
+	}
+	return names
+}
+
+func sortedEnumCases(S kmd.EnumSchema) ([] kmd.TypeId) {
+	var cases = make([] kmd.TypeId, len(S.CaseIndexMap))
+	for id, index := range S.CaseIndexMap {
+		cases[index] = id
+	}
+	return cases
+}
+
+func sortedMethodNames(service ServiceInterface) ([] string) {
+	var names = make([] string, 0, len(service.Methods))
+	for name, _ := range service.Methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
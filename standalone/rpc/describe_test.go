@@ -0,0 +1,163 @@
+package rpc
+
+import (
+	"strings"
+	"testing"
+	"go/parser"
+	"go/token"
+	"encoding/json"
+	"kumachan/standalone/rpc/kmd"
+)
+
+
+func stubTestTypeId(name string) kmd.TypeId {
+	return kmd.TheTypeId("test.stub", "rpc", name, "v1")
+}
+func stubTestType(kind kmd.TypeKind, name string) *kmd.Type {
+	return kmd.AlgebraicType(kind, stubTestTypeId(name))
+}
+
+var stubTestSchema = kmd.SchemaTable {
+	stubTestTypeId("Config"): kmd.RecordSchema { Fields: map[string] kmd.RecordField {
+		"user-name": { Type: testString, Index: 0 },
+	} },
+	stubTestTypeId("Greeting"): kmd.RecordSchema { Fields: map[string] kmd.RecordField {
+		"greeting": { Type: testString, Index: 0 },
+		"times":    { Type: kmd.ContainerType(kmd.Optional, stubTestType(kmd.Record, "Count")), Index: 1 },
+		"tags":     { Type: kmd.ContainerType(kmd.Array, testString), Index: 2, Optional: true },
+	}, AllowUnknown: true },
+	stubTestTypeId("Count"): kmd.RecordSchema { Fields: map[string] kmd.RecordField {
+		"n": { Type: testInteger, Index: 0 },
+	} },
+	stubTestTypeId("Pair"): kmd.TupleSchema { Elements: [] *kmd.Type {
+		testString, testInteger,
+	} },
+	stubTestTypeId("Shape"): kmd.EnumSchema { CaseIndexMap: map[kmd.TypeId] uint {
+		stubTestTypeId("Circle"): 0,
+		stubTestTypeId("Square"): 1,
+	} },
+	stubTestTypeId("Circle"): kmd.RecordSchema { Fields: map[string] kmd.RecordField {
+		"radius": { Type: testFloat, Index: 0 },
+	} },
+	stubTestTypeId("Square"): kmd.RecordSchema { Fields: map[string] kmd.RecordField {
+		"side": { Type: testFloat, Index: 0 },
+	} },
+	stubTestTypeId("Unused"): kmd.TupleSchema {},
+}
+
+func stubTestService() ServiceInterface {
+	return ServiceInterface {
+		ServiceIdentifier: ServiceIdentifier {
+			Vendor:  "test.stub",
+			Project: "rpc",
+			Name:    "Greeter",
+			Version: "v1",
+		},
+		Constructor: ServiceConstructorInterface {
+			ArgType: stubTestType(kmd.Record, "Config"),
+		},
+		Methods: map[string] ServiceMethodInterface {
+			"greet": {
+				ArgType: stubTestType(kmd.Record, "Greeting"),
+				RetType: testString,
+			},
+			"pairs": {
+				ArgType:    testInteger,
+				RetType:    stubTestType(kmd.Tuple, "Pair"),
+				MultiValue: true,
+			},
+			"pick-shape": {
+				ArgType: kmd.ContainerType(kmd.Optional, stubTestType(kmd.Enum, "Shape")),
+				RetType: stubTestType(kmd.Enum, "Shape"),
+			},
+		},
+	}
+}
+
+func TestDescribeService(t *testing.T) {
+	var desc = DescribeService(stubTestService(), stubTestSchema)
+	var bin, err = json.Marshal(desc)
+	if err != nil { t.Fatal(err) }
+	var decoded ServiceDescription
+	err = json.Unmarshal(bin, &decoded)
+	if err != nil { t.Fatal(err) }
+	if decoded.Identifier != "test.stub:rpc:Greeter:v1" {
+		t.Fatalf("unexpected identifier: %s", decoded.Identifier)
+	}
+	if decoded.Constructor.Argument != "{} test.stub.rpc.Config v1" {
+		t.Fatalf("unexpected constructor argument: %s", decoded.Constructor.Argument)
+	}
+	var pairs = decoded.Methods["pairs"]
+	if pairs.Argument != "integer" || pairs.Return != "() test.stub.rpc.Pair v1" || !(pairs.MultiValue) {
+		t.Fatalf("unexpected method description: %+v", pairs)
+	}
+	var expected_types = [] string {
+		"test.stub.rpc.Circle v1", "test.stub.rpc.Config v1", "test.stub.rpc.Count v1",
+		"test.stub.rpc.Greeting v1", "test.stub.rpc.Pair v1", "test.stub.rpc.Shape v1",
+		"test.stub.rpc.Square v1",
+	}
+	if len(decoded.Types) != len(expected_types) {
+		t.Fatalf("unexpected types: %+v", decoded.Types)
+	}
+	for _, id := range expected_types {
+		var _, exists = decoded.Types[id]
+		if !(exists) { t.Fatalf("type %s not described", id) }
+	}
+	var greeting = decoded.Types["test.stub.rpc.Greeting v1"]
+	if greeting.Kind != TypeKindRecord || len(greeting.Fields) != 3 ||
+		greeting.Fields[1] != (FieldDescription { "times", "? {} test.stub.rpc.Count v1", false }) ||
+		greeting.Fields[2] != (FieldDescription { "tags", "[] string", true }) ||
+		!(greeting.AllowUnknown) {
+		t.Fatalf("unexpected record description: %+v", greeting)
+	}
+	var pair = decoded.Types["test.stub.rpc.Pair v1"]
+	if pair.Kind != TypeKindTuple || len(pair.Elements) != 2 || pair.Elements[1] != "integer" {
+		t.Fatalf("unexpected tuple description: %+v", pair)
+	}
+	var shape = decoded.Types["test.stub.rpc.Shape v1"]
+	if shape.Kind != TypeKindEnum || len(shape.Cases) != 2 ||
+		shape.Cases[0] != "{} test.stub.rpc.Circle v1" {
+		t.Fatalf("unexpected enum description: %+v", shape)
+	}
+	for _, text := range append(shape.Cases, greeting.Fields[1].Type, pairs.Return) {
+		var _, ok = kmd.TypeParse(text)
+		if !(ok) { t.Fatalf("type %s cannot be parsed", text) }
+	}
+}
+
+func TestGenerateGoClient(t *testing.T) {
+	var code, err = GenerateGoClient(stubTestService(), stubTestSchema, "greeter")
+	if err != nil { t.Fatal(err) }
+	_, err = parser.ParseFile(token.NewFileSet(), "greeter.go", code, 0)
+	if err != nil { t.Fatal(err) }
+	var source = string(code)
+	for _, expected := range [] string {
+		"package greeter",
+		"UserName string `kmd:\"user-name\"`",
+		"Times    MaybeCount `kmd:\"times\"`",
+		"`kmd:\"tags\" kmd_default:\"\"`",
+		"_        struct{}   `kmd_allow_unknown:\"\"`",
+		"func (Count) Maybe(Count, MaybeCount) {}",
+		"E1 *big.Int",
+		"func (Circle) KmdEnumShape()",
+		"func (Circle) Maybe(Shape, MaybeShape) {}",
+		"func (c Client) PickShape(arg MaybeShape) rx.Observable",
+		"func NewClient(conn net.Conn, arg Config,",
+	} {
+		if !(strings.Contains(source, expected)) {
+			t.Fatalf("%q not found in generated code:\n%s", expected, source)
+		}
+	}
+	if strings.Contains(source, "Unused") {
+		t.Fatalf("unreferenced type generated:\n%s", source)
+	}
+	var unsupported = stubTestService()
+	unsupported.Methods["maybe-integer"] = ServiceMethodInterface {
+		ArgType: kmd.ContainerType(kmd.Optional, testInteger),
+		RetType: testString,
+	}
+	_, err = GenerateGoClient(unsupported, stubTestSchema, "greeter")
+	if err == nil { t.Fatal("unsupported optional type not detected") }
+	_, err = GenerateGoClient(stubTestService(), stubTestSchema, "func")
+	if err == nil { t.Fatal("invalid package name not detected") }
+}
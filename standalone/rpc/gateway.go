@@ -0,0 +1,469 @@
+package rpc
+
+import (
+	"io"
+	"fmt"
+	"net"
+	"sync"
+	"bytes"
+	"errors"
+	"strings"
+	"net/http"
+	"encoding/json"
+	"kumachan/standalone/rx"
+	"kumachan/standalone/rpc/kmd"
+)
+
+
+/**
+ *  HTTP/WebSocket Gateway
+ *
+ *  The gateway exposes a service to clients that cannot speak the
+ *  native protocol (e.g. browsers). It forwards requests to a server
+ *  of the service, and objects are converted between KMD and JSON
+ *  according to the schema table (see kmd/json.go).
+ *  Each gateway session owns a connection to the server, which
+ *  corresponds to a service instance.
+ *
+ *  POST /call/<method>
+ *    The request body is { "constructor": ARG, "argument": ARG }.
+ *    A service instance is created for the request, and the value
+ *    returned by the method is responded as { "value": VALUE }.
+ *    Multi-value methods are not available in this way.
+ *  GET /ws
+ *    A WebSocket connection, where each message is a JSON object.
+ *    The first message from the client must be
+ *      { "kind": "create", "argument": ARG },
+ *    which is answered by { "kind": "created" } or an error event.
+ *    After that, methods are called by
+ *      { "kind": "call" (or "call*"), "id": ID, "method": NAME,
+ *        "argument": ARG },
+ *    and the gateway answers with the following events,
+ *    in the same way as the native protocol:
+ *      { "kind": "value", "id": ID, "value": VALUE }
+ *      { "kind": "error", "id": ID, "error": ERROR }
+ *      { "kind": "complete", "id": ID }
+ *
+ *  Errors are represented as { "desc": STRING, "data": { ... } },
+ *  where data is the extra data of an ErrorWithExtraData,
+ *  or an empty object for other errors.
+ */
+
+const MSG_CREATE = "create"
+
+type GatewayOptions struct {
+	Backend      func() (net.Conn, error)  // connects to a server
+	SchemaTable  kmd.SchemaTable
+	Scheduler    rx.Scheduler  // nil: a new event loop is spawned
+	DebugOutput  io.Writer
+	Limits
+}
+
+type gateway struct {
+	service  ServiceInterface
+	options  *GatewayOptions
+	api      KmdApi
+	sched    rx.Scheduler
+}
+type gatewayRequest struct {
+	Kind      string           `json:"kind"`
+	Id        uint64           `json:"id"`
+	Method    string           `json:"method"`
+	Argument  json.RawMessage  `json:"argument"`
+}
+type gatewayEvent struct {
+	Kind   string               `json:"kind"`
+	Id     *uint64              `json:"id,omitempty"`
+	Value  json.RawMessage      `json:"value,omitempty"`
+	Error  *ErrorWithExtraData  `json:"error,omitempty"`
+}
+type gatewayCallRequest struct {
+	Constructor  json.RawMessage  `json:"constructor"`
+	Argument     json.RawMessage  `json:"argument"`
+}
+type gatewayCallResponse struct {
+	Value  json.RawMessage      `json:"value,omitempty"`
+	Error  *ErrorWithExtraData  `json:"error,omitempty"`
+}
+
+func Gateway(service ServiceInterface, opts *GatewayOptions) http.Handler {
+	var sched = opts.Scheduler
+	if sched == nil {
+		sched = rx.TrivialScheduler { EventLoop: rx.SpawnEventLoop() }
+	}
+	return &gateway {
+		service: service,
+		options: opts,
+		api:     TransformerKmdApi {
+			Transformer: kmd.CreateJsonTransformer(opts.SchemaTable),
+		},
+		sched:   sched,
+	}
+}
+
+func (gw *gateway) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var logger = GatewayLogger {
+		RemoteAddr: req.RemoteAddr,
+		Output:     gw.options.DebugOutput,
+	}
+	var path = req.URL.Path
+	if path == "/ws" {
+		gw.serveWebSocket(w, req, logger)
+	} else if strings.HasPrefix(path, "/call/") {
+		gw.serveCall(w, req, strings.TrimPrefix(path, "/call/"), logger)
+	} else {
+		http.NotFound(w, req)
+	}
+}
+
+func (gw *gateway) serveCall(w http.ResponseWriter, req *http.Request, method_name string, logger GatewayLogger) {
+	var respond = func(status int, value kmd.Object, e error) {
+		var res gatewayCallResponse
+		if e != nil {
+			res.Error = gatewayError(e)
+		} else {
+			var bin, err = json.Marshal(value)
+			if err != nil { panic(err) }
+			res.Value = bin
+		}
+		var bin, err = json.Marshal(res)
+		if err != nil { panic(err) }
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write(bin)
+	}
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		respond(http.StatusMethodNotAllowed, nil,
+			errors.New("method not allowed"))
+		return
+	}
+	var method, exists = gw.service.Methods[method_name]
+	if !(exists) {
+		respond(http.StatusNotFound, nil, errors.New(fmt.Sprintf(
+			"method '%s' does not exist", method_name)))
+		return
+	}
+	if method.MultiValue {
+		respond(http.StatusBadRequest, nil, errors.New(fmt.Sprintf(
+			"method '%s' returns multiple values (use websocket instead)",
+			method_name)))
+		return
+	}
+	var body = req.Body
+	if gw.options.RecvMaxObjectSize != 0 {
+		var limit = int64(gw.options.RecvMaxObjectSize)
+		body = http.MaxBytesReader(w, req.Body, limit)
+	}
+	var call_req gatewayCallRequest
+	var err = json.NewDecoder(body).Decode(&call_req)
+	if err != nil {
+		respond(http.StatusBadRequest, nil,
+			fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	ctor_arg, err := gw.decodeArgument(call_req.Constructor, gw.service.Constructor.ArgType)
+	if err != nil {
+		respond(http.StatusBadRequest, nil,
+			fmt.Errorf("invalid constructor argument: %w", err))
+		return
+	}
+	arg, err := gw.decodeArgument(call_req.Argument, method.ArgType)
+	if err != nil {
+		respond(http.StatusBadRequest, nil,
+			fmt.Errorf("invalid method argument: %w", err))
+		return
+	}
+	conn, err := gw.options.Backend()
+	if err != nil {
+		logger.LogError(err)
+		respond(http.StatusBadGateway, nil,
+			errors.New("service unavailable"))
+		return
+	}
+	session, err := gw.openSession(conn, ctor_arg)
+	if err != nil {
+		respond(http.StatusInternalServerError, nil, err)
+		return
+	}
+	defer session.close()
+	var value kmd.Object
+	var failure error
+	var finished = make(chan struct{})
+	session.call(method_name, arg, gatewayCallHandler {
+		Value: func(v kmd.Object) error {
+			value = v
+			return nil
+		},
+		Error: func(e error) error {
+			failure = e
+			return nil
+		},
+		Complete: func() error {
+			close(finished)
+			return nil
+		},
+	}, logger)
+	select {
+	case <- finished:
+		if failure != nil {
+			respond(http.StatusInternalServerError, nil, failure)
+		} else {
+			respond(http.StatusOK, value, nil)
+		}
+	case <- session.terminated:
+		respond(http.StatusBadGateway, nil,
+			errors.New("connection to the service closed unexpectedly"))
+	case <- req.Context().Done():
+	}
+}
+
+func (gw *gateway) serveWebSocket(w http.ResponseWriter, req *http.Request, logger GatewayLogger) {
+	var limit = gw.options.RecvMaxObjectSize
+	ws, err := UpgradeWebSocket(w, req, limit)
+	if err != nil {
+		logger.LogError(err)
+		return
+	}
+	var send = func(ev gatewayEvent) error {
+		var bin, err = json.Marshal(ev)
+		if err != nil { panic(err) }
+		return ws.WriteText(bin)
+	}
+	var fatal = func(code uint16, e error) {
+		logger.LogError(e)
+		_ = send(gatewayEvent { Kind: MSG_ERROR, Error: gatewayError(e) })
+		_ = ws.Close(code, "")
+	}
+	var ctor_msg, read_err = ws.ReadMessage()
+	if read_err != nil {
+		logger.LogError(read_err)
+		_ = ws.Close(WsCloseNormal, "")
+		return
+	}
+	var ctor_req gatewayRequest
+	err = json.Unmarshal(ctor_msg, &ctor_req)
+	if err != nil || ctor_req.Kind != MSG_CREATE {
+		fatal(WsCloseProtocolError, errors.New("invalid creation message"))
+		return
+	}
+	ctor_arg, err := gw.decodeArgument(ctor_req.Argument, gw.service.Constructor.ArgType)
+	if err != nil {
+		fatal(WsCloseNormal, fmt.Errorf("invalid constructor argument: %w", err))
+		return
+	}
+	conn, err := gw.options.Backend()
+	if err != nil {
+		logger.LogError(err)
+		fatal(WsCloseInternalError, errors.New("service unavailable"))
+		return
+	}
+	session, err := gw.openSession(conn, ctor_arg)
+	if err != nil {
+		_ = send(gatewayEvent { Kind: MSG_ERROR, Error: gatewayError(err) })
+		_ = ws.Close(WsCloseNormal, "")
+		return
+	}
+	defer session.close()
+	err = send(gatewayEvent { Kind: MSG_CREATED })
+	if err != nil {
+		logger.LogError(err)
+		_ = ws.Close(WsCloseNormal, "")
+		return
+	}
+	go (func() {
+		<- session.terminated
+		_ = ws.Close(WsCloseNormal, "service connection closed")
+	})()
+	for {
+		var msg, err = ws.ReadMessage()
+		if err != nil {
+			if err != io.EOF { logger.LogError(err) }
+			_ = ws.Close(WsCloseNormal, "")
+			return
+		}
+		var call_req gatewayRequest
+		err = json.Unmarshal(msg, &call_req)
+		if err != nil {
+			fatal(WsCloseProtocolError, fmt.Errorf("invalid message: %w", err))
+			return
+		}
+		var id = call_req.Id
+		var reject = func(e error) {
+			_ = send(gatewayEvent { Kind: MSG_ERROR, Id: &id, Error: gatewayError(e) })
+			_ = send(gatewayEvent { Kind: MSG_COMPLETE, Id: &id })
+		}
+		if call_req.Kind != MSG_CALL && call_req.Kind != MSG_CALL_MULTI {
+			fatal(WsCloseProtocolError, errors.New(fmt.Sprintf(
+				"unknown message kind: %s", call_req.Kind)))
+			return
+		}
+		var method_name = call_req.Method
+		var method, exists = gw.service.Methods[method_name]
+		if !(exists) {
+			reject(errors.New(fmt.Sprintf(
+				"method '%s' does not exist", method_name)))
+			continue
+		}
+		if (call_req.Kind == MSG_CALL && method.MultiValue) ||
+			(call_req.Kind == MSG_CALL_MULTI && !(method.MultiValue)) {
+			reject(errors.New(fmt.Sprintf(
+				"wrong quantifier (method: '%s')", method_name)))
+			continue
+		}
+		arg, err := gw.decodeArgument(call_req.Argument, method.ArgType)
+		if err != nil {
+			reject(fmt.Errorf("invalid method argument: %w", err))
+			continue
+		}
+		session.call(method_name, arg, gatewayCallHandler {
+			Value: func(v kmd.Object) error {
+				var bin, err = json.Marshal(v)
+				if err != nil { panic(err) }
+				return send(gatewayEvent { Kind: MSG_VALUE, Id: &id, Value: bin })
+			},
+			Error: func(e error) error {
+				return send(gatewayEvent { Kind: MSG_ERROR, Id: &id, Error: gatewayError(e) })
+			},
+			Complete: func() error {
+				return send(gatewayEvent { Kind: MSG_COMPLETE, Id: &id })
+			},
+		}, logger)
+	}
+}
+
+func (gw *gateway) decodeArgument(raw json.RawMessage, t *kmd.Type) (kmd.Object, error) {
+	var value interface{}
+	if len(raw) > 0 {
+		var decoder = json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		var err = decoder.Decode(&value)
+		if err != nil { return nil, err }
+	}
+	return kmd.JsonToObject(value, t, gw.options.SchemaTable)
+}
+
+func gatewayError(e error) *ErrorWithExtraData {
+	var with_extra *ErrorWithExtraData
+	if errors.As(e, &with_extra) {
+		if with_extra.Data == nil {
+			return &ErrorWithExtraData {
+				Desc: with_extra.Desc,
+				Data: make(map[string] string),
+			}
+		}
+		return with_extra
+	} else {
+		return &ErrorWithExtraData {
+			Desc: e.Error(),
+			Data: make(map[string] string),
+		}
+	}
+}
+
+
+type gatewaySession struct {
+	instance    *ClientInstance
+	worker      *rx.Worker
+	sched       rx.Scheduler
+	done        chan struct{}  // closed to close the session
+	terminated  chan struct{}  // closed when the connection is closed
+	once        sync.Once
+}
+type gatewayCallHandler struct {
+	Value     func(kmd.Object) error
+	Error     func(error) error
+	Complete  func() error
+}
+func (gw *gateway) openSession(conn net.Conn, arg kmd.Object) (*gatewaySession, error) {
+	var session = &gatewaySession {
+		worker:     rx.CreateWorker(),
+		sched:      gw.sched,
+		done:       make(chan struct{}),
+		terminated: make(chan struct{}),
+	}
+	var ready = make(chan *ClientInstance, 1)
+	var client = Client(gw.service, &ClientOptions {
+		Connection:          conn,
+		DebugOutput:         gw.options.DebugOutput,
+		ConstructorArgument: arg,
+		InstanceConsumer: func(instance *ClientInstance) rx.Observable {
+			ready <- instance
+			return rx.NewGoroutine(func(sender rx.Sender) {
+				<- session.done
+				sender.Complete()
+			})
+		},
+		Limits: gw.options.Limits,
+		KmdApi: gw.api,
+	})
+	var failure = make(chan rx.Object, 1)
+	var terminate = make(chan bool, 1)
+	rx.Schedule(client, gw.sched, rx.Receiver {
+		Context:   rx.Background(),
+		Error:     failure,
+		Terminate: terminate,
+	})
+	go (func() {
+		<- terminate
+		close(session.terminated)
+		session.worker.Dispose()
+	})()
+	select {
+	case instance := <- ready:
+		session.instance = instance
+		return session, nil
+	case err := <- failure:
+		session.close()
+		return nil, err.(error)
+	case <- session.terminated:
+		session.close()
+		select {
+		case err := <- failure:
+			return nil, err.(error)
+		default:
+			return nil, errors.New("connection to the service closed unexpectedly")
+		}
+	}
+}
+func (session *gatewaySession) close() {
+	session.once.Do(func() {
+		close(session.done)
+	})
+}
+func (session *gatewaySession) call(method_name string, arg kmd.Object, h gatewayCallHandler, logger GatewayLogger) {
+	var action = session.instance.Call(method_name, arg)
+	var with_worker = func(do (func() error)) rx.Observable {
+		return rx.NewQueuedNoValue(session.worker, func() (bool, rx.Object) {
+			err := do()
+			if err != nil { return false, err }
+			return true, nil
+		})
+	}
+	var send_value = func(value kmd.Object) rx.Observable {
+		return with_worker(func() error {
+			return h.Value(value)
+		})
+	}
+	var send_exception = func(e kmd.Object) rx.Observable {
+		return with_worker(func() error {
+			return h.Error(e.(error))
+		})
+	}
+	var send_completion = func(_ kmd.Object) rx.Observable {
+		return with_worker(func() error {
+			return h.Complete()
+		})
+	}
+	var send_all =
+		action.
+		Catch(send_exception).
+		ConcatMap(send_value).
+		WaitComplete().
+		Then(send_completion).
+		Catch(func(err rx.Object) rx.Observable {
+			logger.LogError(err.(error))
+			return rx.Noop()
+		})
+	rx.ScheduleBackground(send_all, session.sched)
+}
@@ -0,0 +1,416 @@
+package rpc
+
+import (
+	"io"
+	"fmt"
+	"net"
+	"bytes"
+	"bufio"
+	"testing"
+	"strings"
+	"net/http"
+	"io/ioutil"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/json"
+	"encoding/binary"
+	"encoding/base64"
+	"net/http/httptest"
+	"kumachan/standalone/rx"
+	"kumachan/standalone/rpc/kmd"
+)
+
+
+func testTypeId(name string) kmd.TypeId {
+	return kmd.TheTypeId("test.gateway", "rpc", name, "v1")
+}
+
+var testString = kmd.PrimitiveType(kmd.String)
+var testInteger = kmd.PrimitiveType(kmd.Integer)
+var testFloat = kmd.PrimitiveType(kmd.Float)
+var testSchema = kmd.SchemaTable {
+	testTypeId("Config"): kmd.RecordSchema { Fields: map[string] kmd.RecordField {
+		"name": { Type: testString, Index: 0 },
+	} },
+	testTypeId("Greeting"): kmd.RecordSchema { Fields: map[string] kmd.RecordField {
+		"greeting": { Type: testString, Index: 0 },
+		"times":    { Type: kmd.ContainerType(kmd.Optional, testInteger), Index: 1 },
+	} },
+	testTypeId("Unit"): kmd.TupleSchema {},
+	testTypeId("Shape"): kmd.EnumSchema { CaseIndexMap: map[kmd.TypeId] uint {
+		testTypeId("Circle"): 0,
+		testTypeId("Square"): 1,
+	} },
+	testTypeId("Circle"): kmd.RecordSchema { Fields: map[string] kmd.RecordField {
+		"radius": { Type: testFloat, Index: 0 },
+	} },
+	testTypeId("Square"): kmd.RecordSchema { Fields: map[string] kmd.RecordField {
+		"side": { Type: testFloat, Index: 0 },
+	} },
+}
+
+func testTypeFromName(name string) *kmd.Type {
+	return testSchema.GetTypeFromId(testTypeId(name))
+}
+
+func testTyped(value interface{}, t *kmd.Type) kmd.Object {
+	var obj, err = kmd.JsonToObject(value, t, testSchema)
+	if err != nil { panic(err) }
+	return obj
+}
+
+func testService() Service {
+	var shape_t = testTypeFromName("Shape")
+	return Service {
+		ServiceIdentifier: ServiceIdentifier {
+			Vendor:  "test.gateway",
+			Project: "rpc",
+			Name:    "Greeter",
+			Version: "v1",
+		},
+		Constructor: ServiceConstructor {
+			ServiceConstructorInterface: ServiceConstructorInterface {
+				ArgType: testTypeFromName("Config"),
+			},
+			GetAction: func(arg kmd.Object, _ interface{}) rx.Observable {
+				var name = arg.(map[string] interface{})["name"].(string)
+				if name == "" {
+					return rx.Throw(&ErrorWithExtraData {
+						Desc: "empty name",
+						Data: map[string] string { "field": "name" },
+					})
+				}
+				return rx.NewConstant(name)
+			},
+		},
+		Destructor: ServiceDestructor {
+			GetAction: func(_ kmd.Object) rx.Observable {
+				return rx.Noop()
+			},
+		},
+		Methods: map[string] ServiceMethod {
+			"greet": {
+				ServiceMethodInterface: ServiceMethodInterface {
+					ArgType: testTypeFromName("Greeting"),
+					RetType: testString,
+				},
+				GetAction: func(instance kmd.Object, arg kmd.Object) rx.Observable {
+					var fields = arg.(map[string] interface{})
+					var times = 1
+					if fields["times"] != nil {
+						fmt.Sscan(string(fields["times"].(json.Number)), &times)
+					}
+					var greeting = fmt.Sprintf("%s, %s", fields["greeting"], instance)
+					var text = strings.Repeat(greeting + "!", times)
+					return rx.NewConstant(testTyped(text, testString))
+				},
+			},
+			"count": {
+				ServiceMethodInterface: ServiceMethodInterface {
+					ArgType:    testInteger,
+					RetType:    testInteger,
+					MultiValue: true,
+				},
+				GetAction: func(_ kmd.Object, arg kmd.Object) rx.Observable {
+					var n int
+					fmt.Sscan(string(arg.(json.Number)), &n)
+					var values = make([] rx.Object, n)
+					for i := 0; i < n; i += 1 {
+						values[i] = testTyped(json.Number(fmt.Sprint(i + 1)), testInteger)
+					}
+					return rx.NewConstant(values...)
+				},
+			},
+			"fail": {
+				ServiceMethodInterface: ServiceMethodInterface {
+					ArgType: testTypeFromName("Unit"),
+					RetType: testString,
+				},
+				GetAction: func(_ kmd.Object, _ kmd.Object) rx.Observable {
+					return rx.Throw(&ErrorWithExtraData {
+						Desc: "failed",
+						Data: map[string] string { "code": "42" },
+					})
+				},
+			},
+			"echo": {
+				ServiceMethodInterface: ServiceMethodInterface {
+					ArgType: shape_t,
+					RetType: shape_t,
+				},
+				GetAction: func(_ kmd.Object, arg kmd.Object) rx.Observable {
+					return rx.NewConstant(testTyped(arg, shape_t))
+				},
+			},
+		},
+	}
+}
+
+func testServiceInterface(service Service) ServiceInterface {
+	var methods = make(map[string] ServiceMethodInterface)
+	for name, method := range service.Methods {
+		methods[name] = method.ServiceMethodInterface
+	}
+	return ServiceInterface {
+		ServiceIdentifier: service.ServiceIdentifier,
+		Constructor:       service.Constructor.ServiceConstructorInterface,
+		Methods:           methods,
+	}
+}
+
+// rx assumes a single event loop in a process
+var testScheduler = rx.TrivialScheduler { EventLoop: rx.SpawnEventLoop() }
+
+func startTestGateway(t *testing.T) (*httptest.Server, func()) {
+	var l, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil { t.Fatal(err) }
+	var api = TransformerKmdApi { Transformer: kmd.CreateJsonTransformer(testSchema) }
+	var service = testService()
+	var server = Server(service, &ServerOptions {
+		Listener: l,
+		KmdApi:   api,
+	})
+	var sched = testScheduler
+	rx.ScheduleBackground(server.Catch(func(_ rx.Object) rx.Observable {
+		return rx.Noop()
+	}), sched)
+	var gw = Gateway(testServiceInterface(service), &GatewayOptions {
+		Backend: func() (net.Conn, error) {
+			return net.Dial("tcp", l.Addr().String())
+		},
+		SchemaTable: testSchema,
+		Scheduler:   sched,
+	})
+	var http_server = httptest.NewServer(gw)
+	return http_server, func() {
+		http_server.Close()
+		_ = l.Close()
+	}
+}
+
+func testPost(t *testing.T, url string, body string) (int, string) {
+	var res, err = http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil { t.Fatal(err) }
+	defer (func() { _ = res.Body.Close() })()
+	content, err := ioutil.ReadAll(res.Body)
+	if err != nil { t.Fatal(err) }
+	return res.StatusCode, string(content)
+}
+
+func TestGatewayHttp(t *testing.T) {
+	var server, stop = startTestGateway(t)
+	defer stop()
+	var cases = [] struct {
+		name    string
+		method  string
+		body    string
+		status  int
+		result  string
+	} {
+		{ "value", "greet",
+			`{"constructor":{"name":"Alice"},"argument":{"greeting":"Hello"}}`,
+			200, `{"value":"Hello, Alice!"}` },
+		{ "optional field", "greet",
+			`{"constructor":{"name":"Bob"},"argument":{"greeting":"Hi","times":2}}`,
+			200, `{"value":"Hi, Bob!Hi, Bob!"}` },
+		{ "enum", "echo",
+			`{"constructor":{"name":"A"},"argument":{"Circle":{"radius":1.5}}}`,
+			200, `{"value":{"Circle":{"radius":1.5}}}` },
+		{ "method error", "fail",
+			`{"constructor":{"name":"A"},"argument":[]}`,
+			500, `{"error":{"desc":"failed","data":{"code":"42"}}}` },
+		{ "constructor error", "greet",
+			`{"constructor":{"name":""},"argument":{"greeting":"Hello"}}`,
+			500, `{"error":{"desc":"empty name","data":{"field":"name"}}}` },
+		{ "invalid argument", "greet",
+			`{"constructor":{"name":"A"},"argument":{"greeting":1}}`,
+			400, "" },
+		{ "unknown field", "greet",
+			`{"constructor":{"name":"A","age":1},"argument":{"greeting":"Hi"}}`,
+			400, "" },
+		{ "multiple values", "count",
+			`{"constructor":{"name":"A"},"argument":3}`,
+			400, "" },
+		{ "unknown method", "foo",
+			`{"constructor":{"name":"A"},"argument":null}`,
+			404, "" },
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var status, result = testPost(t, (server.URL + "/call/" + c.method), c.body)
+			if status != c.status {
+				t.Fatalf("unexpected status %d: %s", status, result)
+			}
+			if c.result != "" && result != c.result {
+				t.Fatalf("unexpected result: %s", result)
+			}
+		})
+	}
+}
+
+func TestGatewayBackendClosed(t *testing.T) {
+	var l, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil { t.Fatal(err) }
+	defer (func() { _ = l.Close() })()
+	go (func() {
+		for {
+			var conn, err = l.Accept()
+			if err != nil { return }
+			_ = conn.Close()
+		}
+	})()
+	var gw = Gateway(testServiceInterface(testService()), &GatewayOptions {
+		Backend: func() (net.Conn, error) {
+			return net.Dial("tcp", l.Addr().String())
+		},
+		SchemaTable: testSchema,
+		Scheduler:   testScheduler,
+	})
+	var server = httptest.NewServer(gw)
+	defer server.Close()
+	var status, result = testPost(t, (server.URL + "/call/greet"),
+		`{"constructor":{"name":"A"},"argument":{"greeting":"Hi"}}`)
+	if status != http.StatusInternalServerError {
+		t.Fatalf("unexpected status %d: %s", status, result)
+	}
+}
+
+type testWebSocketClient struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+}
+
+func dialTestWebSocket(t *testing.T, addr string) *testWebSocketClient {
+	var conn, err = net.Dial("tcp", addr)
+	if err != nil { t.Fatal(err) }
+	var nonce = make([] byte, 16)
+	_, _ = rand.Read(nonce)
+	var key = base64.StdEncoding.EncodeToString(nonce)
+	_, err = fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: %s\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n",
+		addr, key)
+	if err != nil { t.Fatal(err) }
+	var reader = bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil { t.Fatal(err) }
+	var digest = sha1.Sum(([] byte)(key + wsAcceptGUID))
+	var accept = base64.StdEncoding.EncodeToString(digest[:])
+	if res.StatusCode != http.StatusSwitchingProtocols ||
+		res.Header.Get("Sec-WebSocket-Accept") != accept {
+		t.Fatalf("websocket handshake failed: %s", res.Status)
+	}
+	return &testWebSocketClient { conn: conn, reader: reader }
+}
+
+func (c *testWebSocketClient) send(t *testing.T, msg string) {
+	var payload = ([] byte)(msg)
+	var frame = [] byte { 0x81 }
+	if len(payload) < 126 {
+		frame = append(frame, (0x80 | byte(len(payload))))
+	} else {
+		frame = append(frame, (0x80 | 126), 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	}
+	var mask = [] byte { 1, 2, 3, 4 }
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, (b ^ mask[i % 4]))
+	}
+	var _, err = c.conn.Write(frame)
+	if err != nil { t.Fatal(err) }
+}
+
+func (c *testWebSocketClient) receive(t *testing.T) (byte, string) {
+	var header [2] byte
+	var _, err = io.ReadFull(c.reader, header[:])
+	if err != nil { t.Fatal(err) }
+	var length = int(header[1] & 0x7F)
+	if length == 126 {
+		var ext [2] byte
+		_, err = io.ReadFull(c.reader, ext[:])
+		if err != nil { t.Fatal(err) }
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	var payload = make([] byte, length)
+	_, err = io.ReadFull(c.reader, payload)
+	if err != nil { t.Fatal(err) }
+	return (header[0] & 0x0F), string(payload)
+}
+
+func (c *testWebSocketClient) expect(t *testing.T, messages... string) {
+	for _, expected := range messages {
+		var op, msg = c.receive(t)
+		if op != wsOpText || msg != expected {
+			t.Fatalf("unexpected message (op %d): %s (expect %s)", op, msg, expected)
+		}
+	}
+}
+
+func TestGatewayWebSocket(t *testing.T) {
+	var server, stop = startTestGateway(t)
+	defer stop()
+	var addr = strings.TrimPrefix(server.URL, "http://")
+	t.Run("calls", func(t *testing.T) {
+		var c = dialTestWebSocket(t, addr)
+		defer (func() { _ = c.conn.Close() })()
+		c.send(t, `{"kind":"create","argument":{"name":"Alice"}}`)
+		c.expect(t, `{"kind":"created"}`)
+		c.send(t, `{"kind":"call*","id":1,"method":"count","argument":3}`)
+		c.expect(t,
+			`{"kind":"value","id":1,"value":1}`,
+			`{"kind":"value","id":1,"value":2}`,
+			`{"kind":"value","id":1,"value":3}`,
+			`{"kind":"complete","id":1}`)
+		c.send(t, `{"kind":"call","id":2,"method":"greet","argument":{"greeting":"Hello"}}`)
+		c.expect(t,
+			`{"kind":"value","id":2,"value":"Hello, Alice!"}`,
+			`{"kind":"complete","id":2}`)
+		c.send(t, `{"kind":"call","id":3,"method":"fail","argument":null}`)
+		c.expect(t,
+			`{"kind":"error","id":3,"error":{"desc":"failed","data":{"code":"42"}}}`,
+			`{"kind":"complete","id":3}`)
+		c.send(t, `{"kind":"call","id":4,"method":"count","argument":1}`)
+		c.expect(t,
+			`{"kind":"error","id":4,"error":{"desc":"wrong quantifier (method: 'count')","data":{}}}`,
+			`{"kind":"complete","id":4}`)
+		// ping
+		_, _ = c.conn.Write([] byte { 0x89, 0x80, 0, 0, 0, 0 })
+		var op, _ = c.receive(t)
+		if op != wsOpPong { t.Fatalf("unexpected opcode %d", op) }
+	})
+	t.Run("constructor error", func(t *testing.T) {
+		var c = dialTestWebSocket(t, addr)
+		defer (func() { _ = c.conn.Close() })()
+		c.send(t, `{"kind":"create","argument":{"name":""}}`)
+		c.expect(t, `{"kind":"error","error":{"desc":"empty name","data":{"field":"name"}}}`)
+		var op, _ = c.receive(t)
+		if op != wsOpClose { t.Fatalf("unexpected opcode %d", op) }
+	})
+	t.Run("large message", func(t *testing.T) {
+		var c = dialTestWebSocket(t, addr)
+		defer (func() { _ = c.conn.Close() })()
+		var name = strings.Repeat("x", 1000)
+		c.send(t, `{"kind":"create","argument":{"name":"` + name + `"}}`)
+		c.expect(t, `{"kind":"created"}`)
+		c.send(t, `{"kind":"call","id":0,"method":"greet","argument":{"greeting":"Hi"}}`)
+		var _, msg = c.receive(t)
+		if !(bytes.Contains(([] byte)(msg), ([] byte)(name))) {
+			t.Fatalf("unexpected message: %s", msg)
+		}
+	})
+	t.Run("huge declared length", func(t *testing.T) {
+		var c = dialTestWebSocket(t, addr)
+		defer (func() { _ = c.conn.Close() })()
+		// the payload is never sent, which should not be allocated
+		var frame = [] byte { 0x81, (0x80 | 127), 0, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4 }
+		binary.BigEndian.PutUint64(frame[2:10], (1 << 40))
+		var _, err = c.conn.Write(frame)
+		if err != nil { t.Fatal(err) }
+		_ = c.conn.(*net.TCPConn).CloseWrite()
+		_, err = ioutil.ReadAll(c.reader)
+		if err != nil { t.Fatal(err) }
+		var another = dialTestWebSocket(t, addr)
+		_ = another.conn.Close()
+	})
+}
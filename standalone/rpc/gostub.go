@@ -0,0 +1,419 @@
+package rpc
+
+import (
+	"fmt"
+	"errors"
+	"strings"
+	"strconv"
+	"unicode"
+	"go/token"
+	"go/format"
+	"kumachan/standalone/rpc/kmd"
+)
+
+
+/**
+ *  Go Client Stub Generation
+ *
+ *  Generates Go source code that allows a Go program to call a service,
+ *  using the Go struct transformer of KMD (kmd.GoStructOptions):
+ *
+ *  - record  => struct (fields tagged by original names)
+ *  - tuple   => struct (fields E0, E1, ... in order)
+ *  - enum    => interface with a marker method KmdEnum<Name>(),
+ *               which is implemented by all case types
+ *  - ? T     => interface with a single method Maybe(T, MaybeT),
+ *               which is implemented by T (T must be record/tuple/enum)
+ *  - integer => *big.Int
+ *
+ *  A typed Client is generated as well, whose methods correspond to the
+ *  service methods and return observables of the Go types above.
+ */
+
+const goStubMaybePrefix = "Maybe"
+const goStubEnumMarkerPrefix = "KmdEnum"
+var goStubReservedNames = [] string {
+	"Client", "NewClient", "ServiceIdentifier", "ServiceInterface", "KmdOptions",
+}
+
+type goStubGenerator struct {
+	service  ServiceInterface
+	sch      kmd.SchemaTable
+	ids      [] kmd.TypeId
+	names    map[kmd.TypeId] string
+	enums    map[kmd.TypeId] ([] kmd.TypeId)  // case type => enum types
+	maybes   map[kmd.TypeId] bool
+	big      bool
+}
+
+func GenerateGoClient(service ServiceInterface, sch kmd.SchemaTable, pkg string) ([] byte, error) {
+	if !(isGoIdentifier(pkg)) || token.IsKeyword(pkg) {
+		return nil, errors.New(fmt.Sprintf("invalid Go package name: %s", pkg))
+	}
+	var g = &goStubGenerator {
+		service: service,
+		sch:     sch,
+		ids:     collectServiceTypes(service, sch),
+		names:   make(map[kmd.TypeId] string),
+		enums:   make(map[kmd.TypeId] ([] kmd.TypeId)),
+		maybes:  make(map[kmd.TypeId] bool),
+	}
+	err := g.prepare()
+	if err != nil { return nil, err }
+	var buf strings.Builder
+	g.writeTypes(&buf)
+	g.writeService(&buf)
+	var imports = [] string { "net", "reflect" }
+	if g.big {
+		imports = append(imports, "math/big")
+	}
+	imports = append(imports,
+		"kumachan/standalone/rx",
+		"kumachan/standalone/rpc",
+		"kumachan/standalone/rpc/kmd",
+	)
+	var header strings.Builder
+	fmt.Fprintf(&header, "// Code generated by kumachan --mode=service-describe. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&header, "// Package %s is a client of the service %s.\n", pkg,
+		DescribeServiceIdentifier(service.ServiceIdentifier))
+	fmt.Fprintf(&header, "package %s\n\nimport (\n", pkg)
+	for _, path := range imports {
+		fmt.Fprintf(&header, "\t%s\n", strconv.Quote(path))
+	}
+	fmt.Fprintf(&header, ")\n\n")
+	var code = header.String() + buf.String()
+	formatted, err := format.Source(([] byte)(code))
+	if err != nil { panic(fmt.Errorf("invalid Go code generated: %w", err)) }
+	return formatted, nil
+}
+
+func (g *goStubGenerator) prepare() error {
+	var used = make(map[string] kmd.TypeId)
+	for _, name := range goStubReservedNames {
+		used[name] = kmd.TypeId {}
+	}
+	var use = func(name string, id kmd.TypeId) error {
+		var existing, exists = used[name]
+		if exists {
+			return errors.New(fmt.Sprintf(
+				"name conflict in Go: %s (%s, %s)", name, existing, id))
+		}
+		used[name] = id
+		return nil
+	}
+	for _, id := range g.ids {
+		var name = goName(id.Name)
+		err := use(name, id)
+		if err != nil { return err }
+		err = use(goStubMaybePrefix + name, id)
+		if err != nil { return err }
+		g.names[id] = name
+	}
+	for _, id := range g.ids {
+		var S, is_enum = g.sch[id].(kmd.EnumSchema)
+		if !(is_enum) { continue }
+		for _, case_id := range sortedEnumCases(S) {
+			if _, case_is_enum := g.sch[case_id].(kmd.EnumSchema); case_is_enum {
+				return errors.New(fmt.Sprintf(
+					"nested enum is not supported in Go: %s", case_id))
+			}
+			g.enums[case_id] = append(g.enums[case_id], id)
+		}
+	}
+	var visit func(t *kmd.Type) error
+	visit = func(t *kmd.Type) error {
+		switch t.Kind() {
+		case kmd.Integer:
+			g.big = true
+		case kmd.Array:
+			return visit(t.ElementType())
+		case kmd.Optional:
+			var elem_t = t.ElementType()
+			switch elem_t.Kind() {
+			case kmd.Record, kmd.Tuple, kmd.Enum:
+				g.maybes[elem_t.Identifier()] = true
+			default:
+				return errors.New(fmt.Sprintf(
+					"optional type is not supported in Go: %s", t))
+			}
+			return visit(elem_t)
+		}
+		return nil
+	}
+	var types = [] *kmd.Type { g.service.Constructor.ArgType }
+	for _, name := range sortedMethodNames(g.service) {
+		var method = g.service.Methods[name]
+		types = append(types, method.ArgType, method.RetType)
+	}
+	for _, id := range g.ids {
+		switch S := g.sch[id].(type) {
+		case kmd.RecordSchema:
+			var fields = make(map[string] bool)
+			for _, name := range sortedRecordFields(S) {
+				var field_name = goName(name)
+				if fields[field_name] || field_name == goStubMaybePrefix ||
+					strings.HasPrefix(field_name, goStubEnumMarkerPrefix) {
+					return errors.New(fmt.Sprintf(
+						"field name conflict in Go: %s (%s)", field_name, id))
+				}
+				fields[field_name] = true
+				types = append(types, S.Fields[name].Type)
+			}
+		case kmd.TupleSchema:
+			types = append(types, S.Elements...)
+		}
+	}
+	for _, t := range types {
+		err := visit(t)
+		if err != nil { return err }
+	}
+	for case_id, enum_ids := range g.enums {
+		var maybe_count = 0
+		if g.maybes[case_id] { maybe_count += 1 }
+		for _, enum_id := range enum_ids {
+			if g.maybes[enum_id] { maybe_count += 1 }
+		}
+		if maybe_count > 1 {
+			return errors.New(fmt.Sprintf(
+				"ambiguous optional type in Go: %s", case_id))
+		}
+	}
+	return nil
+}
+
+func (g *goStubGenerator) goType(t *kmd.Type) string {
+	switch t.Kind() {
+	case kmd.Bool:     return "bool"
+	case kmd.Float:    return "float64"
+	case kmd.Complex:  return "complex128"
+	case kmd.Integer:  return "*big.Int"
+	case kmd.String:   return "string"
+	case kmd.Binary:   return "[]byte"
+	case kmd.Array:    return ("[]" + g.goType(t.ElementType()))
+	case kmd.Optional: return (goStubMaybePrefix + g.names[t.ElementType().Identifier()])
+	default:           return g.names[t.Identifier()]
+	}
+}
+
+func (g *goStubGenerator) writeTypes(buf *strings.Builder) {
+	for _, id := range g.ids {
+		var name = g.names[id]
+		fmt.Fprintf(buf, "// %s corresponds to %s.\n", name, g.sch.GetTypeFromId(id))
+		switch S := g.sch[id].(type) {
+		case kmd.RecordSchema:
+			fmt.Fprintf(buf, "type %s struct {\n", name)
+			if S.AllowUnknown {
+				fmt.Fprintf(buf, "\t_ struct{} `%s:\"\"`\n", kmd.TagAllowUnknown)
+			}
+			for _, field := range sortedRecordFields(S) {
+				var tag = fmt.Sprintf("kmd:%s", strconv.Quote(field))
+				if S.Fields[field].Optional {
+					tag += fmt.Sprintf(" %s:\"\"", kmd.TagDefault)
+				}
+				fmt.Fprintf(buf, "\t%s %s `%s`\n", goName(field),
+					g.goType(S.Fields[field].Type), tag)
+			}
+			fmt.Fprintf(buf, "}\n\n")
+		case kmd.TupleSchema:
+			fmt.Fprintf(buf, "type %s struct {\n", name)
+			for i, el := range S.Elements {
+				fmt.Fprintf(buf, "\tE%d %s\n", i, g.goType(el))
+			}
+			fmt.Fprintf(buf, "}\n\n")
+		case kmd.EnumSchema:
+			fmt.Fprintf(buf, "type %s interface {\n", name)
+			fmt.Fprintf(buf, "\t%s%s()\n", goStubEnumMarkerPrefix, name)
+			if g.maybes[id] {
+				fmt.Fprintf(buf, "\t%s(%s, %s%s)\n",
+					goStubMaybePrefix, name, goStubMaybePrefix, name)
+			}
+			fmt.Fprintf(buf, "}\n\n")
+		default:
+			panic("something went wrong")
+		}
+		for _, enum_id := range g.enums[id] {
+			var enum_name = g.names[enum_id]
+			fmt.Fprintf(buf, "func (%s) %s%s() {}\n",
+				name, goStubEnumMarkerPrefix, enum_name)
+			if g.maybes[enum_id] {
+				fmt.Fprintf(buf, "func (%s) %s(%s, %s%s) {}\n",
+					name, goStubMaybePrefix, enum_name, goStubMaybePrefix, enum_name)
+			}
+		}
+		if g.maybes[id] {
+			fmt.Fprintf(buf, "// %s%s is an optional %s (nil for nothing).\n",
+				goStubMaybePrefix, name, name)
+			fmt.Fprintf(buf, "type %s%s interface {\n\t%s(%s, %s%s)\n}\n\n",
+				goStubMaybePrefix, name, goStubMaybePrefix, name, goStubMaybePrefix, name)
+			var _, is_enum = g.sch[id].(kmd.EnumSchema)
+			if !(is_enum) {
+				fmt.Fprintf(buf, "func (%s) %s(%s, %s%s) {}\n",
+					name, goStubMaybePrefix, name, goStubMaybePrefix, name)
+			}
+		}
+		fmt.Fprintf(buf, "\n")
+	}
+}
+
+func (g *goStubGenerator) writeService(buf *strings.Builder) {
+	var sid = g.service.ServiceIdentifier
+	fmt.Fprintf(buf, "var ServiceIdentifier = rpc.ServiceIdentifier {\n")
+	fmt.Fprintf(buf, "\tVendor: %s,\n", strconv.Quote(sid.Vendor))
+	fmt.Fprintf(buf, "\tProject: %s,\n", strconv.Quote(sid.Project))
+	fmt.Fprintf(buf, "\tName: %s,\n", strconv.Quote(sid.Name))
+	fmt.Fprintf(buf, "\tVersion: %s,\n", strconv.Quote(sid.Version))
+	fmt.Fprintf(buf, "}\n\n")
+	fmt.Fprintf(buf, "func KmdOptions() kmd.GoStructOptions {\n")
+	fmt.Fprintf(buf, "\treturn kmd.GoStructOptions {\n")
+	for _, field := range [] string { "Types", "Tuples" } {
+		fmt.Fprintf(buf, "\t\t%s: map[kmd.TypeId] reflect.Type {\n", field)
+		for _, id := range g.ids {
+			var _, is_tuple = g.sch[id].(kmd.TupleSchema)
+			if is_tuple != (field == "Tuples") { continue }
+			var _, is_enum = g.sch[id].(kmd.EnumSchema)
+			var rt string
+			if is_enum {
+				rt = fmt.Sprintf("reflect.TypeOf(new(%s)).Elem()", g.names[id])
+			} else {
+				rt = fmt.Sprintf("reflect.TypeOf(%s {})", g.names[id])
+			}
+			fmt.Fprintf(buf, "\t\t\t%s: %s,\n", goTypeIdExpr(id), rt)
+		}
+		fmt.Fprintf(buf, "\t\t},\n")
+	}
+	fmt.Fprintf(buf, "\t\tIntegerKind: kmd.BigInt,\n")
+	fmt.Fprintf(buf, "\t\tStringKind: kmd.GoString,\n")
+	fmt.Fprintf(buf, "\t}\n}\n\n")
+	fmt.Fprintf(buf, "func ServiceInterface() rpc.ServiceInterface {\n")
+	fmt.Fprintf(buf, "\treturn rpc.ServiceInterface {\n")
+	fmt.Fprintf(buf, "\t\tServiceIdentifier: ServiceIdentifier,\n")
+	fmt.Fprintf(buf, "\t\tConstructor: rpc.ServiceConstructorInterface {\n")
+	fmt.Fprintf(buf, "\t\t\tArgType: %s,\n", goTypeExpr(g.service.Constructor.ArgType))
+	fmt.Fprintf(buf, "\t\t},\n")
+	fmt.Fprintf(buf, "\t\tMethods: map[string] rpc.ServiceMethodInterface {\n")
+	for _, name := range sortedMethodNames(g.service) {
+		var method = g.service.Methods[name]
+		fmt.Fprintf(buf, "\t\t\t%s: {\n", strconv.Quote(name))
+		fmt.Fprintf(buf, "\t\t\t\tArgType: %s,\n", goTypeExpr(method.ArgType))
+		fmt.Fprintf(buf, "\t\t\t\tRetType: %s,\n", goTypeExpr(method.RetType))
+		fmt.Fprintf(buf, "\t\t\t\tMultiValue: %t,\n", method.MultiValue)
+		fmt.Fprintf(buf, "\t\t\t},\n")
+	}
+	fmt.Fprintf(buf, "\t\t},\n")
+	fmt.Fprintf(buf, "\t}\n}\n\n")
+	fmt.Fprintf(buf, "type Client struct {\n\tinstance *rpc.ClientInstance\n}\n\n")
+	fmt.Fprintf(buf, "// NewClient creates a service instance using the constructor argument,\n")
+	fmt.Fprintf(buf, "// and calls the consumer with a client of the instance.\n")
+	fmt.Fprintf(buf, "// The instance is disposed when the observable returned by the consumer completes.\n")
+	fmt.Fprintf(buf, "func NewClient(conn net.Conn, arg %s, opts rpc.ClientOptions, consumer func(Client) rx.Observable) rx.Observable {\n",
+		g.goType(g.service.Constructor.ArgType))
+	fmt.Fprintf(buf, "\topts.Connection = conn\n")
+	fmt.Fprintf(buf, "\topts.ConstructorArgument = %s\n",
+		g.goArgExpr(g.service.Constructor.ArgType))
+	fmt.Fprintf(buf, "\topts.InstanceConsumer = func(instance *rpc.ClientInstance) rx.Observable {\n")
+	fmt.Fprintf(buf, "\t\treturn consumer(Client { instance })\n")
+	fmt.Fprintf(buf, "\t}\n")
+	fmt.Fprintf(buf, "\topts.KmdApi = rpc.TransformerKmdApi {\n")
+	fmt.Fprintf(buf, "\t\tTransformer: kmd.CreateGoStructTransformer(KmdOptions()),\n")
+	fmt.Fprintf(buf, "\t}\n")
+	fmt.Fprintf(buf, "\treturn rpc.Client(ServiceInterface(), &opts)\n")
+	fmt.Fprintf(buf, "}\n\n")
+	var method_names = map[string] bool { "Call": true }
+	for _, name := range sortedMethodNames(g.service) {
+		var method = g.service.Methods[name]
+		var go_name = goName(name)
+		if method_names[go_name] {
+			// methods with conflicting names are only available via Call()
+			continue
+		}
+		method_names[go_name] = true
+		var values = "a single value"
+		if method.MultiValue {
+			values = "values"
+		}
+		fmt.Fprintf(buf, "// %s calls the method %s, which emits %s of type %s.\n",
+			go_name, strconv.Quote(name), values, g.goType(method.RetType))
+		fmt.Fprintf(buf, "func (c Client) %s(arg %s) rx.Observable {\n",
+			go_name, g.goType(method.ArgType))
+		fmt.Fprintf(buf, "\treturn c.instance.Call(%s, %s)\n",
+			strconv.Quote(name), g.goArgExpr(method.ArgType))
+		fmt.Fprintf(buf, "}\n\n")
+	}
+	fmt.Fprintf(buf, "// Call calls a method by its original name.\n")
+	fmt.Fprintf(buf, "// An enum or optional argument should be wrapped in kmd.GoInterfaceWorkaround.\n")
+	fmt.Fprintf(buf, "func (c Client) Call(method string, arg interface{}) rx.Observable {\n")
+	fmt.Fprintf(buf, "\treturn c.instance.Call(method, arg)\n")
+	fmt.Fprintf(buf, "}\n")
+}
+
+// goArgExpr keeps the static type of an interface value (enum or optional),
+// which is lost when the value is passed as interface{}.
+func (g *goStubGenerator) goArgExpr(t *kmd.Type) string {
+	switch t.Kind() {
+	case kmd.Enum, kmd.Optional:
+		return fmt.Sprintf(
+			"kmd.GoInterfaceWorkaround { Type: reflect.TypeOf(new(%s)).Elem(), Concrete: arg }",
+			g.goType(t))
+	default:
+		return "arg"
+	}
+}
+
+func goTypeIdExpr(id kmd.TypeId) string {
+	return fmt.Sprintf("kmd.TheTypeId(%s, %s, %s, %s)",
+		strconv.Quote(id.Vendor), strconv.Quote(id.Project),
+		strconv.Quote(id.Name), strconv.Quote(id.Version))
+}
+
+func goTypeExpr(t *kmd.Type) string {
+	switch t.Kind() {
+	case kmd.Bool:     return "kmd.PrimitiveType(kmd.Bool)"
+	case kmd.Float:    return "kmd.PrimitiveType(kmd.Float)"
+	case kmd.Complex:  return "kmd.PrimitiveType(kmd.Complex)"
+	case kmd.Integer:  return "kmd.PrimitiveType(kmd.Integer)"
+	case kmd.String:   return "kmd.PrimitiveType(kmd.String)"
+	case kmd.Binary:   return "kmd.PrimitiveType(kmd.Binary)"
+	case kmd.Array:    return fmt.Sprintf("kmd.ContainerType(kmd.Array, %s)", goTypeExpr(t.ElementType()))
+	case kmd.Optional: return fmt.Sprintf("kmd.ContainerType(kmd.Optional, %s)", goTypeExpr(t.ElementType()))
+	case kmd.Record:   return fmt.Sprintf("kmd.AlgebraicType(kmd.Record, %s)", goTypeIdExpr(t.Identifier()))
+	case kmd.Tuple:    return fmt.Sprintf("kmd.AlgebraicType(kmd.Tuple, %s)", goTypeIdExpr(t.Identifier()))
+	case kmd.Enum:     return fmt.Sprintf("kmd.AlgebraicType(kmd.Enum, %s)", goTypeIdExpr(t.Identifier()))
+	default:           panic("impossible branch")
+	}
+}
+
+// goName converts a name (e.g. "receive-messages") into
+// an exported Go identifier (e.g. "ReceiveMessages").
+func goName(name string) string {
+	var buf strings.Builder
+	var upper = true
+	for _, char := range name {
+		if unicode.IsLetter(char) || unicode.IsDigit(char) {
+			if buf.Len() == 0 && unicode.IsDigit(char) {
+				buf.WriteRune('X')
+			}
+			if upper {
+				buf.WriteRune(unicode.ToUpper(char))
+			} else {
+				buf.WriteRune(char)
+			}
+			upper = false
+		} else {
+			upper = true
+		}
+	}
+	if buf.Len() == 0 {
+		return "X"
+	}
+	return buf.String()
+}
+
+func isGoIdentifier(name string) bool {
+	if name == "" { return false }
+	for i, char := range name {
+		if !(unicode.IsLetter(char) || char == '_' || (i > 0 && unicode.IsDigit(char))) {
+			return false
+		}
+	}
+	return true
+}
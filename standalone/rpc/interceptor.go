@@ -0,0 +1,167 @@
+package rpc
+
+import (
+	"net"
+	"time"
+	"strconv"
+	"kumachan/standalone/rx"
+	"kumachan/standalone/rpc/kmd"
+)
+
+
+/**
+ *  Interceptors
+ *
+ *  Interceptors are invoked for each call received by a server, after
+ *  the argument is received and before the method is invoked. They are
+ *  chained in the order of ServerOptions.Interceptors, where the first
+ *  one is the outermost. An interceptor may inspect the call (service,
+ *  method, metadata, etc.), reject the call by returning an action that
+ *  throws an error (which is sent to the client as the exception of the
+ *  call), or handle the call by next(). The action returned by next()
+ *  emits a CallResult when the call is finished, i.e. when all values of
+ *  the method and the terminal message (error or complete) have been sent.
+ *  An interceptor is instantiated for each connection, which is invoked
+ *  on the goroutine receiving messages from the connection, in the order
+ *  of calls. Therefore per-connection state (e.g. of rate limiting) can
+ *  be kept in the instance without synchronization.
+ */
+
+type Interceptor func(conn ConnectionInfo) CallInterceptor
+type CallInterceptor func(call CallInfo, next CallHandler) rx.Observable
+type CallHandler func() rx.Observable
+
+type ConnectionInfo struct {
+	Service     ServiceIdentifier
+	LocalAddr   net.Addr
+	RemoteAddr  net.Addr
+	Encoding    kmd.Encoding
+	Logger      ServerLogger
+}
+type CallResult struct {
+	Values     uint    // number of values sent
+	SentSize   uint64  // size of values sent (in bytes)
+	Exception  error   // nil if the call completed normally
+	Cancelled  bool    // cancelled by the client
+}
+
+func chainInterceptors(chain ([] CallInterceptor), call CallInfo, handle CallHandler) rx.Observable {
+	if len(chain) == 0 {
+		return handle()
+	}
+	return chain[0](call, func() rx.Observable {
+		return chainInterceptors(chain[1:], call, handle)
+	})
+}
+
+// Authenticate rejects calls for which the action returned by the check
+// function throws an error.
+func Authenticate(check func(CallInfo) rx.Observable) Interceptor {
+	return func(_ ConnectionInfo) CallInterceptor {
+		return func(call CallInfo, next CallHandler) rx.Observable {
+			return check(call).WaitComplete().Then(func(_ rx.Object) rx.Observable {
+				return next()
+			})
+		}
+	}
+}
+
+const RateLimitExceededDesc = "rate limit exceeded"
+
+// RateLimit limits the rate of calls on each connection, with a token
+// bucket of the specified size (burst) that is refilled at the specified
+// rate (calls per second). Calls exceeding the limit are rejected.
+func RateLimit(rate float64, burst uint) Interceptor {
+	return func(_ ConnectionInfo) CallInterceptor {
+		var tokens = float64(burst)
+		var last = time.Now()
+		return func(call CallInfo, next CallHandler) rx.Observable {
+			var now = time.Now()
+			tokens += (rate * now.Sub(last).Seconds())
+			if tokens > float64(burst) {
+				tokens = float64(burst)
+			}
+			last = now
+			if tokens < 1 {
+				var data = make(map[string] string)
+				if rate > 0 {
+					var wait = time.Duration(((1 - tokens) / rate) * float64(time.Second))
+					var ms = (int64(wait / time.Millisecond) + 1)
+					data["retry-after"] = strconv.FormatInt(ms, 10)
+				}
+				return rx.Throw(&ErrorWithExtraData {
+					Desc: RateLimitExceededDesc,
+					Data: data,
+				})
+			}
+			tokens -= 1
+			return next()
+		}
+	}
+}
+
+// AccessLogEntry is written as a line of JSON by the AccessLog interceptor.
+// The status is one of "ok", "exception", "cancelled" and "failed",
+// where "failed" means that the call was rejected (by an interceptor
+// after AccessLog) or the result could not be sent.
+type AccessLogEntry struct {
+	Time      string               `json:"time"`
+	Service   string               `json:"service"`
+	Remote    string               `json:"remote"`
+	Id        uint64               `json:"id"`
+	Method    string               `json:"method"`
+	Metadata  map[string] string   `json:"metadata,omitempty"`
+	Status    string               `json:"status"`
+	Error     string               `json:"error,omitempty"`
+	Latency   float64              `json:"latency-ms"`
+	ArgSize   uint64               `json:"arg-size"`
+	SentSize  uint64               `json:"sent-size"`
+	Values    uint                 `json:"values"`
+}
+
+// AccessLog writes an AccessLogEntry for each call when it is finished,
+// using the logger of the server (nothing is written if it has no output).
+func AccessLog() Interceptor {
+	return func(conn ConnectionInfo) CallInterceptor {
+		var write = conn.Logger.LogAccess
+		var service = DescribeServiceIdentifier(conn.Service)
+		var remote = conn.RemoteAddr.String()
+		return func(call CallInfo, next CallHandler) rx.Observable {
+			var start = time.Now()
+			var entry = func() AccessLogEntry {
+				return AccessLogEntry {
+					Time:     start.Format(time.RFC3339Nano),
+					Service:  service,
+					Remote:   remote,
+					Id:       call.Id,
+					Method:   call.Method,
+					Metadata: call.Metadata,
+					Latency:  (float64(time.Since(start)) / float64(time.Millisecond)),
+					ArgSize:  call.ArgSize,
+				}
+			}
+			return next().Then(func(result_ rx.Object) rx.Observable {
+				var result = result_.(CallResult)
+				var e = entry()
+				e.SentSize = result.SentSize
+				e.Values = result.Values
+				if result.Cancelled {
+					e.Status = "cancelled"
+				} else if result.Exception != nil {
+					e.Status = "exception"
+					e.Error = result.Exception.Error()
+				} else {
+					e.Status = "ok"
+				}
+				write(e)
+				return rx.NewConstant(result)
+			}).Catch(func(err rx.Object) rx.Observable {
+				var e = entry()
+				e.Status = "failed"
+				e.Error = err.(error).Error()
+				write(e)
+				return rx.Throw(err)
+			})
+		}
+	}
+}
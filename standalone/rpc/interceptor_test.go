@@ -0,0 +1,114 @@
+package rpc
+
+import (
+	"net"
+	"errors"
+	"testing"
+	"strings"
+	"encoding/json"
+	"kumachan/standalone/rx"
+	"kumachan/standalone/rpc/kmd"
+)
+
+
+func TestInterceptors(t *testing.T) {
+	var service = testService()
+	var l, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil { t.Fatal(err) }
+	defer l.Close()
+	var access_log = new(testSyncBuffer)
+	var unauthorized = errors.New("unauthorized")
+	var methods = make([] string, 0)
+	var api = TransformerKmdApi { Transformer: kmd.CreateJsonTransformer(testSchema) }
+	rx.ScheduleBackground(Server(service, &ServerOptions {
+		Listener:     l,
+		KmdApi:       api,
+		DebugOutput:  access_log,
+		Interceptors: [] Interceptor {
+			AccessLog(),
+			RateLimit(0, 3),
+			Authenticate(func(call CallInfo) rx.Observable {
+				methods = append(methods, call.Method)
+				if call.Metadata["token"] != "secret" {
+					return rx.Throw(unauthorized)
+				}
+				return rx.Noop()
+			}),
+		},
+	}).Catch(func(_ rx.Object) rx.Observable {
+		return rx.Noop()
+	}), testScheduler)
+	var arg = testTyped(map[string] interface{} { "greeting": "Hi" },
+		testTypeFromName("Greeting"))
+	var three = testTyped(json.Number("3"), testInteger)
+	var authorized = CallOptions {
+		Metadata: map[string] string { "token": "secret" },
+	}
+	var errs = make([] error, 0)
+	var values = make([] rx.Object, 0)
+	var collect = func(action rx.Observable) rx.Observable {
+		return action.ConcatMap(func(v rx.Object) rx.Observable {
+			values = append(values, v)
+			return rx.Noop()
+		}).WaitComplete().Catch(func(e rx.Object) rx.Observable {
+			errs = append(errs, e.(error))
+			return rx.NewConstant(nil)
+		})
+	}
+	testAccess(t, l.Addr().String(), service, func(instance *ClientInstance) rx.Observable {
+		return collect(instance.Call("greet", arg)).Then(func(_ rx.Object) rx.Observable {
+			return collect(instance.CallWithOptions("greet", arg, authorized))
+		}).Then(func(_ rx.Object) rx.Observable {
+			return collect(instance.CallWithOptions("count", three, authorized))
+		}).Then(func(_ rx.Object) rx.Observable {
+			return collect(instance.CallWithOptions("greet", arg, authorized))
+		})
+	})
+	if len(errs) != 2 || errs[0].Error() != "unauthorized" {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	var e_with_extra *ErrorWithExtraData
+	if !(errors.As(errs[1], &e_with_extra)) || e_with_extra.Desc != RateLimitExceededDesc {
+		t.Fatalf("unexpected error: %v", errs[1])
+	}
+	if len(values) != 4 || values[0] != "Hi, tester!" {
+		t.Fatalf("unexpected values: %v", values)
+	}
+	if strings.Join(methods, ",") != "greet,greet,count" {
+		t.Fatalf("unexpected authenticated methods: %v", methods)
+	}
+	var lines = make([] string, 0)
+	for _, line := range strings.Split(access_log.String(), "\n") {
+		if strings.HasPrefix(line, "{") {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) != 4 {
+		t.Fatalf("unexpected access log: %s", access_log.String())
+	}
+	var entries = make([] AccessLogEntry, len(lines))
+	for i, line := range lines {
+		err := json.Unmarshal(([] byte)(line), &entries[i])
+		if err != nil { t.Fatal(err) }
+		var e = entries[i]
+		if e.Service != "test.gateway:rpc:Greeter:v1" || e.ArgSize == 0 || e.Latency < 0 {
+			t.Fatalf("unexpected access log entry: %s", line)
+		}
+	}
+	var check = func(i int, method string, status string, values uint) {
+		var e = entries[i]
+		if e.Method != method || e.Status != status || e.Values != values {
+			t.Fatalf("unexpected access log entry: %s", lines[i])
+		}
+		if (values > 0) != (e.SentSize > 0) {
+			t.Fatalf("unexpected sent size: %s", lines[i])
+		}
+	}
+	check(0, "greet", "failed", 0)
+	check(1, "greet", "ok", 1)
+	check(2, "count", "ok", 3)
+	check(3, "greet", "failed", 0)
+	if entries[1].Metadata["token"] != "secret" {
+		t.Fatalf("unexpected metadata: %s", lines[1])
+	}
+}
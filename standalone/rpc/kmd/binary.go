@@ -0,0 +1,441 @@
+package kmd
+
+import (
+	"io"
+	"fmt"
+	"math"
+	"bufio"
+	"errors"
+	"math/big"
+	"io/ioutil"
+	"encoding/binary"
+	"kumachan/standalone/util"
+)
+
+
+/**
+ *  Binary Encoding
+ *
+ *  The binary encoding carries the same information as the text encoding,
+ *  in a compact form that is faster to read and write. It starts with
+ *  binaryHeader, which is followed by the root object:
+ *
+ *    object   = type value
+ *    type     = symbol            (text form of the type, e.g. "[] float")
+ *    symbol   = uvarint(0) bytes  (defines the next symbol)
+ *             | uvarint(n)        (refers to the n-th symbol, n >= 1)
+ *    bytes    = uvarint(length) byte*length
+ *
+ *  Types and field names are symbols, i.e. they are written once in a
+ *  table that is built as they are used, and referred to by indices
+ *  afterwards. Values are encoded according to their types:
+ *
+ *    bool      byte (0 or 1)
+ *    float     float64 (IEEE 754, little endian)
+ *    complex   float64 float64 (real part and imaginary part)
+ *    integer   uvarint (zigzag encoded, of arbitrary length)
+ *    string    bytes (UTF-8)
+ *    binary    bytes
+ *    array     uvarint(n) value*n
+ *    optional  byte (0: nothing) | byte (1: some) value
+ *    record    uvarint(n) (symbol(field name) object)*n
+ *    tuple     uvarint(n) object*n
+ *    enum      object (of the case type)
+ *
+ *  Similar to the text encoding, types of array items and inner values of
+ *  optionals are omitted, since they are the element types of containers.
+ */
+
+const binaryHeader = "KMDB\x01"
+const binaryLengthPreallocMax = 65536
+
+type binarySerializeContext struct {
+	*Serializer
+	output   *bufio.Writer
+	symbols  map[string] uint
+	buf      [binary.MaxVarintLen64] byte
+}
+
+func SerializeBinary(root Object, serializer *Serializer, output io.Writer) error {
+	var ctx = &binarySerializeContext {
+		Serializer: serializer,
+		output:     bufio.NewWriter(output),
+		symbols:    make(map[string] uint),
+	}
+	_, err := ctx.output.WriteString(binaryHeader)
+	if err != nil { return err }
+	err = serializeBinaryObject(root, ctx)
+	if err != nil { return err }
+	return ctx.output.Flush()
+}
+
+func serializeBinaryObject(obj Object, ctx *binarySerializeContext) error {
+	var t = ctx.DetermineType(obj)
+	err := ctx.writeSymbol(t.String())
+	if err != nil { return err }
+	return serializeBinaryValue(obj, t, ctx)
+}
+
+func serializeBinaryValue(obj Object, t *Type, ctx *binarySerializeContext) error {
+	switch t.kind {
+	case Bool:
+		if ctx.WriteBool(obj) {
+			return ctx.output.WriteByte(1)
+		} else {
+			return ctx.output.WriteByte(0)
+		}
+	case Float:
+		val := ctx.WriteFloat(obj)
+		if !(util.IsNormalFloat(val)) {
+			return errors.New("kmd: refused to serialize an abnormal float value")
+		}
+		return ctx.writeFloat(val)
+	case Complex:
+		val := ctx.WriteComplex(obj)
+		if !(util.IsNormalComplex(val)) {
+			return errors.New("kmd: refused to serialize an abnormal complex value")
+		}
+		err := ctx.writeFloat(real(val))
+		if err != nil { return err }
+		return ctx.writeFloat(imag(val))
+	case Integer:
+		return ctx.writeInteger(ctx.WriteInteger(obj))
+	case String:
+		return ctx.writeBytes(([] byte)(ctx.WriteString(obj)))
+	case Binary:
+		return ctx.writeBytes(ctx.WriteBinary(obj))
+	case Array:
+		var n = uint64(0)
+		_ = ctx.IterateArray(obj, func(_ uint, _ Object) error {
+			n += 1
+			return nil
+		})
+		err := ctx.writeUvarint(n)
+		if err != nil { return err }
+		return ctx.IterateArray(obj, func(_ uint, item Object) error {
+			return serializeBinaryValue(item, ctx.DetermineType(item), ctx)
+		})
+	case Optional:
+		var inner, exists = ctx.UnwrapOptional(obj)
+		if exists {
+			err := ctx.output.WriteByte(1)
+			if err != nil { return err }
+			return serializeBinaryValue(inner, ctx.DetermineType(inner), ctx)
+		} else {
+			return ctx.output.WriteByte(0)
+		}
+	case Record:
+		var n = uint64(0)
+		_ = ctx.IterateRecord(obj, func(_ string, _ Object) error {
+			n += 1
+			return nil
+		})
+		err := ctx.writeUvarint(n)
+		if err != nil { return err }
+		return ctx.IterateRecord(obj, func(key string, value Object) error {
+			err := ctx.writeSymbol(key)
+			if err != nil { return err }
+			return serializeBinaryObject(value, ctx)
+		})
+	case Tuple:
+		var n = uint64(0)
+		_ = ctx.IterateTuple(obj, func(_ uint, _ Object) error {
+			n += 1
+			return nil
+		})
+		err := ctx.writeUvarint(n)
+		if err != nil { return err }
+		return ctx.IterateTuple(obj, func(_ uint, element Object) error {
+			return serializeBinaryObject(element, ctx)
+		})
+	case Enum:
+		return serializeBinaryObject(ctx.Enum2Case(obj), ctx)
+	default:
+		panic("impossible branch")
+	}
+}
+
+func (ctx *binarySerializeContext) writeUvarint(n uint64) error {
+	var size = binary.PutUvarint(ctx.buf[:], n)
+	_, err := ctx.output.Write(ctx.buf[:size])
+	return err
+}
+func (ctx *binarySerializeContext) writeBytes(content ([] byte)) error {
+	err := ctx.writeUvarint(uint64(len(content)))
+	if err != nil { return err }
+	_, err = ctx.output.Write(content)
+	return err
+}
+func (ctx *binarySerializeContext) writeSymbol(symbol string) error {
+	var index, exists = ctx.symbols[symbol]
+	if exists {
+		return ctx.writeUvarint(uint64(index))
+	}
+	ctx.symbols[symbol] = uint(len(ctx.symbols) + 1)
+	err := ctx.writeUvarint(0)
+	if err != nil { return err }
+	return ctx.writeBytes(([] byte)(symbol))
+}
+func (ctx *binarySerializeContext) writeFloat(x float64) error {
+	binary.LittleEndian.PutUint64(ctx.buf[:8], math.Float64bits(x))
+	_, err := ctx.output.Write(ctx.buf[:8])
+	return err
+}
+func (ctx *binarySerializeContext) writeInteger(n *big.Int) error {
+	if n.IsInt64() {
+		var x = n.Int64()
+		return ctx.writeUvarint((uint64(x) << 1) ^ uint64(x >> 63))
+	}
+	// zigzag: 2n for n >= 0, -2n-1 for n < 0
+	var z big.Int
+	z.Lsh(n, 1)
+	if n.Sign() < 0 {
+		z.Neg(&z)
+		z.Sub(&z, big.NewInt(1))
+	}
+	var group big.Int
+	var mask = big.NewInt(0x7f)
+	for {
+		group.And(&z, mask)
+		z.Rsh(&z, 7)
+		var b = byte(group.Uint64())
+		if z.Sign() == 0 {
+			return ctx.output.WriteByte(b)
+		}
+		err := ctx.output.WriteByte(b | 0x80)
+		if err != nil { return err }
+	}
+}
+
+
+type binaryDeserializeContext struct {
+	*Deserializer
+	input    *bufio.Reader
+	symbols  [] string
+	types    [] *Type  // parsed types of symbols, nil if not parsed
+}
+
+func createBinaryDeserializeContext(input io.Reader, deserializer *Deserializer) *binaryDeserializeContext {
+	return &binaryDeserializeContext {
+		Deserializer: deserializer,
+		input:        bufio.NewReader(input),
+		symbols:      make([] string, 0),
+		types:        make([] *Type, 0),
+	}
+}
+
+func DeserializeBinary(input io.Reader, deserializer *Deserializer) (Object, *Type, error) {
+	var ctx = createBinaryDeserializeContext(input, deserializer)
+	var header = make([] byte, len(binaryHeader))
+	_, err := io.ReadFull(ctx.input, header)
+	if err != nil { return nil, nil, err }
+	if string(header) != binaryHeader { return nil, nil, errors.New("invalid header") }
+	obj, t, err := deserializeBinaryObject(ctx)
+	if err != nil { return nil, nil, fmt.Errorf("error reading binary data: %w", err) }
+	return obj, t, nil
+}
+
+func deserializeBinaryObject(ctx *binaryDeserializeContext) (Object, *Type, error) {
+	t, err := ctx.readType()
+	if err != nil { return nil, nil, err }
+	obj, err := deserializeBinaryValue(t, ctx)
+	if err != nil { return nil, nil, err }
+	return obj, t, nil
+}
+
+func deserializeBinaryValue(t *Type, ctx *binaryDeserializeContext) (Object, error) {
+	switch t.kind {
+	case Bool:
+		b, err := ctx.input.ReadByte()
+		if err != nil { return nil, err }
+		switch b {
+		case 1:  return ctx.ReadBool(true), nil
+		case 0:  return ctx.ReadBool(false), nil
+		default: return nil, errors.New("invalid bool")
+		}
+	case Float:
+		value, err := ctx.readFloat()
+		if err != nil { return nil, err }
+		if !(util.IsNormalFloat(value)) {
+			return nil, errors.New("invalid float")
+		}
+		return ctx.ReadFloat(value), nil
+	case Complex:
+		re, err := ctx.readFloat()
+		if err != nil { return nil, err }
+		im, err := ctx.readFloat()
+		if err != nil { return nil, err }
+		var value = complex(re, im)
+		if !(util.IsNormalComplex(value)) {
+			return nil, errors.New("invalid complex")
+		}
+		return ctx.ReadComplex(value), nil
+	case Integer:
+		value, err := ctx.readInteger()
+		if err != nil { return nil, err }
+		var obj, fit = ctx.ReadInteger(value)
+		if fit {
+			return obj, nil
+		} else {
+			return nil, errors.New("integer too big")
+		}
+	case String:
+		value, err := ctx.readBytes()
+		if err != nil { return nil, fmt.Errorf("invalid string: %w", err) }
+		return ctx.ReadString(string(value)), nil
+	case Binary:
+		value, err := ctx.readBytes()
+		if err != nil { return nil, fmt.Errorf("invalid binary: %w", err) }
+		return ctx.ReadBinary(value), nil
+	case Array:
+		n, err := binary.ReadUvarint(ctx.input)
+		if err != nil { return nil, err }
+		var array = ctx.CreateArray(t)
+		for i := uint64(0); i < n; i += 1 {
+			item, err := deserializeBinaryValue(t.elementType, ctx)
+			if err != nil { return nil, err }
+			ctx.AppendItem(&array, item)
+		}
+		return array, nil
+	case Optional:
+		b, err := ctx.input.ReadByte()
+		if err != nil { return nil, err }
+		switch b {
+		case 1:
+			inner, err := deserializeBinaryValue(t.elementType, ctx)
+			if err != nil { return nil, err }
+			return ctx.Some(inner, t), nil
+		case 0:
+			return ctx.Nothing(t), nil
+		default:
+			return nil, errors.New("invalid optional")
+		}
+	case Record:
+		schema, err := ctx.GetRecordSchema(t.identifier)
+		if err != nil { return nil, err }
+		n, err := binary.ReadUvarint(ctx.input)
+		if err != nil { return nil, err }
+		var entries = make(map[string] Object)
+		var types = make(map[string] *Type)
+		var d = ctx.Deserializer
+		for i := uint64(0); i < n; i += 1 {
+			key, err := ctx.readSymbol()
+			if err != nil { return nil, err }
+			ctx.Deserializer = fieldDeserializer(d, schema, key)
+			value, value_t, err := deserializeBinaryObject(ctx)
+			ctx.Deserializer = d
+			if err != nil { return nil, err }
+			var _, exists = entries[key]
+			if exists { return nil, errors.New(fmt.Sprintf(
+				"duplicate field %s", key))}
+			entries[key] = value
+			types[key] = value_t
+		}
+		return buildRecord(ctx.Deserializer, t.identifier, schema, entries, types)
+	case Tuple:
+		n, err := binary.ReadUvarint(ctx.input)
+		if err != nil { return nil, err }
+		var elements = make([] Object, 0)
+		var types = make([] *Type, 0)
+		for i := uint64(0); i < n; i += 1 {
+			value, value_t, err := deserializeBinaryObject(ctx)
+			if err != nil { return nil, err }
+			elements = append(elements, value)
+			types = append(types, value_t)
+		}
+		return buildTuple(ctx.Deserializer, t.identifier, elements, types)
+	case Enum:
+		case_value, case_t, err := deserializeBinaryObject(ctx)
+		if err != nil { return nil, err }
+		if case_t.kind != Record && case_t.kind != Tuple && case_t.kind != Enum {
+			return nil, errors.New(fmt.Sprintf("invalid case type: %s", case_t))
+		}
+		return ctx.Case2Enum(case_value, t.identifier, case_t.identifier)
+	default:
+		panic("impossible branch")
+	}
+}
+
+func (ctx *binaryDeserializeContext) readBytes() (([] byte), error) {
+	n, err := binary.ReadUvarint(ctx.input)
+	if err != nil { return nil, err }
+	if n <= binaryLengthPreallocMax {
+		var buf = make([] byte, n)
+		_, err := io.ReadFull(ctx.input, buf)
+		if err != nil { return nil, err }
+		return buf, nil
+	} else {
+		// the length is not trusted until the content is read
+		buf, err := ioutil.ReadAll(io.LimitReader(ctx.input, int64(n)))
+		if err != nil { return nil, err }
+		if uint64(len(buf)) != n { return nil, io.ErrUnexpectedEOF }
+		return buf, nil
+	}
+}
+func (ctx *binaryDeserializeContext) readSymbol() (string, error) {
+	index, err := ctx.readSymbolIndex()
+	if err != nil { return "", err }
+	return ctx.symbols[index], nil
+}
+func (ctx *binaryDeserializeContext) readSymbolIndex() (uint, error) {
+	n, err := binary.ReadUvarint(ctx.input)
+	if err != nil { return 0, err }
+	if n == 0 {
+		content, err := ctx.readBytes()
+		if err != nil { return 0, err }
+		ctx.symbols = append(ctx.symbols, string(content))
+		ctx.types = append(ctx.types, nil)
+		return uint(len(ctx.symbols) - 1), nil
+	}
+	if n > uint64(len(ctx.symbols)) {
+		return 0, errors.New(fmt.Sprintf("invalid symbol reference: %d", n))
+	}
+	return uint(n - 1), nil
+}
+func (ctx *binaryDeserializeContext) readType() (*Type, error) {
+	index, err := ctx.readSymbolIndex()
+	if err != nil { return nil, err }
+	var t = ctx.types[index]
+	if t == nil {
+		var text = ctx.symbols[index]
+		var parsed, ok = TypeParse(text)
+		if !(ok) { return nil, errors.New(fmt.Sprintf("invalid type: %s", text)) }
+		ctx.types[index] = parsed
+		t = parsed
+	}
+	return t, nil
+}
+func (ctx *binaryDeserializeContext) readFloat() (float64, error) {
+	var buf [8] byte
+	_, err := io.ReadFull(ctx.input, buf[:])
+	if err != nil { return 0, err }
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf[:])), nil
+}
+func (ctx *binaryDeserializeContext) readInteger() (*big.Int, error) {
+	var groups = make([] byte, 0, binary.MaxVarintLen64)
+	for {
+		b, err := ctx.input.ReadByte()
+		if err != nil { return nil, err }
+		groups = append(groups, b)
+		if b < 0x80 { break }
+	}
+	var z, size = binary.Uvarint(groups)
+	if size > 0 {
+		var x = (int64(z >> 1) ^ -int64(z & 1))
+		return big.NewInt(x), nil
+	}
+	var big_z big.Int
+	for i := (len(groups) - 1); i >= 0; i -= 1 {
+		big_z.Lsh(&big_z, 7)
+		big_z.Or(&big_z, big.NewInt(int64(groups[i] & 0x7f)))
+	}
+	var n big.Int
+	if big_z.Bit(0) == 0 {
+		n.Rsh(&big_z, 1)
+	} else {
+		n.Add(&big_z, big.NewInt(1))
+		n.Rsh(&n, 1)
+		n.Neg(&n)
+	}
+	return &n, nil
+}
@@ -0,0 +1,145 @@
+package kmd
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"math/big"
+)
+
+
+type Numbers struct {
+	Integers   [] *big.Int     `kmd:"integers"`
+	Floats     [] float64      `kmd:"floats"`
+	Complexes  [] complex128   `kmd:"complexes"`
+	Content    [] byte         `kmd:"content"`
+	Text       string          `kmd:"text"`
+	Flag       bool            `kmd:"flag"`
+}
+
+var numbersOptions = GoStructOptions {
+	IntegerKind: BigInt,
+	StringKind:  GoString,
+	Types: map[TypeId] reflect.Type {
+		TheTypeId("kmd.test", "go", "Numbers", "v1"): reflect.TypeOf(Numbers {}),
+	},
+}
+
+func sampleNumbers() Numbers {
+	var huge, _ = new(big.Int).SetString("123456789012345678901234567890", 10)
+	var integers = [] *big.Int {
+		big.NewInt(0), big.NewInt(1), big.NewInt(-1), big.NewInt(64), big.NewInt(-65),
+		big.NewInt(9223372036854775807), big.NewInt(-9223372036854775808),
+		new(big.Int).Lsh(big.NewInt(1), 63),
+		new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), 63)),
+		new(big.Int).Sub(new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), 63)), big.NewInt(1)),
+		huge, new(big.Int).Neg(huge),
+	}
+	var floats = make([] float64, 1000)
+	for i := range floats {
+		floats[i] = (float64(i) * 0.5)
+	}
+	return Numbers {
+		Integers:  integers,
+		Floats:    floats,
+		Complexes: [] complex128 { complex(1.5, -2), complex(0, 0) },
+		Content:   ([] byte)("\x00\x01\x02binary\xff"),
+		Text:      "文字 \"quoted\"\n",
+		Flag:      true,
+	}
+}
+
+func testBinaryRoundTrip(t *testing.T, ts Transformer, obj Object) ([] byte) {
+	var bin bytes.Buffer
+	var err = SerializeBinary(obj, ts.Serializer, &bin)
+	if err != nil { t.Fatal(err) }
+	from_bin, bin_t, err := DeserializeBinary(bytes.NewReader(bin.Bytes()), ts.Deserializer)
+	if err != nil { t.Fatal(err) }
+	var text strings.Builder
+	err = Serialize(obj, ts.Serializer, &text)
+	if err != nil { t.Fatal(err) }
+	from_text, text_t, err := Deserialize(strings.NewReader(text.String()), ts.Deserializer)
+	if err != nil { t.Fatal(err) }
+	if !(TypeEqual(bin_t, text_t)) {
+		t.Fatalf("type mismatch: %s (binary) and %s (text)", bin_t, text_t)
+	}
+	if !(reflect.DeepEqual(from_bin, from_text)) {
+		t.Fatalf("round trip mismatch:\n%+v (binary)\n%+v (text)", from_bin, from_text)
+	}
+	return bin.Bytes()
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	var ts = CreateGoStructTransformer(sampleOptions)
+	testBinaryRoundTrip(t, ts, sampleObject)
+	var ts_tuple = CreateGoStructTransformer(optionalAndTupleOptions)
+	var shapes = [] Shape { Circle { Radius: 1 }, Point { Name: "P" } }
+	for _, label := range [] MaybeLabel { Label { Text: "L" }, nil } {
+		testBinaryRoundTrip(t, ts_tuple, Pair {
+			Key:   "k",
+			Value: Marker { Label: label, Shapes: shapes },
+		})
+	}
+	var ts_numbers = CreateGoStructTransformer(numbersOptions)
+	var numbers = sampleNumbers()
+	testBinaryRoundTrip(t, ts_numbers, numbers)
+	var empty = Numbers {
+		Integers:  [] *big.Int {},
+		Floats:    [] float64 {},
+		Complexes: [] complex128 {},
+		Content:   [] byte {},
+	}
+	testBinaryRoundTrip(t, ts_numbers, empty)
+}
+
+func TestBinarySize(t *testing.T) {
+	var ts = CreateGoStructTransformer(numbersOptions)
+	var numbers = sampleNumbers()
+	var bin = testBinaryRoundTrip(t, ts, numbers)
+	var text strings.Builder
+	var err = Serialize(numbers, ts.Serializer, &text)
+	if err != nil { t.Fatal(err) }
+	if !(len(bin) < len(text.String())) {
+		t.Fatalf("binary encoding is not compact: %d bytes (text: %d bytes)",
+			len(bin), len(text.String()))
+	}
+	// types and field names are written only once
+	var points = make([] Point, 100)
+	var ts_points = CreateGoStructTransformer(sampleOptions)
+	var points_bin = testBinaryRoundTrip(t, ts_points, PointGroup { Points: points })
+	if bytes.Count(points_bin, ([] byte)("kmd.test.go.Vector")) != 1 ||
+		bytes.Count(points_bin, ([] byte)("name")) != 1 {
+		t.Fatalf("symbols written more than once: %q", points_bin)
+	}
+}
+
+func TestBinaryInvalid(t *testing.T) {
+	var ts = CreateGoStructTransformer(sampleOptions)
+	var bin bytes.Buffer
+	var err = SerializeBinary(sampleObject, ts.Serializer, &bin)
+	if err != nil { t.Fatal(err) }
+	var data = bin.Bytes()
+	for i := 0; i < len(data); i += 1 {
+		var _, _, err = DeserializeBinary(bytes.NewReader(data[:i]), ts.Deserializer)
+		if err == nil {
+			t.Fatalf("truncated data (%d/%d bytes) accepted", i, len(data))
+		}
+	}
+	var text strings.Builder
+	err = Serialize(sampleObject, ts.Serializer, &text)
+	if err != nil { t.Fatal(err) }
+	_, _, err = DeserializeBinary(strings.NewReader(text.String()), ts.Deserializer)
+	if err == nil || err.Error() != "invalid header" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var ts_numbers = CreateGoStructTransformer(numbersOptions)
+	// a string of 4 GiB without content
+	var oversized = append(([] byte)(binaryHeader), 0, 6)
+	oversized = append(oversized, "string"...)
+	oversized = append(oversized, 0xff, 0xff, 0xff, 0xff, 0x0f)
+	_, _, err = DeserializeBinary(bytes.NewReader(oversized), ts_numbers.Deserializer)
+	if err == nil {
+		t.Fatal("invalid data accepted")
+	}
+}
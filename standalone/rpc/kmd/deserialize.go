@@ -18,6 +18,7 @@ type deserializeContext struct {
 	*Deserializer
 	Depth        uint
 	RequireKey   bool
+	Record       *RecordSchema  // available if RequireKey
 	ReturnKey    *string
 	ReturnType   **Type
 	TypesInfo    *([] omittedTypeInfo)
@@ -106,6 +107,9 @@ func deserialize(input *deserializeReader, ctx deserializeContext) (Object, erro
 		if key == "" { panic("something went wrong") }
 		*ctx.ReturnKey = key
 	}
+	if ctx.Record != nil {
+		ctx.Deserializer = fieldDeserializer(ctx.Deserializer, *ctx.Record, key)
+	}
 	if ctx.ReturnType != nil {
 		*ctx.ReturnType = t
 	}
@@ -221,6 +225,8 @@ func deserialize(input *deserializeReader, ctx deserializeContext) (Object, erro
 			return nil, errors.New("wrong indention")
 		}
 	case Record:
+		schema, err := ctx.GetRecordSchema(t.identifier)
+		if err != nil { return nil, err }
 		var entries = make(map[string] Object)
 		var types = make(map[string] *Type)
 		for {
@@ -233,6 +239,7 @@ func deserialize(input *deserializeReader, ctx deserializeContext) (Object, erro
 					Deserializer: ctx.Deserializer,
 					Depth:        (ctx.Depth + 1),
 					RequireKey:   true,
+					Record:       &schema,
 					ReturnKey:    &key,
 					ReturnType:   &value_t,
 					TypesInfo:    ctx.TypesInfo,
@@ -247,21 +254,7 @@ func deserialize(input *deserializeReader, ctx deserializeContext) (Object, erro
 				types[key] = value_t
 			} else if n <= ctx.Depth {
 				unreadIndent(input, n)
-				var tid = t.identifier
-				err := ctx.CheckRecord(tid, uint(len(entries)))
-				if err != nil { return nil, err }
-				var draft = ctx.CreateRecord(tid)
-				for key, value := range entries {
-					var value_t = types[key]
-					field_t, field_index, err := ctx.GetFieldInfo(tid, key)
-					if err != nil { return nil, err }
-					adapted, err := ctx.AssignObject(value, value_t, field_t)
-					if err != nil { return nil, err }
-					ctx.FillField(draft, field_index, adapted)
-				}
-				record, err := ctx.FinishRecord(draft, tid)
-				if err != nil { return nil, err }
-				return record, nil
+				return buildRecord(ctx.Deserializer, t.identifier, schema, entries, types)
 			} else {
 				return nil, errors.New("wrong indention")
 			}
@@ -287,20 +280,7 @@ func deserialize(input *deserializeReader, ctx deserializeContext) (Object, erro
 				types = append(types, value_t)
 			} else if n <= ctx.Depth {
 				unreadIndent(input, n)
-				var tid = t.identifier
-				err := ctx.CheckTuple(tid, uint(len(elements)))
-				if err != nil { return nil, err }
-				var draft = ctx.CreateTuple(tid)
-				for i, value := range elements {
-					var value_t = types[i]
-					el_t := ctx.GetElementType(tid, uint(i))
-					adapted, err := ctx.AssignObject(value, value_t, el_t)
-					if err != nil { return nil, err }
-					ctx.FillElement(draft, uint(i), adapted)
-				}
-				tuple, err := ctx.FinishTuple(draft, tid)
-				if err != nil { return nil, err }
-				return tuple, nil
+				return buildTuple(ctx.Deserializer, t.identifier, elements, types)
 			} else {
 				return nil, errors.New("wrong indention")
 			}
@@ -332,6 +312,44 @@ func deserialize(input *deserializeReader, ctx deserializeContext) (Object, erro
 	}
 }
 
+func buildRecord(d *Deserializer, tid TypeId, schema RecordSchema, entries (map[string] Object), types (map[string] *Type)) (Object, error) {
+	var draft = d.CreateRecord(tid)
+	for key, value := range entries {
+		var field, exists = schema.Fields[key]
+		if !(exists) {
+			if schema.AllowUnknown { continue }
+			return nil, errors.New(fmt.Sprintf(
+				"field %s does not exist on type %s", key, tid))
+		}
+		var value_t = types[key]
+		adapted, err := d.AssignObject(value, value_t, field.Type)
+		if err != nil { return nil, err }
+		d.FillField(draft, field.Index, adapted)
+	}
+	for name, field := range schema.Fields {
+		var _, given = entries[name]
+		if given { continue }
+		value, err := field.DefaultValue(d)
+		if err != nil { return nil, fmt.Errorf(
+			"field %s of type %s: %w", name, tid, err) }
+		d.FillField(draft, field.Index, value)
+	}
+	return d.FinishRecord(draft, tid)
+}
+func buildTuple(d *Deserializer, tid TypeId, elements ([] Object), types ([] *Type)) (Object, error) {
+	err := d.CheckTuple(tid, uint(len(elements)))
+	if err != nil { return nil, err }
+	var draft = d.CreateTuple(tid)
+	for i, value := range elements {
+		var value_t = types[i]
+		el_t := d.GetElementType(tid, uint(i))
+		adapted, err := d.AssignObject(value, value_t, el_t)
+		if err != nil { return nil, err }
+		d.FillElement(draft, uint(i), adapted)
+	}
+	return d.FinishTuple(draft, tid)
+}
+
 func readIndent(input *deserializeReader) (uint, error) {
 	var n = uint(0) + input.unreadIndention
 	input.unreadIndention = 0
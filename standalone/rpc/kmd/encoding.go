@@ -0,0 +1,40 @@
+package kmd
+
+import "io"
+
+
+type Encoding uint
+const (
+	TextEncoding Encoding = iota
+	BinaryEncoding
+)
+
+func ParseEncoding(name string) (Encoding, bool) {
+	switch name {
+	case "text":   return TextEncoding, true
+	case "binary": return BinaryEncoding, true
+	default:       return TextEncoding, false
+	}
+}
+func (enc Encoding) String() string {
+	switch enc {
+	case TextEncoding:   return "text"
+	case BinaryEncoding: return "binary"
+	default:             panic("impossible branch")
+	}
+}
+
+func (enc Encoding) Serialize(root Object, serializer *Serializer, output io.Writer) error {
+	switch enc {
+	case TextEncoding:   return Serialize(root, serializer, output)
+	case BinaryEncoding: return SerializeBinary(root, serializer, output)
+	default:             panic("impossible branch")
+	}
+}
+func (enc Encoding) Deserialize(input io.Reader, deserializer *Deserializer) (Object, *Type, error) {
+	switch enc {
+	case TextEncoding:   return Deserialize(input, deserializer)
+	case BinaryEncoding: return DeserializeBinary(input, deserializer)
+	default:             panic("impossible branch")
+	}
+}
@@ -0,0 +1,202 @@
+package kmd
+
+import (
+	"fmt"
+	"sort"
+	"errors"
+	"strings"
+	"math/big"
+	"encoding/json"
+	"encoding/base64"
+	"kumachan/standalone/util"
+)
+
+
+/**
+ *  Schema Evolution
+ *
+ *  A record type may be changed without changing its version, as long as
+ *  the change is compatible in both directions, which is declared in the
+ *  schema of the record type:
+ *
+ *    - An optional field may be missing in serialized data, in which case
+ *      the default value of the field is used. Therefore an optional field
+ *      can be added to a record type without breaking older senders.
+ *      In KumaChan, a field is made optional by the tag "# optional"
+ *      (for Maybe and List fields) or "# default: <JSON literal>".
+ *      In Go, the struct tag kmd_default is used (see gostruct.go).
+ *    - If unknown fields are allowed, fields that do not exist in the
+ *      schema are skipped (without checking their types). Therefore a
+ *      field can be added to (or removed from) the sender before all the
+ *      receivers are upgraded.
+ *
+ *  Incompatible changes are made by creating a new version of the type,
+ *  together with an adapter from the old version to the new one.
+ *  Adapters are chained automatically, e.g. data of v1 is assignable to
+ *  v3 if there are adapters v1 -> v2 and v2 -> v3. The shortest chain is
+ *  chosen if there are multiple chains.
+ */
+
+// DefaultValue returns the value of the field when it is missing.
+// The default value of an optional type is Nothing, and the default
+// value of an array type is the empty array, if not specified.
+func (field RecordField) DefaultValue(d *Deserializer) (Object, error) {
+	if !(field.Optional) {
+		return nil, errors.New("missing field")
+	}
+	if field.Default != nil {
+		return field.Default, nil
+	}
+	if field.DefaultLiteral != "" {
+		return parseDefaultLiteral(field.DefaultLiteral, field.Type, d)
+	}
+	switch field.Type.kind {
+	case Optional:
+		return d.Nothing(field.Type), nil
+	case Array:
+		return d.CreateArray(field.Type), nil
+	default:
+		return nil, errors.New(fmt.Sprintf(
+			"missing field (default value of type %s not specified)", field.Type))
+	}
+}
+
+// ValidateDefaultLiteral checks if the JSON literal is a valid default value
+// of the specified type. Only primitive types can have default literals.
+func ValidateDefaultLiteral(text string, t *Type) error {
+	var _, err = parseDefaultLiteral(text, t, discardDeserializer)
+	return err
+}
+func parseDefaultLiteral(text string, t *Type, d *Deserializer) (Object, error) {
+	var value, err = decodeDefaultLiteral(text)
+	if err != nil { return nil, err }
+	var invalid = func() (Object, error) {
+		return nil, errors.New(fmt.Sprintf(
+			"%s is not a valid value of type %s", text, t))
+	}
+	switch t.kind {
+	case Bool:
+		var b, ok = value.(bool)
+		if !(ok) { return invalid() }
+		return d.ReadBool(b), nil
+	case Float:
+		var x, ok = jsonFloat(value)
+		if !(ok) || !(util.IsNormalFloat(x)) { return invalid() }
+		return d.ReadFloat(x), nil
+	case Integer:
+		var str, ok = value.(json.Number)
+		if !(ok) { return invalid() }
+		var n, is_int = new(big.Int).SetString(string(str), 10)
+		if !(is_int) { return invalid() }
+		obj, ok := d.ReadInteger(n)
+		if !(ok) { return invalid() }
+		return obj, nil
+	case String:
+		var str, ok = value.(string)
+		if !(ok) { return invalid() }
+		return d.ReadString(str), nil
+	case Binary:
+		var str, ok = value.(string)
+		if !(ok) { return invalid() }
+		var bin, err = base64.StdEncoding.DecodeString(str)
+		if err != nil { return invalid() }
+		return d.ReadBinary(bin), nil
+	default:
+		return nil, errors.New(fmt.Sprintf(
+			"default value of type %s cannot be specified", t))
+	}
+}
+func decodeDefaultLiteral(text string) (interface{}, error) {
+	if !(json.Valid(([] byte)(text))) { return nil, errors.New(fmt.Sprintf(
+		"%s is not a valid JSON literal", text)) }
+	var dec = json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	var value interface{}
+	var err = dec.Decode(&value)
+	if err != nil { return nil, err }
+	return value, nil
+}
+
+// discardDeserializer is used to read values of unknown fields,
+// which accepts any well-formed data and produces nothing.
+var discardDeserializer = &Deserializer {
+	PrimitiveDeserializer: PrimitiveDeserializer {
+		ReadBool:    func(bool) Object { return nil },
+		ReadFloat:   func(float64) Object { return nil },
+		ReadComplex: func(complex128) Object { return nil },
+		ReadInteger: func(*big.Int) (Object, bool) { return nil, true },
+		ReadString:  func(string) Object { return nil },
+		ReadBinary:  func([] byte) Object { return nil },
+	},
+	ContainerDeserializer: ContainerDeserializer {
+		CreateArray: func(*Type) Object { return nil },
+		AppendItem:  func(*Object, Object) {},
+		Some:        func(Object, *Type) Object { return nil },
+		Nothing:     func(*Type) Object { return nil },
+	},
+	AlgebraicDeserializer: AlgebraicDeserializer {
+		AssignObject:    func(Object, *Type, *Type) (Object, error) { return nil, nil },
+		GetRecordSchema: func(TypeId) (RecordSchema, error) {
+			return RecordSchema { AllowUnknown: true }, nil
+		},
+		CreateRecord:    func(TypeId) Object { return nil },
+		FillField:       func(Object, uint, Object) {},
+		FinishRecord:    func(Object, TypeId) (Object, error) { return nil, nil },
+		CheckTuple:      func(TypeId, uint) error { return nil },
+		GetElementType:  func(TypeId, uint) *Type { return nil },
+		CreateTuple:     func(TypeId) Object { return nil },
+		FillElement:     func(Object, uint, Object) {},
+		FinishTuple:     func(Object, TypeId) (Object, error) { return nil, nil },
+		Case2Enum:       func(Object, TypeId, TypeId) (Object, error) { return nil, nil },
+	},
+}
+func fieldDeserializer(d *Deserializer, schema RecordSchema, field string) *Deserializer {
+	var _, exists = schema.Fields[field]
+	if !(exists) && schema.AllowUnknown {
+		return discardDeserializer
+	} else {
+		return d
+	}
+}
+
+// AdapterIndex finds chains of adapters.
+type AdapterIndex struct {
+	targets  map[TypeId] ([] TypeId)
+}
+func CreateAdapterIndex(adapters ([] AdapterId)) AdapterIndex {
+	var targets = make(map[TypeId] ([] TypeId))
+	for _, id := range adapters {
+		targets[id.From] = append(targets[id.From], id.To)
+	}
+	for _, list := range targets {
+		sort.Slice(list, func(i, j int) bool {
+			return (list[i].String() < list[j].String())
+		})
+	}
+	return AdapterIndex { targets: targets }
+}
+func (index AdapterIndex) FindChain(from TypeId, to TypeId) (([] AdapterId), bool) {
+	var prev = map[TypeId] TypeId { from: from }
+	var queue = [] TypeId { from }
+	for len(queue) > 0 {
+		var current = queue[0]
+		queue = queue[1:]
+		if current == to {
+			var chain = make([] AdapterId, 0)
+			for current != from {
+				var p = prev[current]
+				chain = append([] AdapterId { { From: p, To: current } }, chain...)
+				current = p
+			}
+			return chain, (len(chain) > 0)
+		}
+		for _, next := range index.targets[current] {
+			var _, visited = prev[next]
+			if !(visited) {
+				prev[next] = current
+				queue = append(queue, next)
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,253 @@
+package kmd
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"math/big"
+	"encoding/json"
+)
+
+
+type ProfileSent struct {
+	Name   string   `kmd:"name"`
+	Extra  Circle   `kmd:"extra"`
+}
+type Profile struct {
+	_       struct{}     `kmd_allow_unknown:""`
+	Name    string       `kmd:"name"`
+	Age     *big.Int     `kmd:"age" kmd_default:"18"`
+	Score   float64      `kmd:"score" kmd_default:"0.5"`
+	Active  bool         `kmd:"active" kmd_default:"true"`
+	Nick    MaybeLabel   `kmd:"nick" kmd_default:""`
+	Tags    [] string    `kmd:"tags" kmd_default:""`
+}
+type ProfileStrict struct {
+	Name   string   `kmd:"name"`
+}
+type ProfileRequired struct {
+	_      struct{}   `kmd_allow_unknown:""`
+	Name   string     `kmd:"name"`
+	Email  string     `kmd:"email"`
+}
+type ProfileInvalidDefault struct {
+	Name  string     `kmd:"name"`
+	Age   *big.Int   `kmd:"age" kmd_default:"eighteen"`
+}
+
+var profileId = TheTypeId("kmd.test", "go", "Profile", "v1")
+func profileOptions(profile reflect.Type) GoStructOptions {
+	return GoStructOptions {
+		IntegerKind: BigInt,
+		StringKind:  GoString,
+		Types: map[TypeId] reflect.Type {
+			profileId: profile,
+			TheTypeId("kmd.test", "go", "Vector", "v1"): reflect.TypeOf(Vector {}),
+			TheTypeId("kmd.test", "go", "Circle", "v1"): reflect.TypeOf(Circle {}),
+			TheTypeId("kmd.test", "go", "Label", "v1"): reflect.TypeOf(Label {}),
+		},
+	}
+}
+
+func testEvolution(t *testing.T, obj Object, from Transformer, to Transformer) ([] Object, ([] error)) {
+	var results = make([] Object, 0)
+	var errs = make([] error, 0)
+	for _, enc := range [] Encoding { TextEncoding, BinaryEncoding } {
+		var buf bytes.Buffer
+		var err = enc.Serialize(obj, from.Serializer, &buf)
+		if err != nil { t.Fatal(err) }
+		result, _, err := enc.Deserialize(&buf, to.Deserializer)
+		results = append(results, result)
+		errs = append(errs, err)
+	}
+	return results, errs
+}
+
+func TestEvolutionOptionalAndUnknown(t *testing.T) {
+	var sender = CreateGoStructTransformer(profileOptions(reflect.TypeOf(ProfileSent {})))
+	var receiver = CreateGoStructTransformer(profileOptions(reflect.TypeOf(Profile {})))
+	var sent = ProfileSent {
+		Name:  "Alice",
+		Extra: Circle { Center: Vector { X: 1, Y: 2 }, Radius: 3 },
+	}
+	var expected = Profile {
+		Name:   "Alice",
+		Age:    big.NewInt(18),
+		Score:  0.5,
+		Active: true,
+		Tags:   [] string {},
+	}
+	var results, errs = testEvolution(t, sent, sender, receiver)
+	for i, result := range results {
+		if errs[i] != nil { t.Fatal(errs[i]) }
+		if !(reflect.DeepEqual(result, expected)) {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+	}
+	var full = Profile {
+		Name:   "Bob",
+		Age:    big.NewInt(30),
+		Nick:   Label { Text: "B" },
+		Tags:   [] string { "x" },
+	}
+	results, errs = testEvolution(t, full, receiver, receiver)
+	for i, result := range results {
+		if errs[i] != nil { t.Fatal(errs[i]) }
+		if !(reflect.DeepEqual(result, full)) {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+	}
+}
+
+func TestEvolutionRejected(t *testing.T) {
+	var sender = CreateGoStructTransformer(profileOptions(reflect.TypeOf(ProfileSent {})))
+	var sent = ProfileSent { Name: "Alice" }
+	for _, item := range [] struct { Type reflect.Type; Error string } {
+		{ Type: reflect.TypeOf(ProfileStrict {}), Error: "field extra does not exist" },
+		{ Type: reflect.TypeOf(ProfileRequired {}), Error: "missing field" },
+		{ Type: reflect.TypeOf(ProfileInvalidDefault {}), Error: "invalid default value" },
+	} {
+		var receiver = CreateGoStructTransformer(profileOptions(item.Type))
+		var _, errs = testEvolution(t, sent, sender, receiver)
+		for _, err := range errs {
+			if err == nil || !(strings.Contains(err.Error(), item.Error)) {
+				t.Fatalf("unexpected error for %s: %v", item.Type, err)
+			}
+		}
+	}
+}
+
+
+type Vec1 struct {
+	X  float64   `kmd:"x"`
+}
+type Vec2 struct {
+	X  float64   `kmd:"x"`
+	Y  float64   `kmd:"y"`
+}
+type Vec3 struct {
+	X  float64   `kmd:"x"`
+	Y  float64   `kmd:"y"`
+	Z  float64   `kmd:"z"`
+}
+type Holder1 struct {
+	Vec  Vec1   `kmd:"vec"`
+}
+type Holder3 struct {
+	Vec  Vec3   `kmd:"vec"`
+}
+
+var vecId1 = TheTypeId("kmd.test", "go", "Vec", "v1")
+var vecId2 = TheTypeId("kmd.test", "go", "Vec", "v2")
+var vecId3 = TheTypeId("kmd.test", "go", "Vec", "v3")
+var holderId = TheTypeId("kmd.test", "go", "Holder", "v1")
+
+func TestEvolutionAdapterChain(t *testing.T) {
+	var sender = CreateGoStructTransformer(GoStructOptions {
+		Types: map[TypeId] reflect.Type {
+			vecId1:   reflect.TypeOf(Vec1 {}),
+			holderId: reflect.TypeOf(Holder1 {}),
+		},
+	})
+	var receiver = CreateGoStructTransformer(GoStructOptions {
+		Types: map[TypeId] reflect.Type {
+			vecId1:   reflect.TypeOf(Vec1 {}),
+			vecId2:   reflect.TypeOf(Vec2 {}),
+			vecId3:   reflect.TypeOf(Vec3 {}),
+			holderId: reflect.TypeOf(Holder3 {}),
+		},
+		GoStructDeserializerOptions: GoStructDeserializerOptions {
+			Adapters: map[AdapterId] (func(Object) Object) {
+				{ From: vecId1, To: vecId2 }: func(obj Object) Object {
+					return Vec2 { X: obj.(Vec1).X, Y: -1 }
+				},
+				{ From: vecId2, To: vecId3 }: func(obj Object) Object {
+					var v = obj.(Vec2)
+					return Vec3 { X: v.X, Y: v.Y, Z: -2 }
+				},
+			},
+		},
+	})
+	var results, errs = testEvolution(t, Holder1 { Vec: Vec1 { X: 7 } }, sender, receiver)
+	var expected = Holder3 { Vec: Vec3 { X: 7, Y: -1, Z: -2 } }
+	for i, result := range results {
+		if errs[i] != nil { t.Fatal(errs[i]) }
+		if !(reflect.DeepEqual(result, expected)) {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+	}
+}
+
+func TestFindAdapterChain(t *testing.T) {
+	var vecId4 = TheTypeId("kmd.test", "go", "Vec", "v4")
+	var index = CreateAdapterIndex([] AdapterId {
+		{ From: vecId1, To: vecId2 },
+		{ From: vecId2, To: vecId3 },
+		{ From: vecId3, To: vecId4 },
+		{ From: vecId2, To: vecId4 },
+		{ From: vecId4, To: vecId1 },
+	})
+	var chain, ok = index.FindChain(vecId1, vecId4)
+	if !(ok) || !(reflect.DeepEqual(chain, [] AdapterId {
+		{ From: vecId1, To: vecId2 },
+		{ From: vecId2, To: vecId4 },
+	})) {
+		t.Fatalf("unexpected chain: %v", chain)
+	}
+	chain, ok = index.FindChain(vecId3, vecId2)
+	if !(ok) || len(chain) != 3 {
+		t.Fatalf("unexpected chain: %v", chain)
+	}
+	_, ok = index.FindChain(vecId1, vecId1)
+	if ok { t.Fatal("chain from a type to itself found") }
+	_, ok = index.FindChain(vecId1, holderId)
+	if ok { t.Fatal("chain to an unrelated type found") }
+}
+
+func TestDefaultLiteral(t *testing.T) {
+	var d = CreateGoStructTransformer(profileOptions(reflect.TypeOf(Profile {}))).Deserializer
+	for _, item := range [] struct { Literal string; Type *Type; Value Object } {
+		{ Literal: "true", Type: PrimitiveType(Bool), Value: true },
+		{ Literal: "0.25", Type: PrimitiveType(Float), Value: 0.25 },
+		{ Literal: "-7", Type: PrimitiveType(Integer), Value: big.NewInt(-7) },
+		{ Literal: `"anonymous"`, Type: PrimitiveType(String), Value: "anonymous" },
+		{ Literal: `""`, Type: PrimitiveType(String), Value: "" },
+		{ Literal: `"AQI="`, Type: PrimitiveType(Binary), Value: [] byte { 1, 2 } },
+	} {
+		var field = RecordField {
+			Type:           item.Type,
+			Optional:       true,
+			DefaultLiteral: item.Literal,
+		}
+		var value, err = field.DefaultValue(d)
+		if err != nil { t.Fatal(err) }
+		if !(reflect.DeepEqual(value, item.Value)) {
+			t.Fatalf("unexpected default value of %s: %v", item.Literal, value)
+		}
+	}
+	for _, item := range [] struct { Literal string; Type *Type } {
+		{ Literal: "1.5", Type: PrimitiveType(Integer) },
+		{ Literal: "1 2", Type: PrimitiveType(Integer) },
+		{ Literal: "anonymous", Type: PrimitiveType(String) },
+		{ Literal: "null", Type: PrimitiveType(Bool) },
+		{ Literal: "[]", Type: ContainerType(Array, PrimitiveType(String)) },
+	} {
+		var err = ValidateDefaultLiteral(item.Literal, item.Type)
+		if err == nil {
+			t.Fatalf("invalid default value %s accepted", item.Literal)
+		}
+	}
+	var sch = SchemaTable {
+		profileId: RecordSchema { Fields: map[string] RecordField {
+			"age": { Type: PrimitiveType(Integer), Index: 0,
+				Optional: true, DefaultLiteral: "18" },
+		} },
+	}
+	var ts = CreateJsonTransformer(sch)
+	var obj, err = JsonFormat.Decode(([] byte)("{}"), AlgebraicType(Record, profileId), ts.Deserializer, sch)
+	if err != nil { t.Fatal(err) }
+	if !(reflect.DeepEqual(obj, map[string] interface{} { "age": json.Number("18") })) {
+		t.Fatalf("unexpected result: %#v", obj)
+	}
+}
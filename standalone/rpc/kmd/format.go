@@ -0,0 +1,95 @@
+package kmd
+
+import (
+	"bytes"
+	"errors"
+	"encoding/json"
+)
+
+
+/**
+ *  Data Formats
+ *
+ *  Besides KMD, objects can be encoded in data formats widely used by
+ *  other systems. These formats do not carry type information, so values
+ *  are converted according to the schema table (see JSON Representation
+ *  in json.go), and the required type must be given when decoding.
+ *
+ *    JSON         plain JSON values
+ *    MessagePack  same structure as JSON, except that integers are written
+ *                 as integers (or strings of decimal digits if beyond 64
+ *                 bits) and binary values are written as bin.
+ *
+ *  Objects are converted from and to plain values through the JSON
+ *  transformer, which means the deserializer (adapters and validators
+ *  included) is applied in the same way as for KMD data.
+ */
+
+type Format uint
+const (
+	JsonFormat Format = iota
+	MsgpackFormat
+)
+
+func ParseFormat(name string) (Format, bool) {
+	switch name {
+	case "json":    return JsonFormat, true
+	case "msgpack": return MsgpackFormat, true
+	default:        return JsonFormat, false
+	}
+}
+func (f Format) String() string {
+	switch f {
+	case JsonFormat:    return "json"
+	case MsgpackFormat: return "msgpack"
+	default:            panic("impossible branch")
+	}
+}
+
+func (f Format) Encode(obj Object, t *Type, serializer *Serializer, sch SchemaTable) ([] byte, error) {
+	var ts = CreateJsonTransformer(sch)
+	var value, value_t, err = convert(obj, serializer, ts.Deserializer)
+	if err != nil { return nil, err }
+	value, err = ts.AssignObject(value, value_t, t)
+	if err != nil { return nil, err }
+	switch f {
+	case JsonFormat:
+		return json.Marshal(value)
+	case MsgpackFormat:
+		return msgpackMarshal(value)
+	default:
+		panic("impossible branch")
+	}
+}
+func (f Format) Decode(data ([] byte), t *Type, deserializer *Deserializer, sch SchemaTable) (Object, error) {
+	var value interface{}
+	switch f {
+	case JsonFormat:
+		var decoder = json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		var err = decoder.Decode(&value)
+		if err != nil { return nil, err }
+		if decoder.More() { return nil, errors.New("redundant data after JSON value") }
+	case MsgpackFormat:
+		var err error
+		value, err = msgpackUnmarshal(data)
+		if err != nil { return nil, err }
+	default:
+		panic("impossible branch")
+	}
+	var ts = CreateJsonTransformer(sch)
+	typed, err := JsonToObject(value, t, sch)
+	if err != nil { return nil, err }
+	obj, obj_t, err := convert(typed, ts.Serializer, deserializer)
+	if err != nil { return nil, err }
+	return deserializer.AssignObject(obj, obj_t, t)
+}
+
+// convert converts an object between transformers, through the binary encoding.
+func convert(obj Object, from *Serializer, to *Deserializer) (Object, *Type, error) {
+	var buf bytes.Buffer
+	var err = SerializeBinary(obj, from, &buf)
+	if err != nil { return nil, nil, err }
+	buf.Next(len(binaryHeader))
+	return deserializeBinaryObject(createBinaryDeserializeContext(&buf, to))
+}
@@ -0,0 +1,134 @@
+package kmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"math/big"
+	"encoding/json"
+)
+
+
+func formatTestId(name string) TypeId {
+	return TheTypeId("kmd.test", "go", name, "v1")
+}
+
+var formatTestSchema = SchemaTable {
+	formatTestId("Vector"): RecordSchema { Fields: map[string] RecordField {
+		"x": { Type: PrimitiveType(Float), Index: 0 },
+		"y": { Type: PrimitiveType(Float), Index: 1 },
+	} },
+	formatTestId("Circle"): RecordSchema { Fields: map[string] RecordField {
+		"center": { Type: AlgebraicType(Record, formatTestId("Vector")), Index: 0 },
+		"radius": { Type: PrimitiveType(Float), Index: 1 },
+	} },
+	formatTestId("Numbers"): RecordSchema { Fields: map[string] RecordField {
+		"integers":  { Type: ContainerType(Array, PrimitiveType(Integer)), Index: 0 },
+		"floats":    { Type: ContainerType(Array, PrimitiveType(Float)), Index: 1 },
+		"complexes": { Type: ContainerType(Array, PrimitiveType(Complex)), Index: 2 },
+		"content":   { Type: PrimitiveType(Binary), Index: 3 },
+		"text":      { Type: PrimitiveType(String), Index: 4 },
+		"flag":      { Type: PrimitiveType(Bool), Index: 5 },
+	} },
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	var ts = CreateGoStructTransformer(numbersOptions)
+	var numbers_t = AlgebraicType(Record, formatTestId("Numbers"))
+	var numbers = sampleNumbers()
+	for _, f := range [] Format { JsonFormat, MsgpackFormat } {
+		var data, err = f.Encode(numbers, numbers_t, ts.Serializer, formatTestSchema)
+		if err != nil { t.Fatal(err) }
+		obj, err := f.Decode(data, numbers_t, ts.Deserializer, formatTestSchema)
+		if err != nil { t.Fatal(err) }
+		if !(reflect.DeepEqual(obj, numbers)) {
+			t.Fatalf("round trip failed (%s): %+v", f, obj)
+		}
+	}
+}
+
+func TestFormatJson(t *testing.T) {
+	var ts = CreateGoStructTransformer(sampleOptions)
+	var circle_t = AlgebraicType(Record, formatTestId("Circle"))
+	var circle = Circle { Center: Vector { X: 1, Y: 2.5 }, Radius: 3 }
+	var data, err = JsonFormat.Encode(circle, circle_t, ts.Serializer, formatTestSchema)
+	if err != nil { t.Fatal(err) }
+	var expected = `{"center":{"x":1,"y":2.5},"radius":3}`
+	if string(data) != expected {
+		t.Fatalf("unexpected JSON: %s", data)
+	}
+	var vector_t = AlgebraicType(Record, formatTestId("Vector"))
+	_, err = JsonFormat.Encode(circle, vector_t, ts.Serializer, formatTestSchema)
+	if err == nil { t.Fatal("object of a wrong type encoded") }
+	for _, invalid := range [] string {
+		`{"center":{"x":1,"y":2.5}}`,
+		`{"center":{"x":1,"y":"2.5"},"radius":3}`,
+		`{"center":{"x":1,"y":2.5},"radius":3,"color":"red"}`,
+		expected + ` {}`,
+	} {
+		var _, err = JsonFormat.Decode(([] byte)(invalid), circle_t, ts.Deserializer, formatTestSchema)
+		if err == nil { t.Fatalf("invalid JSON accepted: %s", invalid) }
+	}
+}
+
+func TestFormatMsgpack(t *testing.T) {
+	var huge, _ = new(big.Int).SetString("123456789012345678901234567890", 10)
+	// integers beyond 64 bits are written as strings
+	var huge_str = huge.String()
+	for _, item := range [] struct { Value interface{}; Data string; Decoded interface{} } {
+		{ Value: nil, Data: "\xc0" },
+		{ Value: true, Data: "\xc3" },
+		{ Value: json.Number("1"), Data: "\x01" },
+		{ Value: json.Number("-1"), Data: "\xff" },
+		{ Value: json.Number("200"), Data: "\xcc\xc8" },
+		{ Value: json.Number("-200"), Data: "\xd1\xff\x38" },
+		{ Value: json.Number("18446744073709551615"), Data: "\xcf" + strings.Repeat("\xff", 8) },
+		{ Value: json.Number(huge_str), Data: "\xbe" + huge_str, Decoded: huge_str },
+		{ Value: 1.5, Data: "\xcb\x3f\xf8\x00\x00\x00\x00\x00\x00" },
+		{ Value: ([] byte)("ab"), Data: "\xc4\x02ab" },
+		{ Value: strings.Repeat("s", 40), Data: "\xd9\x28" + strings.Repeat("s", 40) },
+		{ Value: [] interface{} { "a", false }, Data: "\x92\xa1a\xc2" },
+		{ Value: map[string] interface{} { "b": nil, "a": json.Number("0") },
+			Data: "\x82\xa1a\x00\xa1b\xc0" },
+	} {
+		var data, err = msgpackMarshal(item.Value)
+		if err != nil { t.Fatal(err) }
+		if string(data) != item.Data {
+			t.Fatalf("unexpected MessagePack data of %v: %q", item.Value, data)
+		}
+		decoded, err := msgpackUnmarshal(data)
+		if err != nil { t.Fatal(err) }
+		var expected = item.Value
+		if item.Decoded != nil {
+			expected = item.Decoded
+		}
+		if !(reflect.DeepEqual(decoded, expected)) {
+			t.Fatalf("unexpected decoded value: %#v", decoded)
+		}
+	}
+	var sample, err = msgpackMarshal(map[string] interface{} {
+		"list": [] interface{} { json.Number("-100000"), 0.25, "text" },
+	})
+	if err != nil { t.Fatal(err) }
+	for i := 0; i < len(sample); i += 1 {
+		var _, err = msgpackUnmarshal(sample[:i])
+		if err == nil { t.Fatalf("truncated data (%d/%d bytes) accepted", i, len(sample)) }
+	}
+	for _, invalid := range [] string {
+		"\xc0\xc0", "\xc1", "\x81\x01\x02", "\x82\xa1a\x00\xa1a\x00",
+		"\xdd\xff\xff\xff\xff", "\xc6\xff\xff\xff\xff",
+	} {
+		var _, err = msgpackUnmarshal(([] byte)(invalid))
+		if err == nil { t.Fatalf("invalid data accepted: %q", invalid) }
+	}
+	var ts = CreateGoStructTransformer(sampleOptions)
+	var vector_t = AlgebraicType(Record, formatTestId("Vector"))
+	var vector, _ = msgpackMarshal(map[string] interface{} {
+		"x": json.Number("3"), "y": 0.5,
+	})
+	obj, err := MsgpackFormat.Decode(vector, vector_t, ts.Deserializer, formatTestSchema)
+	if err != nil { t.Fatal(err) }
+	if obj != (Vector { X: 3, Y: 0.5 }) {
+		t.Fatalf("unexpected decoded object: %+v", obj)
+	}
+}
@@ -2,14 +2,19 @@ package kmd
 
 import (
 	"fmt"
+	"sync"
 	"errors"
 	"reflect"
+	"strconv"
 	"math/big"
+	"encoding/base64"
 )
 
 
 const Tag = "kmd"
 const TagIgnore = "kmd_ignore"
+const TagDefault = "kmd_default"  // the field is optional, with a default value
+const TagAllowUnknown = "kmd_allow_unknown"  // on a blank field: skip unknown fields
 const MaybeMethod = "Maybe"
 
 type IntegerKind uint
@@ -23,7 +28,8 @@ const (
 	RuneSlice
 )
 type GoStructOptions struct {
-	Types  map[TypeId] reflect.Type
+	Types   map[TypeId] reflect.Type
+	Tuples  map[TypeId] reflect.Type  // Go structs with elements as fields
 	IntegerKind
 	StringKind
 	GoStructSerializerOptions
@@ -40,8 +46,7 @@ type GoInterfaceWorkaround struct {
 }
 
 func getInterfaceValueFromType(t reflect.Type) interface{} {
-	if t.Kind() == reflect.Interface &&
-		!(t.NumMethod() == 1 && t.Method(0).Name == MaybeMethod) {
+	if t.Kind() == reflect.Interface {
 		return GoInterfaceWorkaround { Type: t }
 	} else {
 		var ptr = reflect.New(t)
@@ -50,10 +55,52 @@ func getInterfaceValueFromType(t reflect.Type) interface{} {
 		return i
 	}
 }
-func isEnumType(t reflect.Type) bool {
-	var obj = getInterfaceValueFromType(t)
-	var _, is = obj.(GoInterfaceWorkaround)
-	return is
+func isMaybeType(t reflect.Type) bool {
+	return (t.Kind() == reflect.Interface &&
+		t.NumMethod() == 1 && t.Method(0).Name == MaybeMethod)
+}
+func wrapInterfaceValue(t reflect.Type, obj Object) Object {
+	// the static type of a value stored in an interface-typed
+	// field (or slice element) is lost after v.Interface()
+	if t.Kind() == reflect.Interface {
+		return GoInterfaceWorkaround {
+			Type:     t,
+			Concrete: obj,
+		}
+	} else {
+		return obj
+	}
+}
+func getTupleFields(rt reflect.Type) ([] int) {
+	var fields = make([] int, 0)
+	for i := 0; i < rt.NumField(); i += 1 {
+		var _, ignore = rt.Field(i).Tag.Lookup(TagIgnore)
+		if !(ignore) {
+			fields = append(fields, i)
+		}
+	}
+	return fields
+}
+func isRecordField(field reflect.StructField) bool {
+	var _, ignore = field.Tag.Lookup(TagIgnore)
+	var _, marker = field.Tag.Lookup(TagAllowUnknown)
+	return !(ignore || marker)
+}
+func getRecordFieldName(field reflect.StructField) string {
+	var tagged_name = field.Tag.Get(Tag)
+	if tagged_name != "" {
+		return tagged_name
+	} else {
+		return field.Name
+	}
+}
+func setReflectValue(target reflect.Value, obj Object) {
+	if obj == nil {
+		// Nothing of an optional type
+		target.Set(reflect.Zero(target.Type()))
+	} else {
+		target.Set(reflect.ValueOf(obj))
+	}
 }
 
 func CreateGoStructTransformer(opts GoStructOptions) Transformer {
@@ -65,6 +112,28 @@ func CreateGoStructTransformer(opts GoStructOptions) Transformer {
 		}
 		types_rev[rt] = id
 	}
+	var tuples_rev = make(map[reflect.Type] TypeId)
+	for id, rt := range opts.Tuples {
+		var _, exists = types_rev[rt]
+		var _, tuple_exists = tuples_rev[rt]
+		if exists || tuple_exists {
+			panic(fmt.Sprintf("more than one id for the type %s", rt))
+		}
+		tuples_rev[rt] = id
+	}
+	var get_tuple_type = func(tuple_t TypeId) (reflect.Type, error) {
+		var rt, exists = opts.Tuples[tuple_t]
+		if !(exists) { return nil, errors.New(fmt.Sprintf(
+			"type %s does not exist", tuple_t)) }
+		if rt.Kind() != reflect.Struct { return nil, errors.New(fmt.Sprintf(
+			"type %s is not a tuple type", tuple_t)) }
+		return rt, nil
+	}
+	var adapter_ids = make([] AdapterId, 0, len(opts.Adapters))
+	for id, _ := range opts.Adapters {
+		adapter_ids = append(adapter_ids, id)
+	}
+	var adapters = CreateAdapterIndex(adapter_ids)
 	var determine_type (func(Object) *Type)
 	determine_type = func(obj Object) *Type {
 		switch obj.(type) {
@@ -100,7 +169,7 @@ func CreateGoStructTransformer(opts GoStructOptions) Transformer {
 				var elem = getInterfaceValueFromType(t.Elem())
 				return ContainerType(Array, determine_type(elem))
 			} else if t.Kind() == reflect.Interface {
-				if t.NumMethod() == 1 && t.Method(0).Name == MaybeMethod {
+				if isMaybeType(t) {
 					if t.Method(0).Type.NumIn() != 2 {
 						panic(fmt.Sprintf("%s: Maybe() method should have signature (T,MaybeT)", t))
 					}
@@ -115,6 +184,10 @@ func CreateGoStructTransformer(opts GoStructOptions) Transformer {
 					return AlgebraicType(Enum, id)
 				}
 			} else if t.Kind() == reflect.Struct {
+				var tuple_id, is_tuple = tuples_rev[t]
+				if is_tuple {
+					return AlgebraicType(Tuple, tuple_id)
+				}
 				var id, exists = types_rev[t]
 				if !(exists) {
 					panic(fmt.Sprintf("the type %s does not have an id", t))
@@ -159,16 +232,23 @@ func CreateGoStructTransformer(opts GoStructOptions) Transformer {
 			if !ok {
 				panic(fmt.Sprintf("%s: Maybe() method not found", elem_t))
 			}
-			if method.Type.NumIn() != 2 {
+			// methods of a concrete type take the receiver as In(0)
+			var offset = 0
+			if elem_t.Kind() != reflect.Interface {
+				offset = 1
+			}
+			if method.Type.NumIn() != (2 + offset) {
 				panic(fmt.Sprintf("%s: Maybe() method should have signature (T,MaybeT)", elem_t))
 			}
-			return method.Type.In(1)
+			return method.Type.In(1 + offset)
 		case Record:
 			var rt, ok = opts.Types[t.identifier]
 			if !ok { panic(fmt.Sprintf("unknown type %s", t.identifier)) }
 			return rt
 		case Tuple:
-			panic("tuple is not supported in Go")
+			var rt, ok = opts.Tuples[t.identifier]
+			if !ok { panic(fmt.Sprintf("unknown type %s", t.identifier)) }
+			return rt
 		case Enum:
 			var rt, ok = opts.Types[t.identifier]
 			if !ok { panic(fmt.Sprintf("unknown type %s", t.identifier)) }
@@ -177,6 +257,84 @@ func CreateGoStructTransformer(opts GoStructOptions) Transformer {
 			panic("impossible branch")
 		}
 	}
+	var parse_default = func(text string, t *Type) (Object, error) {
+		switch t.kind {
+		case Bool:
+			return strconv.ParseBool(text)
+		case Float:
+			return strconv.ParseFloat(text, 64)
+		case Complex:
+			return strconv.ParseComplex(text, 128)
+		case Integer:
+			var n, ok = new(big.Int).SetString(text, 10)
+			if !(ok) { return nil, errors.New("invalid integer") }
+			switch opts.IntegerKind {
+			case BigInt:
+				return n, nil
+			case Int64:
+				if !(n.IsInt64()) { return nil, errors.New("integer too big") }
+				return n.Int64(), nil
+			default:
+				panic("impossible branch")
+			}
+		case String:
+			switch opts.StringKind {
+			case GoString:
+				return text, nil
+			case RuneSlice:
+				return ([] rune)(text), nil
+			default:
+				panic("impossible branch")
+			}
+		case Binary:
+			return base64.StdEncoding.DecodeString(text)
+		case Array, Optional:
+			// empty array or Nothing
+			if text != "" { return nil, errors.New("should be empty") }
+			return nil, nil
+		default:
+			return nil, errors.New(fmt.Sprintf("not supported for type %s", t))
+		}
+	}
+	var record_schemas sync.Map
+	var get_record_schema = func(record_t TypeId) (RecordSchema, error) {
+		var cached, is_cached = record_schemas.Load(record_t)
+		if is_cached { return cached.(RecordSchema), nil }
+		var rt, exists = opts.Types[record_t]
+		if !(exists) { return RecordSchema {}, errors.New(fmt.Sprintf(
+			"type %s does not exist", record_t)) }
+		if rt.Kind() != reflect.Struct { return RecordSchema {}, errors.New(fmt.Sprintf(
+			"type %s is not a record type", record_t))}
+		var schema = RecordSchema {
+			Fields: make(map[string] RecordField),
+		}
+		for i := 0; i < rt.NumField(); i += 1 {
+			var field_info = rt.Field(i)
+			var _, marker = field_info.Tag.Lookup(TagAllowUnknown)
+			if marker {
+				schema.AllowUnknown = true
+			}
+			if !(isRecordField(field_info)) { continue }
+			var name = getRecordFieldName(field_info)
+			var obj = getInterfaceValueFromType(field_info.Type)
+			var field = RecordField {
+				Type:  determine_type(obj),
+				Index: uint(i),
+			}
+			var default_text, optional = field_info.Tag.Lookup(TagDefault)
+			if optional {
+				var value, err = parse_default(default_text, field.Type)
+				if err != nil { return RecordSchema {}, fmt.Errorf(
+					"invalid default value of field %s on type %s: %w",
+					name, record_t, err) }
+				field.Optional = true
+				field.Default = value
+			}
+			schema.Fields[name] = field
+		}
+		record_schemas.Store(record_t, schema)
+		return schema, nil
+	}
 	var serializer = Serializer {
 		DetermineType: determine_type,
 		PrimitiveSerializer: PrimitiveSerializer {
@@ -211,21 +369,22 @@ func CreateGoStructTransformer(opts GoStructOptions) Transformer {
 			IterateArray: func(obj Object, f func(uint, Object) error) error {
 				var v = reflect.ValueOf(obj)
 				var elem_t = v.Type().Elem()
-				var is_elem_enum = isEnumType(elem_t)
 				for i := 0; i < v.Len(); i += 1 {
-					var elem = v.Index(i).Interface()
-					if is_elem_enum {
-						elem = GoInterfaceWorkaround {
-							Type:     elem_t,
-							Concrete: elem,
-						}
-					}
+					var elem = wrapInterfaceValue(elem_t, v.Index(i).Interface())
 					err := f(uint(i), elem)
 					if err != nil { return err }
 				}
 				return nil
 			},
 			UnwrapOptional: func(obj Object) (Object, bool) {
+				var workaround, is_workaround = obj.(GoInterfaceWorkaround)
+				if is_workaround {
+					if workaround.Concrete == nil {
+						return nil, false
+					}
+					var elem_t = workaround.Type.Method(0).Type.In(0)
+					return wrapInterfaceValue(elem_t, workaround.Concrete), true
+				}
 				if obj != nil {
 					return obj, true
 				} else {
@@ -238,32 +397,24 @@ func CreateGoStructTransformer(opts GoStructOptions) Transformer {
 				var v = reflect.ValueOf(obj)
 				for i := 0; i < v.NumField(); i += 1 {
 					var field_info = v.Type().Field(i)
-					var field_t = field_info.Type
-					var field_v = v.Field(i)
-					var field_obj = field_v.Interface()
-					if isEnumType(field_t) {
-						field_obj = GoInterfaceWorkaround {
-							Type:     field_t,
-							Concrete: field_obj,
-						}
-					}
-					var _, ignore = field_info.Tag.Lookup(TagIgnore)
-					if !(ignore) {
-						var tagged_name = field_info.Tag.Get(Tag)
-						var name string
-						if tagged_name != "" {
-							name = tagged_name
-						} else {
-							name = field_info.Name
-						}
-						err := f(name, field_obj)
+					if isRecordField(field_info) {
+						var field_t = field_info.Type
+						var field_obj = wrapInterfaceValue(field_t, v.Field(i).Interface())
+						err := f(getRecordFieldName(field_info), field_obj)
 						if err != nil { return err }
 					}
 				}
 				return nil
 			},
-			IterateTuple:  func(Object, func(uint,Object) error) error {
-				panic("tuple is not supported in Go")
+			IterateTuple:  func(obj Object, f func(uint,Object) error) error {
+				var v = reflect.ValueOf(obj)
+				for i, field_index := range getTupleFields(v.Type()) {
+					var field_t = v.Type().Field(field_index).Type
+					var field_obj = wrapInterfaceValue(field_t, v.Field(field_index).Interface())
+					err := f(uint(i), field_obj)
+					if err != nil { return err }
+				}
+				return nil
 			},
 			Enum2Case: func(obj Object) Object {
 				var workaround, is_workaround = obj.(GoInterfaceWorkaround)
@@ -317,7 +468,8 @@ func CreateGoStructTransformer(opts GoStructOptions) Transformer {
 			},
 			AppendItem: func(array *Object, item Object) {
 				var array_v = reflect.ValueOf(*array)
-				var item_v = reflect.ValueOf(item)
+				var item_v = reflect.New(array_v.Type().Elem()).Elem()
+				setReflectValue(item_v, item)
 				var appended_v = reflect.Append(array_v, item_v)
 				var appended = appended_v.Interface()
 				*array = appended
@@ -339,13 +491,12 @@ func CreateGoStructTransformer(opts GoStructOptions) Transformer {
 				if from.kind == Record && to.kind == Record &&
 					from.identifier.TypeIdFuzzy == to.identifier.TypeIdFuzzy &&
 					from.identifier.Version != to.identifier.Version {
-					var adapter_id = AdapterId {
-						From: from.identifier,
-						To:   to.identifier,
-					}
-					var adapter, exists = opts.Adapters[adapter_id]
+					var chain, exists = adapters.FindChain(from.identifier, to.identifier)
 					if exists {
-						return adapter(obj), nil
+						for _, adapter_id := range chain {
+							obj = opts.Adapters[adapter_id](obj)
+						}
+						return obj, nil
 					} else {
 						return nil, errors.New("types are not compatible: " +
 							fmt.Sprintf("\n\t%s\nis not adaptable to\n\t%s\n", from, to))
@@ -357,48 +508,7 @@ func CreateGoStructTransformer(opts GoStructOptions) Transformer {
 						fmt.Sprintf("\n\t%s\ndoes not equal to\n\t%s\n", from, to))
 				}
 			},
-			CheckRecord: func(record_t TypeId, size uint) error {
-				var rt, exists = opts.Types[record_t]
-				if !(exists) { return errors.New(fmt.Sprintf(
-					"type %s does not exist", record_t)) }
-				if rt.Kind() != reflect.Struct { return errors.New(fmt.Sprintf(
-					"type %s is not a record type", record_t))}
-				var valid_field_count = uint(0)
-				for i := 0; i < rt.NumField(); i += 1 {
-					var _, ignore = rt.Field(i).Tag.Lookup(TagIgnore)
-					if !(ignore) {
-						valid_field_count += 1
-					}
-				}
-				if valid_field_count != size { return errors.New(fmt.Sprintf(
-					"record size not matching: given %d, require %d",
-					size, valid_field_count))}
-				return nil
-			},
-			GetFieldInfo: func(record_t TypeId, field string) (*Type, uint, error) {
-				var rt, ok = opts.Types[record_t]
-				if !(ok) { panic("record type existence should be checked" +
-					" before trying to get a field type") }
-				var field_info reflect.StructField
-				var exists = false
-				for i := 0; i < rt.NumField(); i += 1 {
-					var this = rt.Field(i)
-					var _, ignore = this.Tag.Lookup(TagIgnore)
-					if !(ignore) {
-						if this.Tag.Get(Tag) == field || this.Name == field {
-							field_info = this
-							exists = true
-							break
-						}
-					}
-				}
-				if !(exists) { return nil, ^uint(0), errors.New(fmt.Sprintf(
-					"field %s does not exist on type %s", field, record_t))}
-				var obj = getInterfaceValueFromType(field_info.Type)
-				var t = determine_type(obj)
-				var index = uint(field_info.Index[0])
-				return t, index, nil
-			},
+			GetRecordSchema: get_record_schema,
 			CreateRecord: func(record_t TypeId) Object {
 				var rt, ok = opts.Types[record_t]
 				if !(ok) { panic("record type existence should be checked" +
@@ -409,25 +519,42 @@ func CreateGoStructTransformer(opts GoStructOptions) Transformer {
 			FillField: func(record Object, index uint, value Object) {
 				var record_v = reflect.ValueOf(record).Elem()
 				var field_v = record_v.Field(int(index))
-				field_v.Set(reflect.ValueOf(value))
+				setReflectValue(field_v, value)
 			},
 			FinishRecord: func(record Object, _ TypeId) (Object, error) {
 				return reflect.ValueOf(record).Elem().Interface(), nil
 			},
-			CheckTuple: func(TypeId, uint) error {
-				panic("tuple is not supported in Go")
+			CheckTuple: func(tuple_t TypeId, size uint) error {
+				var rt, err = get_tuple_type(tuple_t)
+				if err != nil { return err }
+				var required = uint(len(getTupleFields(rt)))
+				if required != size { return errors.New(fmt.Sprintf(
+					"tuple size not matching: given %d, require %d",
+					size, required))}
+				return nil
 			},
-			GetElementType: func(TypeId, uint) *Type {
-				panic("tuple is not supported in Go")
+			GetElementType: func(tuple_t TypeId, element uint) *Type {
+				var rt, err = get_tuple_type(tuple_t)
+				if err != nil { panic("tuple type existence should be checked" +
+					" before trying to get an element type") }
+				var field_index = getTupleFields(rt)[element]
+				var obj = getInterfaceValueFromType(rt.Field(field_index).Type)
+				return determine_type(obj)
 			},
-			CreateTuple: func(TypeId) Object {
-				panic("tuple is not supported in Go")
+			CreateTuple: func(tuple_t TypeId) Object {
+				var rt, err = get_tuple_type(tuple_t)
+				if err != nil { panic("tuple type existence should be checked" +
+					" before trying to create a tuple") }
+				var struct_ptr = reflect.New(rt)
+				return struct_ptr.Interface()
 			},
-			FillElement: func(Object, uint, Object) {
-				panic("tuple is not supported in Go")
+			FillElement: func(tuple Object, element uint, value Object) {
+				var tuple_v = reflect.ValueOf(tuple).Elem()
+				var field_index = getTupleFields(tuple_v.Type())[element]
+				setReflectValue(tuple_v.Field(field_index), value)
 			},
-			FinishTuple: func(Object, TypeId) (Object, error) {
-				panic("tuple is not supported in Go")
+			FinishTuple: func(tuple Object, _ TypeId) (Object, error) {
+				return reflect.ValueOf(tuple).Elem().Interface(), nil
 			},
 			Case2Enum: func(obj Object, enum_t TypeId, case_t TypeId) (Object, error) {
 				var enum_rt, exists = opts.Types[enum_t]
@@ -1,6 +1,10 @@
 package kmd
 
-import "reflect"
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
 
 
 type Vector struct {
@@ -41,3 +45,52 @@ var sampleOptions = GoStructOptions {
 	},
 }
 
+
+type Label struct {
+	Text  string   `kmd:"text"`
+}
+func (Label) Maybe(Label, MaybeLabel) {}
+type MaybeLabel interface { Maybe(Label, MaybeLabel) }
+type Marker struct {
+	Label   MaybeLabel
+	Shapes  [] Shape
+	Ignored  int   `kmd_ignore:""`
+}
+type Pair struct {
+	Key    string
+	Value  Marker
+}
+
+var optionalAndTupleOptions = GoStructOptions {
+	Types: map[TypeId] reflect.Type {
+		TheTypeId("kmd.test", "go", "Shape", "v1"): reflect.TypeOf(new(Shape)).Elem(),
+		TheTypeId("kmd.test", "go", "Vector", "v1"): reflect.TypeOf(Vector {}),
+		TheTypeId("kmd.test", "go", "Circle", "v1"): reflect.TypeOf(Circle {}),
+		TheTypeId("kmd.test", "go", "Point", "v1"): reflect.TypeOf(Point {}),
+		TheTypeId("kmd.test", "go", "PointGroup", "v1"): reflect.TypeOf(PointGroup {}),
+		TheTypeId("kmd.test", "go", "Label", "v1"): reflect.TypeOf(Label {}),
+		TheTypeId("kmd.test", "go", "Marker", "v1"): reflect.TypeOf(Marker {}),
+	},
+	Tuples: map[TypeId] reflect.Type {
+		TheTypeId("kmd.test", "go", "Pair", "v1"): reflect.TypeOf(Pair {}),
+	},
+}
+
+func TestGoStructOptionalAndTuple(t *testing.T) {
+	var ts = CreateGoStructTransformer(optionalAndTupleOptions)
+	var shapes = [] Shape { Circle { Radius: 1 } }
+	for _, label := range [] MaybeLabel { Label { Text: "L" }, nil } {
+		var pair = Pair {
+			Key:   "k",
+			Value: Marker { Label: label, Shapes: shapes },
+		}
+		var buf strings.Builder
+		var err = Serialize(pair, ts.Serializer, &buf)
+		if err != nil { t.Fatal(err) }
+		obj, _, err := Deserialize(strings.NewReader(buf.String()), ts.Deserializer)
+		if err != nil { t.Fatal(err) }
+		if !(reflect.DeepEqual(obj, pair)) {
+			t.Fatalf("round trip failed: %+v\n%s", obj, buf.String())
+		}
+	}
+}
@@ -38,3 +38,13 @@ func (DeserializerId) TransformerPartId() {}
 type DeserializerId struct {
 	TypeId
 }
+func (EncoderId) TransformerPartId() {}
+type EncoderId struct {
+	TypeId
+	Format  Format
+}
+func (DecoderId) TransformerPartId() {}
+type DecoderId struct {
+	TypeId
+	Format  Format
+}
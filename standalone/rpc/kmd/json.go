@@ -0,0 +1,401 @@
+package kmd
+
+import (
+	"fmt"
+	"errors"
+	"math/big"
+	"encoding/json"
+	"encoding/base64"
+	"kumachan/standalone/util"
+)
+
+
+/**
+ *  JSON Representation
+ *
+ *  Objects are mapped to JSON values according to their types,
+ *  which are looked up in the schema table.
+ *
+ *    bool       true / false
+ *    float      number
+ *    complex    [real, imag]
+ *    integer    number (or a string of decimal digits when decoding)
+ *    string     string
+ *    binary     string (base64)
+ *    [] T       array
+ *    ? T        null (no value) / the value
+ *    record     object (keys are field names)
+ *    tuple      array (null is also accepted for the empty tuple)
+ *    enum       object with a single key, which is the name of the case
+ *
+ *  The JSON transformer converts between KMD and plain JSON values.
+ *  Its serializer accepts objects created by JsonToObject, and its
+ *  deserializer produces values that can be encoded by json.Marshal.
+ *  Default values of optional fields in the schema are plain JSON values
+ *  (or JSON literals, see RecordField).
+ *  Adapters are not supported, that is, a deserialized object must
+ *  have exactly the required type.
+ */
+
+type jsonTypedValue struct {
+	Type   *Type
+	Value  interface{}
+}
+type jsonField struct {
+	Name   string
+	Value  Object
+}
+type jsonRecordDraft struct {
+	Names   [] string
+	Values  map[string] interface{}
+}
+
+func JsonToObject(value interface{}, t *Type, sch SchemaTable) (Object, error) {
+	return jsonToObject(value, t, sch, "")
+}
+
+func jsonToObject(value interface{}, t *Type, sch SchemaTable, path string) (Object, error) {
+	var typed = func(v interface{}) (Object, error) {
+		return jsonTypedValue { Type: t, Value: v }, nil
+	}
+	var mismatch = func() (Object, error) {
+		return nil, errors.New(fmt.Sprintf(
+			"invalid JSON value at %s: %s required", jsonPath(path), t))
+	}
+	switch t.kind {
+	case Bool:
+		var b, ok = value.(bool)
+		if !(ok) { return mismatch() }
+		return typed(b)
+	case Float:
+		var x, ok = jsonFloat(value)
+		if !(ok) || !(util.IsNormalFloat(x)) { return mismatch() }
+		return typed(x)
+	case Complex:
+		var pair, ok = value.([] interface{})
+		if !(ok) || len(pair) != 2 { return mismatch() }
+		var parts [2] float64
+		for i, item := range pair {
+			var x, ok = jsonFloat(item)
+			if !(ok) { return mismatch() }
+			parts[i] = x
+		}
+		var z = complex(parts[0], parts[1])
+		if !(util.IsNormalComplex(z)) { return mismatch() }
+		return typed(z)
+	case Integer:
+		var str string
+		switch v := value.(type) {
+		case json.Number: str = string(v)
+		case string:      str = v
+		default:          return mismatch()
+		}
+		var n, ok = new(big.Int).SetString(str, 10)
+		if !(ok) { return mismatch() }
+		return typed(n)
+	case String:
+		var str, ok = value.(string)
+		if !(ok) { return mismatch() }
+		return typed(str)
+	case Binary:
+		switch v := value.(type) {
+		case [] byte:
+			// decoded from a format supporting binary values
+			return typed(v)
+		case string:
+			var bin, err = base64.StdEncoding.DecodeString(v)
+			if err != nil { return mismatch() }
+			return typed(bin)
+		default:
+			return mismatch()
+		}
+	case Array:
+		var items, ok = value.([] interface{})
+		if !(ok) { return mismatch() }
+		var objects = make([] Object, len(items))
+		for i, item := range items {
+			var item_path = fmt.Sprintf("%s[%d]", path, i)
+			var obj, err = jsonToObject(item, t.elementType, sch, item_path)
+			if err != nil { return nil, err }
+			objects[i] = obj
+		}
+		return typed(objects)
+	case Optional:
+		if value == nil {
+			return typed(nil)
+		}
+		var inner, err = jsonToObject(value, t.elementType, sch, path)
+		if err != nil { return nil, err }
+		return typed(inner)
+	case Record:
+		var entries, ok = value.(map[string] interface{})
+		if !(ok) { return mismatch() }
+		var schema = sch[t.identifier].(RecordSchema)
+		for key, _ := range entries {
+			var _, exists = schema.Fields[key]
+			if !(exists) && !(schema.AllowUnknown) { return nil, errors.New(fmt.Sprintf(
+				"invalid JSON value at %s: field %s does not exist on type %s",
+				jsonPath(path), key, t.identifier)) }
+		}
+		var fields = make([] jsonField, len(schema.Fields))
+		for name, field := range schema.Fields {
+			var field_path = (path + "." + name)
+			var field_value, exists = entries[name]
+			if !(exists) {
+				if field.Optional && field.Default != nil {
+					field_value = field.Default
+				} else if field.Optional && field.DefaultLiteral != "" {
+					var value, err = decodeDefaultLiteral(field.DefaultLiteral)
+					if err != nil { return nil, err }
+					field_value = value
+				} else if field.Optional && field.Type.kind == Array {
+					field_value = [] interface{} {}
+				} else if !(field.Optional) && field.Type.kind != Optional {
+					return nil, errors.New(fmt.Sprintf(
+						"invalid JSON value at %s: missing field %s",
+						jsonPath(path), name))
+				}
+			}
+			var obj, err = jsonToObject(field_value, field.Type, sch, field_path)
+			if err != nil { return nil, err }
+			fields[field.Index] = jsonField { Name: name, Value: obj }
+		}
+		return typed(fields)
+	case Tuple:
+		var schema = sch[t.identifier].(TupleSchema)
+		if value == nil && len(schema.Elements) == 0 {
+			return typed(([] Object {}))
+		}
+		var items, ok = value.([] interface{})
+		if !(ok) || len(items) != len(schema.Elements) { return mismatch() }
+		var objects = make([] Object, len(items))
+		for i, item := range items {
+			var item_path = fmt.Sprintf("%s[%d]", path, i)
+			var obj, err = jsonToObject(item, schema.Elements[i], sch, item_path)
+			if err != nil { return nil, err }
+			objects[i] = obj
+		}
+		return typed(objects)
+	case Enum:
+		var entries, ok = value.(map[string] interface{})
+		if !(ok) || len(entries) != 1 { return mismatch() }
+		var schema = sch[t.identifier].(EnumSchema)
+		for key, case_value := range entries {
+			for case_tid, _ := range schema.CaseIndexMap {
+				if case_tid.Name == key {
+					var case_t = sch.GetTypeFromId(case_tid)
+					var case_path = (path + "." + key)
+					var obj, err = jsonToObject(case_value, case_t, sch, case_path)
+					if err != nil { return nil, err }
+					return typed(obj)
+				}
+			}
+			return nil, errors.New(fmt.Sprintf(
+				"invalid JSON value at %s: %s is not a case of type %s",
+				jsonPath(path), key, t.identifier))
+		}
+		panic("impossible branch")
+	default:
+		panic("impossible branch")
+	}
+}
+
+func jsonFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		var x, err = v.Float64()
+		return x, (err == nil)
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+func jsonPath(path string) string {
+	if path == "" {
+		return "(root)"
+	} else {
+		return path
+	}
+}
+
+func CreateJsonTransformer(sch SchemaTable) Transformer {
+	var get_record_schema = func(record_t TypeId) (RecordSchema, error) {
+		var s, exists = sch[record_t]
+		if !(exists) { return RecordSchema {}, errors.New(fmt.Sprintf(
+			"type %s does not exist", record_t)) }
+		var schema, ok = s.(RecordSchema)
+		if !(ok) { return RecordSchema {}, errors.New(fmt.Sprintf(
+			"type %s is not a record type", record_t)) }
+		return schema, nil
+	}
+	var get_tuple_schema = func(tuple_t TypeId) (TupleSchema, error) {
+		var s, exists = sch[tuple_t]
+		if !(exists) { return TupleSchema {}, errors.New(fmt.Sprintf(
+			"type %s does not exist", tuple_t)) }
+		var schema, ok = s.(TupleSchema)
+		if !(ok) { return TupleSchema {}, errors.New(fmt.Sprintf(
+			"type %s is not a tuple type", tuple_t)) }
+		return schema, nil
+	}
+	return Transformer {
+		Serializer: &Serializer {
+			DetermineType: func(obj Object) *Type {
+				return obj.(jsonTypedValue).Type
+			},
+			PrimitiveSerializer: PrimitiveSerializer {
+				WriteBool: func(obj Object) bool {
+					return obj.(jsonTypedValue).Value.(bool)
+				},
+				WriteFloat: func(obj Object) float64 {
+					return obj.(jsonTypedValue).Value.(float64)
+				},
+				WriteComplex: func(obj Object) complex128 {
+					return obj.(jsonTypedValue).Value.(complex128)
+				},
+				WriteInteger: func(obj Object) *big.Int {
+					return obj.(jsonTypedValue).Value.(*big.Int)
+				},
+				WriteString: func(obj Object) string {
+					return obj.(jsonTypedValue).Value.(string)
+				},
+				WriteBinary: func(obj Object) ([] byte) {
+					return obj.(jsonTypedValue).Value.([] byte)
+				},
+			},
+			ContainerSerializer: ContainerSerializer {
+				IterateArray: func(obj Object, f func(uint, Object) error) error {
+					var items = obj.(jsonTypedValue).Value.([] Object)
+					for i, item := range items {
+						var err = f(uint(i), item)
+						if err != nil { return err }
+					}
+					return nil
+				},
+				UnwrapOptional: func(obj Object) (Object, bool) {
+					var inner = obj.(jsonTypedValue).Value
+					return inner, (inner != nil)
+				},
+			},
+			AlgebraicSerializer: AlgebraicSerializer {
+				IterateRecord: func(obj Object, f func(string, Object) error) error {
+					var fields = obj.(jsonTypedValue).Value.([] jsonField)
+					for _, field := range fields {
+						var err = f(field.Name, field.Value)
+						if err != nil { return err }
+					}
+					return nil
+				},
+				IterateTuple: func(obj Object, f func(uint, Object) error) error {
+					var elements = obj.(jsonTypedValue).Value.([] Object)
+					for i, element := range elements {
+						var err = f(uint(i), element)
+						if err != nil { return err }
+					}
+					return nil
+				},
+				Enum2Case: func(obj Object) Object {
+					return obj.(jsonTypedValue).Value
+				},
+			},
+		},
+		Deserializer: &Deserializer {
+			PrimitiveDeserializer: PrimitiveDeserializer {
+				ReadBool: func(v bool) Object { return v },
+				ReadFloat: func(v float64) Object { return v },
+				ReadComplex: func(v complex128) Object {
+					return [] interface{} { real(v), imag(v) }
+				},
+				ReadInteger: func(v *big.Int) (Object, bool) {
+					return json.Number(v.String()), true
+				},
+				ReadString: func(v string) Object { return v },
+				ReadBinary: func(v ([] byte)) Object { return v },
+			},
+			ContainerDeserializer: ContainerDeserializer {
+				CreateArray: func(_ *Type) Object {
+					return make([] interface{}, 0)
+				},
+				AppendItem: func(array_ptr *Object, item Object) {
+					*array_ptr = append((*array_ptr).([] interface{}), item)
+				},
+				Some: func(obj Object, _ *Type) Object {
+					return obj
+				},
+				Nothing: func(_ *Type) Object {
+					return nil
+				},
+			},
+			AlgebraicDeserializer: AlgebraicDeserializer {
+				AssignObject: func(obj Object, from *Type, to *Type) (Object, error) {
+					if TypeEqual(from, to) {
+						return obj, nil
+					} else {
+						return nil, errors.New(fmt.Sprintf(
+							"the type %s cannot be assigned to the type %s",
+							from, to))
+					}
+				},
+				GetRecordSchema: get_record_schema,
+				CreateRecord: func(record_t TypeId) Object {
+					var schema, err = get_record_schema(record_t)
+					if err != nil { panic("something went wrong") }
+					var names = make([] string, len(schema.Fields))
+					for name, field := range schema.Fields {
+						names[field.Index] = name
+					}
+					return &jsonRecordDraft {
+						Names:  names,
+						Values: make(map[string] interface{}),
+					}
+				},
+				FillField: func(record Object, index uint, value Object) {
+					var draft = record.(*jsonRecordDraft)
+					draft.Values[draft.Names[index]] = value
+				},
+				FinishRecord: func(record Object, _ TypeId) (Object, error) {
+					return record.(*jsonRecordDraft).Values, nil
+				},
+				CheckTuple: func(tuple_t TypeId, size uint) error {
+					var schema, err = get_tuple_schema(tuple_t)
+					if err != nil { return err }
+					var schema_size = uint(len(schema.Elements))
+					if schema_size != size { return errors.New(fmt.Sprintf(
+						"tuple size not matching: given %d, require %d",
+						size, schema_size)) }
+					return nil
+				},
+				GetElementType: func(tuple_t TypeId, i uint) *Type {
+					var schema, err = get_tuple_schema(tuple_t)
+					if err != nil { panic("something went wrong") }
+					return schema.Elements[i]
+				},
+				CreateTuple: func(tuple_t TypeId) Object {
+					var schema, err = get_tuple_schema(tuple_t)
+					if err != nil { panic("something went wrong") }
+					return make([] interface{}, len(schema.Elements))
+				},
+				FillElement: func(tuple Object, i uint, value Object) {
+					tuple.([] interface{})[i] = value
+				},
+				FinishTuple: func(tuple Object, _ TypeId) (Object, error) {
+					return tuple, nil
+				},
+				Case2Enum: func(obj Object, enum_tid TypeId, case_tid TypeId) (Object, error) {
+					var s, exists = sch[enum_tid]
+					if !(exists) { return nil, errors.New(fmt.Sprintf(
+						"type %s does not exist", enum_tid)) }
+					var schema, ok = s.(EnumSchema)
+					if !(ok) { return nil, errors.New(fmt.Sprintf(
+						"type %s is not a enum type", enum_tid)) }
+					var _, is_case = schema.CaseIndexMap[case_tid]
+					if !(is_case) { return nil, errors.New(fmt.Sprintf(
+						"type %s is not a case type of the enum type %s",
+						case_tid, enum_tid)) }
+					return map[string] interface{} { case_tid.Name: obj }, nil
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,291 @@
+package kmd
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"bytes"
+	"errors"
+	"strconv"
+	"encoding/json"
+	"encoding/binary"
+)
+
+
+// MessagePack codec for plain values in the representation of
+// the JSON transformer (nil, bool, json.Number, float64, string,
+// [] byte, [] interface{} and map[string] interface{}).
+
+func msgpackMarshal(value interface{}) ([] byte, error) {
+	var buf bytes.Buffer
+	var err = msgpackWrite(&buf, value)
+	if err != nil { return nil, err }
+	return buf.Bytes(), nil
+}
+
+func msgpackWrite(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		var n, err = strconv.ParseInt(string(v), 10, 64)
+		if err == nil {
+			msgpackWriteInt(buf, n)
+			return nil
+		}
+		u, err := strconv.ParseUint(string(v), 10, 64)
+		if err == nil {
+			msgpackWriteUint(buf, u)
+			return nil
+		}
+		msgpackWriteHeader(buf, 0xa0, 0xd9, 0xda, 0xdb, uint64(len(v)))
+		buf.WriteString(string(v))
+	case float64:
+		buf.WriteByte(0xcb)
+		msgpackWriteBigEndian(buf, math.Float64bits(v), 8)
+	case string:
+		msgpackWriteHeader(buf, 0xa0, 0xd9, 0xda, 0xdb, uint64(len(v)))
+		buf.WriteString(v)
+	case [] byte:
+		msgpackWriteHeader(buf, 0, 0xc4, 0xc5, 0xc6, uint64(len(v)))
+		buf.Write(v)
+	case [] interface{}:
+		msgpackWriteHeader(buf, 0x90, 0, 0xdc, 0xdd, uint64(len(v)))
+		for _, item := range v {
+			err := msgpackWrite(buf, item)
+			if err != nil { return err }
+		}
+	case map[string] interface{}:
+		var keys = make([] string, 0, len(v))
+		for key, _ := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		msgpackWriteHeader(buf, 0x80, 0, 0xde, 0xdf, uint64(len(v)))
+		for _, key := range keys {
+			msgpackWriteHeader(buf, 0xa0, 0xd9, 0xda, 0xdb, uint64(len(key)))
+			buf.WriteString(key)
+			err := msgpackWrite(buf, v[key])
+			if err != nil { return err }
+		}
+	default:
+		return errors.New(fmt.Sprintf("cannot encode value of type %T", value))
+	}
+	return nil
+}
+
+// msgpackWriteHeader writes a header of str, bin, array or map.
+// A zero fix_code or code8 means the corresponding form is not available.
+func msgpackWriteHeader(buf *bytes.Buffer, fix_code byte, code8 byte, code16 byte, code32 byte, size uint64) {
+	var fix_max = uint64(0)
+	switch fix_code {
+	case 0xa0: fix_max = 31
+	case 0x90, 0x80: fix_max = 15
+	}
+	if fix_code != 0 && size <= fix_max {
+		buf.WriteByte(fix_code | byte(size))
+	} else if code8 != 0 && size <= math.MaxUint8 {
+		buf.WriteByte(code8)
+		buf.WriteByte(byte(size))
+	} else if size <= math.MaxUint16 {
+		buf.WriteByte(code16)
+		msgpackWriteBigEndian(buf, size, 2)
+	} else {
+		buf.WriteByte(code32)
+		msgpackWriteBigEndian(buf, size, 4)
+	}
+}
+
+func msgpackWriteInt(buf *bytes.Buffer, n int64) {
+	if n >= 0 {
+		msgpackWriteUint(buf, uint64(n))
+	} else if n >= -32 {
+		buf.WriteByte(byte(int8(n)))
+	} else if n >= math.MinInt8 {
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(int8(n)))
+	} else if n >= math.MinInt16 {
+		buf.WriteByte(0xd1)
+		msgpackWriteBigEndian(buf, uint64(n), 2)
+	} else if n >= math.MinInt32 {
+		buf.WriteByte(0xd2)
+		msgpackWriteBigEndian(buf, uint64(n), 4)
+	} else {
+		buf.WriteByte(0xd3)
+		msgpackWriteBigEndian(buf, uint64(n), 8)
+	}
+}
+
+func msgpackWriteUint(buf *bytes.Buffer, n uint64) {
+	if n <= 0x7f {
+		buf.WriteByte(byte(n))
+	} else if n <= math.MaxUint8 {
+		buf.WriteByte(0xcc)
+		buf.WriteByte(byte(n))
+	} else if n <= math.MaxUint16 {
+		buf.WriteByte(0xcd)
+		msgpackWriteBigEndian(buf, n, 2)
+	} else if n <= math.MaxUint32 {
+		buf.WriteByte(0xce)
+		msgpackWriteBigEndian(buf, n, 4)
+	} else {
+		buf.WriteByte(0xcf)
+		msgpackWriteBigEndian(buf, n, 8)
+	}
+}
+
+func msgpackWriteBigEndian(buf *bytes.Buffer, n uint64, size uint) {
+	var b [8] byte
+	binary.BigEndian.PutUint64(b[:], n)
+	buf.Write(b[(8 - size):])
+}
+
+func msgpackUnmarshal(data ([] byte)) (interface{}, error) {
+	var r = &msgpackReader { data: data }
+	var value, err = r.read()
+	if err != nil { return nil, err }
+	if r.pos != len(r.data) {
+		return nil, errors.New("redundant data after MessagePack value")
+	}
+	return value, nil
+}
+
+type msgpackReader struct {
+	data  [] byte
+	pos   int
+}
+var msgpackUnexpectedEnd = errors.New("unexpected end of MessagePack data")
+
+func (r *msgpackReader) next(size uint64) (([] byte), error) {
+	if size > uint64(len(r.data) - r.pos) {
+		return nil, msgpackUnexpectedEnd
+	}
+	var chunk = r.data[r.pos:(r.pos + int(size))]
+	r.pos += int(size)
+	return chunk, nil
+}
+
+func (r *msgpackReader) readUint(size uint64) (uint64, error) {
+	var chunk, err = r.next(size)
+	if err != nil { return 0, err }
+	var n = uint64(0)
+	for _, b := range chunk {
+		n = ((n << 8) | uint64(b))
+	}
+	return n, nil
+}
+
+func (r *msgpackReader) read() (interface{}, error) {
+	var head, err = r.next(1)
+	if err != nil { return nil, err }
+	var code = head[0]
+	var fixed = func(n int64) (interface{}, error) {
+		return json.Number(strconv.FormatInt(n, 10)), nil
+	}
+	switch {
+	case code <= 0x7f:
+		return fixed(int64(code))
+	case code >= 0xe0:
+		return fixed(int64(int8(code)))
+	case (code & 0xe0) == 0xa0:
+		return r.readString(uint64(code & 0x1f))
+	case (code & 0xf0) == 0x90:
+		return r.readArray(uint64(code & 0x0f))
+	case (code & 0xf0) == 0x80:
+		return r.readMap(uint64(code & 0x0f))
+	}
+	// the size of the following integer (or length)
+	var size = func(code byte, base byte) uint64 {
+		return (uint64(1) << (code - base))
+	}
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xca:
+		var n, err = r.readUint(4)
+		if err != nil { return nil, err }
+		return float64(math.Float32frombits(uint32(n))), nil
+	case 0xcb:
+		var n, err = r.readUint(8)
+		if err != nil { return nil, err }
+		return math.Float64frombits(n), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		var n, err = r.readUint(size(code, 0xcc))
+		if err != nil { return nil, err }
+		return json.Number(strconv.FormatUint(n, 10)), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		var bytes_size = size(code, 0xd0)
+		var n, err = r.readUint(bytes_size)
+		if err != nil { return nil, err }
+		var shift = (64 - (8 * bytes_size))
+		return fixed((int64(n << shift) >> shift))
+	case 0xd9, 0xda, 0xdb:
+		var length, err = r.readUint(size(code, 0xd9))
+		if err != nil { return nil, err }
+		return r.readString(length)
+	case 0xc4, 0xc5, 0xc6:
+		var length, err = r.readUint(size(code, 0xc4))
+		if err != nil { return nil, err }
+		chunk, err := r.next(length)
+		if err != nil { return nil, err }
+		return append(([] byte)(nil), chunk...), nil
+	case 0xdc, 0xdd:
+		var length, err = r.readUint(size(code, 0xdb))
+		if err != nil { return nil, err }
+		return r.readArray(length)
+	case 0xde, 0xdf:
+		var length, err = r.readUint(size(code, 0xdd))
+		if err != nil { return nil, err }
+		return r.readMap(length)
+	default:
+		return nil, errors.New(fmt.Sprintf(
+			"unsupported MessagePack type (0x%02x)", code))
+	}
+}
+
+func (r *msgpackReader) readString(length uint64) (interface{}, error) {
+	var chunk, err = r.next(length)
+	if err != nil { return nil, err }
+	return string(chunk), nil
+}
+
+func (r *msgpackReader) readArray(length uint64) (interface{}, error) {
+	// each item takes at least one byte
+	if length > uint64(len(r.data) - r.pos) { return nil, msgpackUnexpectedEnd }
+	var items = make([] interface{}, length)
+	for i := range items {
+		var item, err = r.read()
+		if err != nil { return nil, err }
+		items[i] = item
+	}
+	return items, nil
+}
+
+func (r *msgpackReader) readMap(length uint64) (interface{}, error) {
+	// each entry takes at least two bytes
+	if length > uint64(len(r.data) - r.pos) { return nil, msgpackUnexpectedEnd }
+	var entries = make(map[string] interface{})
+	for i := uint64(0); i < length; i += 1 {
+		var key, err = r.read()
+		if err != nil { return nil, err }
+		var key_str, ok = key.(string)
+		if !(ok) { return nil, errors.New("non-string MessagePack map key") }
+		value, err := r.read()
+		if err != nil { return nil, err }
+		var _, duplicate = entries[key_str]
+		if duplicate { return nil, errors.New(fmt.Sprintf(
+			"duplicate MessagePack map key: %s", key_str)) }
+		entries[key_str] = value
+	}
+	return entries, nil
+}
@@ -6,11 +6,17 @@ type Schema interface{ KmdSchema() }
 
 func (RecordSchema) KmdSchema() {}
 type RecordSchema struct {
-	Fields  map[string] RecordField
+	Fields        map[string] RecordField
+	AllowUnknown  bool  // unknown fields are skipped when deserializing
 }
 type RecordField struct {
-	Type   *Type
-	Index  uint
+	Type            *Type
+	Index           uint
+	Optional        bool    // the field may be missing when deserializing
+	Default         Object  // value of a missing field, see DefaultValue()
+	                        // (in the representation of the transformer)
+	DefaultLiteral  string  // value of a missing field as a JSON literal,
+	                        // used if Default is nil
 }
 
 func (TupleSchema) KmdSchema() {}
@@ -75,15 +75,8 @@ func serialize(obj Object, ctx serializeContext, output io.Writer) error {
 		var str = ctx.WriteString(obj)
 		return writePrimitive(output, strconv.Quote(str), ctx.Depth)
 	case Binary:
-		err := writeIndent(output, (ctx.Depth + 1))
-		if err != nil { return err }
 		var bin = ctx.WriteBinary(obj)
-		var encoder = base64.NewEncoder(base64.StdEncoding, output)
-		_, err = encoder.Write(bin)
-		if err != nil { return err }
-		err = encoder.Close()
-		if err != nil { return err }
-		return nil
+		return writePrimitive(output, base64.StdEncoding.EncodeToString(bin), ctx.Depth)
 	case Array:
 		return ctx.IterateArray(obj, func(i uint, item Object) error {
 			var item_ctx = serializeContext {
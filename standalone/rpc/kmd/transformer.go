@@ -53,8 +53,7 @@ type ContainerDeserializer struct {
 }
 type AlgebraicDeserializer struct {
 	AssignObject    func(obj Object, from *Type, to *Type) (Object, error)
-	CheckRecord     func(record_t TypeId, size uint) error
-	GetFieldInfo    func(record_t TypeId, field string) (*Type, uint, error)
+	GetRecordSchema func(record_t TypeId) (RecordSchema, error)
 	CreateRecord    func(record_t TypeId) Object
 	FillField       func(record Object, index uint, value Object)
 	FinishRecord    func(record Object, t TypeId) (Object, error)
@@ -4,6 +4,11 @@ import (
 	"io"
 	"net"
 	"fmt"
+	"time"
+	"sort"
+	"strings"
+	"strconv"
+	"encoding/json"
 )
 
 
@@ -19,6 +24,34 @@ func (l ServerLogger) LogError(err error) {
 	}
 }
 
+func (l ServerLogger) LogCallError(info CallInfo, err error) {
+	if l.Output != nil {
+		fmt.Fprintf(l.Output, "[RPC] [Server %s] client %s: call %d (%s)%s: Error: %s\n",
+			l.LocalAddr, l.RemoteAddr, info.Id, info.Method,
+			describeMetadata(info.Metadata), err.Error())
+	}
+}
+func (l ServerLogger) LogAccess(entry AccessLogEntry) {
+	if l.Output != nil {
+		var line, err = json.Marshal(entry)
+		if err != nil { panic(err) }
+		line = append(line, '\n')
+		_, _ = l.Output.Write(line)
+	}
+}
+func describeMetadata(metadata map[string] string) string {
+	var keys = make([] string, 0, len(metadata))
+	for k, _ := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&buf, " %s=%s", k, strconv.Quote(metadata[k]))
+	}
+	return buf.String()
+}
+
 type ClientLogger struct {
 	LocalAddr   net.Addr
 	RemoteAddr  net.Addr
@@ -31,3 +64,20 @@ func (l ClientLogger) LogError(err error) {
 	}
 }
 
+func (l ClientLogger) LogRetry(err error, delay time.Duration) {
+	if l.Output != nil {
+		fmt.Fprintf(l.Output, "[RPC] [Client] Error: %s (reconnecting in %s)\n",
+			err.Error(), delay)
+	}
+}
+
+type GatewayLogger struct {
+	RemoteAddr  string
+	Output      io.Writer
+}
+func (l GatewayLogger) LogError(err error) {
+	if l.Output != nil {
+		fmt.Fprintf(l.Output, "[RPC] [Gateway] client %s: Error: %s\n",
+			l.RemoteAddr, err.Error())
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"strconv"
 	"encoding/json"
+	"kumachan/standalone/rpc/kmd"
 )
 
 
@@ -24,6 +25,18 @@ const MSG_CALL_MULTI = "call*"
 const MSG_VALUE = "value"
 const MSG_ERROR = "error"
 const MSG_COMPLETE = "complete"
+const MSG_CANCEL = "cancel"
+
+// CallHeader is an optional part of the payload of a call message,
+// which follows the method name and a line break (encoded as JSON).
+// The deadline is sent as a relative timeout (in milliseconds),
+// which is not affected by the clock difference between machines.
+type CallHeader struct {
+	Timeout   int64                `json:"timeout,omitempty"`
+	Metadata  map[string] string   `json:"metadata,omitempty"`
+}
+const payloadHeaderSeparator = '\n'
+const DeadlineExceededDesc = "call deadline exceeded"
 
 type ErrorWithExtraData struct {
 	Desc  string               `json:"desc"`
@@ -38,6 +51,90 @@ func (e *ErrorWithExtraData) Serialize() ([] byte) {
 	return content
 }
 
+func IsDeadlineExceeded(e error) bool {
+	var e_with_extra *ErrorWithExtraData
+	return (errors.As(e, &e_with_extra) && e_with_extra.Desc == DeadlineExceededDesc)
+}
+func deadlineExceededError() *ErrorWithExtraData {
+	return &ErrorWithExtraData {
+		Desc: DeadlineExceededDesc,
+		Data: make(map[string] string),
+	}
+}
+
+// ServiceHeader is an optional part of the payload of a service confirmation
+// message, which follows the service identifier and a line break (encoded
+// as JSON), similar to CallHeader. The encoding of objects on the connection
+// is selected by the client, and it is the text encoding if not specified.
+type ServiceHeader struct {
+	Encoding  string   `json:"encoding,omitempty"`
+}
+
+func encodeServicePayload(service_id string, enc kmd.Encoding) ([] byte) {
+	if enc == kmd.TextEncoding {
+		return ([] byte)(service_id)
+	}
+	return encodePayloadWithHeader(service_id, ServiceHeader {
+		Encoding: enc.String(),
+	})
+}
+
+func decodeServicePayload(payload ([] byte)) (string, kmd.Encoding, error) {
+	var header ServiceHeader
+	var service_id, err = decodePayloadWithHeader(payload, &header)
+	if err != nil {
+		return "", kmd.TextEncoding, fmt.Errorf("invalid service header: %w", err)
+	}
+	if header.Encoding == "" {
+		return service_id, kmd.TextEncoding, nil
+	}
+	var enc, ok = kmd.ParseEncoding(header.Encoding)
+	if !(ok) {
+		return "", kmd.TextEncoding, errors.New(fmt.Sprintf(
+			"unsupported encoding: %s", header.Encoding))
+	}
+	return service_id, enc, nil
+}
+
+func encodeCallPayload(method string, header CallHeader) ([] byte) {
+	if header.Timeout == 0 && len(header.Metadata) == 0 {
+		return ([] byte)(method)
+	}
+	return encodePayloadWithHeader(method, header)
+}
+
+func decodeCallPayload(payload ([] byte)) (string, CallHeader, error) {
+	var header CallHeader
+	var method, err = decodePayloadWithHeader(payload, &header)
+	if err != nil {
+		return "", CallHeader {}, fmt.Errorf("invalid call header: %w", err)
+	}
+	if header.Timeout < 0 {
+		return "", CallHeader {}, errors.New("invalid call header: negative timeout")
+	}
+	return method, header, nil
+}
+
+func encodePayloadWithHeader(content string, header interface{}) ([] byte) {
+	var header_bin, err = json.Marshal(header)
+	if err != nil { panic(err) }
+	var buf = make([] byte, 0, (len(content) + 1 + len(header_bin)))
+	buf = append(buf, content...)
+	buf = append(buf, payloadHeaderSeparator)
+	buf = append(buf, header_bin...)
+	return buf
+}
+
+func decodePayloadWithHeader(payload ([] byte), header interface{}) (string, error) {
+	var i = bytes.IndexByte(payload, payloadHeaderSeparator)
+	if i < 0 {
+		return string(payload), nil
+	}
+	var err = json.Unmarshal(payload[i+1:], header)
+	if err != nil { return "", err }
+	return string(payload[:i]), nil
+}
+
 func writeMessageHeaderField(content string, width int, w io.Writer) error {
 	if len(content) > width {
 		panic(fmt.Sprintf("field content width exceeded maximum (%d)", width))
@@ -0,0 +1,586 @@
+package rpc
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+	"errors"
+	"strconv"
+	"kumachan/standalone/rx"
+)
+
+
+/**
+ *  Connection Multiplexing
+ *
+ *  A mux session carries several independent streams over a single
+ *  connection. Each stream is a net.Conn, on which Server and Client
+ *  work as usual, so that several service instances (of the same or
+ *  different services) can be hosted over one TCP connection.
+ *  Either side of a session may open streams. A stream is opened on a
+ *  named channel (e.g. the service identifier), and it is accepted by
+ *  the listener of the channel on the other side, or refused if there
+ *  is no such listener. In this way, a server may also open streams to
+ *  services hosted by its client (server-initiated streams).
+ *
+ *  Frames are encoded in the same way as messages (see message.go),
+ *  where the call id field holds the stream id:
+ *    open    (payload: channel name)
+ *    data    (payload: data)
+ *    window  (payload: decimal number of bytes)
+ *    close   (payload: empty, or the reason of refusal)
+ *  Streams opened by the client side have odd ids, and streams opened
+ *  by the server side have even ids.
+ *  Each stream has a receive window of MuxWindowSize bytes. The sender
+ *  may not send more data than the window allows, and the receiver
+ *  extends the window by window frames when the data has been read.
+ *  Therefore a stream that is not read does not block other streams.
+ */
+
+const MuxWindowSize = (256 * 1024)
+const MuxFrameDataMax = (32 * 1024)
+const MuxAcceptBacklog = 64
+
+const MUX_OPEN = "open"
+const MUX_DATA = "data"
+const MUX_WINDOW = "window"
+const MUX_CLOSE = "close"
+
+var ErrMuxSessionClosed = errors.New("mux session closed")
+var ErrMuxStreamClosed = errors.New("mux stream closed")
+var ErrMuxStreamClosedByPeer = errors.New("mux stream closed by peer")
+
+type MuxSession struct {
+	conn        net.Conn
+	channels    *muxChannels
+	ownChannel  bool  // channels are closed with the session
+	worker      *rx.Worker  // sends frames not related to the order of data
+	sending     sync.Mutex
+	mutex       sync.Mutex
+	streams     map[uint64] *MuxStream
+	nextId      uint64
+	peerLastId  uint64
+	err         error
+	done        chan struct{}
+}
+
+// MuxClient creates a mux session on a connection to a server.
+func MuxClient(conn net.Conn) *MuxSession {
+	return createMuxSession(conn, 1, createMuxChannels(), true)
+}
+// MuxServer creates a mux session on a connection from a client.
+func MuxServer(conn net.Conn) *MuxSession {
+	return createMuxSession(conn, 2, createMuxChannels(), true)
+}
+// MuxSessionOf returns the mux session of a connection, if the connection
+// is a mux stream. It can be used to open streams to the other side of
+// the session, e.g. in the constructor of a service.
+func MuxSessionOf(conn *rx.WrappedConnection) (*MuxSession, bool) {
+	var stream, ok = conn.RawConnection().(*MuxStream)
+	if !(ok) { return nil, false }
+	return stream.session, true
+}
+
+func createMuxSession(conn net.Conn, first_id uint64, channels *muxChannels, own bool) *MuxSession {
+	var session = &MuxSession {
+		conn:       conn,
+		channels:   channels,
+		ownChannel: own,
+		worker:     rx.CreateWorker(),
+		streams:    make(map[uint64] *MuxStream),
+		nextId:     first_id,
+		peerLastId: 0,
+		done:       make(chan struct{}),
+	}
+	go session.receive()
+	return session
+}
+func (s *MuxSession) Open(channel string) (net.Conn, error) {
+	s.mutex.Lock()
+	if s.err != nil {
+		var err = s.err
+		s.mutex.Unlock()
+		return nil, err
+	}
+	var id = s.nextId
+	s.nextId += 2
+	var stream = createMuxStream(s, id, channel)
+	s.streams[id] = stream
+	s.mutex.Unlock()
+	err := s.send(MUX_OPEN, id, ([] byte)(channel))
+	if err != nil { return nil, err }
+	return stream, nil
+}
+func (s *MuxSession) Listen(channel string) (net.Listener, error) {
+	select {
+	case <- s.done:
+		return nil, s.failure()
+	default:
+		return s.channels.listen(channel, s.conn.LocalAddr())
+	}
+}
+func (s *MuxSession) Close() error {
+	s.fail(ErrMuxSessionClosed)
+	return nil
+}
+// Done is closed when the session is closed or the connection is broken.
+func (s *MuxSession) Done() (<- chan struct{}) {
+	return s.done
+}
+func (s *MuxSession) failure() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err
+}
+func (s *MuxSession) fail(err error) {
+	s.mutex.Lock()
+	if s.err != nil {
+		s.mutex.Unlock()
+		return
+	}
+	s.err = err
+	close(s.done)
+	s.streams = make(map[uint64] *MuxStream)
+	s.mutex.Unlock()
+	_ = s.conn.Close()
+	s.worker.Dispose()
+	if s.ownChannel {
+		s.channels.close()
+	}
+}
+func (s *MuxSession) send(kind string, id uint64, payload ([] byte)) error {
+	s.sending.Lock()
+	defer s.sending.Unlock()
+	err := sendMessage(kind, id, payload, s.conn)
+	if err != nil {
+		s.fail(err)
+		return err
+	}
+	return nil
+}
+func (s *MuxSession) sendLater(kind string, id uint64, payload ([] byte)) {
+	s.worker.Do(func() {
+		_ = s.send(kind, id, payload)
+	})
+}
+func (s *MuxSession) lookup(id uint64) *MuxStream {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.streams[id]
+}
+func (s *MuxSession) remove(id uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.streams, id)
+}
+func (s *MuxSession) receive() {
+	for {
+		var kind, id, payload, err = receiveMessage(s.conn)
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		err = s.dispatch(kind, id, payload)
+		if err != nil {
+			s.fail(fmt.Errorf("mux protocol error: %w", err))
+			return
+		}
+	}
+}
+func (s *MuxSession) dispatch(kind string, id uint64, payload ([] byte)) error {
+	switch kind {
+	case MUX_OPEN:
+		return s.accept(id, string(payload))
+	case MUX_DATA:
+		var stream = s.lookup(id)
+		if stream == nil {
+			// the stream has been closed or refused
+			return nil
+		}
+		return stream.receive(payload)
+	case MUX_WINDOW:
+		var n, err = strconv.ParseUint(string(payload), 10, 32)
+		if err != nil { return fmt.Errorf("invalid window frame: %w", err) }
+		var stream = s.lookup(id)
+		if stream == nil { return nil }
+		stream.grant(uint(n))
+		return nil
+	case MUX_CLOSE:
+		var stream = s.lookup(id)
+		if stream == nil { return nil }
+		stream.closeByPeer(string(payload))
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("unknown frame kind: %s", kind))
+	}
+}
+func (s *MuxSession) accept(id uint64, channel string) error {
+	s.mutex.Lock()
+	if (id % 2) == (s.nextId % 2) || id <= s.peerLastId {
+		s.mutex.Unlock()
+		return errors.New(fmt.Sprintf("invalid stream id: %d", id))
+	}
+	s.peerLastId = id
+	var stream = createMuxStream(s, id, channel)
+	s.streams[id] = stream
+	s.mutex.Unlock()
+	var refuse = func(reason string) error {
+		s.remove(id)
+		s.sendLater(MUX_CLOSE, id, ([] byte)(reason))
+		return nil
+	}
+	var l = s.channels.lookup(channel)
+	if l == nil {
+		return refuse(fmt.Sprintf("no listener on channel '%s'", channel))
+	}
+	if !(l.deliver(stream)) {
+		return refuse(fmt.Sprintf("channel '%s' is busy", channel))
+	}
+	return nil
+}
+
+
+type MuxStream struct {
+	session        *MuxSession
+	id             uint64
+	channel        string
+	mutex          sync.Mutex
+	changed        chan struct{}
+	buffer         [] byte
+	consumed       uint  // read but not granted to the peer
+	window         uint  // send window
+	closed         bool
+	peerClosed     bool
+	peerError      error  // the reason of refusal
+	readDeadline   time.Time
+	writeDeadline  time.Time
+}
+type muxAddr struct {
+	base  net.Addr
+	id    uint64
+}
+func (addr muxAddr) Network() string { return "mux" }
+func (addr muxAddr) String() string { return fmt.Sprintf("%s#%d", addr.base, addr.id) }
+
+func createMuxStream(session *MuxSession, id uint64, channel string) *MuxStream {
+	return &MuxStream {
+		session: session,
+		id:      id,
+		channel: channel,
+		changed: make(chan struct{}),
+		window:  MuxWindowSize,
+	}
+}
+func (s *MuxStream) Channel() string {
+	return s.channel
+}
+// notify should be called with the mutex locked
+func (s *MuxStream) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+// wait should be called with the mutex locked,
+// and it returns with the mutex locked.
+func (s *MuxStream) wait(deadline time.Time) error {
+	var changed = s.changed
+	var timeout (<- chan time.Time)
+	if !(deadline.IsZero()) {
+		var d = time.Until(deadline)
+		if d <= 0 { return os.ErrDeadlineExceeded }
+		var timer = time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	s.mutex.Unlock()
+	defer s.mutex.Lock()
+	select {
+	case <- changed:
+		return nil
+	case <- s.session.done:
+		return nil
+	case <- timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+func (s *MuxStream) receive(data ([] byte)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed { return nil }
+	if (uint(len(s.buffer)) + s.consumed + uint(len(data))) > MuxWindowSize {
+		return errors.New(fmt.Sprintf("stream %d: window exceeded", s.id))
+	}
+	s.buffer = append(s.buffer, data...)
+	s.notify()
+	return nil
+}
+func (s *MuxStream) grant(n uint) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.window += n
+	s.notify()
+}
+func (s *MuxStream) closeByPeer(reason string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.peerClosed = true
+	if reason != "" {
+		s.peerError = errors.New(fmt.Sprintf("mux stream refused: %s", reason))
+	}
+	s.notify()
+}
+func (s *MuxStream) Read(buf ([] byte)) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for {
+		if s.closed {
+			return 0, ErrMuxStreamClosed
+		}
+		if len(s.buffer) > 0 {
+			var n = copy(buf, s.buffer)
+			s.buffer = s.buffer[n:]
+			s.consumed += uint(n)
+			if s.consumed >= (MuxWindowSize / 2) && !(s.peerClosed) {
+				var increment = strconv.FormatUint(uint64(s.consumed), 10)
+				s.consumed = 0
+				s.session.sendLater(MUX_WINDOW, s.id, ([] byte)(increment))
+			}
+			return n, nil
+		}
+		if s.peerClosed {
+			if s.peerError != nil {
+				return 0, s.peerError
+			} else {
+				return 0, io.EOF
+			}
+		}
+		err := s.session.failure()
+		if err != nil { return 0, err }
+		if len(buf) == 0 {
+			return 0, nil
+		}
+		err = s.wait(s.readDeadline)
+		if err != nil { return 0, err }
+	}
+}
+func (s *MuxStream) Write(buf ([] byte)) (int, error) {
+	var written = 0
+	for written < len(buf) {
+		var n, err = s.reserve(len(buf) - written)
+		if err != nil { return written, err }
+		var chunk = buf[written:(written + n)]
+		err = s.session.send(MUX_DATA, s.id, chunk)
+		if err != nil { return written, err }
+		written += n
+	}
+	return written, nil
+}
+// reserve waits for the send window, and takes at most max bytes from it.
+func (s *MuxStream) reserve(max int) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for {
+		if s.closed {
+			return 0, ErrMuxStreamClosed
+		}
+		if s.peerClosed {
+			if s.peerError != nil {
+				return 0, s.peerError
+			} else {
+				return 0, ErrMuxStreamClosedByPeer
+			}
+		}
+		err := s.session.failure()
+		if err != nil { return 0, err }
+		if s.window > 0 {
+			var n = max
+			if n > int(s.window) { n = int(s.window) }
+			if n > MuxFrameDataMax { n = MuxFrameDataMax }
+			s.window -= uint(n)
+			return n, nil
+		}
+		err = s.wait(s.writeDeadline)
+		if err != nil { return 0, err }
+	}
+}
+func (s *MuxStream) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.buffer = nil
+	s.notify()
+	s.mutex.Unlock()
+	s.session.remove(s.id)
+	if s.session.failure() == nil {
+		_ = s.session.send(MUX_CLOSE, s.id, ([] byte {}))
+	}
+	return nil
+}
+func (s *MuxStream) LocalAddr() net.Addr {
+	return muxAddr { base: s.session.conn.LocalAddr(), id: s.id }
+}
+func (s *MuxStream) RemoteAddr() net.Addr {
+	return muxAddr { base: s.session.conn.RemoteAddr(), id: s.id }
+}
+func (s *MuxStream) SetDeadline(t time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.readDeadline = t
+	s.writeDeadline = t
+	s.notify()
+	return nil
+}
+func (s *MuxStream) SetReadDeadline(t time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.readDeadline = t
+	s.notify()
+	return nil
+}
+func (s *MuxStream) SetWriteDeadline(t time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.writeDeadline = t
+	s.notify()
+	return nil
+}
+
+
+// MuxHub accepts connections from a listener, and creates a server side
+// mux session for each connection. Streams opened on a channel by any
+// client are accepted by the listener of the channel.
+type MuxHub struct {
+	listener  net.Listener
+	channels  *muxChannels
+}
+func MuxServe(l net.Listener) *MuxHub {
+	var hub = &MuxHub {
+		listener: l,
+		channels: createMuxChannels(),
+	}
+	go (func() {
+		for {
+			var conn, err = l.Accept()
+			if err != nil {
+				hub.channels.close()
+				return
+			}
+			createMuxSession(conn, 2, hub.channels, false)
+		}
+	})()
+	return hub
+}
+func (hub *MuxHub) Listen(channel string) (net.Listener, error) {
+	return hub.channels.listen(channel, hub.listener.Addr())
+}
+func (hub *MuxHub) Close() error {
+	return hub.listener.Close()
+}
+
+
+type muxChannels struct {
+	mutex      sync.Mutex
+	listeners  map[string] *muxListener
+	closed     bool
+}
+type muxListener struct {
+	channels  *muxChannels
+	channel   string
+	addr      net.Addr
+	streams   chan *MuxStream
+	closed    chan struct{}
+	mutex     sync.Mutex
+	isClosed  bool
+}
+func createMuxChannels() *muxChannels {
+	return &muxChannels {
+		listeners: make(map[string] *muxListener),
+	}
+}
+func (c *muxChannels) listen(channel string, addr net.Addr) (net.Listener, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil, ErrMuxSessionClosed
+	}
+	var _, exists = c.listeners[channel]
+	if exists {
+		return nil, errors.New(fmt.Sprintf("channel '%s' is already in use", channel))
+	}
+	var l = &muxListener {
+		channels: c,
+		channel:  channel,
+		addr:     addr,
+		streams:  make(chan *MuxStream, MuxAcceptBacklog),
+		closed:   make(chan struct{}),
+	}
+	c.listeners[channel] = l
+	return l, nil
+}
+func (c *muxChannels) lookup(channel string) *muxListener {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.listeners[channel]
+}
+func (c *muxChannels) close() {
+	c.mutex.Lock()
+	c.closed = true
+	var listeners = c.listeners
+	c.listeners = make(map[string] *muxListener)
+	c.mutex.Unlock()
+	for _, l := range listeners {
+		_ = l.Close()
+	}
+}
+func (l *muxListener) deliver(stream *MuxStream) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.isClosed { return false }
+	select {
+	case l.streams <- stream:
+		return true
+	default:
+		return false
+	}
+}
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case stream := <- l.streams:
+		return stream, nil
+	case <- l.closed:
+		return nil, errors.New("use of closed mux listener")
+	}
+}
+func (l *muxListener) Close() error {
+	l.mutex.Lock()
+	if l.isClosed {
+		l.mutex.Unlock()
+		return nil
+	}
+	l.isClosed = true
+	close(l.closed)
+	l.mutex.Unlock()
+	var c = l.channels
+	c.mutex.Lock()
+	if c.listeners[l.channel] == l {
+		delete(c.listeners, l.channel)
+	}
+	c.mutex.Unlock()
+	// streams not accepted yet are refused
+	for {
+		select {
+		case stream := <- l.streams:
+			_ = stream.Close()
+		default:
+			return nil
+		}
+	}
+}
+func (l *muxListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,165 @@
+package rpc
+
+import (
+	"io"
+	"os"
+	"net"
+	"time"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"kumachan/standalone/rx"
+	"kumachan/standalone/rpc/kmd"
+)
+
+
+func testMuxEcho(l net.Listener) {
+	go (func() {
+		for {
+			var conn, err = l.Accept()
+			if err != nil { return }
+			go (func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			})()
+		}
+	})()
+}
+
+func testMuxRoundTrip(conn net.Conn, size int, seed byte) error {
+	var data = make([] byte, size)
+	for i := range data {
+		data[i] = byte(i) + seed
+	}
+	var written = make(chan error, 1)
+	go (func() {
+		var _, err = conn.Write(data)
+		written <- err
+	})()
+	var echoed = make([] byte, size)
+	var _, err = io.ReadFull(conn, echoed)
+	if err != nil { return err }
+	err = <- written
+	if err != nil { return err }
+	if !(bytes.Equal(data, echoed)) {
+		return errors.New("data corrupted")
+	}
+	return nil
+}
+
+func TestMuxStreams(t *testing.T) {
+	var a, b = net.Pipe()
+	var client = MuxClient(a)
+	var server = MuxServer(b)
+	defer client.Close()
+	echo, err := server.Listen("echo")
+	if err != nil { t.Fatal(err) }
+	testMuxEcho(echo)
+	reverse, err := client.Listen("reverse")
+	if err != nil { t.Fatal(err) }
+	testMuxEcho(reverse)
+	silent, err := server.Listen("silent")
+	if err != nil { t.Fatal(err) }
+	// a stream that is never read
+	blocked, err := client.Open("silent")
+	if err != nil { t.Fatal(err) }
+	go (func() {
+		_, _ = blocked.Write(make([] byte, (2 * MuxWindowSize)))
+	})()
+	var done = make(chan struct{})
+	for i := 0; i < 3; i += 1 {
+		var seed = byte(i)
+		go (func() {
+			defer (func() { done <- struct{}{} })()
+			var conn, err = client.Open("echo")
+			if err != nil { t.Error(err); return }
+			defer conn.Close()
+			err = testMuxRoundTrip(conn, (4 * MuxWindowSize), seed)
+			if err != nil { t.Error(err) }
+		})()
+	}
+	for i := 0; i < 3; i += 1 {
+		<- done
+	}
+	// server-initiated stream
+	conn, err := server.Open("reverse")
+	if err != nil { t.Fatal(err) }
+	err = testMuxRoundTrip(conn, 1000, 7)
+	if err != nil { t.Fatal(err) }
+	_ = conn.Close()
+	// refused stream
+	conn, err = client.Open("nothing")
+	if err != nil { t.Fatal(err) }
+	_, err = conn.Read(make([] byte, 1))
+	if err == nil || !(strings.Contains(err.Error(), "no listener")) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// read deadline
+	conn, err = client.Open("silent")
+	if err != nil { t.Fatal(err) }
+	_, _ = silent.Accept()
+	_ = conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err = conn.Read(make([] byte, 1))
+	if !(errors.Is(err, os.ErrDeadlineExceeded)) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// session closed
+	_ = server.Close()
+	<- client.Done()
+	_, err = client.Open("echo")
+	if err == nil {
+		t.Fatal("stream opened on a closed session")
+	}
+}
+
+func TestMuxServices(t *testing.T) {
+	var l, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil { t.Fatal(err) }
+	var hub = MuxServe(l)
+	defer hub.Close()
+	var service = testService()
+	var channel = DescribeServiceIdentifier(service.ServiceIdentifier)
+	service_l, err := hub.Listen(channel)
+	if err != nil { t.Fatal(err) }
+	var api = TransformerKmdApi { Transformer: kmd.CreateJsonTransformer(testSchema) }
+	rx.ScheduleBackground(Server(service, &ServerOptions {
+		Listener: service_l,
+		KmdApi:   api,
+	}).Catch(func(_ rx.Object) rx.Observable {
+		return rx.Noop()
+	}), testScheduler)
+	raw_conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil { t.Fatal(err) }
+	var session = MuxClient(raw_conn)
+	defer session.Close()
+	var names = [] string { "alice", "bob", "carol" }
+	var greetings = make([] rx.Object, len(names))
+	var clients = make([] rx.Observable, len(names))
+	for i, name := range names {
+		var i = i
+		conn, err := session.Open(channel)
+		if err != nil { t.Fatal(err) }
+		var config = map[string] interface{} { "name": name }
+		clients[i] = Client(testServiceInterface(service), &ClientOptions {
+			Connection:          conn,
+			ConstructorArgument: testTyped(config, testTypeFromName("Config")),
+			InstanceConsumer:    func(instance *ClientInstance) rx.Observable {
+				var arg = testTyped(map[string] interface{} { "greeting": "Hi" },
+					testTypeFromName("Greeting"))
+				return instance.Call("greet", arg).Then(func(v rx.Object) rx.Observable {
+					greetings[i] = v
+					return rx.Noop()
+				})
+			},
+			KmdApi: api,
+		})
+	}
+	var ok = rx.ScheduleBackgroundWaitTerminate(rx.Merge(clients), testScheduler)
+	if !(ok) { t.Fatal("client failed") }
+	for i, name := range names {
+		if greetings[i] != ("Hi, " + name + "!") {
+			t.Fatalf("unexpected greeting: %v", greetings[i])
+		}
+	}
+}
@@ -11,11 +11,27 @@ import (
 
 
 type KmdApi interface {
-	SerializeToStream(v kmd.Object, t *kmd.Type, stream io.Writer) error
-	DeserializeFromStream(t *kmd.Type, stream io.Reader) (kmd.Object, error)
+	SerializeToStream(v kmd.Object, t *kmd.Type, stream io.Writer, enc kmd.Encoding) error
+	DeserializeFromStream(t *kmd.Type, stream io.Reader, enc kmd.Encoding) (kmd.Object, error)
 }
 
-func receiveObject(t *kmd.Type, conn io.Reader, limit uint, api KmdApi) (kmd.Object, error) {
+// TransformerKmdApi adapts a kmd.Transformer (e.g. the Go struct transformer
+// or the JSON transformer) to the KmdApi interface.
+type TransformerKmdApi struct {
+	Transformer  kmd.Transformer
+}
+func (api TransformerKmdApi) SerializeToStream(v kmd.Object, _ *kmd.Type, stream io.Writer, enc kmd.Encoding) error {
+	return enc.Serialize(v, api.Transformer.Serializer, stream)
+}
+func (api TransformerKmdApi) DeserializeFromStream(t *kmd.Type, stream io.Reader, enc kmd.Encoding) (kmd.Object, error) {
+	var obj, real_t, err = enc.Deserialize(stream, api.Transformer.Deserializer)
+	if err != nil { return nil, err }
+	return api.Transformer.AssignObject(obj, real_t, t)
+}
+
+// Objects in the text encoding are compressed by gzip, while objects in
+// the binary encoding are sent as is, since they are already compact.
+func receiveObject(t *kmd.Type, conn io.Reader, limit uint, api KmdApi, enc kmd.Encoding) (kmd.Object, error) {
 	var length uint64
 	err := binary.Read(conn, binary.BigEndian, &length)
 	if err != nil { return nil, err }
@@ -25,22 +41,51 @@ func receiveObject(t *kmd.Type, conn io.Reader, limit uint, api KmdApi) (kmd.Obj
 	var buf = make([] byte, length)
 	_, err = io.ReadFull(conn, buf)
 	if err != nil { return nil, err }
+	if enc == kmd.BinaryEncoding {
+		return api.DeserializeFromStream(t, bytes.NewReader(buf), enc)
+	}
 	var decompressed, gz_err = gzip.NewReader(bytes.NewReader(buf))
 	if gz_err != nil { panic(gz_err) }
-	return api.DeserializeFromStream(t, decompressed)
+	return api.DeserializeFromStream(t, decompressed, enc)
 }
 
-func sendObject(obj kmd.Object, t *kmd.Type, conn io.Writer, api KmdApi) error {
+func sendObject(obj kmd.Object, t *kmd.Type, conn io.Writer, api KmdApi, enc kmd.Encoding) error {
 	var buf bytes.Buffer
-	var compressed = gzip.NewWriter(&buf)
-	err := api.SerializeToStream(obj, t, compressed)
-	if err != nil { return err }
-	err = compressed.Close()
-	if err != nil { return err }
+	if enc == kmd.BinaryEncoding {
+		err := api.SerializeToStream(obj, t, &buf, enc)
+		if err != nil { return err }
+	} else {
+		var compressed = gzip.NewWriter(&buf)
+		err := api.SerializeToStream(obj, t, compressed, enc)
+		if err != nil { return err }
+		err = compressed.Close()
+		if err != nil { return err }
+	}
 	var bin = buf.Bytes()
-	err = binary.Write(conn, binary.BigEndian, uint64(len(bin)))
+	var err = binary.Write(conn, binary.BigEndian, uint64(len(bin)))
 	if err != nil { return err }
 	_, err = conn.Write(bin)
 	return err
 }
 
+
+// countingReader and countingWriter count the bytes of objects
+// received and sent, which are reported to interceptors.
+type countingReader struct {
+	reader  io.Reader
+	count   uint64
+}
+func (r *countingReader) Read(buf ([] byte)) (int, error) {
+	var n, err = r.reader.Read(buf)
+	r.count += uint64(n)
+	return n, err
+}
+type countingWriter struct {
+	writer  io.Writer
+	count   uint64
+}
+func (w *countingWriter) Write(buf ([] byte)) (int, error) {
+	var n, err = w.writer.Write(buf)
+	w.count += uint64(n)
+	return n, err
+}
@@ -0,0 +1,83 @@
+package rpc
+
+import (
+	"net"
+	"testing"
+	"encoding/json"
+	"kumachan/standalone/rx"
+	"kumachan/standalone/rpc/kmd"
+)
+
+
+func TestBinaryEncoding(t *testing.T) {
+	var service = testService()
+	var l, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil { t.Fatal(err) }
+	defer l.Close()
+	var api = TransformerKmdApi { Transformer: kmd.CreateJsonTransformer(testSchema) }
+	var encodings = make([] kmd.Encoding, 0)
+	rx.ScheduleBackground(Server(service, &ServerOptions {
+		Listener:     l,
+		KmdApi:       api,
+		Interceptors: [] Interceptor {
+			func(conn ConnectionInfo) CallInterceptor {
+				encodings = append(encodings, conn.Encoding)
+				return func(_ CallInfo, next CallHandler) rx.Observable {
+					return next()
+				}
+			},
+		},
+	}).Catch(func(_ rx.Object) rx.Observable {
+		return rx.Noop()
+	}), testScheduler)
+	for _, enc := range [] kmd.Encoding { kmd.BinaryEncoding, kmd.TextEncoding } {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil { t.Fatal(err) }
+		var greeting rx.Object
+		var values = make([] rx.Object, 0)
+		var config = map[string] interface{} { "name": "tester" }
+		var arg = testTyped(map[string] interface{} { "greeting": "Hi" },
+			testTypeFromName("Greeting"))
+		var three = testTyped(json.Number("3"), testInteger)
+		var client = Client(testServiceInterface(service), &ClientOptions {
+			Connection:          conn,
+			ConstructorArgument: testTyped(config, testTypeFromName("Config")),
+			InstanceConsumer:    func(instance *ClientInstance) rx.Observable {
+				return instance.Call("greet", arg).Then(func(v rx.Object) rx.Observable {
+					greeting = v
+					return instance.Call("count", three).ConcatMap(func(v rx.Object) rx.Observable {
+						values = append(values, v)
+						return rx.Noop()
+					}).WaitComplete()
+				})
+			},
+			Encoding:            enc,
+			KmdApi:              api,
+		})
+		var ok = rx.ScheduleBackgroundWaitTerminate(client, testScheduler)
+		if !(ok) { t.Fatalf("client failed (%s encoding)", enc) }
+		if greeting != "Hi, tester!" || len(values) != 3 {
+			t.Fatalf("unexpected result (%s encoding): %v %v", enc, greeting, values)
+		}
+	}
+	if len(encodings) != 2 ||
+		encodings[0] != kmd.BinaryEncoding || encodings[1] != kmd.TextEncoding {
+		t.Fatalf("unexpected encodings of connections: %v", encodings)
+	}
+}
+
+func TestServicePayload(t *testing.T) {
+	var id = "foo.bar:rpc:Baz:v1"
+	if string(encodeServicePayload(id, kmd.TextEncoding)) != id {
+		t.Fatal("service header should be omitted for the text encoding")
+	}
+	var decoded, enc, err = decodeServicePayload(encodeServicePayload(id, kmd.BinaryEncoding))
+	if err != nil { t.Fatal(err) }
+	if decoded != id || enc != kmd.BinaryEncoding {
+		t.Fatalf("unexpected decoded payload: %s %s", decoded, enc)
+	}
+	_, _, err = decodeServicePayload(([] byte)(id + "\n" + `{"encoding":"xml"}`))
+	if err == nil {
+		t.Fatal("unsupported encoding accepted")
+	}
+}
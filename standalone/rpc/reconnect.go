@@ -0,0 +1,159 @@
+package rpc
+
+import (
+	"io"
+	"net"
+	"time"
+	"kumachan/standalone/rx"
+	"kumachan/standalone/rpc/kmd"
+)
+
+
+/**
+ *  Reconnecting Client
+ *
+ *  A reconnecting client keeps connected to a server for a long time.
+ *  When the connection is lost (or cannot be established), it connects
+ *  again after a delay, which is doubled on each failure, from
+ *  InitialDelay up to MaxDelay. The constructor of the service is run
+ *  again on each new connection (with the same argument), and the delay
+ *  is reset once a service instance is created.
+ *  Calls are made on the current service instance, and they wait until
+ *  the client is connected. When the connection is lost, multi-value
+ *  calls (call*) in progress are subscribed again on the new instance,
+ *  i.e. the method is called again and its values are passed to the
+ *  same stream, while single-value calls in progress fail with an error
+ *  that satisfies IsConnectionLost, since it is not known whether they
+ *  have taken effect on the server.
+ */
+
+const DefaultBackoffInitialDelay = (100 * time.Millisecond)
+const DefaultBackoffMaxDelay = (30 * time.Second)
+
+type ReconnectingClientOptions struct {
+	Backend              func() (net.Conn, error)  // connects to a server
+	DebugOutput          io.Writer
+	ConstructorArgument  kmd.Object
+	InstanceConsumer     func(*ReconnectingClientInstance) rx.Observable
+	Encoding             kmd.Encoding
+	Backoff
+	Limits
+	KmdApi
+}
+type Backoff struct {
+	InitialDelay  time.Duration  // zero value: DefaultBackoffInitialDelay
+	MaxDelay      time.Duration  // zero value: DefaultBackoffMaxDelay
+	MaxRetries    uint           // zero value: unlimited
+}
+func (b Backoff) delay(retries uint) time.Duration {
+	var d = b.InitialDelay
+	if d == 0 { d = DefaultBackoffInitialDelay }
+	var max = b.MaxDelay
+	if max == 0 { max = DefaultBackoffMaxDelay }
+	for i := uint(0); i < retries && d < max; i += 1 {
+		d *= 2
+	}
+	if d > max { d = max }
+	return d
+}
+
+// ReconnectingClient returns an action that completes when the action
+// returned by the instance consumer completes, and fails when the
+// consumer fails or the maximum number of retries is exceeded.
+func ReconnectingClient(service ServiceInterface, opts *ReconnectingClientOptions) rx.Observable {
+	var logger = ClientLogger {
+		Output: opts.DebugOutput,
+	}
+	var instance = &ReconnectingClientInstance {
+		service: service,
+		current: rx.CreateReactive(nil),
+	}
+	var retries = uint(0)
+	var connect func() rx.Observable
+	connect = func() rx.Observable {
+		return rx.NewGoroutineSingle(func(ctx *rx.Context) (rx.Object, bool) {
+			var conn, err = opts.Backend()
+			if err != nil { return err, false }
+			if ctx.AlreadyCancelled() {
+				_ = conn.Close()
+			}
+			return conn, true
+		}).Then(func(conn rx.Object) rx.Observable {
+			return Client(service, &ClientOptions {
+				Connection:          conn.(net.Conn),
+				DebugOutput:         opts.DebugOutput,
+				ConstructorArgument: opts.ConstructorArgument,
+				InstanceConsumer:    func(current *ClientInstance) rx.Observable {
+					return rx.NewSync(func() (rx.Object, bool) {
+						retries = 0
+						return nil, true
+					}).Then(func(_ rx.Object) rx.Observable {
+						return instance.current.Emit(current)
+					}).Then(func(_ rx.Object) rx.Observable {
+						// keep the connection until it is lost or disposed
+						return rx.NewSubscription(func(_ func(rx.Object)) func() {
+							return nil
+						})
+					})
+				},
+				Encoding:            opts.Encoding,
+				Limits:              opts.Limits,
+				KmdApi:              opts.KmdApi,
+			})
+		}).Catch(func(err_ rx.Object) rx.Observable {
+			var err = err_.(error)
+			return instance.current.Emit(nil).Then(func(_ rx.Object) rx.Observable {
+				if opts.MaxRetries != 0 && retries >= opts.MaxRetries {
+					return rx.Throw(err)
+				}
+				var delay = opts.Backoff.delay(retries)
+				retries += 1
+				logger.LogRetry(err, delay)
+				var ms = uint(delay / time.Millisecond)
+				return rx.Timer(ms).Then(func(_ rx.Object) rx.Observable {
+					return connect()
+				})
+			})
+		})
+	}
+	var consume = opts.InstanceConsumer(instance).WaitComplete()
+	return connect().TakeUntil(consume)
+}
+
+type ReconnectingClientInstance struct {
+	service  ServiceInterface
+	current  rx.ReactiveEntity  // *ClientInstance, or nil if not connected
+}
+func (instance *ReconnectingClientInstance) connected() rx.Observable {
+	return instance.current.Watch().Filter(func(current rx.Object) bool {
+		return (current != nil)
+	}).Take(1)
+}
+// Connected emits true when a service instance is created on a new
+// connection, and emits false when the connection is lost.
+func (instance *ReconnectingClientInstance) Connected() rx.Observable {
+	return instance.current.Watch().Map(func(current rx.Object) rx.Object {
+		return (current != nil)
+	}).DistinctUntilChanged(func(a rx.Object, b rx.Object) bool {
+		return (a.(bool) == b.(bool))
+	})
+}
+func (instance *ReconnectingClientInstance) Call(method_name string, arg kmd.Object) rx.Observable {
+	return instance.CallWithOptions(method_name, arg, CallOptions {})
+}
+func (instance *ReconnectingClientInstance) CallWithOptions(method_name string, arg kmd.Object, opts CallOptions) rx.Observable {
+	var method, exists = instance.service.Methods[method_name]
+	if !(exists) { panic("something went wrong") }
+	var call func() rx.Observable
+	call = func() rx.Observable {
+		return instance.connected().Then(func(current rx.Object) rx.Observable {
+			return current.(*ClientInstance).CallWithOptions(method_name, arg, opts)
+		}).Catch(func(err rx.Object) rx.Observable {
+			if method.MultiValue && IsConnectionLost(err.(error)) {
+				return call()
+			}
+			return rx.Throw(err)
+		})
+	}
+	return call()
+}
@@ -0,0 +1,161 @@
+package rpc
+
+import (
+	"net"
+	"sync"
+	"time"
+	"errors"
+	"testing"
+	"encoding/json"
+	"kumachan/standalone/rx"
+	"kumachan/standalone/rpc/kmd"
+)
+
+
+type testKillableListener struct {
+	net.Listener
+	mutex  sync.Mutex
+	conns  [] net.Conn
+}
+func (l *testKillableListener) Accept() (net.Conn, error) {
+	var conn, err = l.Listener.Accept()
+	if err != nil { return nil, err }
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.conns = append(l.conns, conn)
+	return conn, nil
+}
+func (l *testKillableListener) kill() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, conn := range l.conns {
+		_ = conn.Close()
+	}
+	l.conns = nil
+}
+
+func TestBackoff(t *testing.T) {
+	var b = Backoff {
+		InitialDelay: (10 * time.Millisecond),
+		MaxDelay:     (50 * time.Millisecond),
+	}
+	var expected = [] time.Duration { 10, 20, 40, 50, 50 }
+	for i, d := range expected {
+		if b.delay(uint(i)) != (d * time.Millisecond) {
+			t.Fatalf("unexpected delay of retry %d: %s", i, b.delay(uint(i)))
+		}
+	}
+	if (Backoff {}).delay(100) != DefaultBackoffMaxDelay {
+		t.Fatal("unexpected default maximum delay")
+	}
+}
+
+func TestReconnectingClient(t *testing.T) {
+	var service = createTestCallService()
+	var raw_l, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil { t.Fatal(err) }
+	var l = &testKillableListener { Listener: raw_l }
+	var api = TransformerKmdApi { Transformer: kmd.CreateJsonTransformer(testSchema) }
+	rx.ScheduleBackground(Server(service.Service, &ServerOptions {
+		Listener: l,
+		KmdApi:   api,
+	}).Catch(func(_ rx.Object) rx.Observable {
+		return rx.Noop()
+	}), testScheduler)
+	defer l.Close()
+	var addr = l.Addr().String()
+	var config = map[string] interface{} { "name": "tester" }
+	var zero = testTyped(json.Number("0"), testInteger)
+	var unit = testTyped(nil, testTypeFromName("Unit"))
+	var greeting_arg = testTyped(map[string] interface{} { "greeting": "Hi" },
+		testTypeFromName("Greeting"))
+	var ticks = 0
+	var connected = make([] bool, 0)
+	var hang_err error
+	var greeting rx.Object
+	var client = ReconnectingClient(testServiceInterface(service.Service), &ReconnectingClientOptions {
+		Backend: func() (net.Conn, error) {
+			return net.Dial("tcp", addr)
+		},
+		ConstructorArgument: testTyped(config, testTypeFromName("Config")),
+		InstanceConsumer:    func(instance *ReconnectingClientInstance) rx.Observable {
+			var watch = instance.Connected().Take(4).ConcatMap(func(v rx.Object) rx.Observable {
+				connected = append(connected, v.(bool))
+				return rx.Noop()
+			}).WaitComplete()
+			var hang = instance.Call("hang", unit).Catch(func(e rx.Object) rx.Observable {
+				hang_err = e.(error)
+				return rx.Noop()
+			}).WaitComplete()
+			var stream = instance.Call("ticks", zero).Take(6).ConcatMap(func(_ rx.Object) rx.Observable {
+				ticks += 1
+				if ticks == 3 {
+					l.kill()
+				}
+				return rx.Noop()
+			}).WaitComplete().Then(func(_ rx.Object) rx.Observable {
+				return instance.Call("greet", greeting_arg)
+			}).Then(func(v rx.Object) rx.Observable {
+				greeting = v
+				return rx.Noop()
+			})
+			return rx.Merge([] rx.Observable { watch, hang, stream })
+		},
+		Backoff: Backoff {
+			InitialDelay: (10 * time.Millisecond),
+		},
+		KmdApi: api,
+	})
+	var ok = rx.ScheduleBackgroundWaitTerminate(client, testScheduler)
+	if !(ok) { t.Fatal("client failed") }
+	if ticks != 6 {
+		t.Fatalf("unexpected number of values: %d", ticks)
+	}
+	if !(IsConnectionLost(hang_err)) {
+		t.Fatalf("unexpected error: %v", hang_err)
+	}
+	if greeting != "Hi, tester!" {
+		t.Fatalf("unexpected greeting: %v", greeting)
+	}
+	var expected = [] bool { false, true, false, true }
+	for i, v := range expected {
+		if connected[i] != v {
+			t.Fatalf("unexpected connection states: %v", connected)
+		}
+	}
+}
+
+func TestReconnectingClientMaxRetries(t *testing.T) {
+	var service = testService()
+	var attempts = 0
+	var refused = errors.New("refused")
+	var client = ReconnectingClient(testServiceInterface(service), &ReconnectingClientOptions {
+		Backend: func() (net.Conn, error) {
+			attempts += 1
+			return nil, refused
+		},
+		InstanceConsumer: func(instance *ReconnectingClientInstance) rx.Observable {
+			return instance.Call("greet", nil)
+		},
+		Backoff: Backoff {
+			InitialDelay: time.Millisecond,
+			MaxRetries:   2,
+		},
+	})
+	var e = make(chan rx.Object, 1)
+	rx.Schedule(client, testScheduler, rx.Receiver {
+		Context: rx.Background(),
+		Error:   e,
+	})
+	select {
+	case err := <- e:
+		if err != refused {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <- time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	if attempts != 3 {
+		t.Fatalf("unexpected number of attempts: %d", attempts)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"io"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 	"errors"
 	"kumachan/standalone/rx"
@@ -12,8 +13,9 @@ import (
 
 
 type ServerOptions struct {
-	Listener     net.Listener
-	DebugOutput  io.Writer
+	Listener      net.Listener
+	DebugOutput   io.Writer
+	Interceptors  [] Interceptor
 	Limits
 	KmdApi
 }
@@ -45,15 +47,15 @@ func Server(service Service, opts *ServerOptions) rx.Observable {
 				conn.Fatal(err)
 				return struct{}{}
 			}
-			client_info, err := receiveServiceConfirmation(conn)
+			client_info, enc, err := receiveServiceConfirmation(conn)
 			if err != nil { return fatal(err) }
 			err = validateServiceConfirmation(client_info, service)
 			if err != nil { return fatal(err) }
-			arg, err := receiveConstructorArgument(conn, service, opts)
+			arg, err := receiveConstructorArgument(conn, service, enc, opts)
 			if err != nil { return fatal(err) }
 			instance, err := constructServiceInstance(arg, conn, service)
 			if err != nil { return fatal(err) }
-			err = serverProcessMessages(instance, conn, logger, service, opts)
+			err = serverProcessMessages(instance, conn, logger, service, enc, opts)
 			if err != nil { return fatal(err) }
 			return struct{}{}
 		}
@@ -71,22 +73,27 @@ func Server(service Service, opts *ServerOptions) rx.Observable {
 }
 
 
-func receiveServiceConfirmation(conn io.Reader) (ServiceIdentifier, error) {
+func receiveServiceConfirmation(conn io.Reader) (ServiceIdentifier, kmd.Encoding, error) {
 	kind, _, payload, err := receiveMessage(conn)
 	if err != nil {
-		return ServiceIdentifier{},
+		return ServiceIdentifier{}, kmd.TextEncoding,
 			fmt.Errorf("failed to receive service confirmation: %w", err)
 	}
 	if kind != MSG_SERVICE {
-		return ServiceIdentifier{},
+		return ServiceIdentifier{}, kmd.TextEncoding,
 			errors.New(fmt.Sprintf("unexpected message kind: %s", kind))
 	}
-	id, err := ParseServiceIdentifier(string(payload))
+	service_id, enc, err := decodeServicePayload(payload)
 	if err != nil {
-		return ServiceIdentifier{},
+		return ServiceIdentifier{}, kmd.TextEncoding,
 			fmt.Errorf("failed to parse service confirmation: %w", err)
 	}
-	return id, nil
+	id, err := ParseServiceIdentifier(service_id)
+	if err != nil {
+		return ServiceIdentifier{}, kmd.TextEncoding,
+			fmt.Errorf("failed to parse service confirmation: %w", err)
+	}
+	return id, enc, nil
 }
 func validateServiceConfirmation(id ServiceIdentifier, service Service) error {
 	if id != service.ServiceIdentifier {
@@ -95,10 +102,10 @@ func validateServiceConfirmation(id ServiceIdentifier, service Service) error {
 	return nil
 }
 
-func receiveConstructorArgument(conn io.Reader, service Service, opts *ServerOptions) (kmd.Object, error) {
+func receiveConstructorArgument(conn io.Reader, service Service, enc kmd.Encoding, opts *ServerOptions) (kmd.Object, error) {
 	var ctor = service.Constructor
 	var limit = opts.RecvMaxObjectSize
-	arg, err := receiveObject(ctor.ArgType, conn, limit, opts.KmdApi)
+	arg, err := receiveObject(ctor.ArgType, conn, limit, opts.KmdApi, enc)
 	if err != nil {
 		return nil, fmt.Errorf("failed to receive ctor argument: %w", err)
 	}
@@ -144,8 +151,147 @@ func sendInstanceCreated(conn *rx.WrappedConnection) error {
 	return nil
 }
 
-func serverProcessMessages(instance kmd.Object, conn *rx.WrappedConnection, logger *ServerLogger, service Service, opts *ServerOptions) error {
+type CallInfo struct {
+	Id          uint64
+	Method      string
+	Metadata    map[string] string
+	Deadline    time.Time  // zero value if no deadline
+	ArgSize     uint64     // size of the argument (in bytes)
+	Connection  ConnectionInfo
+}
+
+// serverCallTable tracks calls in progress on a connection, which ensures
+// that exactly one terminal message (error or complete) is sent for each
+// call, even if the call is cancelled or its deadline is exceeded.
+// The state of a call is checked and modified on the worker of the
+// connection, which is also where messages are sent.
+type serverCallTable struct {
+	mutex  sync.Mutex
+	calls  map[uint64] *serverCall
+}
+type serverCall struct {
+	info     CallInfo
+	stop     chan struct{}
+	stopped  bool
+	reason   error  // nil for cancellation
+	timer    *time.Timer
+}
+func createServerCallTable() *serverCallTable {
+	return &serverCallTable {
+		calls: make(map[uint64] *serverCall),
+	}
+}
+func (table *serverCallTable) add(info CallInfo, timeout time.Duration, on_timeout func()) (*serverCall, bool) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	var _, exists = table.calls[info.Id]
+	if exists { return nil, false }
+	var call = &serverCall {
+		info: info,
+		stop: make(chan struct{}),
+	}
+	if timeout != 0 {
+		call.timer = time.AfterFunc(timeout, on_timeout)
+	}
+	table.calls[info.Id] = call
+	return call, true
+}
+func (table *serverCallTable) active(id uint64) bool {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	var call, exists = table.calls[id]
+	return (exists && !(call.stopped))
+}
+func (table *serverCallTable) stop(id uint64, reason error) bool {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	var call, exists = table.calls[id]
+	if !(exists) || call.stopped { return false }
+	call.stopped = true
+	call.reason = reason
+	close(call.stop)
+	return true
+}
+func (table *serverCallTable) finish(id uint64) (*serverCall, bool) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	var call, exists = table.calls[id]
+	if !(exists) { return nil, false }
+	delete(table.calls, id)
+	if call.timer != nil {
+		call.timer.Stop()
+	}
+	return call, true
+}
+func (table *serverCallTable) dispose() {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	for id, call := range table.calls {
+		if call.timer != nil {
+			call.timer.Stop()
+		}
+		if !(call.stopped) {
+			call.stopped = true
+			close(call.stop)
+		}
+		delete(table.calls, id)
+	}
+}
+func (call *serverCall) stopSignal() rx.Observable {
+	return rx.NewGoroutine(func(sender rx.Sender) {
+		select {
+		case <- call.stop:
+			sender.Next(nil)
+			sender.Complete()
+		case <- sender.Context().CancelSignal():
+		}
+	})
+}
+
+func serverProcessMessages(instance kmd.Object, conn *rx.WrappedConnection, logger *ServerLogger, service Service, enc kmd.Encoding, opts *ServerOptions) error {
 	var interval = opts.RecvInterval
+	var conn_info = ConnectionInfo {
+		Service:    service.ServiceIdentifier,
+		LocalAddr:  logger.LocalAddr,
+		RemoteAddr: logger.RemoteAddr,
+		Encoding:   enc,
+		Logger:     *logger,
+	}
+	var interceptors = make([] CallInterceptor, len(opts.Interceptors))
+	for i, interceptor := range opts.Interceptors {
+		interceptors[i] = interceptor(conn_info)
+	}
+	var calls = createServerCallTable()
+	defer calls.dispose()
+	var worker = conn.Worker()
+	var with_worker = func(do (func() error)) rx.Observable {
+		return rx.NewQueuedNoValue(worker, func() (bool, rx.Object) {
+			err := do()
+			if err != nil { return false, err }
+			return true, nil
+		})
+	}
+	// should be called on the worker
+	var send_terminal = func(id uint64, normal (func() error)) error {
+		var call, ok = calls.finish(id)
+		if !(ok) { return nil }
+		if call.stopped {
+			if call.reason != nil {
+				return sendCallException(call.reason, id, conn)
+			} else {
+				return sendCallCompletion(id, conn)
+			}
+		}
+		return normal()
+	}
+	var stop_call = func(id uint64, reason error) {
+		if calls.stop(id, reason) {
+			worker.Do(func() {
+				err := send_terminal(id, nil)
+				if err != nil { logger.LogError(err) }
+			})
+		}
+	}
 	for {
 		if interval != 0 {
 			<- time.After(interval)
@@ -154,7 +300,8 @@ func serverProcessMessages(instance kmd.Object, conn *rx.WrappedConnection, logg
 		if err != nil { return fmt.Errorf("error receiving message: %w", err) }
 		switch kind {
 		case MSG_CALL, MSG_CALL_MULTI:
-			var method_name = string(payload)
+			method_name, header, err := decodeCallPayload(payload)
+			if err != nil { return err }
 			var method, exists = service.Methods[method_name]
 			if !(exists) {
 				return errors.New(fmt.Sprintf("method '%s' does not exist",
@@ -165,70 +312,123 @@ func serverProcessMessages(instance kmd.Object, conn *rx.WrappedConnection, logg
 				return errors.New(fmt.Sprintf("wrong quantifier (method: '%s')",
 					method_name))
 			}
-			arg, err := receiveCallArgument(method, conn, opts)
+			arg, arg_size, err := receiveCallArgument(method, conn, enc, opts)
 			if err != nil { return err }
-			var action = method.GetAction(instance, arg)
-			var worker = conn.Worker()
-			var with_worker = func(do (func() error)) rx.Observable {
-				return rx.NewQueuedNoValue(worker, func() (bool, rx.Object) {
-					err := do()
-					if err != nil { return false, err }
-					return true, nil
-				})
+			var info = CallInfo {
+				Id:         id,
+				Method:     method_name,
+				Metadata:   header.Metadata,
+				ArgSize:    arg_size,
+				Connection: conn_info,
+			}
+			var timeout = (time.Duration(header.Timeout) * time.Millisecond)
+			if timeout != 0 {
+				info.Deadline = time.Now().Add(timeout)
+			}
+			call, ok := calls.add(info, timeout, func() {
+				stop_call(info.Id, deadlineExceededError())
+			})
+			if !(ok) {
+				return errors.New(fmt.Sprintf("duplicate call id: %d", id))
 			}
+			var result CallResult
 			var send_value = func(value kmd.Object) rx.Observable {
 				return with_worker(func() error {
-					return sendCallReturnValue(value, id, method, conn, opts)
+					if !(calls.active(info.Id)) { return nil }
+					size, err := sendCallReturnValue(value, info.Id, method, conn, enc, opts)
+					if err != nil { return err }
+					result.Values += 1
+					result.SentSize += size
+					return nil
 				})
 			}
 			var send_exception = func(e kmd.Object) rx.Observable {
 				var e_as_error, e_is_error = e.(error)
 				if !(e_is_error) { panic("invalid exception") }
+				result.Exception = e_as_error
+				logger.LogCallError(info, e_as_error)
 				return with_worker(func() error {
-					return sendCallException(e_as_error, id, conn)
+					return send_terminal(info.Id, func() error {
+						return sendCallException(e_as_error, info.Id, conn)
+					})
 				})
 			}
-			var send_completion = func(err_val kmd.Object) rx.Observable {
+			var send_completion = func(_ kmd.Object) rx.Observable {
 				return with_worker(func() error {
-					return sendCallCompletion(id, conn)
+					return send_terminal(info.Id, func() error {
+						return sendCallCompletion(info.Id, conn)
+					})
 				})
 			}
+			var get_result = func(_ rx.Object) rx.Observable {
+				return rx.NewSync(func() (rx.Object, bool) {
+					// the call has been finished, and its state is no longer changed
+					if call.stopped {
+						result.Exception = call.reason
+						result.Cancelled = (call.reason == nil)
+					}
+					return result, true
+				})
+			}
+			var handle = func() rx.Observable {
+				var action = method.GetAction(instance, arg)
+				return action.
+					TakeUntil(call.stopSignal()).
+					Catch(send_exception).
+					ConcatMap(send_value).
+					WaitComplete().
+					Then(send_completion).
+					WaitComplete().
+					Then(get_result)
+			}
 			var send_all =
-				action.
-				Catch(send_exception).
-				ConcatMap(send_value).
+				chainInterceptors(interceptors, info, handle).
 				WaitComplete().
-				Then(send_completion).
 				Catch(func(err_ rx.Object) rx.Observable {
-					logger.LogError(err.(error))
+					// rejected by an interceptor, or failed to send the result
+					var err = err_.(error)
+					logger.LogCallError(info, err)
+					return with_worker(func() error {
+						return send_terminal(info.Id, func() error {
+							return sendCallException(err, info.Id, conn)
+						})
+					})
+				}).
+				Catch(func(err rx.Object) rx.Observable {
+					logger.LogCallError(info, err.(error))
 					return rx.Noop()
 				})
 			rx.Schedule(send_all, conn.Scheduler(), rx.Receiver {
 				Context: conn.Context(),
 			})
+		case MSG_CANCEL:
+			// the call may have been finished, which is not an error
+			stop_call(id, nil)
 		default:
 			return errors.New(fmt.Sprintf("unknown message kind: %s", kind))
 		}
 	}
 }
-func receiveCallArgument(method ServiceMethod, conn *rx.WrappedConnection, opts *ServerOptions) (kmd.Object, error) {
+func receiveCallArgument(method ServiceMethod, conn *rx.WrappedConnection, enc kmd.Encoding, opts *ServerOptions) (kmd.Object, uint64, error) {
 	var limit = opts.RecvMaxObjectSize
-	arg, err := receiveObject(method.ArgType, conn, limit, opts.KmdApi)
+	var counter = &countingReader { reader: conn }
+	arg, err := receiveObject(method.ArgType, counter, limit, opts.KmdApi, enc)
 	if err != nil {
-		return nil, fmt.Errorf("failed to receive method argument: %w", err)
+		return nil, 0, fmt.Errorf("failed to receive method argument: %w", err)
 	}
-	return arg, nil
+	return arg, counter.count, nil
 }
-func sendCallReturnValue(value kmd.Object, id uint64, method ServiceMethod, conn *rx.WrappedConnection, opts *ServerOptions) error {
+func sendCallReturnValue(value kmd.Object, id uint64, method ServiceMethod, conn *rx.WrappedConnection, enc kmd.Encoding, opts *ServerOptions) (uint64, error) {
 	err := sendMessage(MSG_VALUE, id, ([] byte {}), conn)
 	if err != nil {
-		return fmt.Errorf("error sending value event header: %w", err)
+		return 0, fmt.Errorf("error sending value event header: %w", err)
 	}
-	err = sendObject(value, method.RetType, conn, opts.KmdApi)
+	var counter = &countingWriter { writer: conn }
+	err = sendObject(value, method.RetType, counter, opts.KmdApi, enc)
 	if err != nil {
-		return fmt.Errorf("error sending value event object: %w", err)
+		return 0, fmt.Errorf("error sending value event object: %w", err)
 	}
-	return nil
+	return counter.count, nil
 }
 func sendCallException(e error, id uint64, conn *rx.WrappedConnection) error {
 	err := sendError(e, id, conn)
@@ -0,0 +1,255 @@
+package rpc
+
+import (
+	"io"
+	"net"
+	"math"
+	"bytes"
+	"sync"
+	"bufio"
+	"errors"
+	"strings"
+	"net/http"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/base64"
+)
+
+
+/**
+ *  WebSocket (Server Side)
+ *
+ *  A minimal implementation of RFC 6455, which is sufficient for the
+ *  gateway: only text and binary messages are delivered, fragmented
+ *  messages are reassembled, pings are answered automatically, and
+ *  extensions (e.g. compression) are not negotiated.
+ */
+
+const wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+const wsMaxPreallocation = (64 * 1024)
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+const (
+	WsCloseNormal          = 1000
+	WsCloseProtocolError   = 1002
+	WsCloseMessageTooBig   = 1009
+	WsCloseInternalError   = 1011
+)
+
+type WebSocket struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	writing  sync.Mutex
+	closed   bool
+	limit    uint
+}
+var errWebSocketClosed = errors.New("websocket closed")
+
+func IsWebSocketRequest(req *http.Request) bool {
+	return headerHasToken(req.Header, "Connection", "upgrade") &&
+		headerHasToken(req.Header, "Upgrade", "websocket")
+}
+func headerHasToken(h http.Header, key string, token string) bool {
+	for _, value := range h.Values(key) {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// UpgradeWebSocket performs the opening handshake. If the request is not
+// a valid WebSocket handshake, an error response is written.
+// The size of received messages is not limited if limit is zero.
+func UpgradeWebSocket(w http.ResponseWriter, req *http.Request, limit uint) (*WebSocket, error) {
+	var bad = func(msg string) (*WebSocket, error) {
+		http.Error(w, msg, http.StatusBadRequest)
+		return nil, errors.New(msg)
+	}
+	if req.Method != http.MethodGet {
+		return bad("websocket handshake requires GET method")
+	}
+	if !(IsWebSocketRequest(req)) {
+		return bad("not a websocket handshake")
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return bad("unsupported websocket version")
+	}
+	var key = req.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return bad("missing websocket key")
+	}
+	var hijacker, ok = w.(http.Hijacker)
+	if !(ok) {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("http connection cannot be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil { return nil, err }
+	var digest = sha1.Sum(([] byte)(key + wsAcceptGUID))
+	var accept = base64.StdEncoding.EncodeToString(digest[:])
+	var response = ("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + accept + "\r\n\r\n")
+	_, err = conn.Write(([] byte)(response))
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &WebSocket {
+		conn:   conn,
+		reader: rw.Reader,
+		limit:  limit,
+	}, nil
+}
+
+func (ws *WebSocket) Conn() net.Conn {
+	return ws.conn
+}
+
+// ReadMessage returns the next text or binary message.
+// io.EOF is returned when the peer closes the connection normally.
+func (ws *WebSocket) ReadMessage() ([] byte, error) {
+	var message ([] byte)
+	var started = false
+	for {
+		var fin, op, payload, err = ws.readFrame()
+		if err != nil { return nil, err }
+		switch op {
+		case wsOpPing:
+			err := ws.writeFrame(wsOpPong, payload)
+			if err != nil { return nil, err }
+		case wsOpPong:
+			// ignored
+		case wsOpClose:
+			_ = ws.Close(WsCloseNormal, "")
+			return nil, io.EOF
+		case wsOpText, wsOpBinary, wsOpContinuation:
+			if (op == wsOpContinuation) != started {
+				return nil, ws.fail(WsCloseProtocolError, "unexpected continuation")
+			}
+			started = true
+			message = append(message, payload...)
+			if ws.limit != 0 && uint(len(message)) > ws.limit {
+				return nil, ws.fail(WsCloseMessageTooBig, "message too big")
+			}
+			if fin {
+				return message, nil
+			}
+		default:
+			return nil, ws.fail(WsCloseProtocolError, "unknown opcode")
+		}
+	}
+}
+func (ws *WebSocket) readFrame() (bool, byte, ([] byte), error) {
+	var header [2] byte
+	_, err := io.ReadFull(ws.reader, header[:])
+	if err != nil { return false, 0, nil, err }
+	var fin = ((header[0] & 0x80) != 0)
+	var op = (header[0] & 0x0F)
+	var masked = ((header[1] & 0x80) != 0)
+	var length = uint64(header[1] & 0x7F)
+	if (header[0] & 0x70) != 0 {
+		return false, 0, nil, ws.fail(WsCloseProtocolError, "unexpected reserved bits")
+	}
+	if !(masked) {
+		return false, 0, nil, ws.fail(WsCloseProtocolError, "unmasked client frame")
+	}
+	switch length {
+	case 126:
+		var ext [2] byte
+		_, err := io.ReadFull(ws.reader, ext[:])
+		if err != nil { return false, 0, nil, err }
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8] byte
+		_, err := io.ReadFull(ws.reader, ext[:])
+		if err != nil { return false, 0, nil, err }
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > math.MaxInt64 {
+		return false, 0, nil, ws.fail(WsCloseProtocolError, "invalid payload length")
+	}
+	if ws.limit != 0 && length > uint64(ws.limit) {
+		return false, 0, nil, ws.fail(WsCloseMessageTooBig, "message too big")
+	}
+	var mask [4] byte
+	_, err = io.ReadFull(ws.reader, mask[:])
+	if err != nil { return false, 0, nil, err }
+	var payload ([] byte)
+	if length <= wsMaxPreallocation {
+		payload = make([] byte, length)
+		_, err = io.ReadFull(ws.reader, payload)
+		if err != nil { return false, 0, nil, err }
+	} else {
+		// the length is not trusted when the size is not limited:
+		// the buffer grows as the payload arrives
+		var buf bytes.Buffer
+		_, err = io.CopyN(&buf, ws.reader, int64(length))
+		if err != nil { return false, 0, nil, err }
+		payload = buf.Bytes()
+	}
+	for i := range payload {
+		payload[i] ^= mask[i % 4]
+	}
+	return fin, op, payload, nil
+}
+
+func (ws *WebSocket) WriteText(message ([] byte)) error {
+	return ws.writeFrame(wsOpText, message)
+}
+func (ws *WebSocket) writeFrame(op byte, payload ([] byte)) error {
+	ws.writing.Lock()
+	defer ws.writing.Unlock()
+	if ws.closed {
+		return errWebSocketClosed
+	}
+	return ws.writeFrameUnlocked(op, payload)
+}
+func (ws *WebSocket) writeFrameUnlocked(op byte, payload ([] byte)) error {
+	var header = make([] byte, 2, 10)
+	header[0] = (0x80 | op)
+	var length = len(payload)
+	if length < 126 {
+		header[1] = byte(length)
+	} else if length <= 0xFFFF {
+		header[1] = 126
+		header = header[:4]
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	} else {
+		header[1] = 127
+		header = header[:10]
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+	_, err := ws.conn.Write(append(header, payload...))
+	return err
+}
+
+// Close sends a close frame and closes the underlying connection.
+func (ws *WebSocket) Close(code uint16, reason string) error {
+	ws.writing.Lock()
+	defer ws.writing.Unlock()
+	if ws.closed {
+		return nil
+	}
+	var payload = make([] byte, 2, (2 + len(reason)))
+	binary.BigEndian.PutUint16(payload, code)
+	payload = append(payload, reason...)
+	_ = ws.writeFrameUnlocked(wsOpClose, payload)
+	ws.closed = true
+	return ws.conn.Close()
+}
+func (ws *WebSocket) fail(code uint16, reason string) error {
+	_ = ws.Close(code, reason)
+	return errors.New("websocket: " + reason)
+}
@@ -86,6 +86,70 @@ func Open(path string, flag int, perm os.FileMode) Observable {
 	})
 }
 
+type TempFile struct {
+	Path  string
+	File  File
+}
+
+func OpenTempFile(dir string, pattern string) Observable {
+	// emits a TempFile, the file is closed on dispose but not removed
+	return NewGoroutine(func(sender Sender) {
+		if sender.Context().AlreadyCancelled() {
+			return
+		}
+		raw, err := ioutil.TempFile(dir, pattern)
+		if err != nil {
+			sender.Error(err)
+			return
+		}
+		var f = File {
+			raw:    raw,
+			worker: CreateWorker(),
+		}
+		sender.Next(TempFile { Path: raw.Name(), File: f })
+		sender.Complete()
+		sender.Context().WaitDispose(func() {
+			_ = raw.Close()
+			f.worker.Dispose()
+		})
+	})
+}
+
+// WithFile opens a file, uses it and then closes it,
+// no matter whether the usage succeeded or failed.
+func WithFile(open Observable, use func(File) Observable) Observable {
+	return open.Then(func(obj Object) Observable {
+		var f = obj.(File)
+		return use(f).Catch(func(err Object) Observable {
+			return f.closeIfOpen().Then(func(_ Object) Observable {
+				return Throw(err)
+			})
+		}).Then(func(result Object) Observable {
+			return f.closeIfOpen().Map(func(_ Object) Object {
+				return result
+			})
+		})
+	})
+}
+
+// closeIfOpen is similar to Close(),
+// but it also completes if the file is already closed by Close().
+func (f File) closeIfOpen() Observable {
+	return Observable { func(sched Scheduler, ob *observer) {
+		var sender = Sender { sched: sched, ob: ob }
+		var queued = f.worker.Do(func() {
+			_ = f.raw.Close()
+			f.worker.Dispose()
+			sender.Next(nil)
+			sender.Complete()
+		})
+		if !(queued) {
+			ob.next(nil)
+			ob.complete()
+		}
+	} }
+}
+
 func (f File) Close() Observable {
 	return NewQueued(f.worker, func() (Object, bool) {
 		_ = f.raw.Close()
@@ -273,6 +337,33 @@ func (f File) ReadLinesRuneSlices() Observable {
 	})
 }
 
+func (f File) ReadChunks(size uint) Observable {
+	// emits byte slices of at most the given size
+	return NewGoroutine(func(s Sender) {
+		f.worker.Do(func() {
+			for {
+				if s.Context().AlreadyCancelled() {
+					return
+				}
+				var buf = make([] byte, size)
+				var n, err = f.raw.Read(buf)
+				if n > 0 {
+					s.Next(buf[:n])
+				}
+				if err != nil {
+					if err == io.EOF {
+						s.Complete()
+						return
+					} else {
+						s.Error(err)
+						return
+					}
+				}
+			}
+		})
+	})
+}
+
 func (f File) ReadLines() Observable {
 	return f.ReadLinesRuneSlices().Map(func(runes Object) Object {
 		return string(runes.([] rune))
@@ -333,3 +424,117 @@ func ListDir(dir_path string) Observable {
 	})
 }
 
+
+
+func Stat(path string) Observable {
+	return NewGoroutineSingle(func(_ *Context) (Object, bool) {
+		var info, err = os.Stat(path)
+		if err != nil { return err, false }
+		return FileStateFromInfo(info), true
+	})
+}
+
+func Lstat(path string) Observable {
+	return NewGoroutineSingle(func(_ *Context) (Object, bool) {
+		var info, err = os.Lstat(path)
+		if err != nil { return err, false }
+		return FileStateFromInfo(info), true
+	})
+}
+
+func Mkdir(path string, perm os.FileMode) Observable {
+	return NewGoroutineSingle(func(_ *Context) (Object, bool) {
+		var err = os.Mkdir(path, perm)
+		if err != nil { return err, false }
+		return nil, true
+	})
+}
+
+func MkdirAll(path string, perm os.FileMode) Observable {
+	return NewGoroutineSingle(func(_ *Context) (Object, bool) {
+		var err = os.MkdirAll(path, perm)
+		if err != nil { return err, false }
+		return nil, true
+	})
+}
+
+func Remove(path string) Observable {
+	return NewGoroutineSingle(func(_ *Context) (Object, bool) {
+		var err = os.Remove(path)
+		if err != nil { return err, false }
+		return nil, true
+	})
+}
+
+func RemoveAll(path string) Observable {
+	return NewGoroutineSingle(func(_ *Context) (Object, bool) {
+		var err = os.RemoveAll(path)
+		if err != nil { return err, false }
+		return nil, true
+	})
+}
+
+func Rename(old_path string, new_path string) Observable {
+	return NewGoroutineSingle(func(_ *Context) (Object, bool) {
+		var err = os.Rename(old_path, new_path)
+		if err != nil { return err, false }
+		return nil, true
+	})
+}
+
+func Chmod(path string, perm os.FileMode) Observable {
+	return NewGoroutineSingle(func(_ *Context) (Object, bool) {
+		var err = os.Chmod(path, perm)
+		if err != nil { return err, false }
+		return nil, true
+	})
+}
+
+func CopyFile(src_path string, dst_path string) Observable {
+	// the destination file is created or truncated,
+	// with the permission bits of the source file
+	return NewGoroutineSingle(func(_ *Context) (Object, bool) {
+		var src, err = os.Open(src_path)
+		if err != nil { return err, false }
+		defer (func() {
+			_ = src.Close()
+		})()
+		info, err := src.Stat()
+		if err != nil { return err, false }
+		if info.IsDir() {
+			return errors.New(fmt.Sprintf("%s is a directory", src_path)), false
+		}
+		var flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		dst, err := os.OpenFile(dst_path, flag, info.Mode().Perm())
+		if err != nil { return err, false }
+		_, err = io.Copy(dst, src)
+		var close_err = dst.Close()
+		if err != nil { return err, false }
+		if close_err != nil { return close_err, false }
+		return nil, true
+	})
+}
+
+func ReadLink(path string) Observable {
+	return NewGoroutineSingle(func(_ *Context) (Object, bool) {
+		var target, err = os.Readlink(path)
+		if err != nil { return err, false }
+		return target, true
+	})
+}
+
+func EvalSymlinks(path string) Observable {
+	return NewGoroutineSingle(func(_ *Context) (Object, bool) {
+		var resolved, err = filepath.EvalSymlinks(path)
+		if err != nil { return err, false }
+		return resolved, true
+	})
+}
+
+func CreateTempDir(dir string, pattern string) Observable {
+	return NewGoroutineSingle(func(_ *Context) (Object, bool) {
+		var path, err = ioutil.TempDir(dir, pattern)
+		if err != nil { return err, false }
+		return path, true
+	})
+}
@@ -25,3 +25,51 @@ func (e Observable) CompleteWhen(p func(Object)(bool)) Observable {
 		})
 	} }
 }
+
+func (e Observable) TakeUntil(signal Observable) Observable {
+	return Observable { func(sched Scheduler, ob *observer) {
+		var ctx, ctx_dispose = ob.context.create_disposable_child()
+		var sig_ctx, sig_dispose = ctx.create_disposable_child()
+		var done = false
+		var finish = func(behaviour disposeBehaviour) bool {
+			if done { return false }
+			done = true
+			sig_dispose(behaviour_cancel)
+			ctx_dispose(behaviour)
+			return true
+		}
+		sched.run(signal, &observer {
+			context:  sig_ctx,
+			next:     func(_ Object) {
+				if finish(behaviour_cancel) {
+					ob.complete()
+				}
+			},
+			error: func(err Object) {
+				if finish(behaviour_cancel) {
+					ob.error(err)
+				}
+			},
+			complete: func() {},
+		})
+		if done { return }
+		sched.run(e, &observer {
+			context:  ctx,
+			next:     func(obj Object) {
+				if !(done) {
+					ob.next(obj)
+				}
+			},
+			error: func(err Object) {
+				if finish(behaviour_terminate) {
+					ob.error(err)
+				}
+			},
+			complete: func() {
+				if finish(behaviour_terminate) {
+					ob.complete()
+				}
+			},
+		})
+	} }
+}
@@ -30,6 +30,9 @@ func (w *WrappedConnection) Scheduler() Scheduler {
 func (w *WrappedConnection) Worker() *Worker {
 	return w.worker
 }
+func (w *WrappedConnection) RawConnection() net.Conn {
+	return w.conn
+}
 func (w *WrappedConnection) closeProperly(err error) {
 	select {
 	case <- w.closed:
@@ -1,6 +1,12 @@
 package rx
 
 import (
+	"io"
+	"sync"
+	"errors"
+	"time"
+	"bytes"
+	"context"
 	"net/url"
 	"net/http"
 	"io/ioutil"
@@ -13,26 +19,68 @@ type HttpResponse struct {
 	Body        [] byte
 }
 
+type HttpRequest struct {
+	Method      string
+	URL         *url.URL
+	Header      http.Header
+	Body        [] byte       // nil: no body
+	BodyStream  *Observable   // emits byte slices, overrides Body
+	Timeout     time.Duration // 0: no timeout, otherwise reading the body is included
+	Redirect    HttpRedirectPolicy
+}
+type HttpRedirectPolicy int
+const (
+	HttpRedirectFollow HttpRedirectPolicy = iota
+	HttpRedirectNone
+)
+
+// HttpResponseStream is a response whose body has not been read yet.
+// The Body field of the embedded HttpResponse is always nil.
+type HttpResponseStream struct {
+	HttpResponse
+	body  *httpStreamBody
+}
+type httpStreamBody struct {
+	raw     io.ReadCloser
+	finish  func()
+	mutex   sync.Mutex
+	taken   bool
+	closed  chan struct{}
+	once    sync.Once
+}
+func (b *httpStreamBody) take() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.taken { return false }
+	b.taken = true
+	return true
+}
+func (b *httpStreamBody) close() {
+	b.once.Do(func() {
+		_ = b.raw.Close()
+		b.finish()
+		close(b.closed)
+	})
+}
+
 func HttpGet(url *url.URL) Observable {
-	return NewGoroutine(func(sender Sender) {
-		if sender.Context().AlreadyCancelled() {
-			return
-		}
-		res, err := http.Get(url.String())
-		if err != nil {
-			sender.Error(err)
-			return
-		}
-		if sender.Context().AlreadyCancelled() {
-			_ = res.Body.Close()
-			return
-		}
+	return HttpDo(HttpRequest {
+		Method: http.MethodGet,
+		URL:    url,
+	})
+}
+
+// HttpDo performs the request and emits a HttpResponse
+// after the whole response body is read.
+func HttpDo(req HttpRequest) Observable {
+	return httpDo(req, func(res *http.Response, sender Sender, finish func()) {
 		body, err := ioutil.ReadAll(res.Body)
+		_ = res.Body.Close()
+		finish()
 		if err != nil {
 			sender.Error(err)
 			return
 		}
-		_ = res.Body.Close()
 		sender.Next(HttpResponse {
 			Body:       body,
 			Header:     res.Header,
@@ -42,3 +90,169 @@ func HttpGet(url *url.URL) Observable {
 	})
 }
 
+// HttpDoStream performs the request and emits a HttpResponseStream
+// as soon as the response header is received.
+// The body of the response should be read by ReadBody(), otherwise
+// it is closed when the context of this effect is disposed.
+func HttpDoStream(req HttpRequest) Observable {
+	return httpDo(req, func(res *http.Response, sender Sender, finish func()) {
+		var body = &httpStreamBody {
+			raw:    res.Body,
+			finish: finish,
+			closed: make(chan struct{}),
+		}
+		sender.Next(HttpResponseStream {
+			HttpResponse: HttpResponse {
+				Header:     res.Header,
+				StatusCode: uint(res.StatusCode),
+			},
+			body: body,
+		})
+		sender.Complete()
+		var ctx = sender.Context()
+		if ctx.disposable() {
+			select {
+			case <- ctx.cancel:
+				body.close()
+			case <- ctx.terminate:
+				body.close()
+			case <- body.closed:
+			}
+		}
+	})
+}
+
+func httpDo(req HttpRequest, handle func(*http.Response, Sender, func())) Observable {
+	return Observable { func(sched Scheduler, ob *observer) {
+		var sender = Sender { sched: sched, ob: ob }
+		if ob.context.AlreadyCancelled() {
+			return
+		}
+		var body io.Reader
+		var body_pipe *io.PipeReader
+		if req.BodyStream != nil {
+			var r, w = io.Pipe()
+			// writing to the pipe blocks until the data is sent,
+			// which should not be done on the scheduler
+			var worker = CreateWorker()
+			sched.run(*(req.BodyStream), &observer {
+				context: ob.context,
+				next: func(chunk Object) {
+					worker.Do(func() {
+						_, _ = w.Write(chunk.([] byte))
+					})
+				},
+				error: func(err Object) {
+					worker.Do(func() {
+						_ = w.CloseWithError(err.(error))
+						worker.Dispose()
+					})
+				},
+				complete: func() {
+					worker.Do(func() {
+						_ = w.Close()
+						worker.Dispose()
+					})
+				},
+			})
+			body = r
+			body_pipe = r
+		} else if req.Body != nil {
+			body = bytes.NewReader(req.Body)
+		}
+		go (func() {
+			var ctx, cancel = context.WithCancel(context.Background())
+			var finished = make(chan struct{})
+			var once sync.Once
+			var finish = func() {
+				once.Do(func() {
+					close(finished)
+					cancel()
+				})
+			}
+			go (func() {
+				select {
+				case <- sender.Context().CancelSignal():
+					cancel()
+				case <- finished:
+				}
+			})()
+			var fail = func(err error) {
+				if body_pipe != nil {
+					_ = body_pipe.Close()
+				}
+				finish()
+				if !(sender.Context().AlreadyCancelled()) {
+					sender.Error(err)
+				}
+			}
+			http_req, err := http.NewRequestWithContext(ctx, req.Method, req.URL.String(), body)
+			if err != nil {
+				fail(err)
+				return
+			}
+			if req.Header != nil {
+				http_req.Header = req.Header
+			}
+			var client = &http.Client {
+				Timeout: req.Timeout,
+			}
+			switch req.Redirect {
+			case HttpRedirectFollow:
+				// default policy of http.Client
+			case HttpRedirectNone:
+				client.CheckRedirect = func(_ *http.Request, _ ([] *http.Request)) error {
+					return http.ErrUseLastResponse
+				}
+			default:
+				panic("impossible branch")
+			}
+			res, err := client.Do(http_req)
+			if err != nil {
+				fail(err)
+				return
+			}
+			handle(res, sender, finish)
+		})()
+	} }
+}
+
+// ReadBody emits chunks of the response body, each of at most the given size.
+// The body can only be read once, and it is closed after reading.
+func (s HttpResponseStream) ReadBody(size uint) Observable {
+	return NewGoroutine(func(sender Sender) {
+		if !(s.body.take()) {
+			sender.Error(errors.New("the response body has already been read"))
+			return
+		}
+		var close_body = s.body.close
+		var done = make(chan struct{})
+		defer close(done)
+		go (func() {
+			select {
+			case <- sender.Context().CancelSignal():
+				close_body()
+			case <- done:
+			}
+		})()
+		for {
+			if sender.Context().AlreadyCancelled() {
+				return
+			}
+			var buf = make([] byte, size)
+			var n, err = s.body.raw.Read(buf)
+			if n > 0 {
+				sender.Next(buf[:n])
+			}
+			if err != nil {
+				close_body()
+				if err == io.EOF {
+					sender.Complete()
+				} else if !(sender.Context().AlreadyCancelled()) {
+					sender.Error(err)
+				}
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,223 @@
+package rx
+
+import (
+	"time"
+	"strings"
+	"testing"
+	"net/url"
+	"net/http"
+	"io/ioutil"
+	"net/http/httptest"
+)
+
+
+var httpTestScheduler = TrivialScheduler { EventLoop: SpawnEventLoop() }
+
+func httpTestRun(e Observable) (chan Object, chan Object) {
+	var values = make(chan Object, 1024)
+	var errors = make(chan Object, 1)
+	Schedule(e, httpTestScheduler, Receiver {
+		Context: Background(),
+		Values:  values,
+		Error:   errors,
+	})
+	return values, errors
+}
+
+func httpTestReceive(t *testing.T, values chan Object, errors chan Object) (Object, bool) {
+	select {
+	case v, ok := <- values:
+		return v, ok
+	case err := <- errors:
+		t.Fatal(err)
+		panic("unreachable")
+	case <- time.After(3 * time.Second):
+		t.Fatal("timeout")
+		panic("unreachable")
+	}
+}
+
+func httpTestURL(t *testing.T, server *httptest.Server, path string) *url.URL {
+	var u, err = url.Parse(server.URL + path)
+	if err != nil { t.Fatal(err) }
+	return u
+}
+
+func TestHttpDo(t *testing.T) {
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Echo", r.Header.Get("X-Value"))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(([] byte)(r.Method + " " + string(body)))
+	}))
+	defer server.Close()
+	var values, errors = httpTestRun(HttpDo(HttpRequest {
+		Method: http.MethodPut,
+		URL:    httpTestURL(t, server, "/"),
+		Header: http.Header { "X-Value": [] string { "foo" } },
+		Body:   ([] byte)("bar"),
+	}))
+	var v, _ = httpTestReceive(t, values, errors)
+	var res = v.(HttpResponse)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status code: %d", res.StatusCode)
+	}
+	if res.Header.Get("X-Echo") != "foo" {
+		t.Fatalf("unexpected header: %v", res.Header)
+	}
+	if string(res.Body) != "PUT bar" {
+		t.Fatalf("unexpected body: %s", res.Body)
+	}
+}
+
+func TestHttpDoStream(t *testing.T) {
+	var proceed = make(chan struct{})
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body, _ = ioutil.ReadAll(r.Body)
+		_, _ = w.Write(body)
+		w.(http.Flusher).Flush()
+		<- proceed
+		_, _ = w.Write(([] byte)("!"))
+	}))
+	defer server.Close()
+	var chunks = NewConstant(([] byte)("foo"), ([] byte)("bar"))
+	var req = HttpDoStream(HttpRequest {
+		Method:     http.MethodPost,
+		URL:        httpTestURL(t, server, "/"),
+		BodyStream: &chunks,
+	})
+	var values, errors = httpTestRun(req.Then(func(s Object) Observable {
+		return s.(HttpResponseStream).ReadBody(4096)
+	}))
+	var first, _ = httpTestReceive(t, values, errors)
+	if string(first.([] byte)) != "foobar" {
+		t.Fatalf("unexpected first chunk: %s", first)
+	}
+	close(proceed)
+	var rest = ""
+	for {
+		var chunk, ok = httpTestReceive(t, values, errors)
+		if !(ok) { break }
+		rest += string(chunk.([] byte))
+	}
+	if rest != "!" {
+		t.Fatalf("unexpected rest of body: %s", rest)
+	}
+}
+
+func TestHttpDoStreamUnread(t *testing.T) {
+	var aborted = make(chan struct{})
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(([] byte)("foo"))
+		w.(http.Flusher).Flush()
+		<- r.Context().Done()
+		close(aborted)
+	}))
+	defer server.Close()
+	var values = make(chan Object, 1)
+	var cancel = testScheduleCancellable(httpTestScheduler, HttpDoStream(HttpRequest {
+		Method: http.MethodGet,
+		URL:    httpTestURL(t, server, "/"),
+	}), Receiver { Values: values })
+	var v, _ = httpTestReceive(t, values, nil)
+	var s = v.(HttpResponseStream)
+	cancel()
+	for _, closed := range [] chan struct{} { s.body.closed, aborted } {
+		select {
+		case <- closed:
+		case <- time.After(3 * time.Second):
+			t.Fatal("unread body not closed")
+		}
+	}
+	values, errors := httpTestRun(s.ReadBody(4096))
+	select {
+	case <- errors:
+	case <- values:
+		t.Fatal("closed body read")
+	case <- time.After(3 * time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestHttpDoStreamReadTwice(t *testing.T) {
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(([] byte)("foo"))
+	}))
+	defer server.Close()
+	var values, errors = httpTestRun(HttpDoStream(HttpRequest {
+		Method: http.MethodGet,
+		URL:    httpTestURL(t, server, "/"),
+	}))
+	var v, _ = httpTestReceive(t, values, errors)
+	var s = v.(HttpResponseStream)
+	var body = ""
+	values, errors = httpTestRun(s.ReadBody(4096))
+	for {
+		var chunk, ok = httpTestReceive(t, values, errors)
+		if !(ok) { break }
+		body += string(chunk.([] byte))
+	}
+	if body != "foo" {
+		t.Fatalf("unexpected body: %s", body)
+	}
+	values, errors = httpTestRun(s.ReadBody(4096))
+	select {
+	case err := <- errors:
+		if !(strings.Contains(err.(error).Error(), "already been read")) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <- values:
+		t.Fatal("body read twice")
+	case <- time.After(3 * time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestHttpDoRedirect(t *testing.T) {
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusFound)
+		} else {
+			_, _ = w.Write(([] byte)(r.URL.Path))
+		}
+	}))
+	defer server.Close()
+	for _, item := range [] struct { Policy HttpRedirectPolicy; Status uint; Body string } {
+		{ Policy: HttpRedirectFollow, Status: http.StatusOK, Body: "/new" },
+		{ Policy: HttpRedirectNone, Status: http.StatusFound },
+	} {
+		var values, errors = httpTestRun(HttpDo(HttpRequest {
+			Method:   http.MethodGet,
+			URL:      httpTestURL(t, server, "/old"),
+			Redirect: item.Policy,
+		}))
+		var v, _ = httpTestReceive(t, values, errors)
+		var res = v.(HttpResponse)
+		if res.StatusCode != item.Status {
+			t.Fatalf("unexpected status code: %d", res.StatusCode)
+		}
+		if item.Body != "" && string(res.Body) != item.Body {
+			t.Fatalf("unexpected body: %s", res.Body)
+		}
+	}
+}
+
+func TestHttpDoTimeout(t *testing.T) {
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <- time.After(time.Second):
+		case <- r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	var _, errors = httpTestRun(HttpDo(HttpRequest {
+		Method:  http.MethodGet,
+		URL:     httpTestURL(t, server, "/"),
+		Timeout: (50 * time.Millisecond),
+	}))
+	select {
+	case <- errors:
+	case <- time.After(500 * time.Millisecond):
+		t.Fatal("request not timed out")
+	}
+}
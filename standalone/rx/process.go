@@ -0,0 +1,164 @@
+package rx
+
+import (
+	"os"
+	"sync"
+	"os/exec"
+)
+
+
+type ProcessOptions struct {
+	Program  string
+	Args     [] string
+	Env      [] string  // nil: inherit the environment of current process
+	Dir      string     // empty: inherit the working directory
+}
+type Process struct {
+	raw      *os.Process
+	Stdin    File
+	Stdout   File
+	Stderr   File
+	exited   chan struct{}
+	status   *ProcessStatus
+	err      *error
+	release  func()  // closes Stdout and Stderr
+}
+type ProcessStatus struct {
+	ExitCode  int  // -1 if the process was terminated by a signal
+	Success   bool
+}
+
+func SpawnProcess(opts ProcessOptions) Observable {
+	return NewGoroutine(func(sender Sender) {
+		if sender.Context().AlreadyCancelled() {
+			return
+		}
+		var pipes = make([] *os.File, 0, 6)
+		var close_all = func() {
+			for _, f := range pipes {
+				_ = f.Close()
+			}
+		}
+		var pipe = func() (*os.File, *os.File, bool) {
+			var r, w, err = os.Pipe()
+			if err != nil {
+				close_all()
+				sender.Error(err)
+				return nil, nil, false
+			}
+			pipes = append(pipes, r, w)
+			return r, w, true
+		}
+		stdin_r, stdin_w, ok := pipe()
+		if !(ok) { return }
+		stdout_r, stdout_w, ok := pipe()
+		if !(ok) { return }
+		stderr_r, stderr_w, ok := pipe()
+		if !(ok) { return }
+		var cmd = exec.Command(opts.Program, opts.Args...)
+		cmd.Env = opts.Env
+		cmd.Dir = opts.Dir
+		cmd.Stdin = stdin_r
+		cmd.Stdout = stdout_w
+		cmd.Stderr = stderr_w
+		var err = cmd.Start()
+		// the child has its own copies of these ends
+		_ = stdin_r.Close()
+		_ = stdout_w.Close()
+		_ = stderr_w.Close()
+		if err != nil {
+			_ = stdin_w.Close()
+			_ = stdout_r.Close()
+			_ = stderr_r.Close()
+			sender.Error(err)
+			return
+		}
+		var released = make(chan struct{})
+		var release_once sync.Once
+		var p = Process {
+			raw:    cmd.Process,
+			Stdin:  FileFrom(stdin_w),
+			Stdout: FileFrom(stdout_r),
+			Stderr: FileFrom(stderr_r),
+			exited: make(chan struct{}),
+			status: new(ProcessStatus),
+			err:    new(error),
+		}
+		p.release = func() {
+			release_once.Do(func() {
+				processCloseFile(p.Stdout)
+				processCloseFile(p.Stderr)
+				close(released)
+			})
+		}
+		go (func() {
+			var err = cmd.Wait()
+			var state = cmd.ProcessState
+			if state != nil {
+				*(p.status) = ProcessStatus {
+					ExitCode: state.ExitCode(),
+					Success:  state.Success(),
+				}
+			} else {
+				*(p.err) = err
+			}
+			// nothing can be written to an exited process
+			processCloseFile(p.Stdin)
+			close(p.exited)
+		})()
+		sender.Next(p)
+		sender.Complete()
+		select {
+		case <- sender.Context().CancelSignal():
+			_ = p.raw.Kill()
+			<- p.exited
+			p.release()
+		case <- released:
+		}
+	})
+}
+
+func processCloseFile(f File) {
+	// pending operations on the file are finished before closing,
+	// and the worker is kept to fail further operations with os.ErrClosed
+	f.worker.Do(func() {
+		_ = f.raw.Close()
+	})
+}
+
+// Wait waits for the process to exit and closes its Stdout and Stderr,
+// which should be read before waiting (similar to exec.Cmd.Wait).
+// The Stdin is closed as soon as the process exits.
+func (p Process) Wait() Observable {
+	return NewGoroutine(func(sender Sender) {
+		select {
+		case <- p.exited:
+			p.release()
+			if *(p.err) != nil {
+				sender.Error(*(p.err))
+				return
+			}
+			sender.Next(*(p.status))
+			sender.Complete()
+		case <- sender.Context().CancelSignal():
+			return
+		}
+	})
+}
+
+func (p Process) Kill() Observable {
+	return NewGoroutineSingle(func(_ *Context) (Object, bool) {
+		var err = p.raw.Kill()
+		if err != nil {
+			select {
+			case <- p.exited:
+				// already exited
+				return nil, true
+			default:
+				return err, false
+			}
+		}
+		<- p.exited
+		return nil, true
+	})
+}
@@ -0,0 +1,99 @@
+package rx
+
+import (
+	"os"
+	"time"
+	"errors"
+	"testing"
+)
+
+
+var processTestScheduler = TrivialScheduler { EventLoop: SpawnEventLoop() }
+
+func processTestResult(t *testing.T, e Observable) (Object, error) {
+	var values = make(chan Object, 1)
+	var errs = make(chan Object, 1)
+	Schedule(e, processTestScheduler, Receiver {
+		Context: Background(),
+		Values:  values,
+		Error:   errs,
+	})
+	select {
+	case v := <- values:
+		return v, nil
+	case err := <- errs:
+		return nil, err.(error)
+	case <- time.After(3 * time.Second):
+		t.Fatal("timeout")
+		panic("unreachable")
+	}
+}
+
+func processTestRun(t *testing.T, e Observable) Object {
+	var v, err = processTestResult(t, e)
+	if err != nil { t.Fatal(err) }
+	return v
+}
+
+func processTestClosed(t *testing.T, e Observable, name string) {
+	var _, err = processTestResult(t, e)
+	if !(errors.Is(err, os.ErrClosed)) {
+		t.Fatalf("%s not closed: %v", name, err)
+	}
+}
+
+func TestProcessExit(t *testing.T) {
+	var p = processTestRun(t, SpawnProcess(ProcessOptions {
+		Program: "sh",
+		Args:    [] string { "-c", "echo hello" },
+	})).(Process)
+	var output = processTestRun(t, p.Stdout.ReadAll()).([] byte)
+	if string(output) != "hello\n" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+	var status = processTestRun(t, p.Wait()).(ProcessStatus)
+	if status != (ProcessStatus { ExitCode: 0, Success: true }) {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	processTestClosed(t, p.Stdin.Write(([] byte)("x")), "stdin")
+	processTestClosed(t, p.Stdout.Read(1), "stdout")
+	processTestClosed(t, p.Stderr.Read(1), "stderr")
+}
+
+func TestProcessKillOnCancel(t *testing.T) {
+	var values = make(chan Object, 1)
+	var cancel = testScheduleCancellable(processTestScheduler, SpawnProcess(ProcessOptions {
+		Program: "sleep",
+		Args:    [] string { "60" },
+	}), Receiver { Values: values })
+	var p Process
+	select {
+	case v := <- values:
+		p = v.(Process)
+	case <- time.After(3 * time.Second):
+		t.Fatal("process not spawned")
+	}
+	cancel()
+	select {
+	case <- p.exited:
+	case <- time.After(3 * time.Second):
+		t.Fatal("process not killed")
+	}
+	if p.status.Success || p.status.ExitCode != -1 {
+		t.Fatalf("unexpected status: %+v", *(p.status))
+	}
+	processTestClosed(t, p.Stdin.Write(([] byte)("x")), "stdin")
+	// the output is closed after the process exited
+	var timeout = time.After(3 * time.Second)
+	for _, f := range [] File { p.Stdout, p.Stderr } {
+		for {
+			var _, err = processTestResult(t, f.Read(1))
+			if errors.Is(err, os.ErrClosed) { break }
+			select {
+			case <- timeout:
+				t.Fatalf("output not closed: %v", err)
+			case <- time.After(10 * time.Millisecond):
+			}
+		}
+	}
+}
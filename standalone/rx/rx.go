@@ -226,6 +226,26 @@ func ScheduleBackgroundWaitTerminate(action Observable, sched Scheduler) bool {
 	return <- wait
 }
 
+// CreateCancellableContext creates a child context of the background
+// in the event loop of the scheduler, returning the context together
+// with a function that cancels all the actions scheduled with it.
+func CreateCancellableContext(sched Scheduler) (*Context, func()) {
+	var created = make(chan *Context)
+	var dispose disposeFunc
+	sched.commit(func() {
+		// contexts are manipulated in the event loop
+		var ctx, ctx_dispose = Background().create_disposable_child()
+		dispose = ctx_dispose
+		created <- ctx
+	})
+	var ctx = <- created
+	return ctx, func() {
+		sched.commit(func() {
+			dispose(behaviour_cancel)
+		})
+	}
+}
+
 func Noop() Observable {
 	return Observable { func(sched Scheduler, ob *observer) {
 		ob.complete()
@@ -0,0 +1,21 @@
+package rx
+
+
+// testScheduleCancellable schedules the action with a disposable context,
+// returning a function that cancels the context.
+func testScheduleCancellable(sched TrivialScheduler, action Observable, r Receiver) func() {
+	var created = make(chan disposeFunc)
+	sched.commit(func() {
+		// contexts are manipulated in the event loop
+		var ctx, dispose = Background().create_disposable_child()
+		r.Context = ctx
+		Schedule(action, sched, r)
+		created <- dispose
+	})
+	var dispose = <- created
+	return func() {
+		sched.commit(func() {
+			dispose(behaviour_cancel)
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package rx
+
+import (
+	"os"
+	"sort"
+	"time"
+	"path/filepath"
+)
+
+
+type FileEvent struct {
+	Kind  FileEventKind
+	Path  string
+}
+type FileEventKind int
+const (
+	FileCreated FileEventKind = iota
+	FileWritten
+	FileRemoved
+	FileRenamed
+)
+type WatchOptions struct {
+	Recursive     bool
+	Polling       bool  // poll even if inotify is available
+	PollInterval  time.Duration
+}
+
+// WatchPath emits events of changes to the file or directory at the given
+// path, until the context is cancelled or the path itself is removed or
+// renamed. On Linux the inotify API is used, otherwise the path is polled,
+// in which case a rename is reported as a removal and a creation.
+func WatchPath(path string, opts WatchOptions) Observable {
+	return NewGoroutine(func(sender Sender) {
+		if sender.Context().AlreadyCancelled() {
+			return
+		}
+		if !(opts.Polling) {
+			var ok = watchInotify(path, opts, sender)
+			if ok { return }
+		}
+		watchPolling(path, opts, sender)
+	})
+}
+
+func watchPolling(root string, opts WatchOptions, sender Sender) {
+	var snapshot, err = watchSnapshot(root, opts.Recursive)
+	if err != nil {
+		sender.Error(err)
+		return
+	}
+	var ticker = time.NewTicker(opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <- ticker.C:
+			var current, err = watchSnapshot(root, opts.Recursive)
+			if err != nil {
+				if os.IsNotExist(err) {
+					sender.Next(FileEvent { Kind: FileRemoved, Path: root })
+					sender.Complete()
+				} else {
+					sender.Error(err)
+				}
+				return
+			}
+			for _, event := range watchDiff(snapshot, current) {
+				sender.Next(event)
+			}
+			snapshot = current
+		case <- sender.Context().CancelSignal():
+			return
+		}
+	}
+}
+
+func watchSnapshot(root string, recursive bool) (map[string] FileState, error) {
+	var info, err = os.Stat(root)
+	if err != nil { return nil, err }
+	var snapshot = map[string] FileState {
+		root: FileStateFromInfo(info),
+	}
+	if !(info.IsDir()) {
+		return snapshot, nil
+	}
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path != root && os.IsNotExist(err) {
+				// removed during the walk
+				return nil
+			}
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		snapshot[path] = FileStateFromInfo(info)
+		if info.IsDir() && !(recursive) {
+			return filepath.SkipDir
+		} else {
+			return nil
+		}
+	})
+	if err != nil { return nil, err }
+	return snapshot, nil
+}
+
+func watchDiff(old map[string] FileState, current map[string] FileState) ([] FileEvent) {
+	var events = make([] FileEvent, 0)
+	for path, state := range current {
+		var old_state, exists = old[path]
+		if !(exists) {
+			events = append(events, FileEvent { Kind: FileCreated, Path: path })
+		} else if !(state.IsDir) {
+			var modified = !(state.ModTime.Equal(old_state.ModTime))
+			var resized = (state.Size.Cmp(old_state.Size) != 0)
+			if modified || resized {
+				events = append(events, FileEvent { Kind: FileWritten, Path: path })
+			}
+		}
+	}
+	for path, _ := range old {
+		var _, exists = current[path]
+		if !(exists) {
+			events = append(events, FileEvent { Kind: FileRemoved, Path: path })
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Path < events[j].Path
+	})
+	return events
+}
@@ -0,0 +1,175 @@
+package rx
+
+import (
+	"os"
+	"errors"
+	"strings"
+	"syscall"
+	"unsafe"
+	"path/filepath"
+)
+
+
+const inotifyMask = (syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF)
+const inotifyNameMax = 255
+
+type inotifyWatcher struct {
+	fd     int
+	paths  map[int] string
+	wds    map[string] int
+}
+
+// watchInotify returns false if inotify is not available,
+// in which case nothing is sent.
+func watchInotify(root string, opts WatchOptions, sender Sender) bool {
+	var fd, err = syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return false
+	}
+	// a non-blocking fd is handled by the runtime poller,
+	// so that a pending read is interrupted by closing the file
+	var file = os.NewFile(uintptr(fd), "inotify")
+	defer (func() {
+		_ = file.Close()
+	})()
+	var w = &inotifyWatcher {
+		fd:    fd,
+		paths: make(map[int] string),
+		wds:   make(map[string] int),
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		sender.Error(err)
+		return true
+	}
+	if info.IsDir() && opts.Recursive {
+		err = w.addTree(root)
+	} else {
+		err = w.add(root)
+	}
+	if err != nil {
+		sender.Error(err)
+		return true
+	}
+	var root_wd = w.wds[root]
+	var done = make(chan struct{})
+	defer close(done)
+	go (func() {
+		select {
+		case <- sender.Context().CancelSignal():
+			_ = file.Close()
+		case <- done:
+		}
+	})()
+	var buf = make([] byte, (64 * (syscall.SizeofInotifyEvent + inotifyNameMax + 1)))
+	for {
+		var n, err = file.Read(buf)
+		if err != nil {
+			if !(sender.Context().AlreadyCancelled()) {
+				sender.Error(err)
+			}
+			return true
+		}
+		var offset = 0
+		for (offset + syscall.SizeofInotifyEvent) <= n {
+			var raw = (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			var name_start = (offset + syscall.SizeofInotifyEvent)
+			var name_end = (name_start + int(raw.Len))
+			var name = strings.TrimRight(string(buf[name_start:name_end]), "\x00")
+			offset = name_end
+			var mask = raw.Mask
+			if (mask & syscall.IN_Q_OVERFLOW) != 0 {
+				sender.Error(errors.New("too many file system events"))
+				return true
+			}
+			var wd = int(raw.Wd)
+			var dir, ok = w.paths[wd]
+			if !(ok) {
+				// removed watch
+				continue
+			}
+			var path = dir
+			if name != "" {
+				path = filepath.Join(dir, name)
+			}
+			var is_dir = ((mask & syscall.IN_ISDIR) != 0)
+			var emit = func(kind FileEventKind) {
+				sender.Next(FileEvent { Kind: kind, Path: path })
+			}
+			if (mask & (syscall.IN_CREATE | syscall.IN_MOVED_TO)) != 0 {
+				if is_dir && opts.Recursive {
+					var err = w.addTree(path)
+					if err != nil {
+						sender.Error(err)
+						return true
+					}
+				}
+				emit(FileCreated)
+			} else if (mask & syscall.IN_MODIFY) != 0 {
+				emit(FileWritten)
+			} else if (mask & syscall.IN_DELETE) != 0 {
+				emit(FileRemoved)
+			} else if (mask & syscall.IN_MOVED_FROM) != 0 {
+				if is_dir {
+					w.removeTree(path)
+				}
+				emit(FileRenamed)
+			} else if (mask & (syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF)) != 0 {
+				// the removal of a subdirectory has been reported by its parent
+				if wd == root_wd {
+					if (mask & syscall.IN_DELETE_SELF) != 0 {
+						emit(FileRemoved)
+					} else {
+						emit(FileRenamed)
+					}
+					sender.Complete()
+					return true
+				}
+			} else if (mask & syscall.IN_IGNORED) != 0 {
+				delete(w.paths, wd)
+				if w.wds[dir] == wd {
+					delete(w.wds, dir)
+				}
+			}
+		}
+	}
+}
+
+func (w *inotifyWatcher) add(path string) error {
+	var wd, err = syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+	if err != nil {
+		return &os.PathError { Op: "watch", Path: path, Err: err }
+	}
+	w.paths[wd] = path
+	w.wds[path] = wd
+	return nil
+}
+
+func (w *inotifyWatcher) addTree(root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() {
+			err = w.add(path)
+		}
+		if err != nil {
+			if path != root && os.IsNotExist(err) {
+				// removed during the walk
+				return nil
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+func (w *inotifyWatcher) removeTree(dir string) {
+	var prefix = (dir + string(os.PathSeparator))
+	for path, wd := range w.wds {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			_, _ = syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.paths, wd)
+			delete(w.wds, path)
+		}
+	}
+}
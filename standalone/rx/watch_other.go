@@ -0,0 +1,9 @@
+// +build !linux
+
+package rx
+
+
+func watchInotify(_ string, _ WatchOptions, _ Sender) bool {
+	// not available, fall back to polling
+	return false
+}
@@ -0,0 +1,159 @@
+package rx
+
+import (
+	"os"
+	"time"
+	"runtime"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+)
+
+
+var testWatchScheduler = TrivialScheduler { EventLoop: SpawnEventLoop() }
+
+type testWatcher struct {
+	t       *testing.T
+	values  chan Object
+	cancel  func()
+}
+
+func startTestWatcher(t *testing.T, path string, opts WatchOptions) testWatcher {
+	var values = make(chan Object, 1024)
+	var cancel = testScheduleCancellable(testWatchScheduler, WatchPath(path, opts), Receiver {
+		Values: values,
+	})
+	// wait for the watcher to be ready
+	time.Sleep(100 * time.Millisecond)
+	return testWatcher {
+		t:      t,
+		values: values,
+		cancel: cancel,
+	}
+}
+
+// expect skips events until the expected one,
+// failing if an event of the unexpected path is encountered.
+func (w testWatcher) expect(kind FileEventKind, path string, unexpected string) {
+	var timeout = time.After(3 * time.Second)
+	for {
+		select {
+		case v, ok := <- w.values:
+			if !(ok) {
+				w.t.Fatalf("watching completed before the event %d of %s", kind, path)
+			}
+			var event = v.(FileEvent)
+			if event.Kind == kind && event.Path == path {
+				return
+			}
+			if unexpected != "" && event.Path == unexpected {
+				w.t.Fatalf("unexpected event %d of %s", event.Kind, event.Path)
+			}
+		case <- timeout:
+			w.t.Fatalf("event %d of %s not received", kind, path)
+		}
+	}
+}
+
+func (w testWatcher) expectComplete() {
+	var timeout = time.After(3 * time.Second)
+	for {
+		select {
+		case _, ok := <- w.values:
+			if !(ok) { return }
+		case <- timeout:
+			w.t.Fatal("watching not completed")
+		}
+	}
+}
+
+func testWatchPath(t *testing.T, opts WatchOptions) {
+	var dir, err = ioutil.TempDir("", "rx-watch-test-")
+	if err != nil { t.Fatal(err) }
+	defer (func() {
+		_ = os.RemoveAll(dir)
+	})()
+	var sub = filepath.Join(dir, "sub")
+	err = os.Mkdir(sub, 0777)
+	if err != nil { t.Fatal(err) }
+	var w = startTestWatcher(t, dir, opts)
+	defer w.cancel()
+	var write = func(path string, content string) {
+		var err = ioutil.WriteFile(path, ([] byte)(content), 0666)
+		if err != nil { t.Fatal(err) }
+	}
+	var a = filepath.Join(dir, "a.txt")
+	var b = filepath.Join(sub, "b.txt")
+	var c = filepath.Join(dir, "c.txt")
+	write(a, "a")
+	w.expect(FileCreated, a, "")
+	// wait for a different modification time
+	time.Sleep(50 * time.Millisecond)
+	write(a, "aa")
+	w.expect(FileWritten, a, "")
+	write(b, "b")
+	if opts.Recursive {
+		w.expect(FileCreated, b, "")
+	}
+	write(c, "c")
+	if opts.Recursive {
+		w.expect(FileCreated, c, "")
+	} else {
+		w.expect(FileCreated, c, b)
+	}
+	err = os.Rename(c, (c + ".bak"))
+	if err != nil { t.Fatal(err) }
+	if opts.Polling {
+		w.expect(FileRemoved, c, "")
+	} else {
+		w.expect(FileRenamed, c, "")
+	}
+	w.expect(FileCreated, (c + ".bak"), "")
+	err = os.Remove(a)
+	if err != nil { t.Fatal(err) }
+	w.expect(FileRemoved, a, "")
+	err = os.RemoveAll(dir)
+	if err != nil { t.Fatal(err) }
+	w.expect(FileRemoved, dir, "")
+	w.expectComplete()
+}
+
+func TestWatchPathInotify(t *testing.T) {
+	if runtime.GOOS != "linux" { t.Skip("inotify not available") }
+	testWatchPath(t, WatchOptions {})
+	testWatchPath(t, WatchOptions { Recursive: true })
+}
+
+func TestWatchPathPolling(t *testing.T) {
+	var interval = (10 * time.Millisecond)
+	testWatchPath(t, WatchOptions { Polling: true, PollInterval: interval })
+	testWatchPath(t, WatchOptions { Recursive: true, Polling: true, PollInterval: interval })
+}
+
+func TestWatchPathCancel(t *testing.T) {
+	if runtime.GOOS != "linux" { t.Skip("inotify not available") }
+	var count_fds = func() int {
+		var fds, err = ioutil.ReadDir("/proc/self/fd")
+		if err != nil { t.Fatal(err) }
+		return len(fds)
+	}
+	var dir, err = ioutil.TempDir("", "rx-watch-test-")
+	if err != nil { t.Fatal(err) }
+	defer (func() {
+		_ = os.RemoveAll(dir)
+	})()
+	var initial = count_fds()
+	var w = startTestWatcher(t, dir, WatchOptions { Recursive: true })
+	if count_fds() <= initial {
+		t.Fatal("inotify instance not created")
+	}
+	w.cancel()
+	var timeout = time.After(3 * time.Second)
+	for count_fds() > initial {
+		select {
+		case <- timeout:
+			t.Fatal("inotify instance not closed after cancellation")
+		case <- time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -41,16 +41,21 @@ func CreateWorker() *Worker {
 	return w
 }
 
-func (w *Worker) Do(work func()) {
+// Do queues the work, returning false if the worker is disposed,
+// in which case the work is dropped.
+func (w *Worker) Do(work func()) bool {
 	w.mutex.Lock()
+	defer w.mutex.Unlock()
 	if !(w.disposed) {
 		w.pending = append(w.pending, work)
 		select {
 		case w.notify <- struct{} {}:
 		default:
 		}
+		return true
+	} else {
+		return false
 	}
-	w.mutex.Unlock()
 }
 
 func (w *Worker) Dispose() {
@@ -38,6 +38,12 @@ func GetInt64Integer(n *big.Int) int64 {
 	}
 }
 
+func GetIntInteger(n *big.Int) int {
+	var i = GetInt64Integer(n)
+	if int64(int(i)) != i { panic("given number too big") }
+	return int(i)
+}
+
 func IsNonNegative(n *big.Int) bool {
 	return (n.Cmp(big.NewInt(0)) >= 0)
 }
@@ -5,13 +5,38 @@ import (
 	"fmt"
 	"bufio"
 	"strings"
+	"path/filepath"
 	"encoding/json"
 	"kumachan/standalone/util"
+	"kumachan/interpreter/compiler/loader"
 )
 
 
 type LangServerContext struct {
 	DebugLog     func(info string)
+	FileSystem   loader.FileSystem  // nil means the real file system
+}
+
+func (ctx LangServerContext) GetFileSystem() loader.FileSystem {
+	if ctx.FileSystem != nil {
+		return ctx.FileSystem
+	} else {
+		return loader.RealFileSystem {}
+	}
+}
+
+// GetModulePath returns the path of the module containing the
+// specified source file.
+func GetModulePath(file_path string, fs loader.FileSystem) string {
+	var dir = filepath.Dir(file_path)
+	var manifest_path = filepath.Join(dir, loader.ManifestFileName)
+	var mf, err_mf = fs.Open(manifest_path)
+	if err_mf != nil {
+		return file_path
+	} else {
+		_ = mf.Close()
+		return dir
+	}
 }
 
 func LangServer(input io.Reader, output io.Writer, debug io.Writer) error {
@@ -1,11 +1,9 @@
 package atom
 
 import (
-	"os"
 	"fmt"
 	"sort"
 	"strings"
-	"path/filepath"
 	"kumachan/interpreter/lang/textual/ast"
 	"kumachan/interpreter/lang/textual/syntax"
 	"kumachan/interpreter/compiler/loader"
@@ -174,17 +172,9 @@ func AutoComplete(req AutoCompleteRequest, ctx LangServerContext) AutoCompleteRe
 		}
 	}
 	if (input_mod == "" && len(input) >= 2) || input_mod != "" {
-		var dir = filepath.Dir(req.CurrentPath)
-		var manifest_path = filepath.Join(dir, loader.ManifestFileName)
-		var mod_path string
-		var mf, err_mf = os.Open(manifest_path)
-		if err_mf != nil {
-			mod_path = req.CurrentPath
-		} else {
-			mod_path = dir
-			_ = mf.Close()
-		}
-		var mod, idx, _, err = loader.LoadEntry(mod_path)
+		var fs = ctx.GetFileSystem()
+		var mod_path = GetModulePath(req.CurrentPath, fs)
+		var mod, idx, _, err = loader.LoadEntryFrom(mod_path, fs)
 		if err != nil { goto keywords }
 		for _, item := range mod.AST.Statements {
 			process_statement(item.Statement, "")
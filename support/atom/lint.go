@@ -1,7 +1,6 @@
 package atom
 
 import (
-	"os"
 	"fmt"
 	"path/filepath"
 	"kumachan/interpreter/def"
@@ -99,37 +98,74 @@ func GetError(e E, tip string) LintError {
 	}
 }
 
+// LintProblem is a problem found in a module, which is independent
+// from the format of the response.
+type LintProblem struct {
+	Tree     *cst.Tree
+	Span     scanner.Span
+	Excerpt  string
+	Tip      string
+	Warning  bool
+}
+
+func getProblem(e E, tip string) LintProblem {
+	var point = e.ErrorPoint()
+	var desc = e.Desc()
+	return LintProblem {
+		Tree:    point.Node.CST,
+		Span:    point.Node.Span,
+		Excerpt: desc.StringPlain(),
+		Tip:     tip,
+	}
+}
+
 func Lint(req LintRequest, ctx LangServerContext) LintResponse {
 	var dir = filepath.Dir(req.Path)
-	var manifest_path = filepath.Join(dir, loader.ManifestFileName)
 	for _, visited := range req.VisitedModules {
 		if dir == visited || req.Path == visited {
 			return LintResponse {}
 		}
 	}
-	var mod_path string
-	var mf, err_mf = os.Open(manifest_path)
-	if err_mf != nil {
-		mod_path = req.Path
-	} else {
-		mod_path = dir
-		_ = mf.Close()
-	}
+	var mod_path = GetModulePath(req.Path, ctx.GetFileSystem())
 	// ctx.DebugLog("Lint Path: " + mod_path)
-	var mod, idx, _, err_loader = loader.LoadEntry(mod_path)
+	var _, _, problems = LintModule(mod_path, ctx)
+	var errs ([] LintError)
+	if len(problems) > 0 {
+		errs = make([] LintError, len(problems))
+		for i, p := range problems {
+			var severity = "error"
+			if p.Warning {
+				severity = "warning"
+			}
+			errs[i] = LintError {
+				Severity:    severity,
+				Location:    GetLocation(p.Tree, p.Span),
+				Excerpt:     p.Excerpt,
+				Description: p.Tip,
+			}
+		}
+	}
+	return LintResponse {
+		Module: mod_path,
+		Errors: errs,
+	}
+}
+
+// LintModule loads, checks and compiles the module at the specified
+// path, and returns problems found. The loaded module is returned if
+// the module and its dependencies are loaded successfully, and the
+// reference index is returned if they are checked successfully.
+func LintModule(mod_path string, ctx LangServerContext) (*loader.Module, *checker.RefIndex, ([] LintProblem)) {
+	var mod, idx, _, err_loader = loader.LoadEntryFrom(mod_path, ctx.GetFileSystem())
 	if err_loader != nil {
 		var point, ok = err_loader.Context.ImportPoint.(ErrorPoint)
 		if ok {
 			var err_desc = err_loader.Desc()
-			var err = LintError {
-				Severity: "error",
-				Location: GetLocationFromErrorPoint(point),
-				Excerpt:  err_desc.StringPlain(),
-			}
-			return LintResponse {
-				Module: mod_path,
-				Errors: [] LintError { err },
-			}
+			return nil, nil, [] LintProblem { {
+				Tree:    point.Node.CST,
+				Span:    point.Node.Span,
+				Excerpt: err_desc.StringPlain(),
+			} }
 		} else {
 			switch e := err_loader.Concrete.(type) {
 			case loader.E_ParseFailed:
@@ -137,28 +173,21 @@ func Lint(req LintRequest, ctx LangServerContext) LintResponse {
 				var tree = e.ParserError.Tree
 				var index = e.ParserError.NodeIndex
 				var token = cst.GetNodeFirstToken(tree, index)
-				var span = token.Span
-				var err = LintError {
-					Severity: "error",
-					Location: GetLocation(tree, span),
-					Excerpt:  desc.StringPlain(),
-				}
-				return LintResponse {
-					Module: mod_path,
-					Errors: [] LintError { err },
-				}
+				return nil, nil, [] LintProblem { {
+					Tree:    tree,
+					Span:    token.Span,
+					Excerpt: desc.StringPlain(),
+				} }
 			default:
 				// var desc = err_loader.Desc()
 				// ctx.DebugLog(mod_path + " unable to lint: " + desc.StringPlain())
-				return LintResponse {
-					Module: mod_path,
-				}
+				return nil, nil, nil
 			}
 		}
 	}
-	var checked_mod, _, _, _, errs_checker = checker.TypeCheck(mod, idx)
+	var checked_mod, checked_idx, _, _, errs_checker = checker.TypeCheck(mod, idx)
 	if errs_checker != nil {
-		var errs = make([] LintError, 0)
+		var problems = make([] LintProblem, 0)
 		for _, e := range errs_checker {
 			switch e := e.(type) {
 			case *checker.ExprError:
@@ -168,17 +197,14 @@ func Lint(req LintRequest, ctx LangServerContext) LintResponse {
 					for _, c := range none_callable.Candidates {
 						var tip = fmt.Sprintf(
 							"(overloaded candidate: %s)", c.FuncDesc)
-						errs = append(errs, GetError(c.Error, tip))
+						problems = append(problems, getProblem(c.Error, tip))
 					}
 					continue
 				}
 			}
-			errs = append(errs, GetError(e, ""))
-		}
-		return LintResponse {
-			Module: mod_path,
-			Errors: errs,
+			problems = append(problems, getProblem(e, ""))
 		}
+		return mod, nil, problems
 	}
 	var data = make([] def.DataValue, 0)
 	var closures = make([] generator.FuncNode, 0)
@@ -186,16 +212,20 @@ func Lint(req LintRequest, ctx LangServerContext) LintResponse {
 	var errs_compiler =
 		generator.CompileModule(checked_mod, index, &data, &closures)
 	if errs_compiler != nil {
-		var errs = make([] LintError, len(errs_compiler))
+		var problems = make([] LintProblem, len(errs_compiler))
 		for i, e := range errs_compiler {
-			errs[i] = GetError(e, "")
-		}
-		return LintResponse {
-			Module: mod_path,
-			Errors: errs,
+			problems[i] = getProblem(e, "")
 		}
+		return mod, checked_mod.Context.References, problems
 	}
-	return LintResponse {
-		Module: mod_path,
+	var warnings = checker.CollectWarnings(checked_idx)
+	if len(warnings) > 0 {
+		var problems = make([] LintProblem, len(warnings))
+		for i, w := range warnings {
+			problems[i] = getProblem(w, "")
+			problems[i].Warning = true
+		}
+		return mod, checked_mod.Context.References, problems
 	}
+	return mod, checked_mod.Context.References, nil
 }
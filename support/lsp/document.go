@@ -0,0 +1,179 @@
+package lsp
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"io/ioutil"
+	"unicode/utf16"
+	"unicode/utf8"
+	"path/filepath"
+	"kumachan/interpreter/lang/textual/cst"
+	"kumachan/interpreter/lang/textual/scanner"
+)
+
+
+type document struct {
+	path     string
+	version  int
+	text     string
+}
+
+type textDocumentIdentifier struct {
+	Uri      string  `json:"uri"`
+	Version  int     `json:"version,omitempty"`
+}
+
+type textDocumentPositionParams struct {
+	TextDocument  textDocumentIdentifier  `json:"textDocument"`
+	Position      Position                `json:"position"`
+}
+
+type referenceParams struct {
+	textDocumentPositionParams
+	Context  struct {
+		IncludeDeclaration  bool  `json:"includeDeclaration"`
+	}  `json:"context"`
+}
+
+type contentChange struct {
+	Range  *Range  `json:"range,omitempty"`
+	Text   string  `json:"text"`
+}
+
+type Position struct {
+	Line       int  `json:"line"`
+	Character  int  `json:"character"`
+}
+
+type Range struct {
+	Start  Position  `json:"start"`
+	End    Position  `json:"end"`
+}
+
+type Location struct {
+	Uri    string  `json:"uri"`
+	Range  Range   `json:"range"`
+}
+
+func (doc *document) applyChange(change contentChange) {
+	if change.Range == nil {
+		doc.text = change.Text
+		return
+	}
+	var start = getOffset(doc.text, change.Range.Start)
+	var end = getOffset(doc.text, change.Range.End)
+	if end < start {
+		end = start
+	}
+	doc.text = (doc.text[:start] + change.Text + doc.text[end:])
+}
+
+// getOffset converts a position into a byte offset of the text.
+// Positions out of the text are moved to the nearest valid offset.
+func getOffset(text string, pos Position) int {
+	var offset = 0
+	for line := 0; line < pos.Line; line += 1 {
+		var i = strings.IndexByte(text[offset:], '\n')
+		if i == -1 {
+			return len(text)
+		}
+		offset += (i + 1)
+	}
+	var units = 0
+	for offset < len(text) && units < pos.Character {
+		var char, size = utf8.DecodeRuneInString(text[offset:])
+		if char == '\n' {
+			break
+		}
+		units += len(utf16.Encode([] rune { char }))
+		offset += size
+	}
+	return offset
+}
+
+// getLine returns the content of a line and the byte offset of
+// the position in the line.
+func getLine(text string, pos Position) (string, int) {
+	var offset = getOffset(text, pos)
+	var start = (strings.LastIndexByte(text[:offset], '\n') + 1)
+	var end = strings.IndexByte(text[offset:], '\n')
+	if end == -1 {
+		end = len(text)
+	} else {
+		end += offset
+	}
+	return strings.TrimSuffix(text[start:end], "\r"), (offset - start)
+}
+
+// getPosition converts a rune index of the code into a position.
+func getPosition(code scanner.Code, index int) Position {
+	if index > len(code) {
+		index = len(code)
+	}
+	var line = 0
+	var start = 0
+	for i := 0; i < index; i += 1 {
+		if code[i] == '\n' {
+			line += 1
+			start = (i + 1)
+		}
+	}
+	return Position {
+		Line:      line,
+		Character: len(utf16.Encode(code[start:index])),
+	}
+}
+
+func getRange(tree *cst.Tree, span scanner.Span) Range {
+	if span == (scanner.Span {}) {
+		return Range {
+			Start: Position { 0, 0 },
+			End:   Position { 0, 1 },
+		}
+	}
+	return Range {
+		Start: getPosition(tree.Code, span.Start),
+		End:   getPosition(tree.Code, span.End),
+	}
+}
+
+func getLocation(tree *cst.Tree, span scanner.Span) Location {
+	return Location {
+		Uri:   pathToUri(tree.Name),
+		Range: getRange(tree, span),
+	}
+}
+
+func (s *Server) getText(path string) (string, error) {
+	var doc, exists = s.documents[path]
+	if exists {
+		return doc.text, nil
+	}
+	var content, err = ioutil.ReadFile(path)
+	if err != nil { return "", err }
+	return string(content), nil
+}
+
+func uriToPath(uri string) (string, error) {
+	var u, err = url.Parse(uri)
+	if err != nil { return "", err }
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported uri %s", uri)
+	}
+	var path = u.Path
+	if filepath.Separator == '\\' {
+		// "/C:/foo" -> "C:/foo"
+		path = strings.TrimPrefix(path, "/")
+	}
+	return filepath.Clean(filepath.FromSlash(path)), nil
+}
+
+func pathToUri(path string) string {
+	var slash_path = filepath.ToSlash(path)
+	if !(strings.HasPrefix(slash_path, "/")) {
+		slash_path = ("/" + slash_path)
+	}
+	var u = url.URL { Scheme: "file", Path: slash_path }
+	return u.String()
+}
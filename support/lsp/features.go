@@ -0,0 +1,561 @@
+package lsp
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+	"unicode/utf16"
+	"kumachan/stdlib"
+	"kumachan/support/atom"
+	"kumachan/interpreter/lang/textual/ast"
+	"kumachan/interpreter/compiler/loader"
+	"kumachan/interpreter/compiler/checker"
+)
+
+
+const maxSignatureLines = 12
+
+type Diagnostic struct {
+	Range     Range   `json:"range"`
+	Severity  int     `json:"severity"`
+	Source    string  `json:"source"`
+	Message   string  `json:"message"`
+}
+const severityError = 1
+const severityWarning = 2
+
+type CompletionItem struct {
+	Label       string     `json:"label"`
+	Kind        int        `json:"kind"`
+	Detail      string     `json:"detail,omitempty"`
+	TextEdit    TextEdit   `json:"textEdit"`
+}
+type TextEdit struct {
+	Range    Range   `json:"range"`
+	NewText  string  `json:"newText"`
+}
+var completionKinds = map[string] int {
+	"function": 3,
+	"variable": 6,
+	"type":     7,
+	"import":   9,
+	"keyword":  14,
+	"constant": 21,
+}
+
+type DocumentSymbol struct {
+	Name            string            `json:"name"`
+	Detail          string            `json:"detail,omitempty"`
+	Kind            int               `json:"kind"`
+	Range           Range             `json:"range"`
+	SelectionRange  Range             `json:"selectionRange"`
+	Children        [] DocumentSymbol `json:"children,omitempty"`
+}
+var symbolKinds = map[string] int {
+	"import":   2,
+	"type":     5,
+	"function": 12,
+	"constant": 14,
+	"enum":     10,
+	"case":     22,
+}
+
+type declaration struct {
+	kind       string  // "import", "function", "constant", "type"
+	name       ast.Identifier
+	node       ast.Node
+	docs       [] ast.Doc
+	public     bool
+	signature  string
+	children   [] declaration
+}
+
+type reference struct {
+	module    string
+	name      string
+	isModule  bool  // module name in a "module::name" reference
+}
+
+func (s *Server) recheck(path string) {
+	var ctx = s.getContext()
+	var mod_path = atom.GetModulePath(path, ctx.FileSystem)
+	var mod, refs, problems = atom.LintModule(mod_path, ctx)
+	if mod != nil {
+		s.modules[mod_path] = mod
+	}
+	if refs != nil {
+		s.indexes[mod_path] = refs
+	} else {
+		delete(s.indexes, mod_path)
+	}
+	var diagnostics = make(map[string] ([] Diagnostic))
+	diagnostics[path] = make([] Diagnostic, 0)
+	for _, p := range problems {
+		if p.Tree == nil {
+			continue
+		}
+		var message = p.Excerpt
+		if p.Tip != "" {
+			message = (message + "\n" + p.Tip)
+		}
+		var file = p.Tree.Name
+		var severity = severityError
+		if p.Warning {
+			severity = severityWarning
+		}
+		diagnostics[file] = append(diagnostics[file], Diagnostic {
+			Range:    getRange(p.Tree, p.Span),
+			Severity: severity,
+			Source:   "kumachan",
+			Message:  message,
+		})
+	}
+	for file := range s.reported[mod_path] {
+		var _, exists = diagnostics[file]
+		if !(exists) {
+			diagnostics[file] = make([] Diagnostic, 0)
+		}
+	}
+	var reported = make(map[string] bool)
+	var files = make([] string, 0, len(diagnostics))
+	for file, list := range diagnostics {
+		files = append(files, file)
+		if len(list) > 0 {
+			reported[file] = true
+		}
+	}
+	s.reported[mod_path] = reported
+	sort.Strings(files)
+	for _, file := range files {
+		s.notify("textDocument/publishDiagnostics", map[string] interface{} {
+			"uri":         pathToUri(file),
+			"diagnostics": diagnostics[file],
+		})
+	}
+}
+
+func (s *Server) completion(params textDocumentPositionParams) (interface{}, error) {
+	var path, err = uriToPath(params.TextDocument.Uri)
+	if err != nil { return nil, err }
+	text, err := s.getText(path)
+	if err != nil { return nil, err }
+	var line, col = getLine(text, params.Position)
+	var res = atom.AutoComplete(atom.AutoCompleteRequest {
+		PrecedingText: line[:col],
+		CurrentPath:   path,
+	}, s.getContext())
+	var items = make([] CompletionItem, 0, len(res.Suggestions))
+	for _, suggestion := range res.Suggestions {
+		var start = (col - len(suggestion.Replace))
+		if start < 0 { start = 0 }
+		items = append(items, CompletionItem {
+			Label:    suggestion.Text,
+			Kind:     completionKinds[suggestion.Type],
+			Detail:   suggestion.Display,
+			TextEdit: TextEdit {
+				Range: Range {
+					Start: getLinePosition(params.Position.Line, line, start),
+					End:   getLinePosition(params.Position.Line, line, col),
+				},
+				NewText: suggestion.Text,
+			},
+		})
+	}
+	return map[string] interface{} {
+		"isIncomplete": false,
+		"items":        items,
+	}, nil
+}
+
+func (s *Server) hover(params textDocumentPositionParams) (interface{}, error) {
+	var decls, r, ok, err = s.resolve(params)
+	if err != nil { return nil, err }
+	if !(ok) || len(decls) == 0 {
+		return nil, nil
+	}
+	var contents = make([] string, len(decls))
+	for i, decl := range decls {
+		var buf strings.Builder
+		buf.WriteString("```kumachan\n")
+		buf.WriteString(decl.signature)
+		buf.WriteString("\n```")
+		var doc = strings.TrimSpace(checker.DocStringFromRaw(decl.docs))
+		if doc != "" {
+			buf.WriteString("\n\n")
+			buf.WriteString(doc)
+		}
+		contents[i] = buf.String()
+	}
+	return map[string] interface{} {
+		"contents": map[string] interface{} {
+			"kind":  "markdown",
+			"value": strings.Join(contents, "\n\n---\n\n"),
+		},
+		"range": r,
+	}, nil
+}
+
+func (s *Server) definition(params textDocumentPositionParams) (interface{}, error) {
+	var path, err = uriToPath(params.TextDocument.Uri)
+	if err != nil { return nil, err }
+	refs, pos, err := s.getRefIndex(path, params.Position)
+	if err != nil { return nil, err }
+	if refs != nil {
+		var _, decl, found = refs.Lookup(path, pos)
+		if found {
+			return [] Location { s.getDeclarationLocation(path, decl) }, nil
+		}
+	}
+	decls, _, ok, err := s.resolve(params)
+	if err != nil { return nil, err }
+	if !(ok) {
+		return nil, nil
+	}
+	var locations = make([] Location, len(decls))
+	for i, decl := range decls {
+		var id = decl.name.Node
+		locations[i] = getLocation(id.CST, id.Span)
+	}
+	return locations, nil
+}
+
+func (s *Server) references(params referenceParams) (interface{}, error) {
+	var path, err = uriToPath(params.TextDocument.Uri)
+	if err != nil { return nil, err }
+	refs, pos, err := s.getRefIndex(path, params.Position)
+	if err != nil { return nil, err }
+	var locations = make([] Location, 0)
+	if refs == nil {
+		return locations, nil
+	}
+	var _, decl, found = refs.Lookup(path, pos)
+	if !(found) {
+		decl, found = refs.LookupDeclaration(path, pos)
+		if !(found) {
+			return locations, nil
+		}
+	}
+	if params.Context.IncludeDeclaration {
+		locations = append(locations, s.getDeclarationLocation(path, decl))
+	}
+	for _, ref := range refs.GetReferences(decl) {
+		locations = append(locations, getLocation(ref.CST, ref.Span))
+	}
+	return locations, nil
+}
+
+func (s *Server) documentSymbol(doc textDocumentIdentifier) (interface{}, error) {
+	var path, err = uriToPath(doc.Uri)
+	if err != nil { return nil, err }
+	var mod = s.getModule(path)
+	var symbols = make([] DocumentSymbol, 0)
+	if mod == nil {
+		return symbols, nil
+	}
+	var convert func(decl declaration) DocumentSymbol
+	convert = func(decl declaration) DocumentSymbol {
+		var kind = decl.kind
+		if kind == "type" && len(decl.children) > 0 {
+			kind = "enum"
+		}
+		var children ([] DocumentSymbol)
+		for _, child := range decl.children {
+			var child_symbol = convert(child)
+			child_symbol.Kind = symbolKinds["case"]
+			children = append(children, child_symbol)
+		}
+		var tree = decl.node.CST
+		return DocumentSymbol {
+			Name:           ast.Id2String(decl.name),
+			Detail:         firstLine(decl.signature),
+			Kind:           symbolKinds[kind],
+			Range:          getRange(tree, decl.node.Span),
+			SelectionRange: getRange(decl.name.CST, decl.name.Span),
+			Children:       children,
+		}
+	}
+	for _, decl := range getDeclarations(mod.AST) {
+		if decl.node.CST == nil || decl.node.CST.Name != path {
+			continue
+		}
+		symbols = append(symbols, convert(decl))
+	}
+	return symbols, nil
+}
+
+// resolve finds declarations referred by the identifier at
+// the specified position.
+func (s *Server) resolve(params textDocumentPositionParams) ([] declaration, Range, bool, error) {
+	var path, err = uriToPath(params.TextDocument.Uri)
+	if err != nil { return nil, Range {}, false, err }
+	text, err := s.getText(path)
+	if err != nil { return nil, Range {}, false, err }
+	var line, col = getLine(text, params.Position)
+	var ref, lo, hi, ok = getReference(line, col)
+	if !(ok) {
+		return nil, Range {}, false, nil
+	}
+	var r = Range {
+		Start: getLinePosition(params.Position.Line, line, lo),
+		End:   getLinePosition(params.Position.Line, line, hi),
+	}
+	var mod = s.getModule(path)
+	if mod == nil {
+		return nil, Range {}, false, nil
+	}
+	return lookup(mod, ref), r, true, nil
+}
+
+// getRefIndex returns the reference index of the module containing
+// the file, and the position converted into a rune index of the file.
+// The index is nil if the module is not checked successfully.
+func (s *Server) getRefIndex(path string, position Position) (*checker.RefIndex, int, error) {
+	var mod_path = atom.GetModulePath(path, s.getFileSystem())
+	var refs, exists = s.indexes[mod_path]
+	if !(exists) {
+		return nil, 0, nil
+	}
+	var text, err = s.getText(path)
+	if err != nil { return nil, 0, err }
+	var offset = getOffset(text, position)
+	return refs, utf8.RuneCountInString(text[:offset]), nil
+}
+
+// getDeclarationLocation returns the location of the name of
+// a declaration if it is found, otherwise the whole declaration.
+func (s *Server) getDeclarationLocation(path string, decl checker.Declaration) Location {
+	var mod_path = atom.GetModulePath(path, s.getFileSystem())
+	var mod, exists = s.modules[mod_path]
+	if exists {
+		var visited = make(map[*loader.Module] bool)
+		var name, found = findDeclarationName(mod, decl.Node, visited)
+		if found {
+			return getLocation(name.CST, name.Span)
+		}
+	}
+	return getLocation(decl.Node.CST, decl.Node.Span)
+}
+
+func findDeclarationName(mod *loader.Module, node ast.Node, visited (map[*loader.Module] bool)) (ast.Identifier, bool) {
+	if visited[mod] {
+		return ast.Identifier {}, false
+	}
+	visited[mod] = true
+	var check func(declaration) (ast.Identifier, bool)
+	check = func(decl declaration) (ast.Identifier, bool) {
+		if decl.node == node {
+			return decl.name, true
+		}
+		for _, child := range decl.children {
+			var name, found = check(child)
+			if found {
+				return name, true
+			}
+		}
+		return ast.Identifier {}, false
+	}
+	for _, decl := range getDeclarations(mod.AST) {
+		var name, found = check(decl)
+		if found {
+			return name, true
+		}
+	}
+	for _, imported := range mod.ImpMap {
+		var name, found = findDeclarationName(imported, node, visited)
+		if found {
+			return name, true
+		}
+	}
+	return ast.Identifier {}, false
+}
+
+func (s *Server) getModule(path string) *loader.Module {
+	var fs = s.getFileSystem()
+	var mod_path = atom.GetModulePath(path, fs)
+	var cached, exists = s.modules[mod_path]
+	if exists {
+		return cached
+	}
+	var mod, _, _, err = loader.LoadEntryFrom(mod_path, fs)
+	if err != nil {
+		return nil
+	}
+	s.modules[mod_path] = mod
+	return mod
+}
+
+func getReference(line string, col int) (reference, int, int, bool) {
+	const double_colon = "::"
+	var ranges = atom.IdentifierRegexp.FindAllStringIndex(line, -1)
+	for i, r := range ranges {
+		var lo = r[0]
+		var hi = r[1]
+		if !(lo <= col && col <= hi) {
+			continue
+		}
+		if col == hi && (i + 1) < len(ranges) && ranges[i+1][0] == col {
+			continue
+		}
+		var name = line[lo:hi]
+		if strings.HasPrefix(line[hi:], double_colon) {
+			return reference { name: name, isModule: true }, lo, hi, true
+		}
+		var mod = ""
+		if i > 0 && strings.HasSuffix(line[:lo], double_colon) {
+			var prev = ranges[i-1]
+			if prev[1] == (lo - len(double_colon)) {
+				mod = line[prev[0]:prev[1]]
+			}
+		}
+		return reference { module: mod, name: name }, lo, hi, true
+	}
+	return reference {}, 0, 0, false
+}
+
+func lookup(mod *loader.Module, ref reference) ([] declaration) {
+	var result = make([] declaration, 0)
+	var check func(declaration, func(declaration) bool)
+	check = func(decl declaration, f func(declaration) bool) {
+		if ast.Id2String(decl.name) == ref.name && f(decl) {
+			result = append(result, decl)
+		}
+		for _, child := range decl.children {
+			check(child, f)
+		}
+	}
+	var add = func(m *loader.Module, f func(declaration) bool) {
+		for _, decl := range getDeclarations(m.AST) {
+			check(decl, f)
+		}
+	}
+	var is_import = func(decl declaration) bool {
+		return decl.kind == "import"
+	}
+	var is_exported = func(decl declaration) bool {
+		return decl.kind != "import" && decl.public
+	}
+	var is_local = func(decl declaration) bool {
+		return decl.kind != "import"
+	}
+	var is_exported_function = func(decl declaration) bool {
+		return decl.kind == "function" && decl.public
+	}
+	var imported_names = make([] string, 0, len(mod.ImpMap))
+	for name := range mod.ImpMap {
+		imported_names = append(imported_names, name)
+	}
+	sort.Strings(imported_names)
+	if ref.isModule {
+		add(mod, is_import)
+	} else if ref.module == loader.SelfModule {
+		add(mod, is_local)
+	} else if ref.module != "" {
+		var imported, exists = mod.ImpMap[ref.module]
+		if exists {
+			add(imported, is_exported)
+		}
+	} else {
+		add(mod, is_local)
+		// functions are overloaded across modules
+		for _, name := range imported_names {
+			add(mod.ImpMap[name], is_exported_function)
+		}
+		if len(result) == 0 {
+			var core, exists = mod.ImpMap[stdlib.Mod_core]
+			if exists {
+				add(core, is_exported)
+			}
+		}
+	}
+	return result
+}
+
+func getDeclarations(root ast.Root) ([] declaration) {
+	var decls = make([] declaration, 0)
+	var get_type func(decl ast.DeclType) declaration
+	get_type = func(decl ast.DeclType) declaration {
+		var children ([] declaration)
+		var enum, is_enum = decl.TypeDef.TypeDef.(ast.EnumType)
+		if is_enum {
+			for _, item := range enum.Cases {
+				children = append(children, get_type(item))
+			}
+		}
+		var sig_end = decl.TypeDef.Node.Span.End
+		if is_enum {
+			sig_end = decl.TypeDef.Node.Span.Start
+		}
+		return declaration {
+			kind:      "type",
+			name:      decl.Name,
+			node:      decl.Node,
+			docs:      decl.Docs,
+			public:    true,
+			signature: getSignature("type", decl.Name, sig_end),
+			children:  children,
+		}
+	}
+	for _, stmt := range root.Statements {
+		switch decl := stmt.Statement.(type) {
+		case ast.Import:
+			var code = decl.Node.CST.Code
+			decls = append(decls, declaration {
+				kind:      "import",
+				name:      decl.Name,
+				node:      decl.Node,
+				public:    false,
+				signature: string(code[decl.Node.Span.Start: decl.Node.Span.End]),
+			})
+		case ast.DeclFunction:
+			decls = append(decls, declaration {
+				kind:      "function",
+				name:      decl.Name,
+				node:      decl.Node,
+				docs:      decl.Docs,
+				public:    decl.Public,
+				signature: getSignature("function", decl.Name, decl.Repr.Node.Span.End),
+			})
+		case ast.DeclConst:
+			decls = append(decls, declaration {
+				kind:      "constant",
+				name:      decl.Name,
+				node:      decl.Node,
+				docs:      decl.Docs,
+				public:    decl.Public,
+				signature: getSignature("const", decl.Name, decl.Type.Node.Span.End),
+			})
+		case ast.DeclType:
+			decls = append(decls, get_type(decl))
+		}
+	}
+	return decls
+}
+
+func getSignature(keyword string, name ast.Identifier, end int) string {
+	var code = name.CST.Code
+	var start = name.Span.Start
+	if end < start || end > len(code) {
+		end = name.Span.End
+	}
+	var lines = strings.Split(string(code[start:end]), "\n")
+	if len(lines) > maxSignatureLines {
+		lines = append(lines[:maxSignatureLines], "...")
+	}
+	return (keyword + " " + strings.TrimSpace(strings.Join(lines, "\n")))
+}
+
+func getLinePosition(line_number int, line string, offset int) Position {
+	return Position {
+		Line:      line_number,
+		Character: len(utf16.Encode(([] rune)(line[:offset]))),
+	}
+}
+
+func firstLine(text string) string {
+	var i = strings.IndexByte(text, '\n')
+	if i == -1 {
+		return text
+	} else {
+		return text[:i]
+	}
+}
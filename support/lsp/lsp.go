@@ -0,0 +1,396 @@
+package lsp
+
+import (
+	"io"
+	"fmt"
+	"sync"
+	"bufio"
+	"errors"
+	"strings"
+	"strconv"
+	"encoding/json"
+	"kumachan/support/atom"
+	"kumachan/interpreter/compiler/loader"
+	"kumachan/interpreter/compiler/checker"
+)
+
+
+/**
+ *  Language Server Protocol (LSP) Server
+ *
+ *  Supported features:
+ *    - diagnostics (errors from the loader, checker and generator)
+ *    - completion
+ *    - hover (signatures and documentation of declarations)
+ *    - go to definition
+ *    - find references
+ *    - document symbols
+ *
+ *  Documents are synchronized incrementally. Contents of open documents
+ *  are used instead of files on the disk, and the module containing
+ *  a document is checked again each time the document is changed.
+ *  Positions are measured in UTF-16 code units, as the protocol says.
+ *  Definitions and references are resolved by the reference index of
+ *  the checker if the module is checked successfully, otherwise the
+ *  definition is looked up by the name of the reference.
+ */
+
+const (
+	errParseError      = -32700
+	errInvalidRequest  = -32600
+	errMethodNotFound  = -32601
+	errInvalidParams   = -32602
+	errRequestFailed   = -32803
+)
+
+type Server struct {
+	input       *bufio.Reader
+	output      io.Writer
+	outputLock  sync.Mutex
+	debug       io.Writer
+	documents   map[string] *document          // path -> document
+	modules     map[string] *loader.Module     // module path -> module
+	indexes     map[string] *checker.RefIndex  // module path -> references
+	reported    map[string] (map[string] bool) // module path -> files
+	shutdown    bool
+}
+
+type message struct {
+	JsonRpc  string            `json:"jsonrpc"`
+	Id       json.RawMessage   `json:"id,omitempty"`
+	Method   string            `json:"method"`
+	Params   json.RawMessage   `json:"params,omitempty"`
+}
+
+type successResponse struct {
+	JsonRpc  string            `json:"jsonrpc"`
+	Id       json.RawMessage   `json:"id"`
+	Result   interface{}       `json:"result"`
+}
+
+type errorResponse struct {
+	JsonRpc  string            `json:"jsonrpc"`
+	Id       json.RawMessage   `json:"id"`
+	Error    responseError     `json:"error"`
+}
+
+type responseError struct {
+	Code     int      `json:"code"`
+	Message  string   `json:"message"`
+}
+
+type notification struct {
+	JsonRpc  string        `json:"jsonrpc"`
+	Method   string        `json:"method"`
+	Params   interface{}   `json:"params"`
+}
+
+type requestError struct {
+	code  int
+	msg   string
+}
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+func CreateServer(input io.Reader, output io.Writer, debug io.Writer) *Server {
+	return &Server {
+		input:     bufio.NewReader(input),
+		output:    output,
+		debug:     debug,
+		documents: make(map[string] *document),
+		modules:   make(map[string] *loader.Module),
+		indexes:   make(map[string] *checker.RefIndex),
+		reported:  make(map[string] (map[string] bool)),
+	}
+}
+
+func LangServer(input io.Reader, output io.Writer, debug io.Writer) error {
+	return CreateServer(input, output, debug).Serve()
+}
+
+// Serve handles messages until the exit notification is received
+// or the input is closed.
+func (s *Server) Serve() error {
+	for {
+		var content, err = s.readMessage()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		var msg message
+		err = json.Unmarshal(content, &msg)
+		if err != nil {
+			s.respondError(json.RawMessage("null"), &requestError {
+				code: errParseError,
+				msg:  err.Error(),
+			})
+			continue
+		}
+		var is_request = (len(msg.Id) > 0 && string(msg.Id) != "null")
+		if msg.Method == "" {
+			// responses to server requests are not used
+			continue
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		var result, req_err = s.handle(msg)
+		if is_request {
+			if req_err != nil {
+				s.respondError(msg.Id, req_err)
+			} else {
+				s.respond(msg.Id, result)
+			}
+		} else if req_err != nil {
+			s.log("%s: %s", msg.Method, req_err.Error())
+		}
+	}
+}
+
+func (s *Server) handle(msg message) (interface{}, error) {
+	if s.shutdown && msg.Method != "exit" {
+		return nil, &requestError {
+			code: errInvalidRequest,
+			msg:  "server is shut down",
+		}
+	}
+	switch msg.Method {
+	case "initialize":
+		return map[string] interface{} {
+			"capabilities": map[string] interface{} {
+				"textDocumentSync": map[string] interface{} {
+					"openClose": true,
+					"change":    2,  // incremental
+					"save":      true,
+				},
+				"completionProvider": map[string] interface{} {
+					"triggerCharacters": [] string { ":" },
+				},
+				"hoverProvider":          true,
+				"definitionProvider":     true,
+				"referencesProvider":     true,
+				"documentSymbolProvider": true,
+			},
+			"serverInfo": map[string] interface{} {
+				"name": "kumachan",
+			},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument  struct {
+				Uri      string  `json:"uri"`
+				Version  int     `json:"version"`
+				Text     string  `json:"text"`
+			}  `json:"textDocument"`
+		}
+		var err = parseParams(msg, &params)
+		if err != nil { return nil, err }
+		var doc = params.TextDocument
+		path, err := uriToPath(doc.Uri)
+		if err != nil { return nil, err }
+		s.documents[path] = &document {
+			path:    path,
+			version: doc.Version,
+			text:    doc.Text,
+		}
+		s.recheck(path)
+		return nil, nil
+	case "textDocument/didChange":
+		var params struct {
+			TextDocument    textDocumentIdentifier  `json:"textDocument"`
+			ContentChanges  [] contentChange        `json:"contentChanges"`
+		}
+		var err = parseParams(msg, &params)
+		if err != nil { return nil, err }
+		path, err := uriToPath(params.TextDocument.Uri)
+		if err != nil { return nil, err }
+		var doc, exists = s.documents[path]
+		if !(exists) {
+			return nil, fmt.Errorf("document %s is not open", path)
+		}
+		for _, change := range params.ContentChanges {
+			doc.applyChange(change)
+		}
+		doc.version = params.TextDocument.Version
+		s.recheck(path)
+		return nil, nil
+	case "textDocument/didSave":
+		var params struct {
+			TextDocument  textDocumentIdentifier  `json:"textDocument"`
+		}
+		var err = parseParams(msg, &params)
+		if err != nil { return nil, err }
+		path, err := uriToPath(params.TextDocument.Uri)
+		if err != nil { return nil, err }
+		s.recheck(path)
+		return nil, nil
+	case "textDocument/didClose":
+		var params struct {
+			TextDocument  textDocumentIdentifier  `json:"textDocument"`
+		}
+		var err = parseParams(msg, &params)
+		if err != nil { return nil, err }
+		path, err := uriToPath(params.TextDocument.Uri)
+		if err != nil { return nil, err }
+		delete(s.documents, path)
+		for _, reported := range s.reported {
+			delete(reported, path)
+		}
+		s.notify("textDocument/publishDiagnostics", map[string] interface{} {
+			"uri":         pathToUri(path),
+			"diagnostics": make([] Diagnostic, 0),
+		})
+		return nil, nil
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		var err = parseParams(msg, &params)
+		if err != nil { return nil, err }
+		return s.completion(params)
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		var err = parseParams(msg, &params)
+		if err != nil { return nil, err }
+		return s.hover(params)
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		var err = parseParams(msg, &params)
+		if err != nil { return nil, err }
+		return s.definition(params)
+	case "textDocument/references":
+		var params referenceParams
+		var err = parseParams(msg, &params)
+		if err != nil { return nil, err }
+		return s.references(params)
+	case "textDocument/documentSymbol":
+		var params struct {
+			TextDocument  textDocumentIdentifier  `json:"textDocument"`
+		}
+		var err = parseParams(msg, &params)
+		if err != nil { return nil, err }
+		return s.documentSymbol(params.TextDocument)
+	default:
+		if strings.HasPrefix(msg.Method, "$/") {
+			// optional notifications and requests
+			return nil, nil
+		}
+		return nil, &requestError {
+			code: errMethodNotFound,
+			msg:  fmt.Sprintf("unsupported method %s", strconv.Quote(msg.Method)),
+		}
+	}
+}
+
+func (s *Server) getFileSystem() loader.FileSystem {
+	var files = make(map[string] ([] byte))
+	for path, doc := range s.documents {
+		files[path] = ([] byte)(doc.text)
+	}
+	return loader.OverlayFileSystem {
+		Base:  loader.RealFileSystem {},
+		Files: files,
+	}
+}
+
+func (s *Server) getContext() atom.LangServerContext {
+	return atom.LangServerContext {
+		DebugLog:   func(info string) { s.log("%s", info) },
+		FileSystem: s.getFileSystem(),
+	}
+}
+
+func (s *Server) log(format string, args ...interface{}) {
+	if s.debug != nil {
+		_, _ = fmt.Fprintf(s.debug, (format + "\n"), args...)
+	}
+}
+
+func parseParams(msg message, v interface{}) error {
+	if len(msg.Params) == 0 {
+		return &requestError {
+			code: errInvalidParams,
+			msg:  "missing params",
+		}
+	}
+	var err = json.Unmarshal(msg.Params, v)
+	if err != nil {
+		return &requestError {
+			code: errInvalidParams,
+			msg:  err.Error(),
+		}
+	}
+	return nil
+}
+
+func (s *Server) readMessage() ([] byte, error) {
+	var length = -1
+	for {
+		var line, err = s.input.ReadString('\n')
+		if err != nil { return nil, err }
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		const prefix = "Content-Length:"
+		if strings.HasPrefix(line, prefix) {
+			var value = strings.TrimSpace(strings.TrimPrefix(line, prefix))
+			length, err = strconv.Atoi(value)
+			if err != nil { return nil, err }
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("missing Content-Length header")
+	}
+	var content = make([] byte, length)
+	var _, err = io.ReadFull(s.input, content)
+	if err != nil { return nil, err }
+	return content, nil
+}
+
+func (s *Server) respond(id json.RawMessage, result interface{}) {
+	s.send(successResponse {
+		JsonRpc: "2.0",
+		Id:      id,
+		Result:  result,
+	})
+}
+
+func (s *Server) respondError(id json.RawMessage, err error) {
+	var code = errRequestFailed
+	var req_err, is_req_err = err.(*requestError)
+	if is_req_err {
+		code = req_err.code
+	}
+	s.send(errorResponse {
+		JsonRpc: "2.0",
+		Id:      id,
+		Error:   responseError {
+			Code:    code,
+			Message: err.Error(),
+		},
+	})
+}
+
+func (s *Server) notify(method string, params interface{}) {
+	s.send(notification {
+		JsonRpc: "2.0",
+		Method:  method,
+		Params:  params,
+	})
+}
+
+func (s *Server) send(msg interface{}) {
+	s.outputLock.Lock()
+	defer s.outputLock.Unlock()
+	var content, err = json.Marshal(msg)
+	if err != nil { panic(err) }
+	_, _ = fmt.Fprintf(s.output, "Content-Length: %d\r\n\r\n", len(content))
+	_, _ = s.output.Write(content)
+}
@@ -0,0 +1,259 @@
+package test
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"bufio"
+	"strings"
+	"strconv"
+	"testing"
+	"path/filepath"
+	"encoding/json"
+	"kumachan/interpreter/def"
+	"kumachan/interpreter/runtime/debugger"
+)
+
+
+type dapMessage struct {
+	Seq         int              `json:"seq"`
+	Type        string           `json:"type"`
+	Command     string           `json:"command"`
+	Event       string           `json:"event"`
+	RequestSeq  int              `json:"request_seq"`
+	Success     bool             `json:"success"`
+	Message     string           `json:"message"`
+	Body        json.RawMessage  `json:"body"`
+}
+
+type dapFakeClient struct {
+	t         *testing.T
+	seq       int
+	writer    io.Writer
+	messages  chan dapMessage
+	output    strings.Builder
+}
+
+func createDapFakeClient(t *testing.T, r io.Reader, w io.Writer) *dapFakeClient {
+	var messages = make(chan dapMessage, 256)
+	go (func() {
+		defer close(messages)
+		var reader = bufio.NewReader(r)
+		for {
+			var header, err = reader.ReadString('\n')
+			if err != nil { return }
+			var length, _ = strconv.Atoi(strings.TrimSpace(
+				strings.TrimPrefix(header, "Content-Length:")))
+			_, err = reader.ReadString('\n')
+			if err != nil { return }
+			var content = make([] byte, length)
+			_, err = io.ReadFull(reader, content)
+			if err != nil { return }
+			var msg dapMessage
+			err = json.Unmarshal(content, &msg)
+			if err != nil { return }
+			messages <- msg
+		}
+	})()
+	return &dapFakeClient {
+		t:        t,
+		writer:   w,
+		messages: messages,
+	}
+}
+
+func (c *dapFakeClient) request(command string, args interface{}, body interface{}) {
+	c.seq += 1
+	var content, err = json.Marshal(map[string] interface{} {
+		"seq":       c.seq,
+		"type":      "request",
+		"command":   command,
+		"arguments": args,
+	})
+	if err != nil { c.t.Fatal(err) }
+	_, err = fmt.Fprintf(c.writer, "Content-Length: %d\r\n\r\n%s", len(content), content)
+	if err != nil { c.t.Fatal(err) }
+	var msg = c.wait(func(msg dapMessage) bool {
+		return msg.Type == "response" && msg.RequestSeq == c.seq
+	})
+	if !(msg.Success) {
+		c.t.Fatalf("request %s failed: %s", command, msg.Message)
+	}
+	c.decode(msg, body)
+}
+
+func (c *dapFakeClient) event(event string, body interface{}) {
+	var msg = c.wait(func(msg dapMessage) bool {
+		return msg.Type == "event" && msg.Event == event
+	})
+	c.decode(msg, body)
+}
+
+func (c *dapFakeClient) wait(f func(dapMessage) bool) dapMessage {
+	for msg := range c.messages {
+		if msg.Type == "event" && msg.Event == "output" {
+			var output struct { Output string `json:"output"` }
+			c.decode(msg, &output)
+			c.output.WriteString(output.Output)
+		}
+		if f(msg) {
+			return msg
+		}
+	}
+	c.t.Fatal("connection closed unexpectedly")
+	panic("something went wrong")
+}
+
+func (c *dapFakeClient) decode(msg dapMessage, body interface{}) {
+	if body == nil || len(msg.Body) == 0 {
+		return
+	}
+	var err = json.Unmarshal(msg.Body, body)
+	if err != nil { c.t.Fatal(err) }
+}
+
+func startDapServer(t *testing.T, mod_path string) (*dapFakeClient, chan struct{}) {
+	var mod, sch, serv, res = check(t, mod_path)
+	var program = compile(t, mod_path, mod, sch, serv)
+	var req_r, req_w = io.Pipe()
+	var res_r, res_w = io.Pipe()
+	var quit = make(chan struct{})
+	var server = debugger.CreateDapServer(req_r, res_w, debugger.DapOptions {
+		Load: func(path string) (def.Program, (map[string] def.Resource), error) {
+			if path != mod_path {
+				return def.Program {}, nil, fmt.Errorf("unexpected path %s", path)
+			}
+			return program, res, nil
+		},
+		MaxStackSize: 65536,
+		Environment:  os.Environ(),
+		Quit:         func() { close(quit) },
+	})
+	go (func() {
+		_ = server.Serve()
+		_ = res_w.Close()
+	})()
+	var c = createDapFakeClient(t, res_r, req_w)
+	c.request("initialize", map[string] interface{} {
+		"adapterID": "kumachan",
+	}, nil)
+	c.event("initialized", nil)
+	c.request("launch", map[string] interface{} {
+		"program": mod_path,
+	}, nil)
+	return c, quit
+}
+
+func TestDapBreakpoint(t *testing.T) {
+	var dir_path = getTestDirPath(t, language)
+	var mod_path = filepath.Join(dir_path, "block", "parallel.km")
+	var c, quit = startDapServer(t, mod_path)
+	var set_breakpoints struct {
+		Breakpoints  [] struct {
+			Id        uint  `json:"id"`
+			Verified  bool  `json:"verified"`
+		}  `json:"breakpoints"`
+	}
+	c.request("setBreakpoints", map[string] interface{} {
+		"source":      map[string] interface{} { "path": mod_path },
+		"breakpoints": [] interface{} { map[string] interface{} { "line": 8 } },
+	}, &set_breakpoints)
+	if len(set_breakpoints.Breakpoints) != 1 || !(set_breakpoints.Breakpoints[0].Verified) {
+		t.Fatalf("unexpected breakpoints %+v", set_breakpoints)
+	}
+	c.request("configurationDone", nil, nil)
+	var stopped struct {
+		Reason            string  `json:"reason"`
+		ThreadId          int     `json:"threadId"`
+		HitBreakpointIds  [] uint `json:"hitBreakpointIds"`
+	}
+	c.event("stopped", &stopped)
+	if stopped.Reason != "breakpoint" || len(stopped.HitBreakpointIds) != 1 ||
+		stopped.HitBreakpointIds[0] != set_breakpoints.Breakpoints[0].Id {
+		t.Fatalf("unexpected stopped event %+v", stopped)
+	}
+	var stack_trace struct {
+		StackFrames  [] struct {
+			Id      int     `json:"id"`
+			Name    string  `json:"name"`
+			Line    int     `json:"line"`
+			Column  int     `json:"column"`
+			Source  struct {
+				Path  string  `json:"path"`
+			}  `json:"source"`
+		}  `json:"stackFrames"`
+	}
+	c.request("stackTrace", map[string] interface{} {
+		"threadId": stopped.ThreadId,
+	}, &stack_trace)
+	if len(stack_trace.StackFrames) < 2 {
+		t.Fatalf("unexpected stack trace %+v", stack_trace)
+	}
+	var top = stack_trace.StackFrames[0]
+	if top.Source.Path != mod_path || top.Line != 8 || top.Column != 29 ||
+		!(strings.HasSuffix(top.Name, "fib")) {
+		t.Fatalf("unexpected top frame %+v", top)
+	}
+	var scopes struct {
+		Scopes  [] struct {
+			Name                string  `json:"name"`
+			VariablesReference  int     `json:"variablesReference"`
+		}  `json:"scopes"`
+	}
+	c.request("scopes", map[string] interface{} {
+		"frameId": top.Id,
+	}, &scopes)
+	if len(scopes.Scopes) == 0 || scopes.Scopes[0].Name != "Locals" {
+		t.Fatalf("unexpected scopes %+v", scopes)
+	}
+	var variables struct {
+		Variables  [] struct {
+			Name   string  `json:"name"`
+			Value  string  `json:"value"`
+		}  `json:"variables"`
+	}
+	c.request("variables", map[string] interface{} {
+		"variablesReference": scopes.Scopes[0].VariablesReference,
+	}, &variables)
+	var values = make([] string, len(variables.Variables))
+	for i, v := range variables.Variables {
+		values[i] = fmt.Sprintf("%s = %s", v.Name, v.Value)
+	}
+	var expected_values = "[0] = [*big.Int 2]; [1] = [*big.Int 1]; [2] = ()"
+	if strings.Join(values, "; ") != expected_values {
+		t.Fatalf("unexpected variables\nexpected: %s\nactual: %s",
+			expected_values, strings.Join(values, "; "))
+	}
+	c.request("setBreakpoints", map[string] interface{} {
+		"source":      map[string] interface{} { "path": mod_path },
+		"breakpoints": [] interface{} {},
+	}, nil)
+	c.request("continue", map[string] interface{} {
+		"threadId": stopped.ThreadId,
+	}, nil)
+	c.event("terminated", nil)
+	var expected_output = "610\n987\n1597\n1597\n610\nnone\nequal\n1598\n144\n"
+	if c.output.String() != expected_output {
+		t.Fatalf("program output not matching\nexpected:\n%s\nactual:\n%s\n",
+			expected_output, c.output.String())
+	}
+	c.request("disconnect", nil, nil)
+	<- quit
+}
+
+func TestDapExitCode(t *testing.T) {
+	var dir_path = getTestDirPath(t, library)
+	var mod_path = filepath.Join(dir_path, "os", "exit.km")
+	var c, quit = startDapServer(t, mod_path)
+	c.request("configurationDone", nil, nil)
+	var exited struct {
+		ExitCode  int  `json:"exitCode"`
+	}
+	c.event("exited", &exited)
+	if exited.ExitCode != 3 {
+		t.Fatalf("unexpected exit code %d", exited.ExitCode)
+	}
+	c.event("terminated", nil)
+	c.request("disconnect", nil, nil)
+	<- quit
+}
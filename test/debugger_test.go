@@ -0,0 +1,61 @@
+package test
+
+import (
+	"os"
+	"fmt"
+	"bytes"
+	"strings"
+	"testing"
+	"path/filepath"
+	"kumachan/interpreter/def"
+	"kumachan/interpreter/runtime/vm"
+	"kumachan/interpreter/runtime/debugger"
+)
+
+
+func TestDebuggerBreakpoint(t *testing.T) {
+	var dir_path = getTestDirPath(t, language)
+	var mod_path = filepath.Join(dir_path, "block", "parallel.km")
+	var mod, sch, serv, res = check(t, mod_path)
+	var program = compile(t, mod_path, mod, sch, serv)
+	var commands = strings.Join([] string {
+		"break block/parallel.km:8",
+		"continue",
+		"locals",
+		"out",
+		"delete 1",
+		"continue",
+	}, "\n")
+	var protocol bytes.Buffer
+	var session = debugger.CreateSession(strings.NewReader(commands), &protocol, func() {
+		t.Fatal("unexpected quit")
+	})
+	expectOutput(t, "", "610\n987\n1597\n1597\n610\nnone\nequal\n1598\n144\n", func(stdio def.StdIO) {
+		vm.Execute(program, vm.Options {
+			Resources:    res,
+			MaxStackSize: 65536,
+			Environment:  os.Environ(),
+			Arguments:    [] string { mod_path },
+			Debugger:     session,
+			StdIO:        stdio,
+		}, nil)
+	})
+	var expected = fmt.Sprintf(strings.Join([] string {
+		"stopped (entry) at %[1]s:22:20",
+		"22 |     let a := { fib 15 },",
+		"breakpoint 1 at block/parallel.km:8",
+		"stopped (breakpoint 1) at %[1]s:8:29",
+		"8 |             let b := { fib (n -! 2) },",
+		"[0] local = [*big.Int 2]",
+		"[1] local = [*big.Int 1]",
+		"[2] local = ()",
+		"stopped (step) at %[1]s:7:22",
+		"7 |             let a := { fib (n -! 1) },",
+		"deleted breakpoint 1",
+		"",
+	}, "\n"), mod_path)
+	if protocol.String() != expected {
+		t.Fatalf("debugger output not matching\nexpected:\n%s\nactual:\n%s\n",
+			expected, protocol.String())
+	}
+}
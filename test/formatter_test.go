@@ -0,0 +1,94 @@
+package test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	"kumachan/stdlib"
+	"kumachan/interpreter/lang/textual/formatter"
+)
+
+
+func TestFormatLayout(t *testing.T) {
+	var code = strings.Join([] string {
+		"/// Shape is a shape.",
+		"type Shape enum { type Circle { radius: Float };   type Square { side: Float }; };",
+		"",
+		"",
+		"",
+		"",
+		"// area",
+		"export function area:",
+		"  &(Shape) => Float",
+		"  &(s) =>",
+		"      switch s:",
+		"  case Circle: ((s.radius  *  s.radius) * 3.14),",
+		"        case Square:",
+		"                  (s.side * s.side),",
+		"      end;",
+	}, "\n")
+	var expected = strings.Join([] string {
+		"/// Shape is a shape.",
+		"type Shape enum {",
+		"    type Circle { radius: Float };",
+		"    type Square { side: Float };",
+		"};",
+		"",
+		"",
+		"// area",
+		"export function area:",
+		"    &(Shape) => Float",
+		"    &(s) =>",
+		"        switch s:",
+		"        case Circle: ((s.radius * s.radius) * 3.14),",
+		"        case Square:",
+		"            (s.side * s.side),",
+		"        end;",
+		"",
+	}, "\n")
+	var formatted, err = formatter.Format(([] rune)(code), "shape.km")
+	if err != nil { t.Fatal(err) }
+	if formatted != expected {
+		t.Fatalf("unexpected output:\n%s", formatted)
+	}
+}
+
+func TestFormatIdempotent(t *testing.T) {
+	for _, kind := range [] string { language, library } {
+		checkFormatIdempotent(t, getTestDirPath(t, kind))
+	}
+}
+
+func TestFormatStdlib(t *testing.T) {
+	var stdlib_path, err = filepath.EvalSymlinks(stdlib.GetDirectoryPath())
+	if err != nil { t.Fatal(err) }
+	var count = checkFormatIdempotent(t, stdlib_path)
+	if count == 0 {
+		t.Fatal("no source files found in the standard library")
+	}
+}
+
+func checkFormatIdempotent(t *testing.T, dir_path string) int {
+	var count = 0
+	var err = filepath.Walk(dir_path, func(path string, info os.FileInfo, err error) error {
+		if err != nil { return err }
+		if info.IsDir() || !(strings.HasSuffix(path, ".km")) {
+			return nil
+		}
+		var content, read_err = ioutil.ReadFile(path)
+		if read_err != nil { return read_err }
+		var once, err1 = formatter.Format(([] rune)(string(content)), path)
+		if err1 != nil { return err1 }
+		var twice, err2 = formatter.Format(([] rune)(once), path)
+		if err2 != nil { return err2 }
+		count += 1
+		if once != twice {
+			t.Errorf("formatting %s is not idempotent", path)
+		}
+		return nil
+	})
+	if err != nil { t.Fatal(err) }
+	return count
+}
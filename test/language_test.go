@@ -1,9 +1,24 @@
 package test
 
 import (
+	"os"
 	"fmt"
+	"time"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
 	"path/filepath"
+	"kumachan/standalone/rx"
+	"kumachan/interpreter/def"
+	"kumachan/interpreter/runtime/vm"
+	"kumachan/interpreter/runtime/tester"
+	"kumachan/interpreter/compiler/loader"
+	"kumachan/interpreter/compiler/checker"
+	"kumachan/interpreter/compiler/generator"
+	"kumachan/interpreter/compiler/generator2"
+	"kumachan/interpreter/compiler/bundle"
+	vm2def "kumachan/interpreter/runtime/vm2/def"
 )
 
 
@@ -91,3 +106,271 @@ func TestMultiSwitch(t *testing.T) {
 	expectStdIO(t, mod_path, input("C", "C"), output("C"))
 }
 
+
+func TestQualifiedOverload(t *testing.T) {
+	var dir_path = getTestDirPath(t, language)
+	var mod_path = filepath.Join(dir_path, "overload", "qualified.km")
+	expectStdIO(t, mod_path, "", "a 1,b 2\n")
+}
+
+func TestParallelBlock(t *testing.T) {
+	var dir_path = getTestDirPath(t, language)
+	var mod_path = filepath.Join(dir_path, "block", "parallel.km")
+	expectStdIO(t, mod_path, "", "610\n987\n1597\n1597\n610\nnone\nequal\n1598\n144\n")
+}
+
+func TestParallelStages(t *testing.T) {
+	var dir_path = getTestDirPath(t, language)
+	var mod_path = filepath.Join(dir_path, "block", "parallel.km")
+	var mod, sch, serv, _ = check(t, mod_path)
+	var idx = make(generator2.Index)
+	var errs = generator2.CompileModule(mod, idx)
+	if errs != nil { t.Fatal(mergeErrorMessages(errs)) }
+	var meta = vm2def.ProgramMetaData { EntryModulePath: mod_path }
+	var program, err = generator2.CreateProgram(meta, idx, sch, serv)
+	if err != nil { t.Fatal(err) }
+	var max_flows = 0
+	var visit_stages func([] vm2def.Stage)
+	visit_stages = func(stages ([] vm2def.Stage)) {
+		for _, stage := range stages {
+			if len(stage) > max_flows {
+				max_flows = len(stage)
+			}
+			for _, flow := range stage {
+				if !(flow.Simple()) {
+					visit_stages(flow.Stages)
+				}
+			}
+		}
+	}
+	var visit_branch func(*vm2def.BranchData)
+	var visit_seed func(*vm2def.FunctionSeedUsual)
+	visit_branch = func(b *vm2def.BranchData) {
+		visit_stages(b.Stages)
+		for _, sub := range b.Branches {
+			visit_branch(sub)
+		}
+		for _, cl := range b.Closures {
+			visit_seed(cl)
+		}
+	}
+	visit_seed = func(seed *vm2def.FunctionSeedUsual) {
+		visit_branch(seed.Trunk)
+	}
+	for _, f := range program.Functions {
+		var seed, is_usual = f.(*vm2def.FunctionSeedUsual)
+		if is_usual {
+			visit_seed(seed)
+		}
+	}
+	if max_flows < 2 {
+		t.Fatal("no stage with more than one flow generated")
+	}
+	expectStdIO(t, mod_path, "", "610\n987\n1597\n1597\n610\nnone\nequal\n1598\n144\n")
+}
+
+func TestReferenceIndex(t *testing.T) {
+	var dir_path = getTestDirPath(t, language)
+	var mod_path = filepath.Join(dir_path, "block", "parallel.km")
+	var mod, _, _, _ = check(t, mod_path)
+	var refs = mod.Context.References
+	var tree = mod.RawModule.AST.Node.CST
+	var code = string(tree.Code)
+	var pos = func(s string) int {
+		var i = strings.Index(code, s)
+		if i == -1 { t.Fatalf("%s not found", s) }
+		return len([] rune(code[:i]))
+	}
+	var fib, ok = refs.LookupDeclaration(tree.Name, pos("fib:"))
+	if !(ok) || fib.Kind != checker.DK_Function {
+		t.Fatalf("unexpected declaration %+v", fib)
+	}
+	var fib_refs = refs.GetReferences(fib)
+	if len(fib_refs) != 7 {
+		t.Fatalf("unexpected number of references to fib: %d", len(fib_refs))
+	}
+	for _, ref := range fib_refs {
+		var _, decl, ok = refs.Lookup(tree.Name, (ref.Span.Start + 1))
+		if !(ok) || decl != fib {
+			t.Fatalf("reference %+v not resolved to fib", ref)
+		}
+	}
+	var _, maybe, found = refs.Lookup(tree.Name, pos("Maybe["))
+	if !(found) || maybe.Kind != checker.DK_Type ||
+		maybe.Node.CST == tree {
+		t.Fatalf("unexpected declaration %+v", maybe)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	var dir_path = getTestDirPath(t, language)
+	var mod_path = filepath.Join(dir_path, "warning", "warning.km")
+	ldr_mod, ldr_idx, _, ldr_err := loader.LoadEntry(mod_path)
+	if ldr_err != nil { t.Fatal(ldr_err) }
+	_, idx, _, _, errs := checker.TypeCheck(ldr_mod, ldr_idx)
+	if errs != nil { t.Fatal(mergeErrorMessages(errs)) }
+	var warnings = checker.CollectWarnings(idx)
+	var expected = [] checker.ConcreteWarning {
+		checker.W_UnusedImport { Alias: "unused_json" },
+		checker.W_Shadowing { Name: "n" },
+	}
+	if len(warnings) != len(expected) {
+		for _, w := range warnings {
+			t.Log(w.Message())
+		}
+		t.Fatalf("unexpected number of warnings: %d", len(warnings))
+	}
+	for i, w := range warnings {
+		if w.Concrete != expected[i] {
+			t.Fatalf("unexpected warning %+v", w.Concrete)
+		}
+	}
+	// warnings do not prevent the module from being compiled
+	expectStdIO(t, mod_path, "", "5\n")
+}
+
+func TestTestCases(t *testing.T) {
+	var dir_path = getTestDirPath(t, language)
+	var mod_path = filepath.Join(dir_path, "tester", "tester.km")
+	ldr_mod, ldr_idx, ldr_res, ldr_err := loader.LoadEntry(mod_path)
+	if ldr_err != nil { t.Fatal(ldr_err) }
+	mod, idx, sch, serv, errs := checker.TypeCheck(ldr_mod, ldr_idx)
+	if errs != nil { t.Fatal(mergeErrorMessages(errs)) }
+	var data = make([] def.DataValue, 0)
+	var closures = make([] generator.FuncNode, 0)
+	var gen_idx = make(generator.Index)
+	errs = generator.CompileModule(mod, gen_idx, &data, &closures)
+	if errs != nil { t.Fatal(mergeErrorMessages(errs)) }
+	var meta = def.ProgramMetaData { EntryModulePath: mod_path }
+	program, locator, err := generator.CreateProgram(meta, gen_idx, data, closures, sch, serv)
+	if err != nil { t.Fatal(err) }
+	var names = func(cases ([] tester.Case)) string {
+		var names = make([] string, len(cases))
+		for i, c := range cases {
+			names[i] = c.FullName()
+		}
+		return strings.Join(names, ",")
+	}
+	var all = tester.CollectCases(idx, locator, nil)
+	const all_names = "Main::equality,Main::yield-value,Main::failed-assertion,Main::thrown-error,Main::slow"
+	if names(all) != all_names {
+		t.Fatalf("unexpected test cases: %s", names(all))
+	}
+	var filtered = tester.CollectCases(idx, locator, regexp.MustCompile("-value$|error"))
+	if names(filtered) != "Main::yield-value,Main::thrown-error" {
+		t.Fatalf("unexpected filtered test cases: %s", names(filtered))
+	}
+	// failed-assertion and slow are executed in separate processes below,
+	// since a runtime error terminates the process and a timeout is handled
+	// by the runner process
+	var expected = map[string] int {
+		"Main::equality":     tester.ExitPassed,
+		"Main::yield-value":  tester.ExitPassed,
+		"Main::thrown-error": tester.ExitFailed,
+	}
+	for _, c := range all {
+		var code, ok = expected[c.FullName()]
+		if !(ok) {
+			continue
+		}
+		var output strings.Builder
+		var got = tester.Execute(program, c.Address, vm.Options {
+			Resources:    ldr_res,
+			MaxStackSize: 65536,
+			Environment:  os.Environ(),
+			Arguments:    [] string { mod_path },
+			StdIO:        def.StdIO {
+				Stdin:  rx.FileFrom(os.Stdin),
+				Stdout: rx.FileFrom(os.Stdout),
+				Stderr: rx.FileFrom(os.Stderr),
+			},
+		}, &output)
+		if got != code {
+			t.Fatalf("unexpected exit code of %s: %d\n%s", c.FullName(), got, output.String())
+		}
+		if code == tester.ExitFailed && !(strings.Contains(output.String(), "something bad")) {
+			t.Fatalf("unexpected output of %s:\n%s", c.FullName(), output.String())
+		}
+	}
+	var image = filepath.Join(t.TempDir(), ("test" + bundle.FileExtension))
+	var write_err = bundle.WriteFile(image, program, ldr_res)
+	if write_err != nil { t.Fatal(write_err) }
+	exe, exe_err := os.Executable()
+	if exe_err != nil { t.Fatal(exe_err) }
+	var timeout = (2 * time.Second)
+	var opts = tester.Options {
+		Executable:   exe,
+		Image:        image,
+		Timeout:      timeout,
+		MaxStackSize: 65536,
+	}
+	var run = func(name string, status tester.Status) string {
+		for _, c := range all {
+			if c.FullName() == name {
+				var result = tester.Run(c, opts)
+				if result.Status != status {
+					t.Fatalf("unexpected status of %s: %v\n%s", name, result.Status, result.Output)
+				}
+				var report strings.Builder
+				tester.Report(&report, result, timeout)
+				return report.String()
+			}
+		}
+		t.Fatalf("test case %s not found", name)
+		panic("something went wrong")
+	}
+	var assertion = run("Main::failed-assertion", tester.Failed)
+	var assertion_header = regexp.MustCompile(
+		`^--- FAIL: Main::failed-assertion \([0-9.]+s\)\n    declared at .*tester\.km`)
+	if !(assertion_header.MatchString(assertion)) ||
+		!(strings.Contains(assertion, "assertion failed")) {
+		t.Fatalf("unexpected report of failed-assertion:\n%s", assertion)
+	}
+	var slow = run("Main::slow", tester.TimedOut)
+	var slow_report = regexp.MustCompile(
+		`^--- FAIL: Main::slow \(timed out after 2s\)\n    declared at .*tester\.km.*\n$`)
+	if !(slow_report.MatchString(slow)) {
+		t.Fatalf("unexpected report of slow:\n%s", slow)
+	}
+}
+
+// runTestCase plays the role of the executable running a single test case
+// of a program image, when the test binary is invoked by tester.Run.
+func runTestCase() (int, bool) {
+	var address = ""
+	var image = ""
+	for i, arg := range os.Args[1:] {
+		if strings.HasPrefix(arg, "--test-case=") {
+			address = strings.TrimPrefix(arg, "--test-case=")
+		}
+		if arg == "--" && (i + 2) < len(os.Args) {
+			image = os.Args[i + 2]
+		}
+	}
+	if address == "" || image == "" {
+		return 0, false
+	}
+	var addr, err = strconv.ParseUint(address, 10, 64)
+	if err != nil { panic(err) }
+	program, res, err := bundle.ReadFile(image)
+	if err != nil { panic(err) }
+	return tester.Execute(program, uint(addr), vm.Options {
+		Resources:    res,
+		MaxStackSize: 65536,
+		Environment:  os.Environ(),
+		Arguments:    [] string { image },
+		StdIO:        def.StdIO {
+			Stdin:  rx.FileFrom(os.Stdin),
+			Stdout: rx.FileFrom(os.Stdout),
+			Stderr: rx.FileFrom(os.Stderr),
+		},
+	}, os.Stderr), true
+}
+
+func TestMain(m *testing.M) {
+	var code, is_test_case = runTestCase()
+	if is_test_case {
+		os.Exit(code)
+	}
+	os.Exit(m.Run())
+}
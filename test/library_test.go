@@ -20,3 +20,39 @@ func TestSeqOps(t *testing.T) {
 	expectStdIO(t, mod_path, "", "100\n")
 }
 
+
+func TestTimeCalendar(t *testing.T) {
+	var dir_path = getTestDirPath(t, library)
+	var mod_path = filepath.Join(dir_path, "time", "calendar.km")
+	expectStdIO(t, mod_path, "", "2020\n2\n29\n0\n15\n2020-02-29T00:15:00Z\n60\nSaturday\n8784h45m0s\nYes\nYes\n2020-02-29 07:30:00\n1582932600\n")
+}
+
+func TestTimeFormat(t *testing.T) {
+	var dir_path = getTestDirPath(t, library)
+	var mod_path = filepath.Join(dir_path, "time", "format.km")
+	expectStdIO(t, mod_path, "", "2021-03-04T05:06:07.5+08:00\nerror\n2021-03-04T05:06:00Z\n2021-03-04T05:06:00+08:00\n1969-12-31T23:59:58.5Z\n-1500000000\n1h2m3.5s\nerror\n-500ms\n3600000\nYes\n")
+}
+
+func TestFileOperations(t *testing.T) {
+	var dir_path = getTestDirPath(t, library)
+	var mod_path = filepath.Join(dir_path, "os", "file.km")
+	expectStdIO(t, mod_path, "", "hello\n5\n384\n-rw-------\nNo\nNo\n2\nYes\nNo\n")
+}
+
+func TestKmdDefault(t *testing.T) {
+	var dir_path = getTestDirPath(t, library)
+	var mod_path = filepath.Join(dir_path, "kmd", "default.km")
+	expectStdIO(t, mod_path, "", "Alice anonymous 18 Yes \nBob B 30 Yes x\n")
+}
+
+func TestWithFileClosed(t *testing.T) {
+	var dir_path = getTestDirPath(t, library)
+	var mod_path = filepath.Join(dir_path, "os", "with_file.km")
+	expectStdIO(t, mod_path, "", "hello\n")
+}
+
+func TestProcess(t *testing.T) {
+	var dir_path = getTestDirPath(t, library)
+	var mod_path = filepath.Join(dir_path, "os", "process.km")
+	expectStdIO(t, mod_path, "", "[hello world][/usr]\noops\n3\nNo\n")
+}
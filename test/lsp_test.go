@@ -0,0 +1,265 @@
+package test
+
+import (
+	"io"
+	"fmt"
+	"bufio"
+	"strings"
+	"strconv"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	"encoding/json"
+	"kumachan/support/lsp"
+)
+
+
+type lspMessage struct {
+	Id      *int             `json:"id"`
+	Method  string           `json:"method"`
+	Params  json.RawMessage  `json:"params"`
+	Result  json.RawMessage  `json:"result"`
+	Error   *struct {
+		Message  string  `json:"message"`
+	}  `json:"error"`
+}
+
+type lspFakeClient struct {
+	t         *testing.T
+	id        int
+	writer    io.Writer
+	messages  chan lspMessage
+}
+
+type lspDiagnostics struct {
+	Uri          string  `json:"uri"`
+	Diagnostics  [] struct {
+		Range    lsp.Range  `json:"range"`
+		Message  string     `json:"message"`
+	}  `json:"diagnostics"`
+}
+
+func createLspFakeClient(t *testing.T, r io.Reader, w io.Writer) *lspFakeClient {
+	var messages = make(chan lspMessage, 256)
+	go (func() {
+		defer close(messages)
+		var reader = bufio.NewReader(r)
+		for {
+			var header, err = reader.ReadString('\n')
+			if err != nil { return }
+			var length, _ = strconv.Atoi(strings.TrimSpace(
+				strings.TrimPrefix(header, "Content-Length:")))
+			_, err = reader.ReadString('\n')
+			if err != nil { return }
+			var content = make([] byte, length)
+			_, err = io.ReadFull(reader, content)
+			if err != nil { return }
+			var msg lspMessage
+			err = json.Unmarshal(content, &msg)
+			if err != nil { return }
+			messages <- msg
+		}
+	})()
+	return &lspFakeClient {
+		t:        t,
+		writer:   w,
+		messages: messages,
+	}
+}
+
+func (c *lspFakeClient) write(msg map[string] interface{}) {
+	msg["jsonrpc"] = "2.0"
+	var content, err = json.Marshal(msg)
+	if err != nil { c.t.Fatal(err) }
+	_, err = fmt.Fprintf(c.writer, "Content-Length: %d\r\n\r\n%s", len(content), content)
+	if err != nil { c.t.Fatal(err) }
+}
+
+func (c *lspFakeClient) request(method string, params interface{}, result interface{}) {
+	c.id += 1
+	c.write(map[string] interface{} {
+		"id":     c.id,
+		"method": method,
+		"params": params,
+	})
+	var msg = c.wait(func(msg lspMessage) bool {
+		return msg.Id != nil && *(msg.Id) == c.id
+	})
+	if msg.Error != nil {
+		c.t.Fatalf("request %s failed: %s", method, msg.Error.Message)
+	}
+	if result != nil {
+		var err = json.Unmarshal(msg.Result, result)
+		if err != nil { c.t.Fatal(err) }
+	}
+}
+
+func (c *lspFakeClient) notify(method string, params interface{}) {
+	c.write(map[string] interface{} {
+		"method": method,
+		"params": params,
+	})
+}
+
+func (c *lspFakeClient) diagnostics(uri string) lspDiagnostics {
+	var msg = c.wait(func(msg lspMessage) bool {
+		if msg.Method != "textDocument/publishDiagnostics" {
+			return false
+		}
+		var d lspDiagnostics
+		var err = json.Unmarshal(msg.Params, &d)
+		return err == nil && d.Uri == uri
+	})
+	var d lspDiagnostics
+	var err = json.Unmarshal(msg.Params, &d)
+	if err != nil { c.t.Fatal(err) }
+	return d
+}
+
+func (c *lspFakeClient) wait(f func(lspMessage) bool) lspMessage {
+	for msg := range c.messages {
+		if f(msg) {
+			return msg
+		}
+	}
+	c.t.Fatal("connection closed unexpectedly")
+	panic("something went wrong")
+}
+
+func TestLspServer(t *testing.T) {
+	var dir_path = getTestDirPath(t, language)
+	var source_path = filepath.Join(dir_path, "block", "parallel.km")
+	var content, err = ioutil.ReadFile(source_path)
+	if err != nil { t.Fatal(err) }
+	var path = filepath.Join(t.TempDir(), "parallel.km")
+	err = ioutil.WriteFile(path, content, 0644)
+	if err != nil { t.Fatal(err) }
+	var uri = ("file://" + filepath.ToSlash(path))
+	var req_r, req_w = io.Pipe()
+	var res_r, res_w = io.Pipe()
+	go (func() {
+		_ = lsp.LangServer(req_r, res_w, ioutil.Discard)
+		_ = res_w.Close()
+	})()
+	var c = createLspFakeClient(t, res_r, req_w)
+	var doc = map[string] interface{} { "uri": uri }
+	var position = func(line int, char int) map[string] interface{} {
+		return map[string] interface{} {
+			"textDocument": doc,
+			"position":     lsp.Position { Line: line, Character: char },
+		}
+	}
+	c.request("initialize", map[string] interface{} {
+		"capabilities": map[string] interface{} {},
+	}, nil)
+	c.notify("initialized", map[string] interface{} {})
+	// the content of the opened document is used instead of the file
+	var broken = strings.Replace(string(content), "(a + b);", "(a + undefined);", 1)
+	c.notify("textDocument/didOpen", map[string] interface{} {
+		"textDocument": map[string] interface{} {
+			"uri":        uri,
+			"languageId": "kumachan",
+			"version":    1,
+			"text":       broken,
+		},
+	})
+	var d = c.diagnostics(uri)
+	if len(d.Diagnostics) == 0 || d.Diagnostics[0].Range.Start.Line != 8 {
+		t.Fatalf("unexpected diagnostics %+v", d)
+	}
+	// incremental change: "undefined" -> "b" at line 9
+	var start = strings.Index("            (a + undefined);", "undefined")
+	c.notify("textDocument/didChange", map[string] interface{} {
+		"textDocument":   map[string] interface{} { "uri": uri, "version": 2 },
+		"contentChanges": [] interface{} {
+			map[string] interface{} {
+				"range": lsp.Range {
+					Start: lsp.Position { Line: 8, Character: start },
+					End:   lsp.Position { Line: 8, Character: (start + len("undefined")) },
+				},
+				"text": "b",
+			},
+		},
+	})
+	d = c.diagnostics(uri)
+	if len(d.Diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics %+v", d)
+	}
+	var hover struct {
+		Contents  struct {
+			Value  string  `json:"value"`
+		}  `json:"contents"`
+		Range  lsp.Range  `json:"range"`
+	}
+	c.request("textDocument/hover", position(6, 24), &hover)
+	if !(strings.Contains(hover.Contents.Value, "function fib:")) ||
+		hover.Range.Start != (lsp.Position { Line: 6, Character: 23 }) {
+		t.Fatalf("unexpected hover %+v", hover)
+	}
+	var definition ([] lsp.Location)
+	c.request("textDocument/definition", position(21, 16), &definition)
+	if len(definition) != 1 || definition[0].Uri != uri ||
+		definition[0].Range.Start != (lsp.Position { Line: 0, Character: 9 }) {
+		t.Fatalf("unexpected definition %+v", definition)
+	}
+	var references ([] lsp.Location)
+	var reference_params = position(21, 16)
+	reference_params["context"] = map[string] interface{} { "includeDeclaration": true }
+	c.request("textDocument/references", reference_params, &references)
+	if len(references) != 8 ||
+		references[0].Range.Start != (lsp.Position { Line: 0, Character: 9 }) ||
+		references[1].Range.Start != (lsp.Position { Line: 6, Character: 23 }) {
+		t.Fatalf("unexpected references %+v", references)
+	}
+	var symbols ([] struct {
+		Name  string  `json:"name"`
+		Kind  int     `json:"kind"`
+	})
+	c.request("textDocument/documentSymbol", map[string] interface{} {
+		"textDocument": doc,
+	}, &symbols)
+	if len(symbols) != 2 || symbols[0].Name != "fib" || symbols[1].Name != "describe" {
+		t.Fatalf("unexpected symbols %+v", symbols)
+	}
+	var completion struct {
+		Items  [] struct {
+			Label  string  `json:"label"`
+		}  `json:"items"`
+	}
+	c.request("textDocument/completion", position(25, 19), &completion)
+	var found = false
+	for _, item := range completion.Items {
+		if item.Label == "describe" {
+			found = true
+		}
+	}
+	if !(found) {
+		t.Fatalf("unexpected completion %+v", completion)
+	}
+	// diagnostics of a closed document are cleared
+	c.notify("textDocument/didChange", map[string] interface{} {
+		"textDocument":   map[string] interface{} { "uri": uri, "version": 3 },
+		"contentChanges": [] interface{} {
+			map[string] interface{} {
+				"range": lsp.Range {
+					Start: lsp.Position { Line: 8, Character: start },
+					End:   lsp.Position { Line: 8, Character: (start + len("b")) },
+				},
+				"text": "undefined",
+			},
+		},
+	})
+	d = c.diagnostics(uri)
+	if len(d.Diagnostics) == 0 {
+		t.Fatalf("unexpected diagnostics %+v", d)
+	}
+	c.notify("textDocument/didClose", map[string] interface{} {
+		"textDocument": doc,
+	})
+	d = c.diagnostics(uri)
+	if len(d.Diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics %+v", d)
+	}
+	c.request("shutdown", nil, nil)
+	c.notify("exit", nil)
+}
@@ -4,17 +4,24 @@ import (
 	"testing"
 	"os"
 	"fmt"
+	"bytes"
 	"errors"
 	"strconv"
 	"io/ioutil"
 	"path/filepath"
 	"kumachan/standalone/rx"
+	"kumachan/standalone/rpc"
+	"kumachan/standalone/rpc/kmd"
 	. "kumachan/standalone/util/error"
 	"kumachan/interpreter/def"
 	"kumachan/interpreter/compiler/loader"
 	"kumachan/interpreter/compiler/checker"
 	"kumachan/interpreter/compiler/generator"
+	"kumachan/interpreter/compiler/bundle"
 	"kumachan/interpreter/runtime/vm"
+	"kumachan/interpreter/compiler/generator2"
+	"kumachan/interpreter/runtime/vm2"
+	vm2def "kumachan/interpreter/runtime/vm2/def"
 )
 
 
@@ -33,35 +40,99 @@ func mergeErrorMessages(errs ([] E)) ErrorMessage {
 	return MsgFailedToCompile(errs[0], messages)
 }
 
-func expectStdIO(t *testing.T, path string, in string, expected_out string) {
+func check(t *testing.T, path string) (
+	*checker.CheckedModule, kmd.SchemaTable, rpc.ServiceIndex,
+	(map[string] def.Resource),
+) {
 	ldr_mod, ldr_idx, ldr_res, ldr_err := loader.LoadEntry(path)
 	if ldr_err != nil { t.Fatal(ldr_err) }
 	mod, _, sch, serv, errs := checker.TypeCheck(ldr_mod, ldr_idx)
 	if errs != nil { t.Fatal(mergeErrorMessages(errs)) }
+	return mod, sch, serv, ldr_res
+}
+
+func compile (
+	t     *testing.T,
+	path  string,
+	mod   *checker.CheckedModule,
+	sch   kmd.SchemaTable,
+	serv  rpc.ServiceIndex,
+) def.Program {
 	var data = make([] def.DataValue, 0)
 	var closures = make([] generator.FuncNode, 0)
 	var idx = make(generator.Index)
-	errs = generator.CompileModule(mod, idx, &data, &closures)
+	var errs = generator.CompileModule(mod, idx, &data, &closures)
 	if errs != nil { t.Fatal(mergeErrorMessages(errs)) }
 	var meta = def.ProgramMetaData { EntryModulePath: path }
 	program, _, err := generator.CreateProgram(meta, idx, data, closures, sch, serv)
 	if err != nil { t.Fatal(err) }
+	return program
+}
+
+func expectStdIO(t *testing.T, path string, in string, expected_out string) {
+	var mod, sch, serv, ldr_res = check(t, path)
+	var program = compile(t, path, mod, sch, serv)
+	var run = func(program def.Program, res (map[string] def.Resource)) func(def.StdIO) {
+		return func(stdio def.StdIO) {
+			vm.Execute(program, vm.Options {
+				Resources:    res,
+				MaxStackSize: 65536,
+				Environment:  os.Environ(),
+				Arguments:    [] string { path },
+				StdIO:        stdio,
+			}, nil)
+		}
+	}
+	expectOutput(t, in, expected_out, run(program, ldr_res))
+	var buf bytes.Buffer
+	e := bundle.Encode(&buf, program, ldr_res)
+	if e != nil { t.Fatal(e) }
+	img_program, img_res, e := bundle.Decode(&buf)
+	if e != nil { t.Fatal(e) }
+	expectOutput(t, in, expected_out, run(img_program, img_res))
+	var idx2 = make(generator2.Index)
+	var errs = generator2.CompileModule(mod, idx2)
+	if errs != nil { t.Fatal(mergeErrorMessages(errs)) }
+	var meta2 = vm2def.ProgramMetaData { EntryModulePath: path }
+	var program2, err = generator2.CreateProgram(meta2, idx2, sch, serv)
+	if err != nil { t.Fatal(err) }
+	var res2 = make(map[string] vm2def.Resource)
+	for res_path, item := range ldr_res {
+		res2[res_path] = vm2def.Resource {
+			Kind: item.Kind,
+			MIME: item.MIME,
+			Data: item.Data,
+		}
+	}
+	for _, parallel := range [] bool { false, true } {
+		expectOutput(t, in, expected_out, func(stdio def.StdIO) {
+			vm2.Execute(program2, vm2.Options {
+				Resources:       res2,
+				MaxStackSize:    65536,
+				SysEnv:          os.Environ(),
+				SysArgs:         [] string { path },
+				ParallelEnabled: parallel,
+				StdIO:           vm2def.StdIO {
+					Stdin:  stdio.Stdin,
+					Stdout: stdio.Stdout,
+					Stderr: stdio.Stderr,
+				},
+			}, nil)
+		})
+	}
+}
+
+func expectOutput(t *testing.T, in string, expected_out string, run func(def.StdIO)) {
 	in_read, in_write, e := os.Pipe()
 	if e != nil { panic(e) }
 	out_read, out_write, e := os.Pipe()
 	if e != nil { panic(e) }
 	go (func() {
-		vm.Execute(program, vm.Options {
-			Resources:    ldr_res,
-			MaxStackSize: 65536,
-			Environment:  os.Environ(),
-			Arguments:    [] string { path },
-			StdIO:        def.StdIO {
-				Stdin:  rx.FileFrom(in_read),
-				Stdout: rx.FileFrom(out_write),
-				Stderr: rx.FileFrom(os.Stderr),
-			},
-		}, nil)
+		run(def.StdIO {
+			Stdin:  rx.FileFrom(in_read),
+			Stdout: rx.FileFrom(out_write),
+			Stderr: rx.FileFrom(os.Stderr),
+		})
 		var e = out_write.Close()
 		if e != nil { panic(e) }
 	})()